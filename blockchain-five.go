@@ -1,7 +1,6 @@
 package main
 
 import (
-	"blockchain-app/wallet"
 	"fmt"
 	"log"
 	"os"
@@ -36,7 +35,10 @@ func RunBlockchainFive() {
 }
 
 func createWallet() {
-	wallets, _ := wallet.NewWallets()
+	wallets, _ := openWallets()
+	if !unlockWallets(wallets) {
+		return
+	}
 	address := wallets.CreateWallet()
 	wallets.SaveToFile()
 
@@ -44,7 +46,7 @@ func createWallet() {
 }
 
 func listAddresses() {
-	wallets, err := wallet.NewWallets()
+	wallets, err := openWallets()
 	if err != nil {
 		log.Panic(err)
 	}
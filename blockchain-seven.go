@@ -10,8 +10,12 @@
 package main
 
 import (
+	"context"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"log"
+	"net"
 	"os"
 	"os/signal"
 	"strconv"
@@ -20,7 +24,9 @@ import (
 	"time"
 
 	"blockchain-app/network"
+	"blockchain-app/pow"
 	"blockchain-app/transaction"
+	"blockchain-app/wallet"
 )
 
 // P2PBlockchain implements the network.BlockchainInterface for P2P layer
@@ -28,49 +34,214 @@ type P2PBlockchain struct {
 	*transaction.Blockchain
 }
 
-// GetBlockHashes returns all block hashes for the P2P layer
-func (bc *P2PBlockchain) GetBlockHashes() [][]byte {
-	var hashes [][]byte
+// GetBlock returns a block by its hash for the P2P layer
+func (bc *P2PBlockchain) GetBlock(blockHash []byte) (network.BlockInterface, error) {
+	block, err := bc.Blockchain.GetBlock(blockHash)
+	if err != nil {
+		return nil, fmt.Errorf("block not found: %v", err)
+	}
 
-	// Get all blocks from the blockchain
-	iterator := bc.Blockchain.Iterator()
+	return &P2PBlock{Block: &block}, nil
+}
 
-	for {
-		block := iterator.Next()
-		if block == nil {
-			break
+// AddBlock validates and adds a block to the blockchain for the P2P layer
+func (bc *P2PBlockchain) AddBlock(block network.BlockInterface) error {
+	p2pBlock, ok := block.(*P2PBlock)
+	if !ok {
+		return fmt.Errorf("unsupported block type %T", block)
+	}
+
+	err := bc.Blockchain.AddBlock(p2pBlock.Block)
+	if errors.Is(err, transaction.ErrOrphan) {
+		return fmt.Errorf("%w: %v", network.ErrOrphanBlock, err)
+	}
+	if errors.Is(err, transaction.ErrInvalidBlock) {
+		return fmt.Errorf("%w: %v", network.ErrInvalidBlock, err)
+	}
+
+	return err
+}
+
+// DeserializeBlock decodes a block received from the P2P layer
+func (bc *P2PBlockchain) DeserializeBlock(data []byte) (network.BlockInterface, error) {
+	block, err := transaction.DeserializeBlock(data)
+	if err != nil {
+		return nil, err
+	}
+
+	return &P2PBlock{Block: block}, nil
+}
+
+// CalculateFee returns the fee paid by a transaction for the P2P layer
+func (bc *P2PBlockchain) CalculateFee(tx network.TransactionInterface) (int64, error) {
+	p2pTx, ok := tx.(*P2PTransaction)
+	if !ok {
+		return 0, fmt.Errorf("unsupported transaction type %T", tx)
+	}
+
+	fee, err := bc.Blockchain.CalculateFee(p2pTx.Transaction)
+	if err != nil {
+		return 0, err
+	}
+
+	return int64(fee), nil
+}
+
+// CheckCoinbaseMaturity rejects transactions spending immature coinbase outputs for the P2P layer
+func (bc *P2PBlockchain) CheckCoinbaseMaturity(tx network.TransactionInterface) error {
+	p2pTx, ok := tx.(*P2PTransaction)
+	if !ok {
+		return fmt.Errorf("unsupported transaction type %T", tx)
+	}
+
+	return bc.Blockchain.CheckCoinbaseMaturity(p2pTx.Transaction)
+}
+
+// CheckTimeLocks rejects transactions that are still timelocked for the P2P layer
+func (bc *P2PBlockchain) CheckTimeLocks(tx network.TransactionInterface) error {
+	p2pTx, ok := tx.(*P2PTransaction)
+	if !ok {
+		return fmt.Errorf("unsupported transaction type %T", tx)
+	}
+
+	return bc.Blockchain.CheckTimeLocks(p2pTx.Transaction)
+}
+
+// VerifyTransaction checks the signatures of a transaction for the P2P layer
+func (bc *P2PBlockchain) VerifyTransaction(tx network.TransactionInterface) error {
+	p2pTx, ok := tx.(*P2PTransaction)
+	if !ok {
+		return fmt.Errorf("unsupported transaction type %T", tx)
+	}
+
+	return bc.Blockchain.VerifyTransaction(p2pTx.Transaction)
+}
+
+// DeserializeTransaction decodes a transaction received from the P2P layer
+func (bc *P2PBlockchain) DeserializeTransaction(data []byte) (network.TransactionInterface, error) {
+	tx, err := transaction.DeserializeTransaction(data)
+	if err != nil {
+		return nil, err
+	}
+
+	return &P2PTransaction{Transaction: tx}, nil
+}
+
+// FundTransaction builds the unsigned payment asked for by a wallet and
+// returns it as a serialized PSBT, so wallets never open the node database
+func (bc *P2PBlockchain) FundTransaction(req network.FundTxData) ([]byte, error) {
+	selector, err := transaction.CoinSelectorByName(req.Strategy)
+	if err != nil {
+		return nil, err
+	}
+	if err := wallet.ValidateAddress(req.To); err != nil {
+		return nil, err
+	}
+
+	tx, err := transaction.NewUnsignedTransaction(req.From, req.To, req.Amount, req.FeeRate, selector, &transaction.UTXOSet{Blockchain: bc.Blockchain})
+	if err != nil {
+		return nil, err
+	}
+	p, err := bc.NewPSBT(tx)
+	if err != nil {
+		return nil, err
+	}
+
+	return p.Serialize(), nil
+}
+
+// ScanWallet returns the balance of every key hash and the transactions
+// involving any of them, so wallets never open the node database
+func (bc *P2PBlockchain) ScanWallet(pubKeyHashes [][]byte) (network.WalletInfoData, error) {
+	var info network.WalletInfoData
+	UTXOSet := transaction.UTXOSet{Blockchain: bc.Blockchain}
+
+	owned := make(map[string]bool)
+	for _, pubKeyHash := range pubKeyHashes {
+		balance := 0
+		for _, out := range UTXOSet.FindUTXO(pubKeyHash) {
+			balance += out.Value
 		}
-		hashes = append(hashes, block.Hash)
+		info.Balances = append(info.Balances, balance)
+		owned[hex.EncodeToString(pubKeyHash)] = true
+	}
+
+	for _, tx := range bc.FindWalletTransactions(owned) {
+		info.History = append(info.History, network.WalletTxData{
+			TxID:     tx.TxID,
+			Height:   tx.Height,
+			Coinbase: tx.Coinbase,
+			Received: tx.Received,
+			Sent:     tx.Sent,
+		})
 	}
 
-	return hashes
+	return info, nil
 }
 
-// GetBlock returns a block by its hash for the P2P layer
-func (bc *P2PBlockchain) GetBlock(blockHash []byte) (network.BlockInterface, error) {
-	// In a real implementation, this would efficiently look up blocks by hash
-	// For now, iterate through all blocks to find the matching hash
-	iterator := bc.Blockchain.Iterator()
+// UsedPubKeyHashes returns every key hash ever paid on chain
+func (bc *P2PBlockchain) UsedPubKeyHashes() ([][]byte, error) {
+	var used [][]byte
+	for encoded := range bc.FindUsedPubKeyHashes() {
+		pubKeyHash, err := hex.DecodeString(encoded)
+		if err != nil {
+			return nil, err
+		}
+		used = append(used, pubKeyHash)
+	}
+
+	return used, nil
+}
 
+// P2PMiner implements the network.BlockMiner, paying block rewards and
+// fees to Address
+type P2PMiner struct {
+	Blockchain *transaction.Blockchain
+	Address    string
+}
+
+// MineBlock mines the transactions behind a coinbase on top of the best
+// chain, stopping when ctx is cancelled
+func (m *P2PMiner) MineBlock(ctx context.Context, transactions []network.TransactionInterface) (network.BlockInterface, error) {
+	txs := make([]*transaction.Transaction, 0, len(transactions)+1)
+	txs = append(txs, nil)
+	for _, tx := range transactions {
+		p2pTx, ok := tx.(*P2PTransaction)
+		if !ok {
+			return nil, fmt.Errorf("unsupported transaction type %T", tx)
+		}
+		txs = append(txs, p2pTx.Transaction)
+	}
+
+	// Transactions may spend outputs of earlier ones in the block
+	fees, err := m.Blockchain.TotalFees(txs[1:])
+	if err != nil {
+		return nil, err
+	}
+	// The coinbase commits to the height, so it is built again when another
+	// block moved the tip before mining started
 	for {
-		block := iterator.Next()
-		if block == nil {
-			break
+		txs[0], err = transaction.NewCoinbaseTX(m.Address, "", m.Blockchain.GetBestHeight()+1, fees)
+		if err != nil {
+			return nil, err
 		}
 
-		// Compare hashes
-		if string(block.Hash) == string(blockHash) {
-			return &P2PBlock{Block: block}, nil
+		block, err := m.Blockchain.MineBlockContext(ctx, txs)
+		if errors.Is(err, transaction.ErrBadCoinbase) && ctx.Err() == nil {
+			continue
+		}
+		if err != nil {
+			return nil, err
 		}
-	}
 
-	return nil, fmt.Errorf("block not found")
+		return &P2PBlock{Block: block}, nil
+	}
 }
 
-// AddBlock adds a block to the blockchain for the P2P layer
-func (bc *P2PBlockchain) AddBlock(block network.BlockInterface) {
-	// In a real implementation, this would properly validate and add the block
-	fmt.Printf("Would add block with hash: %x\n", block.GetHash())
+// reportHashrate prints how fast a block was mined
+func reportHashrate(result *pow.Result) {
+	fmt.Printf("Solved block with nonce %d: %d hashes in %s (%.0f H/s)\n",
+		result.Nonce, result.Hashes, result.Elapsed.Round(time.Millisecond), result.Hashrate())
 }
 
 // P2PBlock implements the network.BlockInterface
@@ -85,8 +256,17 @@ func (b *P2PBlock) GetHash() []byte {
 
 // GetHeight returns the block height
 func (b *P2PBlock) GetHeight() int {
-	// In a real implementation, blocks would have height information
-	return 0
+	return b.Block.Height
+}
+
+// GetTransactionIDs returns the IDs of the block transactions
+func (b *P2PBlock) GetTransactionIDs() [][]byte {
+	ids := make([][]byte, 0, len(b.Block.Transactions))
+	for _, tx := range b.Block.Transactions {
+		ids = append(ids, tx.ID)
+	}
+
+	return ids
 }
 
 // Serialize serializes the block
@@ -94,12 +274,36 @@ func (b *P2PBlock) Serialize() []byte {
 	return b.Block.Serialize()
 }
 
+// P2PTransaction implements the network.TransactionInterface
+type P2PTransaction struct {
+	*transaction.Transaction
+}
+
+// GetID returns the transaction ID
+func (tx *P2PTransaction) GetID() []byte {
+	return tx.Transaction.ID
+}
+
+// GetInputs returns the outputs the transaction spends
+func (tx *P2PTransaction) GetInputs() []network.Outpoint {
+	if tx.IsCoinbase() {
+		return nil
+	}
+
+	inputs := make([]network.Outpoint, 0, len(tx.Vin))
+	for _, vin := range tx.Vin {
+		inputs = append(inputs, network.Outpoint{TxID: vin.Txid, Vout: vin.Vout})
+	}
+
+	return inputs
+}
+
 // CLI functions for blockchain-seven pattern
 func startNodeCommand(args []string) {
 	if len(args) < 2 {
-		fmt.Println("Usage: startnode <port> [bootstrap_nodes...]")
+		fmt.Println("Usage: startnode <port> [-miner <address>] [bootstrap_nodes...]")
 		fmt.Println("Example: startnode 3000")
-		fmt.Println("Example: startnode 3001 localhost:3000")
+		fmt.Println("Example: startnode 3001 -miner 1A1zP1eP5QGefi2DMPTfTL5SLmv7DivfNa localhost:3000")
 		return
 	}
 
@@ -107,11 +311,23 @@ func startNodeCommand(args []string) {
 	address := "localhost:" + port
 	nodeID := "node_" + port
 
-	// Parse bootstrap nodes
-	var bootstrapNodes []string
-	if len(args) > 2 {
-		bootstrapNodes = args[2:]
+	// Parse the mining address and bootstrap nodes
+	var minerAddress string
+	rest := args[2:]
+	if len(rest) > 0 && rest[0] == "-miner" {
+		if len(rest) < 2 {
+			fmt.Println("Missing mining address after -miner")
+			return
+		}
+		minerAddress = rest[1]
+		rest = rest[2:]
+
+		if err := wallet.ValidateAddress(minerAddress); err != nil {
+			fmt.Printf("Invalid mining address: %v\n", err)
+			return
+		}
 	}
+	bootstrapNodes := rest
 
 	fmt.Printf("Starting node %s on %s\n", nodeID, address)
 	if len(bootstrapNodes) > 0 {
@@ -119,8 +335,7 @@ func startNodeCommand(args []string) {
 	}
 
 	// Create blockchain
-	walletFile := fmt.Sprintf("wallet_%s.dat", port)
-	bc := transaction.NewBlockchain(walletFile)
+	bc := openNodeBlockchain(port)
 	// Note: In a production environment, we would need to properly close the database
 
 	// Wrap blockchain for P2P interface
@@ -128,6 +343,25 @@ func startNodeCommand(args []string) {
 
 	// Create P2P server
 	server := network.NewServer(address, nodeID, p2pBlockchain)
+	server.Funder = p2pBlockchain
+	server.Scanner = p2pBlockchain
+
+	// Mine mempool transactions, stopping whenever a competing block arrives
+	if minerAddress != "" {
+		transaction.SetMiner(pow.ReportingMiner{Miner: pow.DefaultMiner, Report: reportHashrate})
+		server.Miner = &P2PMiner{Blockchain: bc, Address: minerAddress}
+		fmt.Printf("Mining to %s\n", minerAddress)
+	}
+
+	// Put transactions dropped by a reorganization back into the mempool
+	bc.SetReorgHandler(func(resurrected []*transaction.Transaction) {
+		for _, tx := range resurrected {
+			err := server.MempoolMgr.AddTransaction(&P2PTransaction{Transaction: tx})
+			if err != nil {
+				log.Printf("Failed to resurrect transaction %x: %v", tx.ID, err)
+			}
+		}
+	})
 
 	// Set up graceful shutdown
 	sigChan := make(chan os.Signal, 1)
@@ -240,26 +474,747 @@ func listPeersCommand(args []string) {
 }
 
 func sendTxCommand(args []string) {
-	if len(args) < 5 {
-		fmt.Println("Usage: sendtx <port> <from> <to> <amount>")
-		fmt.Println("Example: sendtx 3000 alice bob 10")
+	if len(args) < 6 {
+		fmt.Println("Usage: sendtx <port> <from> <to> <amount> <feerate> [largest|smallest|bnb|random]")
+		fmt.Println("Example: sendtx 3000 <from_address> <to_address> 10 5 smallest")
 		return
 	}
 
 	port := args[1]
 	from := args[2]
 	to := args[3]
-	amountStr := args[4]
 
-	amount, err := strconv.Atoi(amountStr)
+	amount, err := strconv.Atoi(args[4])
 	if err != nil {
-		fmt.Printf("Invalid amount: %s\n", amountStr)
+		fmt.Printf("Invalid amount: %s\n", args[4])
+		return
+	}
+	feeRate, err := strconv.Atoi(args[5])
+	if err != nil {
+		fmt.Printf("Invalid fee rate: %s\n", args[5])
+		return
+	}
+
+	strategy := "bnb"
+	if len(args) > 6 {
+		strategy = args[6]
+	}
+	if _, err := transaction.CoinSelectorByName(strategy); err != nil {
+		fmt.Printf("Invalid coin selection strategy: %s\n", strategy)
+		return
+	}
+	if err := wallet.ValidateAddress(to); err != nil {
+		fmt.Printf("Invalid recipient: %v\n", err)
 		return
 	}
 
+	wallets, err := loadedWalletOf(port, from)
+	if err != nil {
+		fmt.Printf("Cannot use wallet: %v (load it with loadwallet)\n", err)
+		return
+	}
+	signer, err := wallets.Signer(from)
+	if errors.Is(err, wallet.ErrWalletLocked) {
+		fmt.Println("The wallet is encrypted. Unlock it with walletpassphrase first.")
+		return
+	}
+	if err != nil {
+		fmt.Printf("Cannot sign with %s: %v\n", from, err)
+		return
+	}
+
+	// The running node holds the database, so it chooses the coins and the
+	// wallet only signs
 	address := "localhost:" + port
-	fmt.Printf("Sending transaction through node %s: %s -> %s (%d)\n", address, from, to, amount)
-	fmt.Println("Note: This would create and broadcast a transaction through the P2P network")
+	p, err := requestPSBT(address, from, to, amount, feeRate, strategy)
+	if err != nil {
+		fmt.Printf("Cannot create transaction: %v\n", err)
+		return
+	}
+	_, err = p.Sign(signer)
+	if err == nil {
+		err = p.Finalize()
+	}
+	if err != nil {
+		fmt.Printf("Cannot sign transaction: %v\n", err)
+		return
+	}
+	tx, err := p.Extract()
+	if err != nil {
+		fmt.Printf("Cannot sign transaction: %v\n", err)
+		return
+	}
+
+	err = submitTransaction(address, tx)
+	if err != nil {
+		fmt.Printf("Cannot send transaction to %s: %v\n", address, err)
+		return
+	}
+
+	fmt.Printf("Transaction %x sent to %s: %s -> %s (%d, %s coin selection)\n", tx.ID, address, from, to, amount, strategy)
+}
+
+func createPSBTCommand(args []string) {
+	if len(args) < 7 {
+		fmt.Println("Usage: createpsbt <port> <from> <to> <amount> <feerate> <file> [largest|smallest|bnb|random]")
+		fmt.Println("Example: createpsbt 3000 alice bob 10 5 payment.psbt")
+		return
+	}
+
+	amount, err := strconv.Atoi(args[4])
+	if err != nil {
+		fmt.Printf("Invalid amount: %s\n", args[4])
+		return
+	}
+	feeRate, err := strconv.Atoi(args[5])
+	if err != nil {
+		fmt.Printf("Invalid fee rate: %s\n", args[5])
+		return
+	}
+
+	strategy := "bnb"
+	if len(args) > 7 {
+		strategy = args[7]
+	}
+	if _, err := transaction.CoinSelectorByName(strategy); err != nil {
+		fmt.Printf("Invalid coin selection strategy: %s\n", strategy)
+		return
+	}
+	if err := wallet.ValidateAddress(args[3]); err != nil {
+		fmt.Printf("Invalid recipient: %v\n", err)
+		return
+	}
+
+	p, err := requestPSBT("localhost:"+args[1], args[2], args[3], amount, feeRate, strategy)
+	if err != nil {
+		fmt.Printf("Cannot create PSBT: %v\n", err)
+		return
+	}
+	fee, err := p.Fee()
+	if err != nil {
+		fmt.Printf("Cannot create PSBT: %v\n", err)
+		return
+	}
+
+	err = writePSBTFile(args[6], p)
+	if err != nil {
+		fmt.Printf("Cannot write PSBT: %v\n", err)
+		return
+	}
+
+	fmt.Printf("Unsigned transaction %x (fee %d) written to %s\n", p.Tx.ID, fee, args[6])
+	fmt.Printf("Sign it with: go run *.go 6 signpsbt %s\n", args[6])
+}
+
+// nodeStores holds the wallet store of each node used in this session.
+// Wallets are loaded by this CLI session, not by the node, which only
+// answers their fundtx, scanwallet and getused requests.
+var nodeStores = make(map[string]*wallet.Store)
+
+// nodeDataDir is the directory holding the wallets of the node on port
+func nodeDataDir(port string) string {
+	return "node_" + port
+}
+
+// nodeWalletStore returns the named wallets of the node on port, so nodes
+// on one machine keep separate wallets
+func nodeWalletStore(port string) *wallet.Store {
+	store, ok := nodeStores[port]
+	if !ok {
+		store = wallet.NewStore(nodeDataDir(port))
+		nodeStores[port] = store
+	}
+
+	return store
+}
+
+// loadedWalletOf returns the loaded wallet of the node on port that holds
+// address
+func loadedWalletOf(port, address string) (*wallet.Wallets, error) {
+	store := nodeWalletStore(port)
+	for _, name := range store.Loaded() {
+		wallets, err := store.Get(name)
+		if err != nil {
+			return nil, err
+		}
+		if wallets.HasAddress(address) {
+			return wallets, nil
+		}
+	}
+
+	return nil, fmt.Errorf("no loaded wallet of node %s holds %s", port, address)
+}
+
+// parseKeyTypeArg returns the key type named by args[i], ECDSA when absent
+func parseKeyTypeArg(args []string, i int) (wallet.KeyType, bool) {
+	if len(args) <= i {
+		return wallet.KeyTypeECDSA, true
+	}
+
+	kt, err := wallet.ParseKeyType(args[i])
+	if err != nil {
+		fmt.Printf("Invalid key type: %s (use ecdsa or ed25519)\n", args[i])
+		return 0, false
+	}
+
+	return kt, true
+}
+
+func createWalletCommand(args []string) {
+	if len(args) < 3 || len(args) > 4 || (len(args) == 4 && args[3] != "-encrypt") {
+		fmt.Println("Usage: createwallet <port> <name> [-encrypt]")
+		fmt.Println("Example: createwallet 3000 alice -encrypt")
+		return
+	}
+
+	// Ask before creating the wallet so a mistyped passphrase leaves nothing behind
+	var passphrase string
+	if len(args) == 4 {
+		var ok bool
+		passphrase, ok = readNewPassphrase()
+		if !ok {
+			return
+		}
+	}
+
+	wallets, err := nodeWalletStore(args[1]).Create(args[2])
+	if err != nil {
+		fmt.Printf("Cannot create wallet: %v\n", err)
+		return
+	}
+	if passphrase != "" {
+		err = wallets.Encrypt(passphrase)
+		if err != nil {
+			fmt.Printf("Cannot encrypt wallet: %v\n", err)
+			return
+		}
+	}
+
+	address, err := wallets.CreateWalletWithKeyType(wallet.KeyTypeECDSA)
+	if err != nil {
+		fmt.Printf("Cannot create address: %v\n", err)
+		return
+	}
+	wallets.SaveToFile()
+
+	fmt.Printf("Created and loaded wallet %s (%s)\n", args[2], wallets.Path())
+	if mnemonic, err := wallets.Mnemonic(); err == nil {
+		fmt.Println("Write down these words, they restore every address:")
+		fmt.Printf("  %s\n", mnemonic)
+	}
+	fmt.Printf("First address: %s\n", address)
+}
+
+func loadWalletCommand(args []string) {
+	if len(args) < 3 {
+		fmt.Println("Usage: loadwallet <port> <name>")
+		return
+	}
+
+	wallets, err := nodeWalletStore(args[1]).Load(args[2])
+	if err != nil {
+		fmt.Printf("Cannot load wallet: %v\n", err)
+		return
+	}
+
+	state := "unencrypted"
+	if wallets.IsLocked() {
+		state = "locked"
+	}
+	fmt.Printf("Loaded wallet %s with %d addresses (%s)\n", args[2], len(wallets.GetAddresses()), state)
+}
+
+func unloadWalletCommand(args []string) {
+	if len(args) < 3 {
+		fmt.Println("Usage: unloadwallet <port> <name>")
+		return
+	}
+
+	err := nodeWalletStore(args[1]).Unload(args[2])
+	if err != nil {
+		fmt.Printf("Cannot unload wallet: %v\n", err)
+		return
+	}
+
+	fmt.Printf("Unloaded wallet %s\n", args[2])
+}
+
+func listWalletsCommand(args []string) {
+	if len(args) < 2 {
+		fmt.Println("Usage: listwallets <port>")
+		return
+	}
+
+	store := nodeWalletStore(args[1])
+	names, err := store.List()
+	if err != nil {
+		fmt.Printf("Cannot list wallets: %v\n", err)
+		return
+	}
+	if len(names) == 0 {
+		fmt.Printf("No wallets in %s\n", store.Dir())
+		return
+	}
+
+	loaded := make(map[string]bool)
+	for _, name := range store.Loaded() {
+		loaded[name] = true
+	}
+
+	fmt.Printf("Wallets in %s:\n", store.Dir())
+	for _, name := range names {
+		if loaded[name] {
+			fmt.Printf("  %s (loaded)\n", name)
+		} else {
+			fmt.Printf("  %s\n", name)
+		}
+	}
+}
+
+func getNewAddressCommand(args []string) {
+	if len(args) < 3 {
+		fmt.Println("Usage: getnewaddress <port> <name> [ecdsa|ed25519]")
+		return
+	}
+
+	keyType, ok := parseKeyTypeArg(args, 3)
+	if !ok {
+		return
+	}
+
+	wallets, err := nodeWalletStore(args[1]).Get(args[2])
+	if err != nil {
+		fmt.Printf("Cannot use wallet: %v (load it with loadwallet)\n", err)
+		return
+	}
+	address, err := wallets.CreateWalletWithKeyType(keyType)
+	if errors.Is(err, wallet.ErrWalletLocked) {
+		fmt.Println("The wallet is encrypted. Unlock it with walletpassphrase first.")
+		return
+	}
+	if err != nil {
+		fmt.Printf("Cannot create address: %v\n", err)
+		return
+	}
+	wallets.SaveToFile()
+
+	fmt.Printf("New address: %s (%s)\n", address, wallets.GetWallet(address).Path)
+}
+
+func restoreWalletCommand(args []string) {
+	if len(args) < 15 {
+		fmt.Println("Usage: restorewallet <port> <name> <word...>")
+		fmt.Println("Example: restorewallet 3000 alice abandon abandon ... about")
+		return
+	}
+
+	mnemonic := strings.Join(args[3:], " ")
+	err := wallet.ValidateMnemonic(mnemonic)
+	if err != nil {
+		fmt.Printf("Cannot restore wallet: %v\n", err)
+		return
+	}
+
+	// The running node holds the database, so it tells which addresses
+	// were used. It is asked first so no wallet is left half restored.
+	used, err := requestUsedPubKeyHashes("localhost:" + args[1])
+	if err != nil {
+		fmt.Printf("Cannot discover addresses: %v\n", err)
+		return
+	}
+
+	wallets, err := nodeWalletStore(args[1]).Create(args[2])
+	if err != nil {
+		fmt.Printf("Cannot restore wallet: %v\n", err)
+		return
+	}
+	err = wallets.InitHD(mnemonic, "")
+	if err != nil {
+		fmt.Printf("Cannot restore wallet: %v\n", err)
+		return
+	}
+
+	isUsed := func(pubKeyHash []byte) bool {
+		return used[hex.EncodeToString(pubKeyHash)]
+	}
+	for _, kt := range []wallet.KeyType{wallet.KeyTypeECDSA, wallet.KeyTypeEd25519} {
+		found, err := wallets.Discover(kt, wallet.DefaultGapLimit, isUsed)
+		if err != nil {
+			fmt.Printf("Cannot discover %s addresses: %v\n", kt, err)
+			return
+		}
+		fmt.Printf("Found %d used %s addresses\n", found, kt)
+	}
+	wallets.SaveToFile()
+
+	fmt.Printf("Wallet %s restored with %d addresses\n", args[2], len(wallets.GetAddresses()))
+	fmt.Println("Protect it with: encryptwallet <port> <name>")
+}
+
+func encryptWalletCommand(args []string) {
+	if len(args) < 3 {
+		fmt.Println("Usage: encryptwallet <port> <name>")
+		return
+	}
+
+	wallets, err := nodeWalletStore(args[1]).Get(args[2])
+	if err != nil {
+		fmt.Printf("Cannot use wallet: %v (load it with loadwallet)\n", err)
+		return
+	}
+	passphrase, ok := readNewPassphrase()
+	if !ok {
+		return
+	}
+	err = wallets.Encrypt(passphrase)
+	if err != nil {
+		fmt.Printf("Cannot encrypt wallet: %v\n", err)
+		return
+	}
+	wallets.SaveToFile()
+
+	fmt.Printf("Wallet %s encrypted\n", args[2])
+}
+
+func walletPassphraseCommand(args []string) {
+	if len(args) < 4 {
+		fmt.Println("Usage: walletpassphrase <port> <name> <seconds>")
+		return
+	}
+
+	seconds, err := strconv.Atoi(args[3])
+	if err != nil || seconds <= 0 {
+		fmt.Printf("Invalid timeout: %s\n", args[3])
+		return
+	}
+
+	wallets, err := nodeWalletStore(args[1]).Get(args[2])
+	if err != nil {
+		fmt.Printf("Cannot use wallet: %v (load it with loadwallet)\n", err)
+		return
+	}
+	passphrase := readPassphrase("Wallet passphrase: ", "WALLET_PASSPHRASE")
+	err = wallets.Unlock(passphrase, time.Duration(seconds)*time.Second)
+	if err != nil {
+		fmt.Printf("Cannot unlock wallet: %v\n", err)
+		return
+	}
+
+	fmt.Printf("Wallet %s unlocked for %d seconds\n", args[2], seconds)
+}
+
+func walletLockCommand(args []string) {
+	if len(args) < 3 {
+		fmt.Println("Usage: walletlock <port> <name>")
+		return
+	}
+
+	wallets, err := nodeWalletStore(args[1]).Get(args[2])
+	if err != nil {
+		fmt.Printf("Cannot use wallet: %v\n", err)
+		return
+	}
+	err = wallets.Lock()
+	if err != nil {
+		fmt.Printf("Cannot lock wallet: %v\n", err)
+		return
+	}
+
+	fmt.Printf("Wallet %s locked\n", args[2])
+}
+
+func importPrivKeyCommand(args []string) {
+	if len(args) < 4 {
+		fmt.Println("Usage: importprivkey <port> <name> <wif>")
+		return
+	}
+
+	wallets, err := nodeWalletStore(args[1]).Get(args[2])
+	if err != nil {
+		fmt.Printf("Cannot use wallet: %v (load it with loadwallet)\n", err)
+		return
+	}
+	address, err := wallets.ImportWIF(args[3])
+	if errors.Is(err, wallet.ErrWalletLocked) {
+		fmt.Println("The wallet is encrypted. Unlock it with walletpassphrase first.")
+		return
+	}
+	if err != nil {
+		fmt.Printf("Cannot import key: %v\n", err)
+		return
+	}
+	wallets.SaveToFile()
+
+	fmt.Printf("Imported address %s\n", address)
+}
+
+func importAddressCommand(args []string) {
+	if len(args) < 4 {
+		fmt.Println("Usage: importaddress <port> <name> <address|pubkey>")
+		return
+	}
+
+	wallets, err := nodeWalletStore(args[1]).Get(args[2])
+	if err != nil {
+		fmt.Printf("Cannot use wallet: %v (load it with loadwallet)\n", err)
+		return
+	}
+
+	// Hex public keys can't be mistaken for Base58 addresses, which never
+	// contain a 0, or Bech32 addresses, whose prefixes aren't hex
+	var address string
+	pubKey, hexErr := hex.DecodeString(args[3])
+	if hexErr == nil {
+		address, err = wallets.ImportPubKey(pubKey)
+	} else {
+		address, err = wallets.ImportAddress(args[3])
+	}
+	if err != nil {
+		fmt.Printf("Cannot import address: %v\n", err)
+		return
+	}
+	wallets.SaveToFile()
+
+	fmt.Printf("Watching address %s\n", address)
+}
+
+func getBalanceCommand(args []string) {
+	if len(args) < 3 {
+		fmt.Println("Usage: getbalance <port> <name>")
+		return
+	}
+
+	wallets, err := nodeWalletStore(args[1]).Get(args[2])
+	if err != nil {
+		fmt.Printf("Cannot use wallet: %v (load it with loadwallet)\n", err)
+		return
+	}
+
+	addresses := wallets.GetAddresses()
+	info, err := requestWalletInfo("localhost:"+args[1], wallets, addresses)
+	if err != nil {
+		fmt.Printf("Cannot get balance: %v\n", err)
+		return
+	}
+
+	total := 0
+	for i, address := range addresses {
+		balance := info.Balances[i]
+		total += balance
+
+		if wallets.GetWallet(address).WatchOnly {
+			fmt.Printf("  %s: %d (watch-only)\n", address, balance)
+		} else {
+			fmt.Printf("  %s: %d\n", address, balance)
+		}
+	}
+	fmt.Printf("Balance of wallet %s: %d\n", args[2], total)
+}
+
+func listTransactionsCommand(args []string) {
+	if len(args) < 3 {
+		fmt.Println("Usage: listtransactions <port> <name>")
+		return
+	}
+
+	wallets, err := nodeWalletStore(args[1]).Get(args[2])
+	if err != nil {
+		fmt.Printf("Cannot use wallet: %v (load it with loadwallet)\n", err)
+		return
+	}
+
+	info, err := requestWalletInfo("localhost:"+args[1], wallets, wallets.GetAddresses())
+	if err != nil {
+		fmt.Printf("Cannot list transactions: %v\n", err)
+		return
+	}
+	if len(info.History) == 0 {
+		fmt.Printf("Wallet %s has no transactions\n", args[2])
+		return
+	}
+
+	for _, tx := range info.History {
+		kind := "send"
+		if tx.Coinbase {
+			kind = "generate"
+		} else if tx.Received > tx.Sent {
+			kind = "receive"
+		}
+		fmt.Printf("  %x height %d %s %+d\n", tx.TxID, tx.Height, kind, tx.Received-tx.Sent)
+	}
+}
+
+func broadcastPSBTCommand(args []string) {
+	if len(args) < 3 {
+		fmt.Println("Usage: broadcastpsbt <port> <file>")
+		fmt.Println("Example: broadcastpsbt 3000 payment.psbt")
+		return
+	}
+
+	p, err := readPSBTFile(args[2])
+	if err != nil {
+		fmt.Printf("Cannot read PSBT: %v\n", err)
+		return
+	}
+	if !p.IsComplete() {
+		err = p.Finalize()
+		if err != nil {
+			fmt.Printf("Cannot finalize PSBT: %v\n", err)
+			return
+		}
+	}
+	tx, err := p.Extract()
+	if err != nil {
+		fmt.Printf("Cannot extract transaction: %v\n", err)
+		return
+	}
+
+	address := "localhost:" + args[1]
+	err = submitTransaction(address, tx)
+	if err != nil {
+		fmt.Printf("Cannot send transaction to %s: %v\n", address, err)
+		return
+	}
+
+	fmt.Printf("Transaction %x sent to %s\n", tx.ID, address)
+}
+
+// requestPSBT asks the node at address for an unsigned transaction paying
+// amount from one address to another
+func requestPSBT(address, from, to string, amount, feeRate int, strategy string) (*transaction.PSBT, error) {
+	conn, err := net.Dial("tcp", address)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	req := network.FundTxData{
+		AddrFrom: "cli",
+		From:     from,
+		To:       to,
+		Amount:   amount,
+		FeeRate:  feeRate,
+		Strategy: strategy,
+	}
+	err = network.WriteMessage(conn, network.Message{
+		Command: network.CmdFundTx,
+		Data:    network.GobEncode(req),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	msg, err := network.ReadMessage(conn)
+	if err != nil {
+		return nil, err
+	}
+	if msg.Command != network.CmdPSBT {
+		return nil, fmt.Errorf("unexpected %s reply", msg.Command)
+	}
+	var reply network.PSBTData
+	network.GobDecode(msg.Data, &reply)
+	if reply.Error != "" {
+		return nil, errors.New(reply.Error)
+	}
+
+	return transaction.DeserializePSBT(reply.PSBT)
+}
+
+// requestWalletInfo asks the node at address for the balances of the given
+// addresses of wallets, in order, and the history of all of them
+func requestWalletInfo(address string, wallets *wallet.Wallets, addresses []string) (*network.WalletInfoData, error) {
+	conn, err := net.Dial("tcp", address)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	req := network.ScanWalletData{AddrFrom: "cli"}
+	for _, addr := range addresses {
+		req.PubKeyHashes = append(req.PubKeyHashes, wallets.GetWallet(addr).KeyHash())
+	}
+	err = network.WriteMessage(conn, network.Message{
+		Command: network.CmdScanWallet,
+		Data:    network.GobEncode(req),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	msg, err := network.ReadMessage(conn)
+	if err != nil {
+		return nil, err
+	}
+	if msg.Command != network.CmdWalletInfo {
+		return nil, fmt.Errorf("unexpected %s reply", msg.Command)
+	}
+	var reply network.WalletInfoData
+	network.GobDecode(msg.Data, &reply)
+	if reply.Error != "" {
+		return nil, errors.New(reply.Error)
+	}
+	if len(reply.Balances) != len(addresses) {
+		return nil, fmt.Errorf("got %d balances for %d addresses", len(reply.Balances), len(addresses))
+	}
+
+	return &reply, nil
+}
+
+// requestUsedPubKeyHashes asks the node at address for the hex encoded key
+// hashes ever paid on chain
+func requestUsedPubKeyHashes(address string) (map[string]bool, error) {
+	conn, err := net.Dial("tcp", address)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	err = network.WriteMessage(conn, network.Message{
+		Command: network.CmdGetUsed,
+		Data:    network.GobEncode(network.GetUsedData{AddrFrom: "cli"}),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	msg, err := network.ReadMessage(conn)
+	if err != nil {
+		return nil, err
+	}
+	if msg.Command != network.CmdUsed {
+		return nil, fmt.Errorf("unexpected %s reply", msg.Command)
+	}
+	var reply network.UsedData
+	network.GobDecode(msg.Data, &reply)
+	if reply.Error != "" {
+		return nil, errors.New(reply.Error)
+	}
+
+	used := make(map[string]bool)
+	for _, pubKeyHash := range reply.PubKeyHashes {
+		used[hex.EncodeToString(pubKeyHash)] = true
+	}
+
+	return used, nil
+}
+
+// submitTransaction sends a signed transaction to the node at address
+func submitTransaction(address string, tx *transaction.Transaction) error {
+	conn, err := net.Dial("tcp", address)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	txData := network.TxData{
+		AddrFrom:    "cli",
+		Transaction: tx.Serialize(),
+	}
+
+	return network.WriteMessage(conn, network.Message{
+		Command: network.CmdTx,
+		Data:    network.GobEncode(txData),
+	})
 }
 
 func mineBlockCommand(args []string) {
@@ -290,17 +1245,119 @@ func syncStatusCommand(args []string) {
 	fmt.Println("Note: This would query the running node's synchronization status")
 }
 
+func reindexCommand(args []string) {
+	if len(args) < 2 {
+		fmt.Println("Usage: reindex <port>")
+		fmt.Println("Example: reindex 3000")
+		return
+	}
+
+	port := args[1]
+	bc, err := openOfflineBlockchain(port)
+	if err != nil {
+		fmt.Printf("Cannot reindex: %v\n", err)
+		return
+	}
+	defer bc.Close()
+
+	fmt.Printf("Rebuilding transaction index for node %s\n", port)
+	err = bc.EnableTxIndex()
+	if err != nil {
+		fmt.Printf("Reindex failed: %v\n", err)
+		return
+	}
+
+	fmt.Println("Transaction index rebuilt")
+}
+
+func printChainCommand(args []string) {
+	if len(args) < 2 {
+		fmt.Println("Usage: printchain <port>")
+		fmt.Println("Example: printchain 3000")
+		return
+	}
+
+	bc, err := openOfflineBlockchain(args[1])
+	if err != nil {
+		fmt.Printf("Cannot print chain: %v\n", err)
+		return
+	}
+	defer bc.Close()
+
+	bci := bc.ForwardIterator()
+
+	for block := bci.Next(); block != nil; block = bci.Next() {
+		fmt.Printf("============ Block %x ============\n", block.Hash)
+		fmt.Printf("Height: %d\n", block.Height)
+		fmt.Printf("Timestamp: %d\n", block.Timestamp)
+		fmt.Printf("PrevBlockHash: %x\n", block.PrevBlockHash)
+		fmt.Printf("MerkleRoot: %x\n", block.MerkleRoot)
+		fmt.Printf("Bits: %08x\n", block.Bits)
+		fmt.Printf("PoW: %s\n", strconv.FormatBool(block.ValidatePoW()))
+		for _, tx := range block.Transactions {
+			fmt.Println(tx)
+		}
+		fmt.Println()
+	}
+}
+
+// openNodeBlockchain opens the blockchain database of the node on the given
+// port. Databases of nodes that named theirs after a wallet file are still
+// found.
+func openNodeBlockchain(port string) *transaction.Blockchain {
+	nodeID := "node_" + port
+	legacyID := fmt.Sprintf("wallet_%s.dat", port)
+	if !transaction.BlockchainExists(nodeID) && transaction.BlockchainExists(legacyID) {
+		nodeID = legacyID
+	}
+
+	return transaction.NewBlockchain(nodeID)
+}
+
+// openOfflineBlockchain opens the blockchain database of the node on the
+// given port for commands that need it to themselves. A running node holds
+// the database lock, so they are refused while it answers on its port.
+func openOfflineBlockchain(port string) (*transaction.Blockchain, error) {
+	conn, err := net.DialTimeout("tcp", "localhost:"+port, time.Second)
+	if err == nil {
+		conn.Close()
+		return nil, fmt.Errorf("node %s is running, stop it first", port)
+	}
+
+	return openNodeBlockchain(port), nil
+}
+
 // runBlockchainSeven demonstrates P2P blockchain functionality
 func runBlockchainSeven() {
 	fmt.Println("=== Blockchain Pattern 7: P2P Network Layer ===")
 	fmt.Println("Available commands:")
-	fmt.Println("  startnode <port> [bootstrap_nodes...] - Start a P2P node")
+	fmt.Println("  startnode <port> [-miner <address>] [bootstrap_nodes...]")
+	fmt.Println("                                        - Start a P2P node, mining to address if given")
 	fmt.Println("  nodeinfo <port>                       - Get node information")
 	fmt.Println("  connectpeer <local_port> <peer_addr>  - Connect to a peer")
 	fmt.Println("  listpeers <port>                      - List connected peers")
-	fmt.Println("  sendtx <port> <from> <to> <amount>    - Send transaction")
+	fmt.Println("  sendtx <port> <from> <to> <amount> <feerate> [strategy]")
+	fmt.Println("                                        - Sign a payment from a loaded wallet and send it to the node")
+	fmt.Println("  createpsbt <port> <from> <to> <amount> <feerate> <file> [strategy]")
+	fmt.Println("                                        - Create an unsigned PSBT for offline signing")
+	fmt.Println("  broadcastpsbt <port> <file>           - Finalize a signed PSBT and send it to a node")
+	fmt.Println("  createwallet <port> <name> [-encrypt] - Create and load a named wallet of the node")
+	fmt.Println("  loadwallet <port> <name>              - Load a named wallet")
+	fmt.Println("  unloadwallet <port> <name>            - Unload a named wallet")
+	fmt.Println("  listwallets <port>                    - List the wallets of the node")
+	fmt.Println("  getnewaddress <port> <name> [ecdsa|ed25519] - Derive the next address of a wallet")
+	fmt.Println("  restorewallet <port> <name> <word...> - Restore a wallet from its seed phrase")
+	fmt.Println("  encryptwallet <port> <name>           - Encrypt a loaded wallet")
+	fmt.Println("  walletpassphrase <port> <name> <secs> - Unlock a wallet for a while")
+	fmt.Println("  walletlock <port> <name>              - Lock a wallet again")
+	fmt.Println("  importprivkey <port> <name> <wif>     - Import a private key into a wallet")
+	fmt.Println("  importaddress <port> <name> <address|pubkey> - Watch an address without its key")
+	fmt.Println("  getbalance <port> <name>              - Show the balance of a wallet")
+	fmt.Println("  listtransactions <port> <name>        - List the transactions of a wallet")
 	fmt.Println("  mineblock <port>                      - Mine a new block")
 	fmt.Println("  syncstatus <port>                     - Get sync status")
+	fmt.Println("  reindex <port>                        - Rebuild the transaction index of a stopped node")
+	fmt.Println("  printchain <port>                     - Print the chain of a stopped node from genesis")
 	fmt.Println("  help                                  - Show this help")
 	fmt.Println("  exit                                  - Exit program")
 	fmt.Println()
@@ -310,10 +1367,17 @@ func runBlockchainSeven() {
 	fmt.Println("3. In another terminal: startnode 3002 localhost:3000 localhost:3001")
 	fmt.Println()
 
+	// Share the reader with passphrase prompts so neither buffers input
+	// meant for the other
+	reader := passphraseReader
+
 	for {
 		fmt.Print("blockchain7> ")
-		var input string
-		fmt.Scanln(&input)
+		input, err := reader.ReadString('\n')
+		if err != nil {
+			fmt.Println()
+			return
+		}
 
 		args := strings.Fields(input)
 		if len(args) == 0 {
@@ -332,19 +1396,73 @@ func runBlockchainSeven() {
 			listPeersCommand(args)
 		case "sendtx":
 			sendTxCommand(args)
+		case "createpsbt":
+			createPSBTCommand(args)
+		case "broadcastpsbt":
+			broadcastPSBTCommand(args)
+		case "createwallet":
+			createWalletCommand(args)
+		case "loadwallet":
+			loadWalletCommand(args)
+		case "unloadwallet":
+			unloadWalletCommand(args)
+		case "listwallets":
+			listWalletsCommand(args)
+		case "getnewaddress":
+			getNewAddressCommand(args)
+		case "restorewallet":
+			restoreWalletCommand(args)
+		case "encryptwallet":
+			encryptWalletCommand(args)
+		case "walletpassphrase":
+			walletPassphraseCommand(args)
+		case "walletlock":
+			walletLockCommand(args)
+		case "importprivkey":
+			importPrivKeyCommand(args)
+		case "importaddress":
+			importAddressCommand(args)
+		case "getbalance":
+			getBalanceCommand(args)
+		case "listtransactions":
+			listTransactionsCommand(args)
 		case "mineblock":
 			mineBlockCommand(args)
 		case "syncstatus":
 			syncStatusCommand(args)
+		case "reindex":
+			reindexCommand(args)
+		case "printchain":
+			printChainCommand(args)
 		case "help":
 			fmt.Println("Available commands:")
-			fmt.Println("  startnode <port> [bootstrap_nodes...] - Start a P2P node")
+			fmt.Println("  startnode <port> [-miner <address>] [bootstrap_nodes...]")
+			fmt.Println("                                        - Start a P2P node, mining to address if given")
 			fmt.Println("  nodeinfo <port>                       - Get node information")
 			fmt.Println("  connectpeer <local_port> <peer_addr>  - Connect to a peer")
 			fmt.Println("  listpeers <port>                      - List connected peers")
-			fmt.Println("  sendtx <port> <from> <to> <amount>    - Send transaction")
+			fmt.Println("  sendtx <port> <from> <to> <amount> <feerate> [strategy]")
+			fmt.Println("                                        - Sign a payment from a loaded wallet and send it to the node")
+			fmt.Println("  createpsbt <port> <from> <to> <amount> <feerate> <file> [strategy]")
+			fmt.Println("                                        - Create an unsigned PSBT for offline signing")
+			fmt.Println("  broadcastpsbt <port> <file>           - Finalize a signed PSBT and send it to a node")
+			fmt.Println("  createwallet <port> <name> [-encrypt] - Create and load a named wallet of the node")
+			fmt.Println("  loadwallet <port> <name>              - Load a named wallet")
+			fmt.Println("  unloadwallet <port> <name>            - Unload a named wallet")
+			fmt.Println("  listwallets <port>                    - List the wallets of the node")
+			fmt.Println("  getnewaddress <port> <name> [ecdsa|ed25519] - Derive the next address of a wallet")
+			fmt.Println("  restorewallet <port> <name> <word...> - Restore a wallet from its seed phrase")
+			fmt.Println("  encryptwallet <port> <name>           - Encrypt a loaded wallet")
+			fmt.Println("  walletpassphrase <port> <name> <secs> - Unlock a wallet for a while")
+			fmt.Println("  walletlock <port> <name>              - Lock a wallet again")
+			fmt.Println("  importprivkey <port> <name> <wif>     - Import a private key into a wallet")
+			fmt.Println("  importaddress <port> <name> <address|pubkey> - Watch an address without its key")
+			fmt.Println("  getbalance <port> <name>              - Show the balance of a wallet")
+			fmt.Println("  listtransactions <port> <name>        - List the transactions of a wallet")
 			fmt.Println("  mineblock <port>                      - Mine a new block")
 			fmt.Println("  syncstatus <port>                     - Get sync status")
+			fmt.Println("  reindex <port>                        - Rebuild the transaction index")
+			fmt.Println("  printchain <port>                     - Print the chain from genesis")
 			fmt.Println("  help                                  - Show this help")
 			fmt.Println("  exit                                  - Exit program")
 		case "exit":
@@ -1,9 +1,15 @@
 package main
 
 import (
+	"bufio"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"os"
+	"strconv"
+	"strings"
 
+	"blockchain-app/transaction"
 	"blockchain-app/wallet"
 )
 
@@ -13,14 +19,28 @@ func RunBlockchainSix() {
 	fmt.Println("Key Features Implemented:")
 	fmt.Println("  ✓ Transaction structure (TxInput, TxOutput, Transaction)")
 	fmt.Println("  ✓ UTXO (Unspent Transaction Output) model")
-	fmt.Println("  ✓ Digital signature framework (ECDSA)")
+	fmt.Println("  ✓ Digital signature framework (ECDSA, Ed25519)")
 	fmt.Println("  ✓ Coinbase transactions (initial coin creation)")
 	fmt.Println("  ✓ Transaction validation and verification")
 	fmt.Println("  ✓ Wallet integration")
 	fmt.Println()
 	fmt.Println("Available wallet commands:")
-	fmt.Println("  go run *.go 6 createwallet")
+	fmt.Println("  go run *.go 6 createwallet [ecdsa|ed25519]")
 	fmt.Println("  go run *.go 6 listaddresses")
+	fmt.Println("  go run *.go 6 showmnemonic")
+	fmt.Println("  go run *.go 6 encryptwallet")
+	fmt.Println("  go run *.go 6 changepassphrase")
+	fmt.Println("  go run *.go 6 dumpprivkey <address>")
+	fmt.Println("  go run *.go 6 importprivkey <wif>")
+	fmt.Println("  go run *.go 6 importpubkey <hex>")
+	fmt.Println("  go run *.go 6 importaddress <address>")
+	fmt.Println("  go run *.go 6 validateaddress <address>")
+	fmt.Println("  go run *.go 6 createmultisig <required> <address...>")
+	fmt.Println("  go run *.go 6 decodepsbt <file>")
+	fmt.Println("  go run *.go 6 signpsbt <file> [address...]")
+	fmt.Println("  go run *.go 6 combinepsbt <out_file> <file...>")
+	fmt.Println("  go run *.go 6 finalizepsbt <file>")
+	fmt.Println("Set WALLET_FILE to use another wallet file than wallet.json.")
 	fmt.Println()
 
 	if len(os.Args) < 3 {
@@ -31,26 +51,101 @@ func RunBlockchainSix() {
 
 	switch command {
 	case "createwallet":
-		createWalletTX()
+		createWalletTX(os.Args[3:])
 	case "listaddresses":
 		listAddressesTX()
+	case "showmnemonic":
+		showMnemonicTX()
+	case "encryptwallet":
+		encryptWalletTX()
+	case "changepassphrase":
+		changePassphraseTX()
+	case "dumpprivkey":
+		dumpPrivKeyTX(os.Args[3:])
+	case "importprivkey":
+		importPrivKeyTX(os.Args[3:])
+	case "importpubkey":
+		importPubKeyTX(os.Args[3:])
+	case "importaddress":
+		importAddressTX(os.Args[3:])
+	case "validateaddress":
+		validateAddressTX(os.Args[3:])
+	case "createmultisig":
+		createMultiSigTX(os.Args[3:])
+	case "decodepsbt":
+		decodePSBTTX(os.Args[3:])
+	case "signpsbt":
+		signPSBTTX(os.Args[3:])
+	case "combinepsbt":
+		combinePSBTTX(os.Args[3:])
+	case "finalizepsbt":
+		finalizePSBTTX(os.Args[3:])
 	default:
 		fmt.Printf("Unknown command: %s\n", command)
-		fmt.Println("Available commands: createwallet, listaddresses")
+		fmt.Println("Available commands: createwallet, listaddresses, showmnemonic, encryptwallet, changepassphrase, dumpprivkey, importprivkey, importpubkey, importaddress, validateaddress, createmultisig, decodepsbt, signpsbt, combinepsbt, finalizepsbt")
 	}
 }
 
 
-func createWalletTX() {
-	wallets, _ := wallet.NewWallets()
-	address := wallets.CreateWallet()
+func createWalletTX(args []string) {
+	keyType := wallet.KeyTypeECDSA
+	if len(args) > 0 {
+		kt, err := wallet.ParseKeyType(args[0])
+		if err != nil {
+			fmt.Printf("Invalid key type: %s (use ecdsa or ed25519)\n", args[0])
+			return
+		}
+		keyType = kt
+	}
+
+	wallets, err := openWallets()
+	if os.IsNotExist(err) {
+		// New wallet files are encrypted from the start
+		passphrase, ok := readNewPassphrase()
+		if !ok {
+			return
+		}
+		err = wallets.Encrypt(passphrase)
+		if err != nil {
+			fmt.Printf("Cannot encrypt wallet: %v\n", err)
+			return
+		}
+	} else if !unlockWallets(wallets) {
+		return
+	}
+
+	newSeed := !wallets.HasSeed()
+	address, err := wallets.CreateWalletWithKeyType(keyType)
+	if err != nil {
+		fmt.Printf("Cannot create wallet: %v\n", err)
+		return
+	}
 	wallets.SaveToFile()
 
-	fmt.Printf("Your new address: %s\n", address)
+	if mnemonic, err := wallets.Mnemonic(); newSeed && err == nil {
+		fmt.Println("Created a new wallet seed. Write down these words, they restore every address:")
+		fmt.Printf("  %s\n", mnemonic)
+	}
+	fmt.Printf("Your new address: %s (%s)\n", address, wallets.GetWallet(address).Path)
+}
+
+func showMnemonicTX() {
+	wallets, _ := openWallets()
+	if !unlockWallets(wallets) {
+		return
+	}
+	mnemonic, err := wallets.Mnemonic()
+	if err != nil {
+		fmt.Println("The wallet has no seed. Create a wallet first.")
+		return
+	}
+
+	fmt.Println("Wallet seed phrase:")
+	fmt.Printf("  %s\n", mnemonic)
 }
 
 func listAddressesTX() {
-	wallets, _ := wallet.NewWallets()
+	wallets, _ := openWallets()
 	addresses := wallets.GetAddresses()
 
 	if len(addresses) == 0 {
@@ -60,6 +155,431 @@ func listAddressesTX() {
 
 	fmt.Println("Wallet addresses:")
 	for _, address := range addresses {
-		fmt.Printf("  %s\n", address)
+		w := wallets.GetWallet(address)
+		switch {
+		case w.WatchOnly:
+			fmt.Printf("  %s (watch-only)\n", address)
+		case w.Path != "":
+			fmt.Printf("  %s %s\n", address, w.Path)
+		default:
+			fmt.Printf("  %s\n", address)
+		}
+	}
+}
+
+func createMultiSigTX(args []string) {
+	if len(args) < 2 {
+		fmt.Println("Usage: createmultisig <required> <address...>")
+		return
+	}
+
+	required, err := strconv.Atoi(args[0])
+	if err != nil {
+		fmt.Printf("Invalid signature count: %s\n", args[0])
+		return
+	}
+
+	wallets, _ := openWallets()
+
+	var pubKeys [][]byte
+	for _, address := range args[1:] {
+		if !wallets.HasAddress(address) {
+			fmt.Printf("Address %s is not in the wallet file\n", address)
+			return
+		}
+		pubKeys = append(pubKeys, wallets.GetWallet(address).PublicKey)
+	}
+
+	ms, err := wallet.NewMultiSig(required, pubKeys)
+	if err != nil {
+		fmt.Printf("Cannot create multisig: %v\n", err)
+		return
+	}
+
+	fmt.Printf("Multisig address (%d of %d): %s\n", required, len(pubKeys), ms.GetAddress())
+	fmt.Printf("Redeem script: %x\n", ms.RedeemScript)
+}
+
+func encryptWalletTX() {
+	wallets, err := openWallets()
+	if err != nil {
+		fmt.Println("No wallet file found. Create a wallet first.")
+		return
+	}
+	if wallets.IsEncrypted() {
+		fmt.Println("The wallet is already encrypted. Use changepassphrase to change its passphrase.")
+		return
+	}
+
+	passphrase, ok := readNewPassphrase()
+	if !ok {
+		return
+	}
+	err = wallets.Encrypt(passphrase)
+	if err != nil {
+		fmt.Printf("Cannot encrypt wallet: %v\n", err)
+		return
+	}
+	wallets.SaveToFile()
+
+	fmt.Println("Wallet encrypted. Its private keys and seed now need the passphrase.")
+}
+
+func changePassphraseTX() {
+	wallets, err := openWallets()
+	if err != nil || !wallets.IsEncrypted() {
+		fmt.Println("The wallet is not encrypted. Use encryptwallet to set a passphrase.")
+		return
+	}
+
+	oldPassphrase := readPassphrase("Current passphrase: ", "WALLET_PASSPHRASE")
+	newPassphrase, ok := readNewPassphrase()
+	if !ok {
+		return
+	}
+	err = wallets.ChangePassphrase(oldPassphrase, newPassphrase)
+	if err != nil {
+		fmt.Printf("Cannot change passphrase: %v\n", err)
+		return
+	}
+	wallets.SaveToFile()
+
+	fmt.Println("Passphrase changed.")
+}
+
+func dumpPrivKeyTX(args []string) {
+	if len(args) < 1 {
+		fmt.Println("Usage: dumpprivkey <address>")
+		return
+	}
+
+	wallets, _ := openWallets()
+	if !unlockWallets(wallets) {
+		return
+	}
+	wif, err := wallets.ExportWIF(args[0])
+	if err != nil {
+		fmt.Printf("Cannot export key of %s: %v\n", args[0], err)
+		return
+	}
+
+	fmt.Println(wif)
+}
+
+func importPrivKeyTX(args []string) {
+	if len(args) < 1 {
+		fmt.Println("Usage: importprivkey <wif>")
+		return
+	}
+
+	wallets, err := openWallets()
+	if os.IsNotExist(err) {
+		passphrase, ok := readNewPassphrase()
+		if !ok {
+			return
+		}
+		err = wallets.Encrypt(passphrase)
+		if err != nil {
+			fmt.Printf("Cannot encrypt wallet: %v\n", err)
+			return
+		}
+	} else if !unlockWallets(wallets) {
+		return
+	}
+
+	address, err := wallets.ImportWIF(args[0])
+	if err != nil {
+		fmt.Printf("Cannot import key: %v\n", err)
+		return
+	}
+	wallets.SaveToFile()
+
+	fmt.Printf("Imported address %s\n", address)
+}
+
+func importPubKeyTX(args []string) {
+	if len(args) < 1 {
+		fmt.Println("Usage: importpubkey <hex>")
+		return
+	}
+
+	pubKey, err := hex.DecodeString(args[0])
+	if err != nil {
+		fmt.Printf("Invalid public key: %s\n", args[0])
+		return
+	}
+
+	wallets, _ := openWallets()
+	address, err := wallets.ImportPubKey(pubKey)
+	if err != nil {
+		fmt.Printf("Cannot import public key: %v\n", err)
+		return
+	}
+	wallets.SaveToFile()
+
+	fmt.Printf("Watching address %s\n", address)
+}
+
+func importAddressTX(args []string) {
+	if len(args) < 1 {
+		fmt.Println("Usage: importaddress <address>")
+		return
+	}
+
+	wallets, _ := openWallets()
+	address, err := wallets.ImportAddress(args[0])
+	if err != nil {
+		fmt.Printf("Cannot import address: %v\n", err)
+		return
 	}
-}
\ No newline at end of file
+	wallets.SaveToFile()
+
+	fmt.Printf("Watching address %s\n", address)
+}
+
+func validateAddressTX(args []string) {
+	if len(args) < 1 {
+		fmt.Println("Usage: validateaddress <address>")
+		return
+	}
+
+	addrType, hash, err := wallet.DecodeAddress(args[0])
+	if err != nil {
+		fmt.Printf("Address is not valid on %s: %v\n", wallet.NetParams().Name, err)
+		return
+	}
+
+	fmt.Printf("Address is valid on %s\n", wallet.NetParams().Name)
+	if addrType == wallet.ScriptHashAddress {
+		fmt.Printf("  Pays to script hash: %x\n", hash)
+	} else {
+		fmt.Printf("  Pays to public key hash: %x\n", hash)
+	}
+	fmt.Printf("  Base58: %s\n", wallet.EncodeAddress(addrType, hash))
+	fmt.Printf("  Bech32: %s\n", wallet.EncodeBech32Address(addrType, hash))
+}
+
+// openWallets loads the wallet file named by WALLET_FILE, wallet.json by
+// default
+func openWallets() (*wallet.Wallets, error) {
+	path := os.Getenv("WALLET_FILE")
+	if path == "" {
+		path = wallet.DefaultWalletFile
+	}
+
+	return wallet.NewWalletsFromFile(path)
+}
+
+// passphraseReader reads passphrases typed on stdin
+var passphraseReader = bufio.NewReader(os.Stdin)
+
+// readPassphrase returns the passphrase in the environment variable env, or
+// prompts for it on stdin
+func readPassphrase(prompt, env string) string {
+	if passphrase, ok := os.LookupEnv(env); ok {
+		return passphrase
+	}
+
+	fmt.Print(prompt)
+	line, _ := passphraseReader.ReadString('\n')
+
+	return strings.TrimRight(line, "\r\n")
+}
+
+// readNewPassphrase reads a new passphrase from WALLET_NEW_PASSPHRASE, or
+// twice from stdin
+func readNewPassphrase() (string, bool) {
+	if passphrase, ok := os.LookupEnv("WALLET_NEW_PASSPHRASE"); ok {
+		return passphrase, true
+	}
+
+	passphrase := readPassphrase("New passphrase: ", "")
+	if passphrase == "" {
+		fmt.Println("The passphrase must not be empty")
+		return "", false
+	}
+	if readPassphrase("Repeat passphrase: ", "") != passphrase {
+		fmt.Println("The passphrases don't match")
+		return "", false
+	}
+
+	return passphrase, true
+}
+
+// unlockWallets asks for the passphrase of encrypted wallets and unlocks
+// them for the rest of the command
+func unlockWallets(wallets *wallet.Wallets) bool {
+	if !wallets.IsLocked() {
+		return true
+	}
+
+	passphrase := readPassphrase("Wallet passphrase: ", "WALLET_PASSPHRASE")
+	err := wallets.Unlock(passphrase, 0)
+	if err != nil {
+		fmt.Printf("Cannot unlock wallet: %v\n", err)
+		return false
+	}
+
+	return true
+}
+
+// readPSBTFile reads a base64 encoded PSBT from a file
+func readPSBTFile(path string) (*transaction.PSBT, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return transaction.DecodePSBTBase64(strings.TrimSpace(string(data)))
+}
+
+// writePSBTFile writes a PSBT to a file as base64. Only the owner may read
+// it since it reveals the wallet's coins and, once signed, its signatures.
+func writePSBTFile(path string, p *transaction.PSBT) error {
+	return wallet.WriteFileAtomic(path, []byte(p.Base64()+"\n"), 0600)
+}
+
+func decodePSBTTX(args []string) {
+	if len(args) < 1 {
+		fmt.Println("Usage: decodepsbt <file>")
+		return
+	}
+
+	p, err := readPSBTFile(args[0])
+	if err != nil {
+		fmt.Printf("Cannot read PSBT: %v\n", err)
+		return
+	}
+
+	fmt.Println(p.Tx)
+	fee, err := p.Fee()
+	if err != nil {
+		fmt.Printf("Fee: %v\n", err)
+	} else {
+		fmt.Printf("Fee: %d\n", fee)
+	}
+	for inID, in := range p.Inputs {
+		status := fmt.Sprintf("%d signature(s)", len(in.PartialSigs))
+		if in.FinalScriptSig != nil {
+			status = "finalized"
+		}
+		fmt.Printf("Input %d: spends %d, %s\n", inID, in.PrevOut.Value, status)
+	}
+}
+
+func signPSBTTX(args []string) {
+	if len(args) < 1 {
+		fmt.Println("Usage: signpsbt <file> [address...]")
+		return
+	}
+
+	p, err := readPSBTFile(args[0])
+	if err != nil {
+		fmt.Printf("Cannot read PSBT: %v\n", err)
+		return
+	}
+
+	wallets, _ := openWallets()
+	if !unlockWallets(wallets) {
+		return
+	}
+	addresses := args[1:]
+	if len(addresses) == 0 {
+		for _, address := range wallets.GetAddresses() {
+			if !wallets.GetWallet(address).WatchOnly {
+				addresses = append(addresses, address)
+			}
+		}
+	}
+
+	signed := 0
+	for _, address := range addresses {
+		signer, err := wallets.Signer(address)
+		if err != nil {
+			fmt.Printf("Cannot sign with %s: %v\n", address, err)
+			return
+		}
+
+		n, err := p.Sign(signer)
+		if errors.Is(err, transaction.ErrNotSigner) {
+			continue
+		}
+		if err != nil {
+			fmt.Printf("Cannot sign with %s: %v\n", address, err)
+			return
+		}
+		fmt.Printf("Signed %d input(s) with %s\n", n, address)
+		signed += n
+	}
+
+	if signed == 0 {
+		fmt.Println("No wallet key can sign this PSBT")
+		return
+	}
+
+	err = writePSBTFile(args[0], p)
+	if err != nil {
+		fmt.Printf("Cannot write PSBT: %v\n", err)
+	}
+}
+
+func combinePSBTTX(args []string) {
+	if len(args) < 2 {
+		fmt.Println("Usage: combinepsbt <out_file> <file...>")
+		return
+	}
+
+	var combined *transaction.PSBT
+	for _, path := range args[1:] {
+		p, err := readPSBTFile(path)
+		if err != nil {
+			fmt.Printf("Cannot read %s: %v\n", path, err)
+			return
+		}
+
+		if combined == nil {
+			combined = p
+			continue
+		}
+		err = combined.Combine(p)
+		if err != nil {
+			fmt.Printf("Cannot combine %s: %v\n", path, err)
+			return
+		}
+	}
+
+	err := writePSBTFile(args[0], combined)
+	if err != nil {
+		fmt.Printf("Cannot write PSBT: %v\n", err)
+		return
+	}
+
+	fmt.Printf("Combined %d PSBT(s) into %s\n", len(args)-1, args[0])
+}
+
+func finalizePSBTTX(args []string) {
+	if len(args) < 1 {
+		fmt.Println("Usage: finalizepsbt <file>")
+		return
+	}
+
+	p, err := readPSBTFile(args[0])
+	if err != nil {
+		fmt.Printf("Cannot read PSBT: %v\n", err)
+		return
+	}
+
+	err = p.Finalize()
+	if err != nil {
+		fmt.Printf("Cannot finalize PSBT: %v\n", err)
+		return
+	}
+
+	err = writePSBTFile(args[0], p)
+	if err != nil {
+		fmt.Printf("Cannot write PSBT: %v\n", err)
+		return
+	}
+
+	fmt.Printf("Finalized transaction %x, broadcast it with broadcastpsbt in pattern 7\n", p.Tx.ID)
+}
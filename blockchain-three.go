@@ -2,19 +2,22 @@ package main
 
 import (
 	"bytes"
-	"crypto/sha256"
+	"context"
 	"encoding/gob"
 	"fmt"
 	"log"
-	"math"
 	"math/big"
 	"os"
 	"strconv"
 	"time"
 
 	"github.com/dgraph-io/badger/v3"
+
+	powengine "blockchain-app/pow"
 )
 
+// targetBitsPersistent counts leading zero bits like Pattern 2. It stays
+// fixed so databases written by this pattern keep loading.
 const targetBitsPersistent = 16
 const dbFile = "./blockchain.db"
 
@@ -24,7 +27,7 @@ type BlockPersistent struct {
 	PrevBlockHash []byte
 	Hash          []byte
 	Nonce         int
-	Difficulty    int
+	Difficulty    int // leading zero bits, not compact bits
 }
 
 type BlockchainPersistent struct {
@@ -67,14 +70,13 @@ func DeserializeBlock(d []byte) *BlockPersistent {
 }
 
 func NewProofOfWorkPersistent(b *BlockPersistent) *ProofOfWorkPersistent {
-	target := big.NewInt(1)
-	target.Lsh(target, uint(256-targetBitsPersistent))
+	target := powengine.NewTarget(targetBitsPersistent)
 
 	pow := &ProofOfWorkPersistent{b, target}
 	return pow
 }
 
-func (pow *ProofOfWorkPersistent) prepareData(nonce int) []byte {
+func (pow *ProofOfWorkPersistent) PrepareData(nonce int) []byte {
 	data := bytes.Join(
 		[][]byte{
 			pow.block.PrevBlockHash,
@@ -88,38 +90,24 @@ func (pow *ProofOfWorkPersistent) prepareData(nonce int) []byte {
 	return data
 }
 
-func (pow *ProofOfWorkPersistent) Run() (int, []byte) {
-	var hashInt big.Int
-	var hash [32]byte
-	nonce := 0
+func (pow *ProofOfWorkPersistent) Target() *big.Int {
+	return pow.target
+}
 
+func (pow *ProofOfWorkPersistent) Run() (int, []byte) {
 	fmt.Printf("Mining the block containing \"%s\"\n", pow.block.Data)
-	for nonce < math.MaxInt64 {
-		data := pow.prepareData(nonce)
-		hash = sha256.Sum256(data)
-		hashInt.SetBytes(hash[:])
-
-		if hashInt.Cmp(pow.target) == -1 {
-			fmt.Printf("\r%x", hash)
-			break
-		} else {
-			nonce++
-		}
+	result, err := powengine.DefaultMiner.Mine(context.Background(), pow)
+	if err != nil {
+		log.Panic(err)
 	}
+	fmt.Printf("\r%x", result.Hash)
 	fmt.Print("\n\n")
 
-	return nonce, hash[:]
+	return result.Nonce, result.Hash
 }
 
 func (pow *ProofOfWorkPersistent) Validate() bool {
-	var hashInt big.Int
-
-	data := pow.prepareData(pow.block.Nonce)
-	hash := sha256.Sum256(data)
-	hashInt.SetBytes(hash[:])
-
-	isValid := hashInt.Cmp(pow.target) == -1
-	return isValid
+	return powengine.Validate(pow, pow.block.Nonce)
 }
 
 func NewBlockPersistent(data string, prevBlockHash []byte) *BlockPersistent {
@@ -2,14 +2,19 @@ package main
 
 import (
 	"bytes"
-	"crypto/sha256"
+	"context"
 	"fmt"
-	"math"
+	"log"
 	"math/big"
 	"strconv"
 	"time"
+
+	powengine "blockchain-app/pow"
 )
 
+// targetBits is the fixed number of leading zero bits blocks of this
+// pattern need. The pattern predates compact bits and retargeting in the
+// pow and transaction packages and is kept as it was on purpose.
 const targetBits = 24
 
 type BlockPoW struct {
@@ -18,7 +23,7 @@ type BlockPoW struct {
 	PrevBlockHash []byte
 	Hash          []byte
 	Nonce         int
-	Difficulty    int
+	Difficulty    int // leading zero bits, not compact bits
 }
 
 type BlockchainPoW struct {
@@ -31,14 +36,13 @@ type ProofOfWork struct {
 }
 
 func NewProofOfWork(b *BlockPoW) *ProofOfWork {
-	target := big.NewInt(1)
-	target.Lsh(target, uint(256-targetBits))
+	target := powengine.NewTarget(targetBits)
 
 	pow := &ProofOfWork{b, target}
 	return pow
 }
 
-func (pow *ProofOfWork) prepareData(nonce int) []byte {
+func (pow *ProofOfWork) PrepareData(nonce int) []byte {
 	data := bytes.Join(
 		[][]byte{
 			pow.block.PrevBlockHash,
@@ -52,38 +56,24 @@ func (pow *ProofOfWork) prepareData(nonce int) []byte {
 	return data
 }
 
-func (pow *ProofOfWork) Run() (int, []byte) {
-	var hashInt big.Int
-	var hash [32]byte
-	nonce := 0
+func (pow *ProofOfWork) Target() *big.Int {
+	return pow.target
+}
 
+func (pow *ProofOfWork) Run() (int, []byte) {
 	fmt.Printf("Mining the block containing \"%s\"\n", pow.block.Data)
-	for nonce < math.MaxInt64 {
-		data := pow.prepareData(nonce)
-		hash = sha256.Sum256(data)
-		hashInt.SetBytes(hash[:])
-
-		if hashInt.Cmp(pow.target) == -1 {
-			fmt.Printf("\r%x", hash)
-			break
-		} else {
-			nonce++
-		}
+	result, err := powengine.DefaultMiner.Mine(context.Background(), pow)
+	if err != nil {
+		log.Panic(err)
 	}
+	fmt.Printf("\r%x", result.Hash)
 	fmt.Print("\n\n")
 
-	return nonce, hash[:]
+	return result.Nonce, result.Hash
 }
 
 func (pow *ProofOfWork) Validate() bool {
-	var hashInt big.Int
-
-	data := pow.prepareData(pow.block.Nonce)
-	hash := sha256.Sum256(data)
-	hashInt.SetBytes(hash[:])
-
-	isValid := hashInt.Cmp(pow.target) == -1
-	return isValid
+	return powengine.Validate(pow, pow.block.Nonce)
 }
 
 func NewBlockPoW(data string, prevBlockHash []byte) *BlockPoW {
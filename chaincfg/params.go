@@ -0,0 +1,120 @@
+package chaincfg
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Params defines the consensus rules of a blockchain network
+type Params struct {
+	Name string
+
+	// PowLimitBits is the compact target of the easiest allowed block,
+	// which is also the difficulty of the genesis block
+	PowLimitBits uint32
+
+	// RetargetInterval is the number of blocks between difficulty adjustments
+	RetargetInterval int
+
+	// TargetTimePerBlock is the desired number of seconds between blocks
+	TargetTimePerBlock int64
+
+	// MaxRetargetFactor limits how much a single adjustment can change the target
+	MaxRetargetFactor int64
+
+	// InitialSubsidy is the reward paid by the coinbase of the first blocks
+	InitialSubsidy int
+
+	// SubsidyHalvingInterval is the number of blocks after which the subsidy halves
+	SubsidyHalvingInterval int
+
+	// CoinbaseMaturity is the number of blocks a coinbase output must wait
+	// before it can be spent
+	CoinbaseMaturity int
+
+	// PubKeyHashAddrID and ScriptHashAddrID are the version bytes of Base58
+	// addresses paying to a public key hash and a script hash
+	PubKeyHashAddrID byte
+	ScriptHashAddrID byte
+
+	// PrivateKeyID is the version byte of private keys in wallet import format
+	PrivateKeyID byte
+
+	// Bech32HRP is the human readable part that starts Bech32 addresses
+	Bech32HRP string
+}
+
+// ErrUnknownNetwork is returned for network names without parameters
+var ErrUnknownNetwork = errors.New("unknown network")
+
+// MainNetParams are the consensus rules of the main network
+var MainNetParams = Params{
+	Name:               "mainnet",
+	PowLimitBits:       0x1f010000, // 16 leading zero bits
+	RetargetInterval:   10,
+	TargetTimePerBlock: 10,
+	MaxRetargetFactor:  4,
+
+	InitialSubsidy:         10,
+	SubsidyHalvingInterval: 210,
+	CoinbaseMaturity:       10,
+
+	PubKeyHashAddrID: 0x00,
+	ScriptHashAddrID: 0x05,
+	PrivateKeyID:     0x80,
+	Bech32HRP:        "bc",
+}
+
+// TestNetParams are the rules of the public test network. They match the
+// main network except for the address and key versions, so coins of one
+// can't be sent to the other by mistake.
+var TestNetParams = Params{
+	Name:               "testnet",
+	PowLimitBits:       0x1f010000,
+	RetargetInterval:   10,
+	TargetTimePerBlock: 10,
+	MaxRetargetFactor:  4,
+
+	InitialSubsidy:         10,
+	SubsidyHalvingInterval: 210,
+	CoinbaseMaturity:       10,
+
+	PubKeyHashAddrID: 0x6f,
+	ScriptHashAddrID: 0xc4,
+	PrivateKeyID:     0xef,
+	Bech32HRP:        "tb",
+}
+
+// RegTestParams are the rules of a private regression test network, whose
+// minimal difficulty mines blocks instantly. Base58 addresses look like
+// those of the test network; Bech32 addresses tell them apart.
+var RegTestParams = Params{
+	Name:               "regtest",
+	PowLimitBits:       0x207fffff,
+	RetargetInterval:   10,
+	TargetTimePerBlock: 10,
+	MaxRetargetFactor:  4,
+
+	InitialSubsidy:         10,
+	SubsidyHalvingInterval: 150,
+	CoinbaseMaturity:       10,
+
+	PubKeyHashAddrID: 0x6f,
+	ScriptHashAddrID: 0xc4,
+	PrivateKeyID:     0xef,
+	Bech32HRP:        "bcrt",
+}
+
+// Networks lists the parameters of every known network
+var Networks = []*Params{&MainNetParams, &TestNetParams, &RegTestParams}
+
+// ParamsByName returns the parameters of the network with the given name
+func ParamsByName(name string) (*Params, error) {
+	for _, params := range Networks {
+		if params.Name == name {
+			return params, nil
+		}
+	}
+
+	return nil, fmt.Errorf("%w: %s", ErrUnknownNetwork, name)
+}
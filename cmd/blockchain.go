@@ -2,18 +2,21 @@ package cmd
 
 import (
 	"bytes"
-	"crypto/sha256"
+	"context"
 	"encoding/gob"
 	"fmt"
 	"log"
-	"math"
 	"math/big"
 	"strconv"
 	"time"
 
 	"github.com/dgraph-io/badger/v3"
+
+	powengine "blockchain-app/pow"
 )
 
+// targetBitsCLI counts leading zero bits like Pattern 3. It stays fixed
+// so the CLI database format doesn't change.
 const targetBitsCLI = 16
 const dbFileCLI = "./blockchain-cli.db"
 
@@ -23,7 +26,7 @@ type BlockCLI struct {
 	PrevBlockHash []byte
 	Hash          []byte
 	Nonce         int
-	Difficulty    int
+	Difficulty    int // leading zero bits, not compact bits
 }
 
 type BlockchainCLI struct {
@@ -66,14 +69,13 @@ func DeserializeBlockCLI(d []byte) *BlockCLI {
 }
 
 func NewProofOfWorkCLI(b *BlockCLI) *ProofOfWorkCLI {
-	target := big.NewInt(1)
-	target.Lsh(target, uint(256-targetBitsCLI))
+	target := powengine.NewTarget(targetBitsCLI)
 
 	pow := &ProofOfWorkCLI{b, target}
 	return pow
 }
 
-func (pow *ProofOfWorkCLI) prepareData(nonce int) []byte {
+func (pow *ProofOfWorkCLI) PrepareData(nonce int) []byte {
 	data := bytes.Join(
 		[][]byte{
 			pow.block.PrevBlockHash,
@@ -87,38 +89,24 @@ func (pow *ProofOfWorkCLI) prepareData(nonce int) []byte {
 	return data
 }
 
-func (pow *ProofOfWorkCLI) Run() (int, []byte) {
-	var hashInt big.Int
-	var hash [32]byte
-	nonce := 0
+func (pow *ProofOfWorkCLI) Target() *big.Int {
+	return pow.target
+}
 
+func (pow *ProofOfWorkCLI) Run() (int, []byte) {
 	fmt.Printf("Mining the block containing \"%s\"\n", pow.block.Data)
-	for nonce < math.MaxInt64 {
-		data := pow.prepareData(nonce)
-		hash = sha256.Sum256(data)
-		hashInt.SetBytes(hash[:])
-
-		if hashInt.Cmp(pow.target) == -1 {
-			fmt.Printf("\r%x", hash)
-			break
-		} else {
-			nonce++
-		}
+	result, err := powengine.DefaultMiner.Mine(context.Background(), pow)
+	if err != nil {
+		log.Panic(err)
 	}
+	fmt.Printf("\r%x", result.Hash)
 	fmt.Print("\n\n")
 
-	return nonce, hash[:]
+	return result.Nonce, result.Hash
 }
 
 func (pow *ProofOfWorkCLI) Validate() bool {
-	var hashInt big.Int
-
-	data := pow.prepareData(pow.block.Nonce)
-	hash := sha256.Sum256(data)
-	hashInt.SetBytes(hash[:])
-
-	isValid := hashInt.Cmp(pow.target) == -1
-	return isValid
+	return powengine.Validate(pow, pow.block.Nonce)
 }
 
 func NewBlockCLI(data string, prevBlockHash []byte) *BlockCLI {
@@ -3,6 +3,10 @@ package main
 import (
 	"fmt"
 	"os"
+
+	"blockchain-app/chaincfg"
+	"blockchain-app/transaction"
+	"blockchain-app/wallet"
 )
 
 func main() {
@@ -16,9 +20,20 @@ func main() {
 		fmt.Println("  5 - Blockchain with Wallet System")
 		fmt.Println("  6 - Blockchain with Transactions and UTXO")
 		fmt.Println("  7 - Blockchain with P2P Network Layer")
+		fmt.Println("Set BLOCKCHAIN_NETWORK to mainnet, testnet or regtest to choose the network.")
 		return
 	}
 
+	if name := os.Getenv("BLOCKCHAIN_NETWORK"); name != "" {
+		params, err := chaincfg.ParamsByName(name)
+		if err != nil {
+			fmt.Printf("%v (use mainnet, testnet or regtest)\n", err)
+			return
+		}
+		wallet.SetNetParams(params)
+		transaction.SetNetParams(params)
+	}
+
 	pattern := os.Args[1]
 
 	switch pattern {
@@ -0,0 +1,138 @@
+package merkle
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+)
+
+// Leaves and inner nodes are hashed with different prefixes so an inner
+// node can never be passed off as a leaf
+const (
+	leafPrefix = byte(0x00)
+	nodePrefix = byte(0x01)
+)
+
+// ErrEmptyTree is returned when a tree is built without any leaves
+var ErrEmptyTree = errors.New("merkle tree has no leaves")
+
+// Tree is a binary merkle tree. When a level has an odd number of nodes the
+// last one is promoted to the next level unchanged instead of duplicated,
+// so two different leaf lists never share a root.
+type Tree struct {
+	levels [][][]byte
+}
+
+// ProofStep is one sibling hash on the path from a leaf to the root
+type ProofStep struct {
+	Hash []byte
+	Left bool
+}
+
+// Proof shows that a leaf is part of a tree with a given root
+type Proof struct {
+	Index int
+	Steps []ProofStep
+}
+
+// NewTree builds a merkle tree from the given leaves
+func NewTree(leaves [][]byte) (*Tree, error) {
+	if len(leaves) == 0 {
+		return nil, ErrEmptyTree
+	}
+
+	level := make([][]byte, len(leaves))
+	for i, leaf := range leaves {
+		level[i] = hashLeaf(leaf)
+	}
+
+	tree := &Tree{levels: [][][]byte{level}}
+	for len(level) > 1 {
+		var next [][]byte
+
+		for i := 0; i < len(level); i += 2 {
+			if i+1 == len(level) {
+				next = append(next, level[i])
+				continue
+			}
+			next = append(next, hashNode(level[i], level[i+1]))
+		}
+
+		tree.levels = append(tree.levels, next)
+		level = next
+	}
+
+	return tree, nil
+}
+
+// Root returns the root hash of the tree
+func (t *Tree) Root() []byte {
+	return t.levels[len(t.levels)-1][0]
+}
+
+// Proof returns the inclusion proof of the leaf at index
+func (t *Tree) Proof(index int) (*Proof, error) {
+	if index < 0 || index >= len(t.levels[0]) {
+		return nil, fmt.Errorf("leaf index %d out of range", index)
+	}
+
+	proof := &Proof{Index: index}
+	pos := index
+
+	for _, level := range t.levels[:len(t.levels)-1] {
+		if pos%2 == 1 {
+			proof.Steps = append(proof.Steps, ProofStep{level[pos-1], true})
+		} else if pos+1 < len(level) {
+			proof.Steps = append(proof.Steps, ProofStep{level[pos+1], false})
+		}
+		pos /= 2
+	}
+
+	return proof, nil
+}
+
+// Root computes the root of the leaf list in a single pass
+func Root(leaves [][]byte) ([]byte, error) {
+	tree, err := NewTree(leaves)
+	if err != nil {
+		return nil, err
+	}
+
+	return tree.Root(), nil
+}
+
+// Verify reports whether the proof links leaf to root
+func Verify(root, leaf []byte, proof *Proof) bool {
+	if proof == nil {
+		return false
+	}
+
+	hash := hashLeaf(leaf)
+	for _, step := range proof.Steps {
+		if step.Left {
+			hash = hashNode(step.Hash, hash)
+		} else {
+			hash = hashNode(hash, step.Hash)
+		}
+	}
+
+	return bytes.Equal(hash, root)
+}
+
+// hashLeaf hashes a leaf of the tree
+func hashLeaf(data []byte) []byte {
+	hash := sha256.Sum256(append([]byte{leafPrefix}, data...))
+	return hash[:]
+}
+
+// hashNode hashes two child nodes into their parent
+func hashNode(left, right []byte) []byte {
+	data := make([]byte, 0, 1+len(left)+len(right))
+	data = append(data, nodePrefix)
+	data = append(data, left...)
+	data = append(data, right...)
+
+	hash := sha256.Sum256(data)
+	return hash[:]
+}
@@ -0,0 +1,178 @@
+package merkle
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"testing"
+)
+
+// testLeaves returns n distinct leaves
+func testLeaves(n int) [][]byte {
+	leaves := make([][]byte, n)
+	for i := range leaves {
+		leaves[i] = []byte(fmt.Sprintf("leaf %d", i))
+	}
+
+	return leaves
+}
+
+func TestRoot(t *testing.T) {
+	a, b, c := []byte("a"), []byte("b"), []byte("c")
+
+	tests := []struct {
+		name   string
+		leaves [][]byte
+		want   []byte
+	}{
+		{"one leaf", [][]byte{a}, hashLeaf(a)},
+		{"two leaves", [][]byte{a, b}, hashNode(hashLeaf(a), hashLeaf(b))},
+		{"odd leaf promoted", [][]byte{a, b, c}, hashNode(hashNode(hashLeaf(a), hashLeaf(b)), hashLeaf(c))},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			root, err := Root(tt.leaves)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(root, tt.want) {
+				t.Errorf("Root = %x, want %x", root, tt.want)
+			}
+		})
+	}
+
+	if _, err := Root(nil); !errors.Is(err, ErrEmptyTree) {
+		t.Errorf("Root of no leaves returned %v, want %v", err, ErrEmptyTree)
+	}
+}
+
+func TestProofs(t *testing.T) {
+	for _, n := range []int{1, 2, 3, 4, 5, 7, 9} {
+		t.Run(fmt.Sprintf("%d leaves", n), func(t *testing.T) {
+			leaves := testLeaves(n)
+			tree, err := NewTree(leaves)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			for i, leaf := range leaves {
+				proof, err := tree.Proof(i)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if n == 1 && len(proof.Steps) != 0 {
+					t.Errorf("proof of a single leaf has %d steps", len(proof.Steps))
+				}
+				if !Verify(tree.Root(), leaf, proof) {
+					t.Errorf("proof of leaf %d does not verify", i)
+				}
+			}
+
+			for _, index := range []int{-1, n} {
+				if _, err := tree.Proof(index); err == nil {
+					t.Errorf("Proof(%d) succeeded", index)
+				}
+			}
+		})
+	}
+}
+
+func TestVerifyRejectsTamperedProofs(t *testing.T) {
+	leaves := testLeaves(5)
+	tree, err := NewTree(leaves)
+	if err != nil {
+		t.Fatal(err)
+	}
+	root := tree.Root()
+
+	// Leaf 2 has a sibling on the first two levels and the promoted leaf 4
+	// on the last
+	proof, err := tree.Proof(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// tamper returns a copy of the proof changed by f
+	tamper := func(f func(p *Proof)) *Proof {
+		p := &Proof{Index: proof.Index}
+		for _, step := range proof.Steps {
+			p.Steps = append(p.Steps, ProofStep{append([]byte(nil), step.Hash...), step.Left})
+		}
+		f(p)
+		return p
+	}
+
+	tests := []struct {
+		name  string
+		leaf  []byte
+		proof *Proof
+	}{
+		{"tampered leaf", []byte("leaf 9"), proof},
+		{"leaf of another proof", leaves[3], proof},
+		{"tampered step hash", leaves[2], tamper(func(p *Proof) { p.Steps[1].Hash[0] ^= 1 })},
+		{"flipped step side", leaves[2], tamper(func(p *Proof) { p.Steps[0].Left = !p.Steps[0].Left })},
+		{"missing step", leaves[2], tamper(func(p *Proof) { p.Steps = p.Steps[:len(p.Steps)-1] })},
+		{"extra step", leaves[2], tamper(func(p *Proof) { p.Steps = append(p.Steps, ProofStep{hashLeaf(nil), false}) })},
+		{"nil proof", leaves[2], nil},
+	}
+
+	if !Verify(root, leaves[2], proof) {
+		t.Fatal("untampered proof does not verify")
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if Verify(root, tt.leaf, tt.proof) {
+				t.Error("Verify accepted the proof")
+			}
+		})
+	}
+}
+
+func TestDifferentLeavesHaveDifferentRoots(t *testing.T) {
+	a, b, c := []byte("a"), []byte("b"), []byte("c")
+
+	// Duplicating the odd node would give these the same root
+	duplicated := [][][]byte{{a, b, c}, {a, b, c, c}}
+
+	// Without the leaf and node prefixes an inner node would pass as a leaf
+	inner := append(hashLeaf(a), hashLeaf(b)...)
+	secondPreimage := [][][]byte{{a, b}, {inner}}
+
+	for _, lists := range [][][][]byte{duplicated, secondPreimage} {
+		first, err := Root(lists[0])
+		if err != nil {
+			t.Fatal(err)
+		}
+		second, err := Root(lists[1])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if bytes.Equal(first, second) {
+			t.Errorf("leaves %q and %q share the root %x", lists[0], lists[1], first)
+		}
+	}
+
+	// Every list of up to five leaves over a small alphabet has its own root
+	seen := make(map[string][][]byte)
+	var walk func(leaves [][]byte)
+	walk = func(leaves [][]byte) {
+		if len(leaves) > 0 {
+			root, err := Root(leaves)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if other, ok := seen[string(root)]; ok {
+				t.Fatalf("leaves %q and %q share the root %x", leaves, other, root)
+			}
+			seen[string(root)] = append([][]byte(nil), leaves...)
+		}
+		if len(leaves) == 5 {
+			return
+		}
+		for _, leaf := range [][]byte{a, b, c} {
+			walk(append(leaves, leaf))
+		}
+	}
+	walk(nil)
+}
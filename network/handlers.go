@@ -1,8 +1,10 @@
 package network
 
 import (
+	"errors"
 	"fmt"
 	"log"
+	"math/big"
 	"net"
 )
 
@@ -13,13 +15,14 @@ func (s *Server) HandleVersion(data []byte, conn net.Conn) {
 
 	fmt.Printf("Received version from %s (height: %d)\n", versionData.AddrFrom, versionData.BestHeight)
 
-	myBestHeight := s.Blockchain.GetBestHeight()
-	foreignerBestHeight := int(versionData.BestHeight)
+	// The chain with the most cumulative work wins, not the longest one
+	myChainWork := s.Blockchain.GetChainWork()
+	foreignerChainWork := new(big.Int).SetBytes(versionData.ChainWork)
 
-	if myBestHeight < foreignerBestHeight {
+	if myChainWork.Cmp(foreignerChainWork) < 0 {
 		// Request blocks from the peer
 		s.SendGetBlocks(versionData.AddrFrom)
-	} else if myBestHeight > foreignerBestHeight {
+	} else if myChainWork.Cmp(foreignerChainWork) > 0 {
 		// Send our version back
 		s.SendVersion(versionData.AddrFrom)
 	}
@@ -44,7 +47,13 @@ func (s *Server) HandleGetBlocks(data []byte, conn net.Conn) {
 
 	fmt.Printf("Received getblocks from %s\n", getBlocksData.AddrFrom)
 
-	blocks := s.Blockchain.GetBlockHashes()
+	// Hashes are sent genesis first so the peer receives parents before children
+	blocks, err := s.Blockchain.GetBlockHashes(0, s.Blockchain.GetBestHeight())
+	if err != nil {
+		log.Printf("Failed to list block hashes: %v", err)
+		return
+	}
+
 	s.SendInv(getBlocksData.AddrFrom, "block", blocks)
 }
 
@@ -56,18 +65,21 @@ func (s *Server) HandleInv(data []byte, conn net.Conn) {
 	fmt.Printf("Received inventory with %d %s\n", len(invData.Items), invData.Type)
 
 	if invData.Type == "block" {
-		blocksInTransit = invData.Items
+		// Only download the blocks we don't have yet
+		blocksInTransit = nil
+		for _, hash := range invData.Items {
+			if _, err := s.Blockchain.GetBlock(hash); err != nil {
+				blocksInTransit = append(blocksInTransit, hash)
+			}
+		}
+		if len(blocksInTransit) == 0 {
+			return
+		}
 
-		blockHash := invData.Items[0]
+		blockHash := blocksInTransit[0]
 		s.SendGetData(invData.AddrFrom, "block", blockHash)
 
-		var newInTransit [][]byte
-		for _, b := range blocksInTransit {
-			if !BytesEqual(b, blockHash) {
-				newInTransit = append(newInTransit, b)
-			}
-		}
-		blocksInTransit = newInTransit
+		blocksInTransit = blocksInTransit[1:]
 	}
 
 	if invData.Type == "tx" {
@@ -116,15 +128,37 @@ func (s *Server) HandleBlock(data []byte, conn net.Conn) {
 
 	fmt.Printf("Received new block from %s\n", blockData.AddrFrom)
 
-	// Deserialize and add block (simplified - would need proper block interface)
-	// This would typically involve:
-	// 1. Deserializing the block
-	// 2. Validating the block
-	// 3. Adding to blockchain
-	// 4. Updating UTXO set
-	// 5. Removing transactions from mempool
+	block, err := s.Blockchain.DeserializeBlock(blockData.Block)
+	if err != nil {
+		log.Printf("Failed to decode block from %s: %v", blockData.AddrFrom, err)
+		s.penalizePeer(blockData.AddrFrom, banScoreMalformedMessage, err.Error())
+		return
+	}
+
+	err = s.Blockchain.AddBlock(block)
+	if errors.Is(err, ErrOrphanBlock) {
+		// Not the peer's fault: we simply haven't seen the parent yet
+		log.Printf("Block %x from %s: %v", block.GetHash(), blockData.AddrFrom, err)
+	} else if errors.Is(err, ErrInvalidBlock) {
+		log.Printf("Rejected block %x from %s: %v", block.GetHash(), blockData.AddrFrom, err)
+		s.penalizePeer(blockData.AddrFrom, banScoreInvalidBlock, err.Error())
+		return
+	} else if err != nil {
+		// A failure of our own, like reading the chain, says nothing
+		// about the block
+		log.Printf("Failed to add block %x from %s: %v", block.GetHash(), blockData.AddrFrom, err)
+		return
+	} else {
+		// A competing block makes the one we are mining stale, so start
+		// over on top of it with what is left in the mempool
+		s.StopMining()
+		fmt.Printf("Added block %x\n", block.GetHash())
 
-	fmt.Printf("Added block. Blocks in transit: %d\n", len(blocksInTransit))
+		s.MempoolMgr.RemoveConfirmedTransactions(block.GetTransactionIDs())
+		s.MineMempool()
+	}
+
+	fmt.Printf("Blocks in transit: %d\n", len(blocksInTransit))
 
 	if len(blocksInTransit) > 0 {
 		blockHash := blocksInTransit[0]
@@ -148,14 +182,126 @@ func (s *Server) HandleTx(data []byte, conn net.Conn) {
 
 	fmt.Printf("Received new transaction from %s\n", txData.AddrFrom)
 
-	// This would involve:
-	// 1. Deserializing transaction
-	// 2. Validating transaction
-	// 3. Adding to mempool
-	// 4. Broadcasting to other nodes
+	tx, err := s.Blockchain.DeserializeTransaction(txData.Transaction)
+	if err != nil {
+		log.Printf("Failed to decode transaction from %s: %v", txData.AddrFrom, err)
+		s.penalizePeer(txData.AddrFrom, banScoreMalformedMessage, err.Error())
+		return
+	}
+
+	// The mempool validates the transaction and relays it to other nodes
+	err = s.MempoolMgr.AddTransaction(tx)
+	if err != nil {
+		log.Printf("Transaction from %s not added to mempool: %v", txData.AddrFrom, err)
+		if errors.Is(err, ErrCoinbaseTransaction) {
+			s.penalizePeer(txData.AddrFrom, banScoreMalformedMessage, err.Error())
+		}
+		return
+	}
+
+	s.MineMempool()
+}
+
+// HandleFundTx handles fundtx messages, answering with an unsigned
+// transaction on the same connection. Funding reveals the coins of any
+// address, so it is only served to wallets on the same machine.
+func (s *Server) HandleFundTx(data []byte, conn net.Conn) {
+	var req FundTxData
+	GobDecode(data, &req)
+
+	fmt.Printf("Received fundtx from %s\n", req.AddrFrom)
+
+	var reply PSBTData
+	if !isLoopback(conn.RemoteAddr()) {
+		log.Printf("Refused fundtx from non-local %s", conn.RemoteAddr())
+		reply.Error = "fundtx is only served to local wallets"
+	} else if s.Funder == nil {
+		reply.Error = "node does not fund transactions"
+	} else if psbt, err := s.Funder.FundTransaction(req); err != nil {
+		reply.Error = err.Error()
+	} else {
+		reply.PSBT = psbt
+	}
+
+	msg := Message{
+		Command: CmdPSBT,
+		Data:    GobEncode(reply),
+	}
+
+	err := WriteMessage(conn, msg)
+	if err != nil {
+		log.Printf("Failed to send psbt: %v", err)
+	}
+}
+
+// HandleScanWallet handles scanwallet messages, answering with the balances
+// and history of the key hashes on the same connection. Like funding, it is
+// only served to wallets on the same machine.
+func (s *Server) HandleScanWallet(data []byte, conn net.Conn) {
+	var req ScanWalletData
+	GobDecode(data, &req)
+
+	fmt.Printf("Received scanwallet from %s\n", req.AddrFrom)
+
+	var reply WalletInfoData
+	if !isLoopback(conn.RemoteAddr()) {
+		log.Printf("Refused scanwallet from non-local %s", conn.RemoteAddr())
+		reply.Error = "scanwallet is only served to local wallets"
+	} else if s.Scanner == nil {
+		reply.Error = "node does not scan wallets"
+	} else if info, err := s.Scanner.ScanWallet(req.PubKeyHashes); err != nil {
+		reply.Error = err.Error()
+	} else {
+		reply = info
+	}
+
+	msg := Message{
+		Command: CmdWalletInfo,
+		Data:    GobEncode(reply),
+	}
 
-	// Simplified implementation
-	fmt.Println("Transaction processed and added to mempool")
+	err := WriteMessage(conn, msg)
+	if err != nil {
+		log.Printf("Failed to send walletinfo: %v", err)
+	}
+}
+
+// HandleGetUsed handles getused messages, answering with every key hash
+// ever paid on chain so a restored wallet can find its addresses. Like
+// funding, it is only served to wallets on the same machine.
+func (s *Server) HandleGetUsed(data []byte, conn net.Conn) {
+	var req GetUsedData
+	GobDecode(data, &req)
+
+	fmt.Printf("Received getused from %s\n", req.AddrFrom)
+
+	var reply UsedData
+	if !isLoopback(conn.RemoteAddr()) {
+		log.Printf("Refused getused from non-local %s", conn.RemoteAddr())
+		reply.Error = "getused is only served to local wallets"
+	} else if s.Scanner == nil {
+		reply.Error = "node does not scan wallets"
+	} else if used, err := s.Scanner.UsedPubKeyHashes(); err != nil {
+		reply.Error = err.Error()
+	} else {
+		reply.PubKeyHashes = used
+	}
+
+	msg := Message{
+		Command: CmdUsed,
+		Data:    GobEncode(reply),
+	}
+
+	err := WriteMessage(conn, msg)
+	if err != nil {
+		log.Printf("Failed to send used: %v", err)
+	}
+}
+
+// isLoopback reports whether addr is a TCP address on the loopback interface
+func isLoopback(addr net.Addr) bool {
+	tcpAddr, ok := addr.(*net.TCPAddr)
+	return ok && tcpAddr.IP.IsLoopback()
 }
 
 // HandlePing handles ping messages
@@ -184,6 +330,7 @@ func (s *Server) SendVersion(addr string) {
 	versionData := VersionData{
 		Version:    1,
 		BestHeight: int32(bestHeight),
+		ChainWork:  s.Blockchain.GetChainWork().Bytes(),
 		AddrFrom:   s.Address,
 	}
 
@@ -288,6 +435,13 @@ func (s *Server) SendTx(addr string, tx TransactionInterface) {
 
 // Helper functions
 
+// penalizePeer reports misbehavior of a peer to the node manager
+func (s *Server) penalizePeer(addr string, score int, reason string) {
+	if s.NodeManager != nil {
+		s.NodeManager.Misbehaving(addr, score, reason)
+	}
+}
+
 // NodeIsKnown checks if a node is in the known nodes list
 func (s *Server) NodeIsKnown(addr string) bool {
 	s.mu.RLock()
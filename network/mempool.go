@@ -1,18 +1,28 @@
 package network
 
 import (
+	"errors"
 	"fmt"
+	"log"
+	"sort"
 	"sync"
 	"time"
 )
 
+// ErrCoinbaseTransaction is returned when a coinbase is offered to the
+// mempool; only a block may create one
+var ErrCoinbaseTransaction = errors.New("coinbase transaction outside a block")
+
 // MempoolManager manages unconfirmed transactions
 type MempoolManager struct {
 	server       *Server
 	transactions map[string]MempoolTransaction
-	mu           sync.RWMutex
-	maxSize      int
-	timeout      time.Duration
+	// spent maps every outpoint spent by a mempool transaction to its ID,
+	// so conflicting transactions are refused
+	spent   map[string]string
+	mu      sync.RWMutex
+	maxSize int
+	timeout time.Duration
 }
 
 // MempoolTransaction represents a transaction in the mempool
@@ -29,6 +39,7 @@ func NewMempoolManager(server *Server) *MempoolManager {
 	return &MempoolManager{
 		server:       server,
 		transactions: make(map[string]MempoolTransaction),
+		spent:        make(map[string]string),
 		maxSize:      1000,           // Maximum number of transactions
 		timeout:      24 * time.Hour, // Transaction timeout
 	}
@@ -36,16 +47,52 @@ func NewMempoolManager(server *Server) *MempoolManager {
 
 // AddTransaction adds a transaction to the mempool
 func (mm *MempoolManager) AddTransaction(tx TransactionInterface) error {
-	mm.mu.Lock()
-	defer mm.mu.Unlock()
-
 	txID := fmt.Sprintf("%x", tx.GetID())
 
 	// Check if transaction already exists
+	if mm.HasTransaction(tx.GetID()) {
+		return fmt.Errorf("transaction %s already in mempool", txID)
+	}
+
+	// Verification reads the chainstate and runs every script, so it is
+	// done before taking mm.mu to keep the miner and readers going
+	fees, err := mm.checkTransaction(tx)
+	if err != nil {
+		return fmt.Errorf("transaction %s rejected: %w", txID, err)
+	}
+
+	err = mm.insert(txID, tx, fees)
+	if err != nil {
+		return err
+	}
+
+	// Broadcast transaction to network
+	mm.broadcastTransaction(tx)
+
+	return nil
+}
+
+// insert adds a verified transaction unless it is already in the mempool or
+// spends an output another mempool transaction spends
+func (mm *MempoolManager) insert(txID string, tx TransactionInterface, fees int64) error {
+	mm.mu.Lock()
+	defer mm.mu.Unlock()
+
+	// Another caller may have added it while it was being verified
 	if _, exists := mm.transactions[txID]; exists {
 		return fmt.Errorf("transaction %s already in mempool", txID)
 	}
 
+	// Two mempool transactions spending the same output can't both be mined
+	inputs := make(map[string]bool)
+	for _, in := range tx.GetInputs() {
+		outpoint := in.String()
+		if other, ok := mm.spent[outpoint]; ok || inputs[outpoint] {
+			return fmt.Errorf("transaction %s rejected: %s is already spent by %s", txID, outpoint, other)
+		}
+		inputs[outpoint] = true
+	}
+
 	// Check mempool size limit
 	if len(mm.transactions) >= mm.maxSize {
 		// Remove oldest transaction
@@ -56,18 +103,18 @@ func (mm *MempoolManager) AddTransaction(tx TransactionInterface) error {
 	mempoolTx := MempoolTransaction{
 		Transaction: tx,
 		Timestamp:   time.Now(),
-		Fees:        mm.calculateFees(tx),
+		Fees:        fees,
 		Size:        len(tx.Serialize()),
-		Verified:    true, // Simplified - would verify transaction here
+		Verified:    true,
 	}
 
 	mm.transactions[txID] = mempoolTx
+	for outpoint := range inputs {
+		mm.spent[outpoint] = txID
+	}
 
 	fmt.Printf("Added transaction %s to mempool (size: %d)\n", txID[:8], len(mm.transactions))
 
-	// Broadcast transaction to network
-	mm.broadcastTransaction(tx)
-
 	return nil
 }
 
@@ -77,9 +124,27 @@ func (mm *MempoolManager) RemoveTransaction(txID []byte) {
 	defer mm.mu.Unlock()
 
 	txIDStr := fmt.Sprintf("%x", txID)
-	delete(mm.transactions, txIDStr)
+	if mm.remove(txIDStr) {
+		fmt.Printf("Removed transaction %s from mempool\n", txIDStr[:8])
+	}
+}
+
+// remove deletes a transaction and releases the outputs it spends. The
+// caller holds mm.mu.
+func (mm *MempoolManager) remove(txID string) bool {
+	mempoolTx, exists := mm.transactions[txID]
+	if !exists {
+		return false
+	}
 
-	fmt.Printf("Removed transaction %s from mempool\n", txIDStr[:8])
+	for _, in := range mempoolTx.Transaction.GetInputs() {
+		if mm.spent[in.String()] == txID {
+			delete(mm.spent, in.String())
+		}
+	}
+	delete(mm.transactions, txID)
+
+	return true
 }
 
 // GetTransaction gets a transaction from the mempool
@@ -109,32 +174,72 @@ func (mm *MempoolManager) GetAllTransactions() []TransactionInterface {
 	return transactions
 }
 
-// GetTransactionsByFees returns transactions sorted by fees (highest first)
+// GetTransactionsByFees returns up to limit transactions, highest fee per
+// byte first
 func (mm *MempoolManager) GetTransactionsByFees(limit int) []TransactionInterface {
 	mm.mu.RLock()
 	defer mm.mu.RUnlock()
 
-	// Create a slice of mempool transactions
-	mempoolTxs := make([]MempoolTransaction, 0, len(mm.transactions))
-	for _, tx := range mm.transactions {
-		mempoolTxs = append(mempoolTxs, tx)
+	ids := make([]string, 0, len(mm.transactions))
+	for txID := range mm.transactions {
+		ids = append(ids, txID)
 	}
-
-	// Sort by fees (simplified - would use proper sorting)
-	// For now, just return first 'limit' transactions
-	result := make([]TransactionInterface, 0, limit)
-	count := 0
-	for _, mempoolTx := range mempoolTxs {
-		if count >= limit {
-			break
+	sort.Slice(ids, func(i, j int) bool {
+		a, b := mm.transactions[ids[i]], mm.transactions[ids[j]]
+		// Compare fee rates without dividing: a.Fees/a.Size > b.Fees/b.Size
+		if left, right := a.Fees*int64(b.Size), b.Fees*int64(a.Size); left != right {
+			return left > right
 		}
-		result = append(result, mempoolTx.Transaction)
-		count++
+		return ids[i] < ids[j]
+	})
+
+	if len(ids) > limit {
+		ids = ids[:limit]
+	}
+
+	result := make([]TransactionInterface, 0, len(ids))
+	for _, txID := range ids {
+		result = append(result, mm.transactions[txID].Transaction)
 	}
 
 	return result
 }
 
+// EvictInvalidTransactions checks every mempool transaction against the
+// current chain again and removes the ones that can no longer be mined. It
+// returns how many were removed.
+func (mm *MempoolManager) EvictInvalidTransactions() int {
+	mm.mu.RLock()
+	transactions := make(map[string]MempoolTransaction, len(mm.transactions))
+	for txID, mempoolTx := range mm.transactions {
+		transactions[txID] = mempoolTx
+	}
+	mm.mu.RUnlock()
+
+	// Checked without mm.mu like in AddTransaction
+	var invalid []string
+	for txID, mempoolTx := range transactions {
+		if _, err := mm.checkTransaction(mempoolTx.Transaction); err != nil {
+			log.Printf("Evicting transaction %s from mempool: %v", txID, err)
+			invalid = append(invalid, txID)
+		}
+	}
+
+	mm.mu.Lock()
+	defer mm.mu.Unlock()
+
+	removed := 0
+	for _, txID := range invalid {
+		// Leave an entry removed and added again while it was checked
+		current, ok := mm.transactions[txID]
+		if ok && current.Timestamp.Equal(transactions[txID].Timestamp) && mm.remove(txID) {
+			removed++
+		}
+	}
+
+	return removed
+}
+
 // RemoveConfirmedTransactions removes transactions that have been confirmed in a block
 func (mm *MempoolManager) RemoveConfirmedTransactions(confirmedTxs [][]byte) {
 	mm.mu.Lock()
@@ -142,9 +247,7 @@ func (mm *MempoolManager) RemoveConfirmedTransactions(confirmedTxs [][]byte) {
 
 	removedCount := 0
 	for _, txID := range confirmedTxs {
-		txIDStr := fmt.Sprintf("%x", txID)
-		if _, exists := mm.transactions[txIDStr]; exists {
-			delete(mm.transactions, txIDStr)
+		if mm.remove(fmt.Sprintf("%x", txID)) {
 			removedCount++
 		}
 	}
@@ -170,7 +273,7 @@ func (mm *MempoolManager) CleanExpiredTransactions() {
 
 	// Remove expired transactions
 	for _, txID := range expiredTxs {
-		delete(mm.transactions, txID)
+		mm.remove(txID)
 	}
 
 	if len(expiredTxs) > 0 {
@@ -242,11 +345,38 @@ func (mm *MempoolManager) broadcastTransaction(tx TransactionInterface) {
 	fmt.Printf("Broadcasted transaction %x to network\n", txID)
 }
 
-// calculateFees calculates transaction fees (simplified)
-func (mm *MempoolManager) calculateFees(tx TransactionInterface) int64 {
-	// Simplified fee calculation based on transaction size
-	size := len(tx.Serialize())
-	return int64(size) * 10 // 10 satoshis per byte
+// checkTransaction checks that a transaction could be mined in the next
+// block and returns its fee: the value of the outputs it spends minus the
+// value of the outputs it creates, none of which may be negative
+func (mm *MempoolManager) checkTransaction(tx TransactionInterface) (int64, error) {
+	// A second coinbase would make every block mined from the mempool invalid
+	if tx.IsCoinbase() {
+		return 0, ErrCoinbaseTransaction
+	}
+
+	fees, err := mm.server.Blockchain.CalculateFee(tx)
+	if err != nil {
+		return 0, err
+	}
+
+	// Coinbase outputs can't be spent until they are deep enough in the chain
+	err = mm.server.Blockchain.CheckCoinbaseMaturity(tx)
+	if err != nil {
+		return 0, err
+	}
+
+	// Lock times and relative locks must allow the next block to include it
+	err = mm.server.Blockchain.CheckTimeLocks(tx)
+	if err != nil {
+		return 0, err
+	}
+
+	err = mm.server.Blockchain.VerifyTransaction(tx)
+	if err != nil {
+		return 0, err
+	}
+
+	return fees, nil
 }
 
 // evictOldestTransaction removes the oldest transaction to make space
@@ -262,7 +392,7 @@ func (mm *MempoolManager) evictOldestTransaction() {
 	}
 
 	if oldestTxID != "" {
-		delete(mm.transactions, oldestTxID)
+		mm.remove(oldestTxID)
 		fmt.Printf("Evicted oldest transaction %s from mempool\n", oldestTxID[:8])
 	}
 }
@@ -314,6 +444,7 @@ func (mm *MempoolManager) Clear() {
 
 	count := len(mm.transactions)
 	mm.transactions = make(map[string]MempoolTransaction)
+	mm.spent = make(map[string]string)
 
 	fmt.Printf("Cleared %d transactions from mempool\n", count)
 }
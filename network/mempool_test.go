@@ -0,0 +1,307 @@
+package network
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+// spendTx is a transaction spending the given outputs
+type spendTx struct {
+	id     string
+	inputs []Outpoint
+}
+
+func (tx spendTx) GetID() []byte         { return []byte(tx.id) }
+func (tx spendTx) GetInputs() []Outpoint { return tx.inputs }
+func (tx spendTx) IsCoinbase() bool      { return false }
+func (tx spendTx) Serialize() []byte     { return []byte(tx.id) }
+
+// coinbaseTx is a coinbase carried as its ID
+type coinbaseTx struct{ testTx }
+
+func (tx coinbaseTx) IsCoinbase() bool { return true }
+
+// coinbaseChain is a testChain decoding every transaction as a coinbase
+type coinbaseChain struct{ testChain }
+
+func (coinbaseChain) DeserializeTransaction(data []byte) (TransactionInterface, error) {
+	return coinbaseTx{testTx(data)}, nil
+}
+
+// peerBanScore returns the misbehavior score of a peer
+func peerBanScore(s *Server, address string) int {
+	for _, peer := range s.NodeManager.GetAllPeers() {
+		if peer.Address == address {
+			return peer.BanScore
+		}
+	}
+
+	return 0
+}
+
+// stubChain is a testChain with fees per transaction and transactions that
+// can turn invalid
+type stubChain struct {
+	testChain
+	mu      sync.Mutex
+	fees    map[string]int64
+	invalid map[string]bool
+}
+
+func newStubChain() *stubChain {
+	return &stubChain{fees: make(map[string]int64), invalid: make(map[string]bool)}
+}
+
+func (c *stubChain) CalculateFee(tx TransactionInterface) (int64, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if fee, ok := c.fees[string(tx.GetID())]; ok {
+		return fee, nil
+	}
+	return 1, nil
+}
+
+func (c *stubChain) VerifyTransaction(tx TransactionInterface) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.invalid[string(tx.GetID())] {
+		return fmt.Errorf("transaction %s is invalid", tx.GetID())
+	}
+	return nil
+}
+
+func (c *stubChain) setInvalid(id string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.invalid[id] = true
+}
+
+// minedBlock is a block holding transactions
+type minedBlock struct {
+	testBlock
+	ids [][]byte
+}
+
+func (b minedBlock) GetTransactionIDs() [][]byte { return b.ids }
+
+// checkingMiner refuses templates holding a transaction the chain rejects,
+// or any transaction in reject, like a block failing validation
+type checkingMiner struct {
+	chain  *stubChain
+	reject map[string]bool
+	mined  chan []TransactionInterface
+}
+
+func (m *checkingMiner) MineBlock(ctx context.Context, transactions []TransactionInterface) (BlockInterface, error) {
+	block := minedBlock{testBlock: testBlock("block")}
+	for _, tx := range transactions {
+		if m.reject[string(tx.GetID())] || m.chain.VerifyTransaction(tx) != nil {
+			return nil, errors.New("invalid transaction")
+		}
+		block.ids = append(block.ids, tx.GetID())
+	}
+	m.mined <- transactions
+
+	return block, nil
+}
+
+func ids(transactions []TransactionInterface) []string {
+	var result []string
+	for _, tx := range transactions {
+		result = append(result, string(tx.GetID()))
+	}
+
+	return result
+}
+
+func TestMempoolRejectsConflicts(t *testing.T) {
+	chain := newStubChain()
+	mm := NewServer("localhost:0", "test", chain).MempoolMgr
+	coin := Outpoint{TxID: []byte("funding"), Vout: 0}
+
+	if err := mm.AddTransaction(spendTx{"payment", []Outpoint{coin}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := mm.AddTransaction(spendTx{"double spend", []Outpoint{coin}}); err == nil {
+		t.Error("a transaction spending a mempool input was accepted")
+	}
+	if err := mm.AddTransaction(spendTx{"spends twice", []Outpoint{{[]byte("other"), 1}, {[]byte("other"), 1}}}); err == nil {
+		t.Error("a transaction spending one output twice was accepted")
+	}
+
+	chain.setInvalid("bad signature")
+	if err := mm.AddTransaction(spendTx{"bad signature", []Outpoint{{[]byte("other"), 2}}}); err == nil {
+		t.Error("a transaction failing verification was accepted")
+	}
+
+	// Once the payment is gone its input may be spent again
+	mm.RemoveTransaction([]byte("payment"))
+	if err := mm.AddTransaction(spendTx{"replacement", []Outpoint{coin}}); err != nil {
+		t.Errorf("input of a removed transaction is still reserved: %v", err)
+	}
+}
+
+func TestMempoolRejectsCoinbase(t *testing.T) {
+	s := NewServer("localhost:0", "test", coinbaseChain{})
+	s.Miner = &checkingMiner{chain: newStubChain(), mined: make(chan []TransactionInterface, 1)}
+
+	err := s.MempoolMgr.AddTransaction(coinbaseTx{testTx("coinbase")})
+	if !errors.Is(err, ErrCoinbaseTransaction) {
+		t.Errorf("AddTransaction of a coinbase returned %v, want %v", err, ErrCoinbaseTransaction)
+	}
+
+	// A peer relaying one is refused and blamed, and nothing is mined
+	s.HandleTx(GobEncode(TxData{AddrFrom: "peer", Transaction: []byte("coinbase")}), nil)
+	if s.MempoolMgr.Size() != 0 || s.IsMining() {
+		t.Errorf("coinbase added to the mempool: size %d, mining %v", s.MempoolMgr.Size(), s.IsMining())
+	}
+	if peerBanScore(s, "peer") == 0 {
+		t.Error("peer relaying a coinbase was not penalized")
+	}
+}
+
+func TestGetTransactionsByFees(t *testing.T) {
+	chain := newStubChain()
+	mm := NewServer("localhost:0", "test", chain).MempoolMgr
+
+	// Fees per byte of serialized transaction order them
+	chain.fees = map[string]int64{"cheap": 1, "rich": 90, "middle": 5}
+	for i, id := range []string{"cheap", "rich", "middle"} {
+		if err := mm.AddTransaction(spendTx{id, []Outpoint{{[]byte("funding"), i}}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if got := fmt.Sprint(ids(mm.GetTransactionsByFees(10))); got != "[rich middle cheap]" {
+		t.Errorf("transactions %s, want [rich middle cheap]", got)
+	}
+	if got := fmt.Sprint(ids(mm.GetTransactionsByFees(2))); got != "[rich middle]" {
+		t.Errorf("transactions %s, want [rich middle]", got)
+	}
+}
+
+func waitMined(t *testing.T, mined chan []TransactionInterface) []string {
+	t.Helper()
+
+	select {
+	case transactions := <-mined:
+		return ids(transactions)
+	case <-time.After(5 * time.Second):
+		t.Fatal("no block was mined")
+		return nil
+	}
+}
+
+func TestMinerEvictsInvalidTransactions(t *testing.T) {
+	chain := newStubChain()
+	s := NewServer("localhost:0", "test", chain)
+
+	for _, tx := range []spendTx{
+		{"good", []Outpoint{{[]byte("funding"), 0}}},
+		{"conflicted", []Outpoint{{[]byte("funding"), 1}}},
+	} {
+		if err := s.MempoolMgr.AddTransaction(tx); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// A block spent the input of a mempool transaction, which now fails
+	// every template it is part of
+	chain.setInvalid("conflicted")
+	miner := &checkingMiner{chain: chain, mined: make(chan []TransactionInterface, 4)}
+	s.Miner = miner
+	s.MineMempool()
+
+	if got := fmt.Sprint(waitMined(t, miner.mined)); got != "[good]" {
+		t.Errorf("mined %s, want [good]", got)
+	}
+	s.StopMining()
+	if s.MempoolMgr.Size() != 0 {
+		t.Errorf("mempool holds %d transactions, want 0", s.MempoolMgr.Size())
+	}
+}
+
+func TestMinerDropsTemplateItCannotBlame(t *testing.T) {
+	chain := newStubChain()
+	s := NewServer("localhost:0", "test", chain)
+	if err := s.MempoolMgr.AddTransaction(spendTx{"poison", []Outpoint{{[]byte("funding"), 0}}}); err != nil {
+		t.Fatal(err)
+	}
+
+	// The chain still accepts the transaction but no block holding it is
+	// valid, so it must not be retried forever
+	miner := &checkingMiner{chain: chain, reject: map[string]bool{"poison": true}, mined: make(chan []TransactionInterface, 4)}
+	s.Miner = miner
+	s.MineMempool()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for s.IsMining() || s.MempoolMgr.Size() > 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("mining did not give up on the template")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// New transactions are mined again
+	if err := s.MempoolMgr.AddTransaction(spendTx{"fresh", []Outpoint{{[]byte("funding"), 1}}}); err != nil {
+		t.Fatal(err)
+	}
+	s.MineMempool()
+	if got := fmt.Sprint(waitMined(t, miner.mined)); got != "[fresh]" {
+		t.Errorf("mined %s, want [fresh]", got)
+	}
+	s.StopMining()
+}
+
+// blockingChain is a stubChain whose verification waits for release
+type blockingChain struct {
+	*stubChain
+	verifying chan struct{}
+	release   chan struct{}
+}
+
+func (c *blockingChain) VerifyTransaction(tx TransactionInterface) error {
+	c.verifying <- struct{}{}
+	<-c.release
+
+	return c.stubChain.VerifyTransaction(tx)
+}
+
+func TestAddTransactionVerifiesWithoutMempoolLock(t *testing.T) {
+	chain := &blockingChain{newStubChain(), make(chan struct{}), make(chan struct{})}
+	mm := NewServer("localhost:0", "test", chain).MempoolMgr
+
+	added := make(chan error, 1)
+	go func() {
+		added <- mm.AddTransaction(spendTx{"slow", []Outpoint{{[]byte("funding"), 0}}})
+	}()
+	<-chain.verifying
+
+	// Readers and the miner's template go on while signatures are checked
+	done := make(chan struct{})
+	go func() {
+		mm.GetMempoolInfo()
+		mm.GetTransactionsByFees(10)
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("mempool readers blocked behind verification")
+	}
+
+	close(chain.release)
+	if err := <-added; err != nil {
+		t.Fatal(err)
+	}
+	if !mm.HasTransaction([]byte("slow")) {
+		t.Error("verified transaction not added")
+	}
+}
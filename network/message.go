@@ -11,14 +11,20 @@ import (
 const (
 	CommandLength = 12
 
-	CmdVersion   = "version"
-	CmdGetBlocks = "getblocks"
-	CmdInv       = "inv"
-	CmdGetData   = "getdata"
-	CmdBlock     = "block"
-	CmdTx        = "tx"
-	CmdPing      = "ping"
-	CmdPong      = "pong"
+	CmdVersion    = "version"
+	CmdGetBlocks  = "getblocks"
+	CmdInv        = "inv"
+	CmdGetData    = "getdata"
+	CmdBlock      = "block"
+	CmdTx         = "tx"
+	CmdPing       = "ping"
+	CmdPong       = "pong"
+	CmdFundTx     = "fundtx"
+	CmdPSBT       = "psbt"
+	CmdScanWallet = "scanwallet"
+	CmdWalletInfo = "walletinfo"
+	CmdGetUsed    = "getused"
+	CmdUsed       = "used"
 )
 
 // Message represents a network message
@@ -31,6 +37,7 @@ type Message struct {
 type VersionData struct {
 	Version    int32
 	BestHeight int32
+	ChainWork  []byte
 	AddrFrom   string
 }
 
@@ -65,6 +72,62 @@ type TxData struct {
 	Transaction []byte
 }
 
+// FundTxData represents a request to build an unsigned payment of Amount
+// from the address From to To
+type FundTxData struct {
+	AddrFrom string
+	From     string
+	To       string
+	Amount   int
+	FeeRate  int
+	Strategy string
+}
+
+// PSBTData represents the answer to a fundtx request, a serialized PSBT or
+// why none was built
+type PSBTData struct {
+	PSBT  []byte
+	Error string
+}
+
+// ScanWalletData represents a request for the balances and history of the
+// key hashes of a wallet
+type ScanWalletData struct {
+	AddrFrom     string
+	PubKeyHashes [][]byte
+}
+
+// WalletInfoData represents the answer to a scanwallet request: the balance
+// of every requested key hash in order and the transactions involving any of
+// them, newest first, or why they weren't found
+type WalletInfoData struct {
+	Balances []int
+	History  []WalletTxData
+	Error    string
+}
+
+// WalletTxData represents a transaction of a wallet and the amounts it moved
+// into and out of it
+type WalletTxData struct {
+	TxID     []byte
+	Height   int
+	Coinbase bool
+	Received int
+	Sent     int
+}
+
+// GetUsedData represents a request for every key hash ever paid on chain
+type GetUsedData struct {
+	AddrFrom string
+}
+
+// UsedData represents the answer to a getused request, the key hashes ever
+// paid on chain or why they weren't found
+type UsedData struct {
+	PubKeyHashes [][]byte
+	Error        string
+}
+
 // PingData represents ping message payload
 type PingData struct {
 	AddrFrom string
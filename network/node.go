@@ -27,11 +27,19 @@ type Peer struct {
 	Latency   time.Duration
 	Connected bool
 	Version   int32
+	BanScore  int
 }
 
 // PeerStatus represents the status of a peer
 type PeerStatus int
 
+// Misbehavior scores; a peer is banned once its score reaches banThreshold
+const (
+	banThreshold             = 100
+	banScoreInvalidBlock     = 100
+	banScoreMalformedMessage = 20
+)
+
 const (
 	PeerStatusConnecting PeerStatus = iota
 	PeerStatusConnected
@@ -296,6 +304,30 @@ func (nm *NodeManager) BanPeer(address string, reason string) {
 	}
 }
 
+// Misbehaving adds score to the ban score of a peer and bans it once the
+// score reaches the threshold
+func (nm *NodeManager) Misbehaving(address string, score int, reason string) {
+	nm.mu.Lock()
+	peer, exists := nm.peers[address]
+	if !exists {
+		peer = &Peer{
+			Address:  address,
+			LastSeen: time.Now(),
+			Status:   PeerStatusDisconnected,
+		}
+		nm.peers[address] = peer
+	}
+	peer.BanScore += score
+	banScore := peer.BanScore
+	nm.mu.Unlock()
+
+	fmt.Printf("Peer %s misbehaving (score %d): %s\n", address, banScore, reason)
+
+	if banScore >= banThreshold {
+		nm.BanPeer(address, reason)
+	}
+}
+
 // IsPeerBanned checks if a peer is banned
 func (nm *NodeManager) IsPeerBanned(address string) bool {
 	nm.mu.RLock()
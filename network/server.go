@@ -1,9 +1,12 @@
 package network
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"io"
 	"log"
+	"math/big"
 	"net"
 	"sync"
 )
@@ -21,29 +24,96 @@ type Server struct {
 	mu          sync.RWMutex
 	running     bool
 	listener    net.Listener
+
+	// Miner mines blocks of mempool transactions; nil disables mining
+	Miner BlockMiner
+
+	// Funder builds the transactions asked for by fundtx requests; nil
+	// refuses them
+	Funder TransactionFunder
+
+	// Scanner answers scanwallet and getused requests; nil refuses them
+	Scanner WalletScanner
+
+	miningCtx    context.Context
+	miningCancel context.CancelFunc
+	miningDone   chan struct{}
 }
 
+// maxBlockTransactions caps the mempool transactions mined into one block
+const maxBlockTransactions = 100
+
+// AddBlock errors
+var (
+	// ErrOrphanBlock is returned when the parent of a block is unknown
+	ErrOrphanBlock = errors.New("orphan block")
+	// ErrInvalidBlock is returned when a block breaks the consensus rules,
+	// the only AddBlock error the sending peer is blamed for
+	ErrInvalidBlock = errors.New("invalid block")
+)
+
 // BlockchainInterface defines required blockchain methods
 type BlockchainInterface interface {
 	GetBestHeight() int
-	GetBlockHashes() [][]byte
+	GetChainWork() *big.Int
+	GetBlockHashes(from, to int) ([][]byte, error)
 	GetBlock(blockHash []byte) (BlockInterface, error)
-	AddBlock(block BlockInterface)
+	AddBlock(block BlockInterface) error
+	DeserializeBlock(data []byte) (BlockInterface, error)
+	CalculateFee(tx TransactionInterface) (int64, error)
+	CheckCoinbaseMaturity(tx TransactionInterface) error
+	CheckTimeLocks(tx TransactionInterface) error
+	VerifyTransaction(tx TransactionInterface) error
+	DeserializeTransaction(data []byte) (TransactionInterface, error)
+}
+
+// BlockMiner mines a block holding transactions on top of the best chain
+// and adds it to the blockchain, giving up when ctx is cancelled
+type BlockMiner interface {
+	MineBlock(ctx context.Context, transactions []TransactionInterface) (BlockInterface, error)
+}
+
+// TransactionFunder builds an unsigned transaction from the UTXO set of the
+// node, returned as a serialized PSBT for the wallet to sign
+type TransactionFunder interface {
+	FundTransaction(req FundTxData) ([]byte, error)
+}
+
+// WalletScanner finds the balances and history of wallet key hashes in the
+// chain of the node, and the key hashes ever used, so wallets never open the
+// node database
+type WalletScanner interface {
+	ScanWallet(pubKeyHashes [][]byte) (WalletInfoData, error)
+	UsedPubKeyHashes() ([][]byte, error)
 }
 
 // BlockInterface defines required block methods
 type BlockInterface interface {
 	GetHash() []byte
 	GetHeight() int
+	GetTransactionIDs() [][]byte
 	Serialize() []byte
 }
 
 // TransactionInterface defines required transaction methods
 type TransactionInterface interface {
 	GetID() []byte
+	GetInputs() []Outpoint
+	IsCoinbase() bool
 	Serialize() []byte
 }
 
+// Outpoint identifies the output of a transaction spent by an input
+type Outpoint struct {
+	TxID []byte
+	Vout int
+}
+
+// String returns the outpoint as txid:vout
+func (o Outpoint) String() string {
+	return fmt.Sprintf("%x:%d", o.TxID, o.Vout)
+}
+
 // NewServer creates a new P2P server
 func NewServer(address, nodeID string, blockchain BlockchainInterface) *Server {
 	server := &Server{
@@ -99,6 +169,8 @@ func (s *Server) Start() error {
 func (s *Server) Stop() error {
 	s.running = false
 
+	s.StopMining()
+
 	// Stop the managers
 	if s.NodeManager != nil {
 		s.NodeManager.Stop()
@@ -110,6 +182,128 @@ func (s *Server) Stop() error {
 	return nil
 }
 
+// StartMining runs mine in the background until it returns or is stopped.
+// Any block mined by a previous call is stopped first.
+func (s *Server) StartMining(mine func(ctx context.Context)) {
+	s.StopMining()
+	s.startMining(nil, mine)
+}
+
+// startMining starts a mining run unless the run current was replaced or
+// stopped meanwhile; current is nil when no run may be in progress
+func (s *Server) startMining(current context.Context, mine func(ctx context.Context)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.miningCtx != current {
+		return
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	s.miningCtx = ctx
+	s.miningCancel = cancel
+	s.miningDone = done
+
+	go func() {
+		defer close(done)
+		defer s.finishMining(ctx, cancel)
+		mine(ctx)
+	}()
+}
+
+// StopMining cancels the block currently being mined, if any, and waits
+// until the miner gave up, so no block it mined is added afterwards
+func (s *Server) StopMining() {
+	s.mu.Lock()
+	cancel, done := s.miningCancel, s.miningDone
+	s.miningCtx = nil
+	s.miningCancel = nil
+	s.miningDone = nil
+	s.mu.Unlock()
+
+	if cancel != nil {
+		cancel()
+		<-done
+	}
+}
+
+// IsMining reports whether a block is being mined
+func (s *Server) IsMining() bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.miningCtx != nil
+}
+
+// finishMining releases a mining run, forgetting it unless a newer run
+// has already replaced it
+func (s *Server) finishMining(ctx context.Context, cancel context.CancelFunc) {
+	cancel()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.miningCtx == ctx {
+		s.miningCtx = nil
+		s.miningCancel = nil
+		s.miningDone = nil
+	}
+}
+
+// MineMempool starts mining the mempool transactions when the server has a
+// Miner, the mempool isn't empty and no block is being mined yet
+func (s *Server) MineMempool() {
+	if s.Miner == nil || s.MempoolMgr.Size() == 0 {
+		return
+	}
+
+	s.startMining(nil, s.mineBlock)
+}
+
+// mineBlock mines a block of mempool transactions, announces it to the
+// known nodes and carries on while transactions are left
+func (s *Server) mineBlock(ctx context.Context) {
+	transactions := s.MempoolMgr.GetTransactionsByFees(maxBlockTransactions)
+	fmt.Printf("Mining a block with %d transactions\n", len(transactions))
+
+	block, err := s.Miner.MineBlock(ctx, transactions)
+	if err != nil {
+		if ctx.Err() != nil {
+			fmt.Println("Mining cancelled")
+			return
+		}
+		log.Printf("Failed to mine block: %v", err)
+
+		// Transactions that turned invalid, for example because a block
+		// spent their inputs, would fail every block they are mined in.
+		// When none can be blamed the whole template is dropped, so mining
+		// never stalls on the same transactions.
+		if s.MempoolMgr.EvictInvalidTransactions() == 0 {
+			for _, tx := range transactions {
+				s.MempoolMgr.RemoveTransaction(tx.GetID())
+			}
+		}
+		if s.MempoolMgr.Size() > 0 {
+			s.startMining(ctx, s.mineBlock)
+		}
+		return
+	}
+
+	s.MempoolMgr.RemoveConfirmedTransactions(block.GetTransactionIDs())
+	fmt.Printf("Mined block %x at height %d\n", block.GetHash(), block.GetHeight())
+
+	for _, node := range s.GetKnownNodes() {
+		if node != s.Address {
+			s.SendInv(node, "block", [][]byte{block.GetHash()})
+		}
+	}
+
+	if s.MempoolMgr.Size() > 0 {
+		s.startMining(ctx, s.mineBlock)
+	}
+}
+
 // HandleConnection handles incoming connections
 func (s *Server) HandleConnection(conn net.Conn) {
 	defer conn.Close()
@@ -169,6 +363,12 @@ func (s *Server) ProcessMessage(msg Message, conn net.Conn) {
 		s.HandleTx(msg.Data, conn)
 	case CmdPing:
 		s.HandlePing(msg.Data, conn)
+	case CmdFundTx:
+		s.HandleFundTx(msg.Data, conn)
+	case CmdScanWallet:
+		s.HandleScanWallet(msg.Data, conn)
+	case CmdGetUsed:
+		s.HandleGetUsed(msg.Data, conn)
 	default:
 		fmt.Printf("Unknown command: %s\n", msg.Command)
 	}
@@ -214,6 +414,7 @@ func (s *Server) ConnectToPeer(address string) error {
 	versionData := VersionData{
 		Version:    1,
 		BestHeight: int32(s.Blockchain.GetBestHeight()),
+		ChainWork:  s.Blockchain.GetChainWork().Bytes(),
 		AddrFrom:   s.Address,
 	}
 
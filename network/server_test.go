@@ -0,0 +1,258 @@
+package network
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// testTx is a transaction carried as its ID
+type testTx []byte
+
+func (tx testTx) GetID() []byte         { return tx }
+func (tx testTx) GetInputs() []Outpoint { return nil }
+func (tx testTx) IsCoinbase() bool      { return false }
+func (tx testTx) Serialize() []byte     { return tx }
+
+// testBlock is a block carried as its hash
+type testBlock []byte
+
+func (b testBlock) GetHash() []byte             { return b }
+func (b testBlock) GetHeight() int              { return 1 }
+func (b testBlock) GetTransactionIDs() [][]byte { return nil }
+func (b testBlock) Serialize() []byte           { return b }
+
+// testChain accepts every block and transaction
+type testChain struct{}
+
+func (testChain) GetBestHeight() int                            { return 0 }
+func (testChain) GetChainWork() *big.Int                        { return big.NewInt(0) }
+func (testChain) GetBlockHashes(from, to int) ([][]byte, error) { return nil, nil }
+func (testChain) GetBlock(blockHash []byte) (BlockInterface, error) {
+	return nil, errors.New("unknown block")
+}
+func (testChain) AddBlock(block BlockInterface) error                  { return nil }
+func (testChain) DeserializeBlock(data []byte) (BlockInterface, error) { return testBlock(data), nil }
+func (testChain) CalculateFee(tx TransactionInterface) (int64, error)  { return 1, nil }
+func (testChain) CheckCoinbaseMaturity(tx TransactionInterface) error  { return nil }
+func (testChain) CheckTimeLocks(tx TransactionInterface) error         { return nil }
+func (testChain) VerifyTransaction(tx TransactionInterface) error      { return nil }
+func (testChain) DeserializeTransaction(data []byte) (TransactionInterface, error) {
+	return testTx(data), nil
+}
+
+// blockingMiner mines until it is cancelled, handing out the context of
+// every run
+type blockingMiner struct {
+	started chan context.Context
+}
+
+func (m *blockingMiner) MineBlock(ctx context.Context, transactions []TransactionInterface) (BlockInterface, error) {
+	m.started <- ctx
+	<-ctx.Done()
+
+	return nil, ctx.Err()
+}
+
+func waitMining(t *testing.T, started chan context.Context) context.Context {
+	t.Helper()
+
+	select {
+	case ctx := <-started:
+		return ctx
+	case <-time.After(5 * time.Second):
+		t.Fatal("mining did not start")
+		return nil
+	}
+}
+
+func TestCompetingBlockRestartsMining(t *testing.T) {
+	miner := &blockingMiner{started: make(chan context.Context, 4)}
+	s := NewServer("localhost:0", "test", testChain{})
+	s.Miner = miner
+
+	s.HandleTx(GobEncode(TxData{AddrFrom: "peer", Transaction: []byte("tx 1")}), nil)
+	first := waitMining(t, miner.started)
+
+	// A second transaction joins the mempool without restarting the run
+	s.HandleTx(GobEncode(TxData{AddrFrom: "peer", Transaction: []byte("tx 2")}), nil)
+	select {
+	case <-miner.started:
+		t.Fatal("a new transaction restarted mining")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	s.HandleBlock(GobEncode(BlockData{AddrFrom: "peer", Block: []byte("competing block")}), nil)
+
+	select {
+	case <-first.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("competing block did not stop mining")
+	}
+	second := waitMining(t, miner.started)
+	if second.Err() != nil {
+		t.Fatal("mining was not restarted on the new tip")
+	}
+
+	s.StopMining()
+	<-second.Done()
+	if s.IsMining() {
+		t.Error("server still mining after StopMining")
+	}
+}
+
+// slowMiner keeps working for a while after it is cancelled, like a miner
+// that is adding the block it just found
+type slowMiner struct {
+	started  chan struct{}
+	finished atomic.Bool
+}
+
+func (m *slowMiner) MineBlock(ctx context.Context, transactions []TransactionInterface) (BlockInterface, error) {
+	close(m.started)
+	<-ctx.Done()
+	time.Sleep(50 * time.Millisecond)
+	m.finished.Store(true)
+
+	return nil, ctx.Err()
+}
+
+func TestStopMiningWaitsForMiner(t *testing.T) {
+	miner := &slowMiner{started: make(chan struct{})}
+	s := NewServer("localhost:0", "test", testChain{})
+	s.Miner = miner
+
+	s.HandleTx(GobEncode(TxData{AddrFrom: "peer", Transaction: []byte("transaction")}), nil)
+	select {
+	case <-miner.started:
+	case <-time.After(5 * time.Second):
+		t.Fatal("mining did not start")
+	}
+
+	s.StopMining()
+	if !miner.finished.Load() {
+		t.Error("StopMining returned while the miner was still running")
+	}
+}
+
+func TestMineMempoolWithoutMiner(t *testing.T) {
+	s := NewServer("localhost:0", "test", testChain{})
+
+	s.HandleTx(GobEncode(TxData{AddrFrom: "peer", Transaction: []byte("transaction")}), nil)
+	if s.IsMining() {
+		t.Error("server without a miner started mining")
+	}
+	if s.MempoolMgr.Size() != 1 {
+		t.Errorf("mempool holds %d transactions, want 1", s.MempoolMgr.Size())
+	}
+}
+
+func TestIsLoopback(t *testing.T) {
+	tests := []struct {
+		addr net.Addr
+		want bool
+	}{
+		{&net.TCPAddr{IP: net.ParseIP("127.0.0.1"), Port: 3000}, true},
+		{&net.TCPAddr{IP: net.ParseIP("::1"), Port: 3000}, true},
+		{&net.TCPAddr{IP: net.ParseIP("192.168.1.5"), Port: 3000}, false},
+		{&net.TCPAddr{IP: net.ParseIP("::ffff:10.0.0.1"), Port: 3000}, false},
+		{&net.UnixAddr{Name: "/tmp/node.sock", Net: "unix"}, false},
+	}
+
+	for _, tt := range tests {
+		if got := isLoopback(tt.addr); got != tt.want {
+			t.Errorf("isLoopback(%v) = %v, want %v", tt.addr, got, tt.want)
+		}
+	}
+}
+
+// rejectingChain is a testChain whose AddBlock fails with err
+type rejectingChain struct {
+	testChain
+	err error
+}
+
+func (c rejectingChain) AddBlock(block BlockInterface) error { return c.err }
+
+func TestHandleBlockBlamesOnlyInvalidBlocks(t *testing.T) {
+	tests := []struct {
+		name   string
+		err    error
+		blamed bool
+	}{
+		{"invalid block", fmt.Errorf("%w: bad merkle root", ErrInvalidBlock), true},
+		{"orphan", fmt.Errorf("%w: unknown parent", ErrOrphanBlock), false},
+		{"read failure", errors.New("missing undo data"), false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := NewServer("localhost:0", "test", rejectingChain{err: tt.err})
+
+			s.HandleBlock(GobEncode(BlockData{AddrFrom: "peer", Block: []byte("block")}), nil)
+
+			if blamed := peerBanScore(s, "peer") > 0; blamed != tt.blamed {
+				t.Errorf("peer blamed %v, want %v", blamed, tt.blamed)
+			}
+		})
+	}
+}
+
+// balanceScanner gives every key hash a balance of its length
+type balanceScanner struct{}
+
+func (balanceScanner) ScanWallet(pubKeyHashes [][]byte) (WalletInfoData, error) {
+	var info WalletInfoData
+	for _, pubKeyHash := range pubKeyHashes {
+		info.Balances = append(info.Balances, len(pubKeyHash))
+	}
+
+	return info, nil
+}
+
+func (balanceScanner) UsedPubKeyHashes() ([][]byte, error) { return nil, nil }
+
+func TestHandleScanWallet(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	s := NewServer("localhost:0", "test", testChain{})
+	s.Scanner = balanceScanner{}
+	req := ScanWalletData{AddrFrom: "cli", PubKeyHashes: [][]byte{[]byte("a"), []byte("abc")}}
+
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		s.HandleScanWallet(GobEncode(req), conn)
+	}()
+
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	msg, err := ReadMessage(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg.Command != CmdWalletInfo {
+		t.Fatalf("reply %s, want %s", msg.Command, CmdWalletInfo)
+	}
+	var reply WalletInfoData
+	GobDecode(msg.Data, &reply)
+	if reply.Error != "" || fmt.Sprint(reply.Balances) != "[1 3]" {
+		t.Errorf("reply balances %v, error %q; want [1 3]", reply.Balances, reply.Error)
+	}
+}
@@ -3,6 +3,7 @@ package network
 import (
 	"fmt"
 	"log"
+	"math/big"
 	"time"
 )
 
@@ -66,8 +67,12 @@ func (sm *SyncManager) SyncWithNode(nodeAddr string) error {
 func (sm *SyncManager) ValidateChain() bool {
 	fmt.Println("Validating blockchain integrity...")
 
-	// Get all block hashes
-	blockHashes := sm.server.Blockchain.GetBlockHashes()
+	// Get all block hashes, genesis first
+	blockHashes, err := sm.server.Blockchain.GetBlockHashes(0, sm.server.Blockchain.GetBestHeight())
+	if err != nil {
+		log.Printf("Failed to list block hashes: %v", err)
+		return false
+	}
 
 	if len(blockHashes) == 0 {
 		log.Println("No blocks to validate")
@@ -105,20 +110,20 @@ func (sm *SyncManager) ValidateChain() bool {
 func (sm *SyncManager) ResolveChainConflicts(competingChains []ChainInfo) {
 	fmt.Printf("Resolving chain conflicts among %d chains...\n", len(competingChains))
 
-	// Find the longest valid chain
-	longestChain := sm.findLongestChain(competingChains)
+	// Find the valid chain with the most cumulative work
+	bestChain := sm.findBestChain(competingChains)
 
-	if longestChain == nil {
+	if bestChain == nil {
 		log.Println("No valid chain found")
 		return
 	}
 
-	currentHeight := sm.server.Blockchain.GetBestHeight()
-	if longestChain.Height > currentHeight {
-		fmt.Printf("Adopting longer chain (height: %d -> %d)\n", currentHeight, longestChain.Height)
-		sm.adoptChain(longestChain)
+	currentWork := sm.server.Blockchain.GetChainWork()
+	if bestChain.Work.Cmp(currentWork) > 0 {
+		fmt.Printf("Adopting chain with more work (work: %s -> %s)\n", currentWork, bestChain.Work)
+		sm.adoptChain(bestChain)
 	} else {
-		fmt.Println("Current chain is already the longest")
+		fmt.Println("Current chain already has the most work")
 	}
 }
 
@@ -126,29 +131,32 @@ func (sm *SyncManager) ResolveChainConflicts(competingChains []ChainInfo) {
 type ChainInfo struct {
 	NodeAddr string
 	Height   int
+	Work     *big.Int
 	Hash     []byte
 }
 
-// findLongestChain finds the longest valid chain
-func (sm *SyncManager) findLongestChain(chains []ChainInfo) *ChainInfo {
-	var longest *ChainInfo
+// findBestChain finds the valid chain with the most cumulative work
+func (sm *SyncManager) findBestChain(chains []ChainInfo) *ChainInfo {
+	var best *ChainInfo
 
-	for _, chain := range chains {
-		if longest == nil || chain.Height > longest.Height {
-			// In reality, we would validate the entire chain here
-			if sm.validateChainInfo(chain) {
-				longest = &chain
-			}
+	for i := range chains {
+		// Validate first, an invalid chain may have no work to compare.
+		// In reality, we would validate the entire chain here
+		if !sm.validateChainInfo(chains[i]) {
+			continue
+		}
+		if best == nil || chains[i].Work.Cmp(best.Work) > 0 {
+			best = &chains[i]
 		}
 	}
 
-	return longest
+	return best
 }
 
 // validateChainInfo validates basic chain information
 func (sm *SyncManager) validateChainInfo(chain ChainInfo) bool {
 	// Simplified validation
-	return chain.Height >= 0 && len(chain.Hash) > 0
+	return chain.Height >= 0 && chain.Work != nil && len(chain.Hash) > 0
 }
 
 // adoptChain adopts a new chain by downloading all blocks
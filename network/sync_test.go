@@ -0,0 +1,29 @@
+package network
+
+import (
+	"math/big"
+	"testing"
+)
+
+func TestFindBestChainSkipsInvalidChains(t *testing.T) {
+	sm := NewServer("localhost:0", "test", testChain{}).SyncMgr
+
+	// The chain without work follows a valid one, so comparing before
+	// validating would dereference its nil work
+	chains := []ChainInfo{
+		{NodeAddr: "a", Height: 3, Work: big.NewInt(30), Hash: []byte("a")},
+		{NodeAddr: "nil work", Height: 9, Hash: []byte("b")},
+		{NodeAddr: "c", Height: 5, Work: big.NewInt(50), Hash: []byte("c")},
+		{NodeAddr: "no hash", Height: 7, Work: big.NewInt(70)},
+	}
+	if best := sm.findBestChain(chains); best == nil || best.NodeAddr != "c" {
+		t.Fatalf("best chain %+v, want c", best)
+	}
+
+	if best := sm.findBestChain(chains[1:2]); best != nil {
+		t.Fatalf("best chain %+v, want none", best)
+	}
+	if best := sm.findBestChain([]ChainInfo{chains[1], chains[0]}); best == nil || best.NodeAddr != "a" {
+		t.Fatalf("best chain %+v, want a", best)
+	}
+}
@@ -0,0 +1,84 @@
+package pow
+
+import "math/big"
+
+// CompactToBig converts a compact representation of a target to a big integer.
+// The top byte is the length of the target in bytes and the lower three bytes
+// are its most significant digits.
+func CompactToBig(compact uint32) *big.Int {
+	mantissa := int64(compact & 0x007fffff)
+	exponent := uint(compact >> 24)
+
+	if exponent <= 3 {
+		return big.NewInt(mantissa >> (8 * (3 - exponent)))
+	}
+
+	target := big.NewInt(mantissa)
+	return target.Lsh(target, 8*(exponent-3))
+}
+
+// BigToCompact converts a target to its compact representation
+func BigToCompact(target *big.Int) uint32 {
+	if target.Sign() <= 0 {
+		return 0
+	}
+
+	var mantissa uint32
+	exponent := uint(len(target.Bytes()))
+
+	if exponent <= 3 {
+		mantissa = uint32(target.Uint64()) << (8 * (3 - exponent))
+	} else {
+		shifted := new(big.Int).Rsh(target, 8*(exponent-3))
+		mantissa = uint32(shifted.Uint64())
+	}
+
+	// The sign bit of the mantissa must stay clear
+	if mantissa&0x00800000 != 0 {
+		mantissa >>= 8
+		exponent++
+	}
+
+	return uint32(exponent<<24) | mantissa
+}
+
+// Retarget scales the target of bits by how long the last interval actually
+// took compared to targetTimespan. The change is clamped to maxFactor in
+// either direction and the result never gets easier than powLimit.
+func Retarget(bits uint32, actualTimespan, targetTimespan, maxFactor int64, powLimit uint32) uint32 {
+	minTimespan := targetTimespan / maxFactor
+	maxTimespan := targetTimespan * maxFactor
+
+	if actualTimespan < minTimespan {
+		actualTimespan = minTimespan
+	}
+	if actualTimespan > maxTimespan {
+		actualTimespan = maxTimespan
+	}
+
+	newTarget := CompactToBig(bits)
+	newTarget.Mul(newTarget, big.NewInt(actualTimespan))
+	newTarget.Div(newTarget, big.NewInt(targetTimespan))
+
+	limit := CompactToBig(powLimit)
+	if newTarget.Cmp(limit) > 0 {
+		newTarget = limit
+	}
+
+	return BigToCompact(newTarget)
+}
+
+// oneLsh256 is 2^256, the size of the hash space
+var oneLsh256 = new(big.Int).Lsh(big.NewInt(1), 256)
+
+// CalcWork returns the expected number of hashes needed to solve a block
+// with the given bits, which is what chains are compared by
+func CalcWork(bits uint32) *big.Int {
+	target := CompactToBig(bits)
+	if target.Sign() <= 0 {
+		return big.NewInt(0)
+	}
+
+	denominator := new(big.Int).Add(target, big.NewInt(1))
+	return new(big.Int).Div(oneLsh256, denominator)
+}
@@ -0,0 +1,221 @@
+package pow
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"math"
+	"math/big"
+	"math/rand"
+	"runtime"
+	"sync"
+	"time"
+)
+
+// ErrNonceSpaceExhausted is returned when no nonce solves the work
+var ErrNonceSpaceExhausted = errors.New("nonce space exhausted")
+
+// Miner searches for a nonce that solves a Work
+type Miner interface {
+	Mine(ctx context.Context, w Work) (*Result, error)
+}
+
+// Roller is implemented by work that can change its data once the nonce space runs out
+type Roller interface {
+	Roll()
+}
+
+// Result describes a solved Work
+type Result struct {
+	Nonce   int
+	Hash    []byte
+	Hashes  uint64
+	Elapsed time.Duration
+}
+
+// Hashrate returns the number of hashes computed per second
+func (r *Result) Hashrate() float64 {
+	if r.Elapsed <= 0 {
+		return 0
+	}
+	return float64(r.Hashes) / r.Elapsed.Seconds()
+}
+
+// ReportingMiner mines with Miner and passes the result of every solved
+// work to Report, so callers can show how fast blocks are mined
+type ReportingMiner struct {
+	Miner
+	Report func(*Result)
+}
+
+// Mine solves the work with the wrapped miner and reports the result
+func (m ReportingMiner) Mine(ctx context.Context, w Work) (*Result, error) {
+	result, err := m.Miner.Mine(ctx, w)
+	if err == nil && m.Report != nil {
+		m.Report(result)
+	}
+
+	return result, err
+}
+
+// DefaultMiner is the miner used when no other one is configured
+var DefaultMiner Miner = NewParallelMiner(runtime.NumCPU())
+
+// SerialMiner scans nonces one by one on the calling goroutine
+type SerialMiner struct{}
+
+// Mine returns the first nonce whose hash is below the target
+func (SerialMiner) Mine(ctx context.Context, w Work) (*Result, error) {
+	var hashInt big.Int
+	target := w.Target()
+	start := time.Now()
+
+	for nonce := 0; nonce < math.MaxInt64; nonce++ {
+		if nonce%cancelCheckInterval == 0 && ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+
+		hash := sha256.Sum256(w.PrepareData(nonce))
+		hashInt.SetBytes(hash[:])
+
+		if hashInt.Cmp(target) == -1 {
+			return &Result{nonce, hash[:], uint64(nonce) + 1, time.Since(start)}, nil
+		}
+	}
+
+	return nil, ErrNonceSpaceExhausted
+}
+
+// cancelCheckInterval is how many hashes a worker computes between context checks
+const cancelCheckInterval = 4096
+
+// ParallelMiner splits the nonce space across several worker goroutines.
+// Work is scanned in rounds of Workers*BatchSize nonces and the lowest
+// solving nonce of a round wins, so the result only depends on the
+// starting nonce and never on goroutine scheduling.
+type ParallelMiner struct {
+	Workers   int
+	BatchSize int
+	MaxNonce  int
+	Seed      int64
+}
+
+// NewParallelMiner creates a ParallelMiner with the given number of workers
+func NewParallelMiner(workers int) *ParallelMiner {
+	if workers < 1 {
+		workers = 1
+	}
+
+	return &ParallelMiner{
+		Workers:   workers,
+		BatchSize: 1 << 14,
+		MaxNonce:  math.MaxUint32,
+	}
+}
+
+// Mine searches the nonce space until the work is solved or ctx is done.
+// When the nonce space is exhausted the work is rolled if it implements
+// Roller, otherwise ErrNonceSpaceExhausted is returned.
+func (m *ParallelMiner) Mine(ctx context.Context, w Work) (*Result, error) {
+	start := time.Now()
+	startNonce := m.startNonce()
+	var hashes uint64
+
+	for {
+		result, scanned, err := m.scan(ctx, w, startNonce)
+		hashes += scanned
+		if err != nil {
+			return nil, err
+		}
+		if result != nil {
+			result.Hashes = hashes
+			result.Elapsed = time.Since(start)
+			return result, nil
+		}
+
+		roller, ok := w.(Roller)
+		if !ok {
+			return nil, ErrNonceSpaceExhausted
+		}
+		roller.Roll()
+	}
+}
+
+// scan walks the whole nonce space once, beginning at startNonce
+func (m *ParallelMiner) scan(ctx context.Context, w Work, startNonce int) (*Result, uint64, error) {
+	target := w.Target()
+	batch := m.BatchSize
+	if batch < 1 {
+		batch = 1
+	}
+	space := m.MaxNonce + 1
+	var hashes uint64
+
+	for offset := 0; offset < space; offset += m.Workers * batch {
+		if ctx.Err() != nil {
+			return nil, hashes, ctx.Err()
+		}
+
+		found := make([]*Result, m.Workers)
+		counts := make([]uint64, m.Workers)
+		var wg sync.WaitGroup
+
+		for i := 0; i < m.Workers; i++ {
+			from := offset + i*batch
+			to := from + batch
+			if to > space {
+				to = space
+			}
+			if from >= to {
+				break
+			}
+
+			wg.Add(1)
+			go func(worker, from, to int) {
+				defer wg.Done()
+
+				var hashInt big.Int
+				for k := from; k < to; k++ {
+					if (k-from)%cancelCheckInterval == 0 && ctx.Err() != nil {
+						return
+					}
+
+					nonce := (startNonce + k) % space
+					hash := sha256.Sum256(w.PrepareData(nonce))
+					counts[worker]++
+					hashInt.SetBytes(hash[:])
+
+					if hashInt.Cmp(target) == -1 {
+						found[worker] = &Result{Nonce: nonce, Hash: hash[:]}
+						return
+					}
+				}
+			}(i, from, to)
+		}
+		wg.Wait()
+
+		if ctx.Err() != nil {
+			return nil, hashes, ctx.Err()
+		}
+		for _, count := range counts {
+			hashes += count
+		}
+		for _, result := range found {
+			if result != nil {
+				return result, hashes, nil
+			}
+		}
+	}
+
+	return nil, hashes, nil
+}
+
+// startNonce picks where the search begins; a fixed Seed makes it reproducible
+func (m *ParallelMiner) startNonce() int {
+	seed := m.Seed
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+
+	return rand.New(rand.NewSource(seed)).Intn(m.MaxNonce + 1)
+}
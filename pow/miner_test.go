@@ -0,0 +1,103 @@
+package pow
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+)
+
+// testHeader returns a header whose target about one nonce in 2048 meets
+func testHeader() *Header {
+	return &Header{
+		PrevBlockHash: []byte("previous block"),
+		MerkleRoot:    []byte("merkle root"),
+		Timestamp:     1700000000,
+		Bits:          0x1f200000,
+		Height:        42,
+	}
+}
+
+// testMiner returns a seeded miner whose nonce space is too small to hold
+// a solution for every timestamp, so it has to roll the header
+func testMiner(workers int) *ParallelMiner {
+	return &ParallelMiner{Workers: workers, BatchSize: 8, MaxNonce: 255, Seed: 7}
+}
+
+func TestParallelMinerSameSeedSameResult(t *testing.T) {
+	var want *Result
+	var wantTimestamp int64
+
+	for _, workers := range []int{1, 1, 4, 4, 16} {
+		header := testHeader()
+		result, err := testMiner(workers).Mine(context.Background(), header)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !Validate(header, result.Nonce) {
+			t.Fatalf("%d workers: nonce %d does not meet the target", workers, result.Nonce)
+		}
+
+		if want == nil {
+			want, wantTimestamp = result, header.Timestamp
+			if wantTimestamp == testHeader().Timestamp {
+				t.Fatal("header was never rolled, the extra nonce is not exercised")
+			}
+			continue
+		}
+		if result.Nonce != want.Nonce || header.Timestamp != wantTimestamp || !bytes.Equal(result.Hash, want.Hash) {
+			t.Errorf("%d workers: nonce %d timestamp %d, want nonce %d timestamp %d",
+				workers, result.Nonce, header.Timestamp, want.Nonce, wantTimestamp)
+		}
+	}
+}
+
+func TestParallelMinerMatchesSerialMiner(t *testing.T) {
+	header := testHeader()
+	miner := testMiner(3)
+
+	result, err := miner.Mine(context.Background(), header)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	start := miner.startNonce()
+	for k := 0; k <= miner.MaxNonce; k++ {
+		nonce := (start + k) % (miner.MaxNonce + 1)
+		if Validate(header, nonce) {
+			if nonce != result.Nonce {
+				t.Fatalf("parallel miner found nonce %d, first solution from %d is %d", result.Nonce, start, nonce)
+			}
+			return
+		}
+	}
+	t.Fatal("no solution in the nonce space")
+}
+
+func TestParallelMinerCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	header := testHeader()
+	header.Bits = 0x1d00ffff
+	_, err := NewParallelMiner(2).Mine(ctx, header)
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("Mine returned %v, want %v", err, context.Canceled)
+	}
+}
+
+func TestReportingMinerReportsHashrate(t *testing.T) {
+	var reported *Result
+	miner := ReportingMiner{Miner: testMiner(2), Report: func(r *Result) { reported = r }}
+
+	result, err := miner.Mine(context.Background(), testHeader())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reported != result {
+		t.Fatal("result was not reported")
+	}
+	if result.Hashes == 0 || result.Hashrate() < 0 {
+		t.Errorf("reported %d hashes at %f H/s", result.Hashes, result.Hashrate())
+	}
+}
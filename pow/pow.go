@@ -0,0 +1,81 @@
+package pow
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"math/big"
+)
+
+// Work describes the data a Miner hashes while searching for a nonce
+type Work interface {
+	PrepareData(nonce int) []byte
+	Target() *big.Int
+}
+
+// Header holds the block fields covered by proof of work
+type Header struct {
+	PrevBlockHash []byte
+	MerkleRoot    []byte
+	Timestamp     int64
+	Bits          uint32
+	Nonce         int
+	Height        int
+}
+
+// NewTarget returns the target for the given number of leading zero bits
+func NewTarget(bits int) *big.Int {
+	target := big.NewInt(1)
+	target.Lsh(target, uint(256-bits))
+
+	return target
+}
+
+// PrepareData returns the header bytes to hash for the given nonce
+func (h *Header) PrepareData(nonce int) []byte {
+	data := bytes.Join(
+		[][]byte{
+			h.PrevBlockHash,
+			h.MerkleRoot,
+			intToBytes(h.Timestamp),
+			intToBytes(int64(h.Bits)),
+			intToBytes(int64(nonce)),
+			intToBytes(int64(h.Height)),
+		},
+		[]byte{},
+	)
+	return data
+}
+
+// Target returns the target encoded in the compact Bits of the header
+func (h *Header) Target() *big.Int {
+	return CompactToBig(h.Bits)
+}
+
+// Roll bumps the timestamp so the nonce space can be searched again
+func (h *Header) Roll() {
+	h.Timestamp++
+}
+
+// Hash returns the hash of the header with its current nonce
+func (h *Header) Hash() []byte {
+	hash := sha256.Sum256(h.PrepareData(h.Nonce))
+	return hash[:]
+}
+
+// Validate checks that the work hashed with nonce meets its target
+func Validate(w Work, nonce int) bool {
+	var hashInt big.Int
+
+	hash := sha256.Sum256(w.PrepareData(nonce))
+	hashInt.SetBytes(hash[:])
+
+	return hashInt.Cmp(w.Target()) == -1
+}
+
+// intToBytes encodes n as 8 big-endian bytes
+func intToBytes(n int64) []byte {
+	buf := make([]byte, 8)
+	binary.BigEndian.PutUint64(buf, uint64(n))
+	return buf
+}
@@ -0,0 +1,509 @@
+package script
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+)
+
+// Checker verifies signatures for OP_CHECKSIG and OP_CHECKMULTISIG and
+// timelocks for OP_CHECKLOCKTIMEVERIFY and OP_CHECKSEQUENCEVERIFY. It is
+// bound to the transaction input being spent, so the script package doesn't
+// need to know how signature hashes, keys or lock fields are encoded.
+type Checker interface {
+	// CheckSig reports whether sig is a valid signature by pubKey. Badly
+	// encoded signatures or keys are errors that fail the script outright.
+	CheckSig(sig, pubKey []byte) (bool, error)
+	CheckLockTime(lockTime int64) bool
+	CheckSequence(sequence int64) bool
+}
+
+// Verify runs the signature script of an input followed by the locking
+// script of the output it spends. For pay-to-script-hash outputs the
+// redeem script pushed by the signature script is run as well.
+func Verify(sigScript, pkScript []byte, checker Checker) error {
+	if len(sigScript) > MaxScriptSize || len(pkScript) > MaxScriptSize {
+		return ErrScriptTooBig
+	}
+	if !IsPushOnly(sigScript) {
+		return ErrNotPushOnly
+	}
+
+	stack, err := execute(sigScript, nil, checker)
+	if err != nil {
+		return err
+	}
+	redeemStack := append([][]byte{}, stack...)
+
+	stack, err = execute(pkScript, stack, checker)
+	if err != nil {
+		return err
+	}
+	if !topIsTrue(stack) {
+		return ErrEvalFalse
+	}
+
+	if IsPayToScriptHash(pkScript) {
+		if len(redeemStack) == 0 {
+			return ErrStackUnderflow
+		}
+
+		redeemScript := redeemStack[len(redeemStack)-1]
+		stack, err = execute(redeemScript, redeemStack[:len(redeemStack)-1], checker)
+		if err != nil {
+			return fmt.Errorf("redeem script: %w", err)
+		}
+		if !topIsTrue(stack) {
+			return ErrEvalFalse
+		}
+	}
+
+	return nil
+}
+
+// execute runs a script on top of an initial stack and returns the final stack
+func execute(script []byte, stack [][]byte, checker Checker) ([][]byte, error) {
+	if len(script) > MaxScriptSize {
+		return nil, ErrScriptTooBig
+	}
+
+	instructions, err := parse(script)
+	if err != nil {
+		return nil, err
+	}
+
+	vm := &machine{stack: stack, checker: checker}
+	for _, in := range instructions {
+		err := vm.step(in)
+		if err != nil {
+			return nil, err
+		}
+		if len(vm.stack) > MaxStackSize {
+			return nil, ErrStackOverflow
+		}
+	}
+
+	if len(vm.conditions) != 0 {
+		return nil, ErrUnbalancedConditional
+	}
+
+	return vm.stack, nil
+}
+
+// machine holds the interpreter state while a single script runs
+type machine struct {
+	stack      [][]byte
+	conditions []bool
+	ops        int
+	checker    Checker
+}
+
+// executing reports whether every enclosing conditional branch is taken
+func (vm *machine) executing() bool {
+	for _, cond := range vm.conditions {
+		if !cond {
+			return false
+		}
+	}
+
+	return true
+}
+
+// step runs a single instruction
+func (vm *machine) step(in instruction) error {
+	if len(in.data) > MaxPushSize {
+		return ErrPushTooBig
+	}
+
+	if in.op > OP_16 {
+		vm.ops++
+		if vm.ops > MaxOpsPerScript {
+			return ErrTooManyOps
+		}
+	}
+
+	// Conditionals are tracked even inside branches that are not taken
+	switch in.op {
+	case OP_IF, OP_NOTIF:
+		cond := false
+		if vm.executing() {
+			value, err := vm.pop()
+			if err != nil {
+				return err
+			}
+			cond = asBool(value)
+			if in.op == OP_NOTIF {
+				cond = !cond
+			}
+		}
+		vm.conditions = append(vm.conditions, cond)
+		return nil
+	case OP_ELSE:
+		if len(vm.conditions) == 0 {
+			return ErrUnbalancedConditional
+		}
+		last := len(vm.conditions) - 1
+		vm.conditions[last] = !vm.conditions[last]
+		return nil
+	case OP_ENDIF:
+		if len(vm.conditions) == 0 {
+			return ErrUnbalancedConditional
+		}
+		vm.conditions = vm.conditions[:len(vm.conditions)-1]
+		return nil
+	}
+
+	if !vm.executing() {
+		return nil
+	}
+
+	if in.isPush() {
+		vm.push(pushValue(in))
+		return nil
+	}
+
+	switch in.op {
+	case OP_NOP:
+	case OP_VERIFY:
+		value, err := vm.pop()
+		if err != nil {
+			return err
+		}
+		if !asBool(value) {
+			return ErrVerifyFailed
+		}
+	case OP_RETURN:
+		return ErrEarlyReturn
+	case OP_DROP:
+		_, err := vm.pop()
+		return err
+	case OP_DUP:
+		value, err := vm.peek(0)
+		if err != nil {
+			return err
+		}
+		vm.push(value)
+	case OP_SWAP:
+		if len(vm.stack) < 2 {
+			return ErrStackUnderflow
+		}
+		n := len(vm.stack)
+		vm.stack[n-1], vm.stack[n-2] = vm.stack[n-2], vm.stack[n-1]
+	case OP_SIZE:
+		value, err := vm.peek(0)
+		if err != nil {
+			return err
+		}
+		vm.push(encodeNumber(len(value)))
+	case OP_EQUAL, OP_EQUALVERIFY:
+		a, err := vm.pop()
+		if err != nil {
+			return err
+		}
+		b, err := vm.pop()
+		if err != nil {
+			return err
+		}
+		equal := bytes.Equal(a, b)
+		if in.op == OP_EQUALVERIFY {
+			if !equal {
+				return ErrVerifyFailed
+			}
+			return nil
+		}
+		vm.push(encodeBool(equal))
+	case OP_SHA256:
+		value, err := vm.pop()
+		if err != nil {
+			return err
+		}
+		hash := sha256.Sum256(value)
+		vm.push(hash[:])
+	case OP_HASH160:
+		value, err := vm.pop()
+		if err != nil {
+			return err
+		}
+		vm.push(Hash160(value))
+	case OP_CHECKSIG, OP_CHECKSIGVERIFY:
+		pubKey, err := vm.pop()
+		if err != nil {
+			return err
+		}
+		sig, err := vm.pop()
+		if err != nil {
+			return err
+		}
+		valid, err := vm.checkSig(sig, pubKey)
+		if err != nil {
+			return err
+		}
+		if in.op == OP_CHECKSIGVERIFY {
+			if !valid {
+				return ErrVerifyFailed
+			}
+			return nil
+		}
+		vm.push(encodeBool(valid))
+	case OP_CHECKLOCKTIMEVERIFY:
+		lockTime, err := vm.peekLockNumber()
+		if err != nil {
+			return err
+		}
+		if vm.checker == nil || !vm.checker.CheckLockTime(lockTime) {
+			return ErrUnsatisfiedLockTime
+		}
+	case OP_CHECKSEQUENCEVERIFY:
+		sequence, err := vm.peekLockNumber()
+		if err != nil {
+			return err
+		}
+		// With the disable flag set the opcode behaves as a NOP
+		if sequence&sequenceLockTimeDisabled != 0 {
+			return nil
+		}
+		if vm.checker == nil || !vm.checker.CheckSequence(sequence) {
+			return ErrUnsatisfiedLockTime
+		}
+	case OP_CHECKMULTISIG, OP_CHECKMULTISIGVERIFY:
+		valid, err := vm.checkMultiSig()
+		if err != nil {
+			return err
+		}
+		if in.op == OP_CHECKMULTISIGVERIFY {
+			if !valid {
+				return ErrVerifyFailed
+			}
+			return nil
+		}
+		vm.push(encodeBool(valid))
+	default:
+		return fmt.Errorf("%w: %s", ErrUnknownOpcode, OpcodeName(in.op))
+	}
+
+	return nil
+}
+
+// checkMultiSig pops <sig...> <m> <pubkey...> <n> and reports whether m of
+// the n keys signed. Signatures must appear in the same order as their keys.
+func (vm *machine) checkMultiSig() (bool, error) {
+	n, err := vm.popCount(MaxPubKeysPerMultiSig)
+	if err != nil {
+		return false, err
+	}
+
+	vm.ops += n
+	if vm.ops > MaxOpsPerScript {
+		return false, ErrTooManyOps
+	}
+
+	pubKeys := make([][]byte, n)
+	for i := n - 1; i >= 0; i-- {
+		pubKeys[i], err = vm.pop()
+		if err != nil {
+			return false, err
+		}
+	}
+
+	m, err := vm.popCount(n)
+	if err != nil {
+		return false, err
+	}
+
+	sigs := make([][]byte, m)
+	for i := m - 1; i >= 0; i-- {
+		sigs[i], err = vm.pop()
+		if err != nil {
+			return false, err
+		}
+	}
+
+	if vm.checker == nil {
+		return m == 0, nil
+	}
+
+	key := 0
+	for _, sig := range sigs {
+		for {
+			if len(pubKeys)-key < 1 {
+				return false, nil
+			}
+			matched, err := vm.checkSig(sig, pubKeys[key])
+			if err != nil {
+				return false, err
+			}
+			key++
+			if matched {
+				break
+			}
+		}
+	}
+
+	return true, nil
+}
+
+// peekLockNumber reads the lock time or sequence on top of the stack. It is
+// left in place, so the value can be up to 5 bytes but never negative.
+func (vm *machine) peekLockNumber() (int64, error) {
+	value, err := vm.peek(0)
+	if err != nil {
+		return 0, err
+	}
+
+	n, err := decodeNumber(value, lockNumberLen)
+	if err != nil {
+		return 0, err
+	}
+	if n < 0 {
+		return 0, fmt.Errorf("%w: negative lock %d", ErrInvalidNumber, n)
+	}
+
+	return int64(n), nil
+}
+
+// checkSig verifies one signature. An empty signature is simply invalid, so
+// scripts can require a signature to be absent.
+func (vm *machine) checkSig(sig, pubKey []byte) (bool, error) {
+	if len(sig) == 0 || vm.checker == nil {
+		return false, nil
+	}
+
+	valid, err := vm.checker.CheckSig(sig, pubKey)
+	if err != nil {
+		return false, fmt.Errorf("%w: %v", ErrBadSignatureEncoding, err)
+	}
+
+	return valid, nil
+}
+
+// popCount pops a multisig count and checks it lies within 0..max
+func (vm *machine) popCount(max int) (int, error) {
+	value, err := vm.pop()
+	if err != nil {
+		return 0, err
+	}
+
+	count, err := decodeNumber(value, defaultNumberLen)
+	if err != nil {
+		return 0, err
+	}
+	if count < 0 || count > max {
+		return 0, fmt.Errorf("%w: %d", ErrInvalidMultiSig, count)
+	}
+
+	return count, nil
+}
+
+func (vm *machine) push(value []byte) {
+	vm.stack = append(vm.stack, value)
+}
+
+func (vm *machine) pop() ([]byte, error) {
+	if len(vm.stack) == 0 {
+		return nil, ErrStackUnderflow
+	}
+
+	value := vm.stack[len(vm.stack)-1]
+	vm.stack = vm.stack[:len(vm.stack)-1]
+
+	return value, nil
+}
+
+func (vm *machine) peek(depth int) ([]byte, error) {
+	if len(vm.stack) <= depth {
+		return nil, ErrStackUnderflow
+	}
+
+	return vm.stack[len(vm.stack)-1-depth], nil
+}
+
+// pushValue returns the stack item pushed by a push instruction
+func pushValue(in instruction) []byte {
+	switch {
+	case in.op == OP_0:
+		return []byte{}
+	case in.op == OP_1NEGATE:
+		return encodeNumber(-1)
+	case in.op >= OP_1 && in.op <= OP_16:
+		return encodeNumber(smallIntValue(in.op))
+	}
+
+	return in.data
+}
+
+// topIsTrue reports whether the stack ends with a true value
+func topIsTrue(stack [][]byte) bool {
+	return len(stack) > 0 && asBool(stack[len(stack)-1])
+}
+
+// asBool interprets a stack item: zero, negative zero and empty are false
+func asBool(value []byte) bool {
+	for i, b := range value {
+		if b != 0 {
+			return !(i == len(value)-1 && b == 0x80)
+		}
+	}
+
+	return false
+}
+
+func encodeBool(value bool) []byte {
+	if value {
+		return []byte{1}
+	}
+
+	return []byte{}
+}
+
+// encodeNumber encodes a number as minimal little-endian sign-magnitude
+func encodeNumber(n int) []byte {
+	if n == 0 {
+		return []byte{}
+	}
+
+	negative := n < 0
+	if negative {
+		n = -n
+	}
+
+	var result []byte
+	for n > 0 {
+		result = append(result, byte(n&0xff))
+		n >>= 8
+	}
+
+	if result[len(result)-1]&0x80 != 0 {
+		extra := byte(0)
+		if negative {
+			extra = 0x80
+		}
+		result = append(result, extra)
+	} else if negative {
+		result[len(result)-1] |= 0x80
+	}
+
+	return result
+}
+
+// decodeNumber decodes a number of at most maxLen bytes
+func decodeNumber(value []byte, maxLen int) (int, error) {
+	if len(value) > maxLen {
+		return 0, fmt.Errorf("%w: %d bytes", ErrInvalidNumber, len(value))
+	}
+	if len(value) == 0 {
+		return 0, nil
+	}
+
+	n := 0
+	for i, b := range value {
+		n |= int(b) << (8 * uint(i))
+	}
+
+	last := value[len(value)-1]
+	if last&0x80 != 0 {
+		n &^= 0x80 << (8 * uint(len(value)-1))
+		n = -n
+	}
+
+	return n, nil
+}
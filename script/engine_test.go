@@ -0,0 +1,233 @@
+package script
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+// testChecker accepts the signature "sig " + pubKey and rejects keys starting
+// with 0xff as badly encoded. Locks up to lockTime and sequence are satisfied.
+type testChecker struct {
+	lockTime int64
+	sequence int64
+}
+
+func (c testChecker) CheckSig(sig, pubKey []byte) (bool, error) {
+	if len(pubKey) > 0 && pubKey[0] == 0xff {
+		return false, errors.New("bad public key")
+	}
+
+	return bytes.Equal(sig, testSig(pubKey)), nil
+}
+
+func (c testChecker) CheckLockTime(lockTime int64) bool {
+	return lockTime <= c.lockTime
+}
+
+func (c testChecker) CheckSequence(sequence int64) bool {
+	return sequence <= c.sequence
+}
+
+func testSig(pubKey []byte) []byte {
+	return append([]byte("sig "), pubKey...)
+}
+
+func mustScript(t *testing.T) func([]byte, error) []byte {
+	return func(script []byte, err error) []byte {
+		t.Helper()
+
+		if err != nil {
+			t.Fatal(err)
+		}
+		return script
+	}
+}
+
+// repeat returns a script of count copies of op
+func repeat(op byte, count int) []byte {
+	return bytes.Repeat([]byte{op}, count)
+}
+
+func TestVerifyStandardScripts(t *testing.T) {
+	must := mustScript(t)
+
+	alice, bob, carol := []byte("alice key"), []byte("bob key"), []byte("carol key")
+	badKey := []byte{0xff, 1, 2, 3}
+
+	p2pkh := must(PayToPubKeyHashScript(Hash160(alice)))
+	badP2PKH := must(PayToPubKeyHashScript(Hash160(badKey)))
+	multiSig := must(MultiSigScript(2, [][]byte{alice, bob, carol}))
+	p2sh := must(PayToScriptHashScript(Hash160(multiSig)))
+	nullData := must(NullDataScript([]byte("hello")))
+
+	tests := []struct {
+		name      string
+		sigScript []byte
+		pkScript  []byte
+		want      error
+	}{
+		{"p2pkh", must(PayToPubKeyHashSigScript(testSig(alice), alice)), p2pkh, nil},
+		{"p2pkh wrong signature", must(PayToPubKeyHashSigScript(testSig(bob), alice)), p2pkh, ErrEvalFalse},
+		{"p2pkh empty signature", must(PayToPubKeyHashSigScript(nil, alice)), p2pkh, ErrEvalFalse},
+		{"p2pkh wrong key", must(PayToPubKeyHashSigScript(testSig(bob), bob)), p2pkh, ErrVerifyFailed},
+		{"p2pkh badly encoded key", must(PayToPubKeyHashSigScript(testSig(badKey), badKey)), badP2PKH, ErrBadSignatureEncoding},
+		{"p2pkh missing key", must(NewBuilder().AddData(testSig(alice)).Script()), p2pkh, ErrVerifyFailed},
+
+		{"bare multisig", must(MultiSigSigScript([][]byte{testSig(alice), testSig(bob)}, nil)), multiSig, nil},
+		{"bare multisig wrong signature", must(MultiSigSigScript([][]byte{testSig(alice), testSig(alice)}, nil)), multiSig, ErrEvalFalse},
+
+		{"p2sh multisig", must(MultiSigSigScript([][]byte{testSig(alice), testSig(carol)}, multiSig)), p2sh, nil},
+		{"p2sh multisig last two keys", must(MultiSigSigScript([][]byte{testSig(bob), testSig(carol)}, multiSig)), p2sh, nil},
+		{"p2sh multisig out of key order", must(MultiSigSigScript([][]byte{testSig(carol), testSig(alice)}, multiSig)), p2sh, ErrEvalFalse},
+		{"p2sh multisig too few signatures", must(MultiSigSigScript([][]byte{testSig(alice)}, multiSig)), p2sh, ErrStackUnderflow},
+		{"p2sh multisig unknown signer", must(MultiSigSigScript([][]byte{testSig(alice), []byte("sig dave")}, multiSig)), p2sh, ErrEvalFalse},
+		{"p2sh wrong redeem script", must(MultiSigSigScript([][]byte{testSig(alice), testSig(bob)}, p2pkh)), p2sh, ErrEvalFalse},
+		{"p2sh without redeem script", nil, p2sh, ErrStackUnderflow},
+
+		{"op_return", must(NewBuilder().AddInt(1).Script()), nullData, ErrEarlyReturn},
+		{"op_return in untaken branch", nil, []byte{OP_0, OP_IF, OP_RETURN, OP_ENDIF, OP_1}, nil},
+
+		{"signature script not push only", []byte{OP_1, OP_DUP}, []byte{OP_EQUAL}, ErrNotPushOnly},
+		{"unbalanced conditional", nil, []byte{OP_1, OP_IF, OP_1}, ErrUnbalancedConditional},
+		{"unknown opcode", nil, []byte{OP_1, 0xba}, ErrUnknownOpcode},
+		{"truncated push", nil, []byte{OP_PUSHDATA1, 5, 1}, ErrMalformedPush},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := Verify(tt.sigScript, tt.pkScript, testChecker{})
+			if !errors.Is(err, tt.want) {
+				t.Errorf("Verify returned %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestVerifyNullDataIsUnspendable(t *testing.T) {
+	nullData, err := NullDataScript([]byte("data"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !IsUnspendable(nullData) {
+		t.Error("null data script is spendable")
+	}
+	if class := GetScriptClass(nullData); class != NullDataTy {
+		t.Errorf("null data script has class %v", class)
+	}
+
+	// No signature script can make OP_RETURN succeed
+	for _, sigScript := range [][]byte{nil, {OP_1}, {OP_0}, {OP_DATA_1, OP_RETURN}} {
+		if err := Verify(sigScript, nullData, testChecker{}); !errors.Is(err, ErrEarlyReturn) {
+			t.Errorf("Verify(%x) returned %v, want %v", sigScript, err, ErrEarlyReturn)
+		}
+	}
+}
+
+func TestVerifyLimits(t *testing.T) {
+	must := mustScript(t)
+
+	size := MaxPushSize + 1
+	oversizedPush := append([]byte{OP_PUSHDATA2, byte(size), byte(size >> 8)}, make([]byte, size)...)
+	largestPush := must(NewBuilder().AddData(make([]byte, MaxPushSize)).Script())
+
+	// Pushes and drops of the largest direct push, padded to the size limit
+	largestScript := []byte{OP_1}
+	for len(largestScript)+int(OP_DATA_75)+2 <= MaxScriptSize {
+		largestScript = append(largestScript, OP_DATA_75)
+		largestScript = append(largestScript, make([]byte, OP_DATA_75)...)
+		largestScript = append(largestScript, OP_DROP)
+	}
+	padding := MaxScriptSize - len(largestScript) - 2
+	largestScript = append(largestScript, byte(padding))
+	largestScript = append(largestScript, make([]byte, padding)...)
+	largestScript = append(largestScript, OP_DROP)
+
+	manyKeys := NewBuilder().AddInt(1)
+	for i := 0; i <= MaxPubKeysPerMultiSig; i++ {
+		manyKeys.AddData([]byte{byte(i + 1)})
+	}
+	tooManyKeys := append(must(manyKeys.Script()), must(NewBuilder().AddNumber(MaxPubKeysPerMultiSig+1).Script())...)
+	tooManyKeys = append(tooManyKeys, OP_CHECKMULTISIG)
+
+	// Each multisig counts its keys as operations, on top of the opcode
+	multiSigOps := append(repeat(OP_NOP, MaxOpsPerScript-MaxPubKeysPerMultiSig-1), OP_0)
+	for i := 0; i < MaxPubKeysPerMultiSig; i++ {
+		multiSigOps = append(multiSigOps, OP_DATA_1, byte(i+1))
+	}
+	multiSigOps = append(multiSigOps, must(NewBuilder().AddNumber(MaxPubKeysPerMultiSig).Script())...)
+	multiSigOps = append(multiSigOps, OP_CHECKMULTISIG)
+
+	tests := []struct {
+		name      string
+		sigScript []byte
+		pkScript  []byte
+		want      error
+	}{
+		{"largest script", nil, largestScript, nil},
+		{"script too big", nil, repeat(OP_1, MaxScriptSize+1), ErrScriptTooBig},
+		{"signature script too big", repeat(OP_1, MaxScriptSize+1), []byte{OP_1}, ErrScriptTooBig},
+		{"largest push", largestPush, must(NewBuilder().AddOp(OP_SIZE).AddNumber(MaxPushSize).AddOp(OP_EQUAL).Script()), nil},
+		{"push too big", nil, append(oversizedPush, OP_1), ErrPushTooBig},
+		{"most operations", nil, append(repeat(OP_NOP, MaxOpsPerScript), OP_1), nil},
+		{"too many operations", nil, append(repeat(OP_NOP, MaxOpsPerScript+1), OP_1), ErrTooManyOps},
+		{"pushes are not operations", nil, append(repeat(OP_NOP, MaxOpsPerScript), repeat(OP_1, 50)...), nil},
+		{"multisig keys counted as operations", nil, multiSigOps, nil},
+		{"multisig keys over the operation limit", nil, append([]byte{OP_NOP}, multiSigOps...), ErrTooManyOps},
+		{"too many multisig keys", nil, tooManyKeys, ErrInvalidMultiSig},
+		{"largest stack", repeat(OP_1, MaxStackSize), []byte{OP_DROP, OP_1}, nil},
+		{"stack overflow", nil, repeat(OP_1, MaxStackSize+1), ErrStackOverflow},
+		{"stack overflow across scripts", repeat(OP_1, MaxStackSize), []byte{OP_1}, ErrStackOverflow},
+		{"stack underflow", nil, []byte{OP_DROP}, ErrStackUnderflow},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := Verify(tt.sigScript, tt.pkScript, testChecker{})
+			if !errors.Is(err, tt.want) {
+				t.Errorf("Verify returned %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestVerifyTimeLocks(t *testing.T) {
+	must := mustScript(t)
+
+	checker := testChecker{lockTime: 500, sequence: 10}
+	unlocked := []byte{OP_1}
+
+	tests := []struct {
+		name     string
+		pkScript []byte
+		want     error
+	}{
+		{"cltv satisfied", must(LockTimeScript(499, unlocked)), nil},
+		{"cltv at the lock time", must(LockTimeScript(500, unlocked)), nil},
+		{"cltv not yet", must(LockTimeScript(501, unlocked)), ErrUnsatisfiedLockTime},
+		{"cltv zero", must(LockTimeScript(0, unlocked)), nil},
+		{"cltv largest lock", must(LockTimeScript(0xffffffff, unlocked)), ErrUnsatisfiedLockTime},
+		{"cltv negative lock", []byte{OP_1NEGATE, OP_CHECKLOCKTIMEVERIFY, OP_DROP, OP_1}, ErrInvalidNumber},
+		{"cltv six byte lock", []byte{6, 1, 0, 0, 0, 0, 0, OP_CHECKLOCKTIMEVERIFY, OP_DROP, OP_1}, ErrInvalidNumber},
+		{"cltv empty stack", []byte{OP_CHECKLOCKTIMEVERIFY}, ErrStackUnderflow},
+		{"cltv leaves the lock", []byte{OP_1, OP_CHECKLOCKTIMEVERIFY}, nil},
+		{"cltv wrapped script fails", must(LockTimeScript(1, []byte{OP_0})), ErrEvalFalse},
+
+		{"csv satisfied", must(SequenceLockScript(10, unlocked)), nil},
+		{"csv not yet", must(SequenceLockScript(11, unlocked)), ErrUnsatisfiedLockTime},
+		{"csv disabled", must(SequenceLockScript(sequenceLockTimeDisabled|1000, unlocked)), nil},
+		{"csv negative sequence", []byte{OP_1NEGATE, OP_CHECKSEQUENCEVERIFY, OP_DROP, OP_1}, ErrInvalidNumber},
+		{"csv empty stack", []byte{OP_CHECKSEQUENCEVERIFY}, ErrStackUnderflow},
+		{"csv in untaken branch", []byte{OP_0, OP_IF, OP_CHECKSEQUENCEVERIFY, OP_ENDIF, OP_1}, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := Verify(nil, tt.pkScript, checker)
+			if !errors.Is(err, tt.want) {
+				t.Errorf("Verify returned %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
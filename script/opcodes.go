@@ -0,0 +1,103 @@
+package script
+
+import "fmt"
+
+// Opcodes understood by the interpreter. Values follow Bitcoin script so
+// scripts are easy to compare with existing tooling.
+const (
+	OP_0                   = byte(0x00)
+	OP_FALSE               = OP_0
+	OP_DATA_1              = byte(0x01)
+	OP_DATA_75             = byte(0x4b)
+	OP_PUSHDATA1           = byte(0x4c)
+	OP_PUSHDATA2           = byte(0x4d)
+	OP_1NEGATE             = byte(0x4f)
+	OP_1                   = byte(0x51)
+	OP_TRUE                = OP_1
+	OP_16                  = byte(0x60)
+	OP_NOP                 = byte(0x61)
+	OP_IF                  = byte(0x63)
+	OP_NOTIF               = byte(0x64)
+	OP_ELSE                = byte(0x67)
+	OP_ENDIF               = byte(0x68)
+	OP_VERIFY              = byte(0x69)
+	OP_RETURN              = byte(0x6a)
+	OP_DROP                = byte(0x75)
+	OP_DUP                 = byte(0x76)
+	OP_SWAP                = byte(0x7c)
+	OP_SIZE                = byte(0x82)
+	OP_EQUAL               = byte(0x87)
+	OP_EQUALVERIFY         = byte(0x88)
+	OP_SHA256              = byte(0xa8)
+	OP_HASH160             = byte(0xa9)
+	OP_CHECKSIG            = byte(0xac)
+	OP_CHECKSIGVERIFY      = byte(0xad)
+	OP_CHECKMULTISIG       = byte(0xae)
+	OP_CHECKMULTISIGVERIFY = byte(0xaf)
+	OP_CHECKLOCKTIMEVERIFY = byte(0xb1)
+	OP_CHECKSEQUENCEVERIFY = byte(0xb2)
+)
+
+// opcodeNames maps the named opcodes to their disassembly
+var opcodeNames = map[byte]string{
+	OP_0:                   "OP_0",
+	OP_PUSHDATA1:           "OP_PUSHDATA1",
+	OP_PUSHDATA2:           "OP_PUSHDATA2",
+	OP_1NEGATE:             "OP_1NEGATE",
+	OP_NOP:                 "OP_NOP",
+	OP_IF:                  "OP_IF",
+	OP_NOTIF:               "OP_NOTIF",
+	OP_ELSE:                "OP_ELSE",
+	OP_ENDIF:               "OP_ENDIF",
+	OP_VERIFY:              "OP_VERIFY",
+	OP_RETURN:              "OP_RETURN",
+	OP_DROP:                "OP_DROP",
+	OP_DUP:                 "OP_DUP",
+	OP_SWAP:                "OP_SWAP",
+	OP_SIZE:                "OP_SIZE",
+	OP_EQUAL:               "OP_EQUAL",
+	OP_EQUALVERIFY:         "OP_EQUALVERIFY",
+	OP_SHA256:              "OP_SHA256",
+	OP_HASH160:             "OP_HASH160",
+	OP_CHECKSIG:            "OP_CHECKSIG",
+	OP_CHECKSIGVERIFY:      "OP_CHECKSIGVERIFY",
+	OP_CHECKMULTISIG:       "OP_CHECKMULTISIG",
+	OP_CHECKMULTISIGVERIFY: "OP_CHECKMULTISIGVERIFY",
+	OP_CHECKLOCKTIMEVERIFY: "OP_CHECKLOCKTIMEVERIFY",
+	OP_CHECKSEQUENCEVERIFY: "OP_CHECKSEQUENCEVERIFY",
+}
+
+// OpcodeName returns the disassembly name of an opcode
+func OpcodeName(op byte) string {
+	if name, ok := opcodeNames[op]; ok {
+		return name
+	}
+	if op >= OP_1 && op <= OP_16 {
+		return fmt.Sprintf("OP_%d", op-OP_1+1)
+	}
+
+	return fmt.Sprintf("OP_UNKNOWN_%02x", op)
+}
+
+// isSmallInt reports whether op pushes a number from 0 to 16
+func isSmallInt(op byte) bool {
+	return op == OP_0 || (op >= OP_1 && op <= OP_16)
+}
+
+// smallIntValue returns the number pushed by a small integer opcode
+func smallIntValue(op byte) int {
+	if op == OP_0 {
+		return 0
+	}
+
+	return int(op-OP_1) + 1
+}
+
+// smallIntOp returns the opcode pushing a number from 0 to 16
+func smallIntOp(n int) byte {
+	if n == 0 {
+		return OP_0
+	}
+
+	return OP_1 + byte(n-1)
+}
@@ -0,0 +1,236 @@
+package script
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/ripemd160"
+)
+
+// Consensus limits enforced by the interpreter
+const (
+	MaxScriptSize         = 10000
+	MaxPushSize           = 520
+	MaxOpsPerScript       = 201
+	MaxStackSize          = 1000
+	MaxPubKeysPerMultiSig = 20
+)
+
+// Byte lengths of numbers read from the stack. Lock times need 5 bytes to
+// hold every positive 32 bit value.
+const (
+	defaultNumberLen = 4
+	lockNumberLen    = 5
+)
+
+// sequenceLockTimeDisabled is the sequence bit turning OP_CHECKSEQUENCEVERIFY
+// into a NOP
+const sequenceLockTimeDisabled = 1 << 31
+
+// Script errors
+var (
+	ErrScriptTooBig          = errors.New("script is too big")
+	ErrPushTooBig            = errors.New("push exceeds the maximum element size")
+	ErrTooManyOps            = errors.New("script exceeds the operation limit")
+	ErrStackOverflow         = errors.New("stack exceeds the maximum size")
+	ErrStackUnderflow        = errors.New("operation needs more stack items")
+	ErrMalformedPush         = errors.New("push runs past the end of the script")
+	ErrUnknownOpcode         = errors.New("unknown opcode")
+	ErrUnbalancedConditional = errors.New("unbalanced conditional")
+	ErrVerifyFailed          = errors.New("verify failed")
+	ErrEarlyReturn           = errors.New("script returned early")
+	ErrEvalFalse             = errors.New("script evaluated to false")
+	ErrNotPushOnly           = errors.New("signature script is not push only")
+	ErrInvalidMultiSig       = errors.New("invalid multisig counts")
+	ErrInvalidNumber         = errors.New("invalid script number")
+	ErrUnsatisfiedLockTime   = errors.New("timelock is not satisfied")
+	ErrBadSignatureEncoding  = errors.New("non-canonical signature or public key")
+)
+
+// instruction is a parsed opcode with the data it pushes, if any
+type instruction struct {
+	op   byte
+	data []byte
+}
+
+// parse splits a script into instructions
+func parse(script []byte) ([]instruction, error) {
+	var instructions []instruction
+
+	for i := 0; i < len(script); {
+		op := script[i]
+		i++
+
+		var size int
+		switch {
+		case op >= OP_DATA_1 && op <= OP_DATA_75:
+			size = int(op)
+		case op == OP_PUSHDATA1:
+			if i+1 > len(script) {
+				return nil, ErrMalformedPush
+			}
+			size = int(script[i])
+			i++
+		case op == OP_PUSHDATA2:
+			if i+2 > len(script) {
+				return nil, ErrMalformedPush
+			}
+			size = int(binary.LittleEndian.Uint16(script[i:]))
+			i += 2
+		default:
+			instructions = append(instructions, instruction{op, nil})
+			continue
+		}
+
+		if i+size > len(script) {
+			return nil, ErrMalformedPush
+		}
+		instructions = append(instructions, instruction{op, script[i : i+size]})
+		i += size
+	}
+
+	return instructions, nil
+}
+
+// isPush reports whether the instruction only pushes data
+func (in instruction) isPush() bool {
+	return in.op <= OP_16 && in.op != 0x50
+}
+
+// IsPushOnly reports whether a script consists of data pushes only
+func IsPushOnly(script []byte) bool {
+	instructions, err := parse(script)
+	if err != nil {
+		return false
+	}
+
+	for _, in := range instructions {
+		if !in.isPush() {
+			return false
+		}
+	}
+
+	return true
+}
+
+// PushedData returns the data pushed by a push only script
+func PushedData(script []byte) ([][]byte, error) {
+	instructions, err := parse(script)
+	if err != nil {
+		return nil, err
+	}
+
+	var data [][]byte
+	for _, in := range instructions {
+		if !in.isPush() {
+			return nil, ErrNotPushOnly
+		}
+		data = append(data, pushValue(in))
+	}
+
+	return data, nil
+}
+
+// Disassemble returns a human-readable form of a script
+func Disassemble(script []byte) string {
+	instructions, err := parse(script)
+	if err != nil {
+		return fmt.Sprintf("[error: %v] %x", err, script)
+	}
+
+	var parts []string
+	for _, in := range instructions {
+		if in.data != nil {
+			parts = append(parts, hex.EncodeToString(in.data))
+		} else {
+			parts = append(parts, OpcodeName(in.op))
+		}
+	}
+
+	return strings.Join(parts, " ")
+}
+
+// Hash160 returns RIPEMD160(SHA256(data)), the hash used by P2PKH and P2SH
+func Hash160(data []byte) []byte {
+	sha := sha256.Sum256(data)
+
+	hasher := ripemd160.New()
+	hasher.Write(sha[:])
+
+	return hasher.Sum(nil)
+}
+
+// Builder assembles a script from opcodes and data pushes
+type Builder struct {
+	script []byte
+	err    error
+}
+
+// NewBuilder creates an empty script Builder
+func NewBuilder() *Builder {
+	return &Builder{}
+}
+
+// AddOp appends an opcode
+func (b *Builder) AddOp(op byte) *Builder {
+	b.script = append(b.script, op)
+	return b
+}
+
+// AddInt appends the opcode pushing a number from 0 to 16
+func (b *Builder) AddInt(n int) *Builder {
+	if n < 0 || n > 16 {
+		b.err = fmt.Errorf("%w: %d is not a small integer", ErrInvalidNumber, n)
+		return b
+	}
+
+	return b.AddOp(smallIntOp(n))
+}
+
+// AddData appends the smallest push of data
+func (b *Builder) AddData(data []byte) *Builder {
+	size := len(data)
+
+	switch {
+	case size > MaxPushSize:
+		b.err = fmt.Errorf("%w: %d bytes", ErrPushTooBig, size)
+		return b
+	case size == 0:
+		b.script = append(b.script, OP_0)
+		return b
+	case size <= int(OP_DATA_75):
+		b.script = append(b.script, byte(size))
+	case size <= 0xff:
+		b.script = append(b.script, OP_PUSHDATA1, byte(size))
+	default:
+		b.script = append(b.script, OP_PUSHDATA2, byte(size), byte(size>>8))
+	}
+	b.script = append(b.script, data...)
+
+	return b
+}
+
+// AddNumber appends the smallest push of a script number
+func (b *Builder) AddNumber(n int64) *Builder {
+	if n >= 0 && n <= 16 {
+		return b.AddInt(int(n))
+	}
+
+	return b.AddData(encodeNumber(int(n)))
+}
+
+// Script returns the assembled script
+func (b *Builder) Script() ([]byte, error) {
+	if b.err != nil {
+		return nil, b.err
+	}
+	if len(b.script) > MaxScriptSize {
+		return nil, ErrScriptTooBig
+	}
+
+	return b.script, nil
+}
@@ -0,0 +1,267 @@
+package script
+
+import (
+	"fmt"
+)
+
+// MaxDataCarrierSize is the largest payload of an OP_RETURN output
+const MaxDataCarrierSize = 80
+
+// ScriptClass identifies a standard script template
+type ScriptClass int
+
+// Standard script classes
+const (
+	NonStandardTy ScriptClass = iota
+	PubKeyHashTy
+	ScriptHashTy
+	MultiSigTy
+	NullDataTy
+)
+
+// String returns the name of the script class
+func (c ScriptClass) String() string {
+	switch c {
+	case PubKeyHashTy:
+		return "pubkeyhash"
+	case ScriptHashTy:
+		return "scripthash"
+	case MultiSigTy:
+		return "multisig"
+	case NullDataTy:
+		return "nulldata"
+	default:
+		return "nonstandard"
+	}
+}
+
+// PayToPubKeyHashScript locks an output to the owner of a public key hash:
+// OP_DUP OP_HASH160 <hash> OP_EQUALVERIFY OP_CHECKSIG
+func PayToPubKeyHashScript(pubKeyHash []byte) ([]byte, error) {
+	return NewBuilder().AddOp(OP_DUP).AddOp(OP_HASH160).AddData(pubKeyHash).
+		AddOp(OP_EQUALVERIFY).AddOp(OP_CHECKSIG).Script()
+}
+
+// PayToScriptHashScript locks an output to the hash of a redeem script:
+// OP_HASH160 <hash> OP_EQUAL
+func PayToScriptHashScript(scriptHash []byte) ([]byte, error) {
+	return NewBuilder().AddOp(OP_HASH160).AddData(scriptHash).AddOp(OP_EQUAL).Script()
+}
+
+// MultiSigScript requires m signatures out of the given public keys:
+// <m> <pubkey...> <n> OP_CHECKMULTISIG
+func MultiSigScript(m int, pubKeys [][]byte) ([]byte, error) {
+	n := len(pubKeys)
+	if n == 0 || n > 16 || m < 1 || m > n {
+		return nil, fmt.Errorf("%w: %d of %d", ErrInvalidMultiSig, m, n)
+	}
+
+	builder := NewBuilder().AddInt(m)
+	for _, pubKey := range pubKeys {
+		builder.AddData(pubKey)
+	}
+
+	return builder.AddInt(n).AddOp(OP_CHECKMULTISIG).Script()
+}
+
+// NullDataScript creates a provably unspendable output carrying data:
+// OP_RETURN <data>
+func NullDataScript(data []byte) ([]byte, error) {
+	if len(data) > MaxDataCarrierSize {
+		return nil, fmt.Errorf("%w: %d bytes of null data", ErrPushTooBig, len(data))
+	}
+
+	return NewBuilder().AddOp(OP_RETURN).AddData(data).Script()
+}
+
+// LockTimeScript adds an absolute timelock in front of a locking script:
+// <lockTime> OP_CHECKLOCKTIMEVERIFY OP_DROP <script>
+func LockTimeScript(lockTime int64, lockingScript []byte) ([]byte, error) {
+	return timeLockScript(OP_CHECKLOCKTIMEVERIFY, lockTime, lockingScript)
+}
+
+// SequenceLockScript adds a relative timelock in front of a locking script:
+// <sequence> OP_CHECKSEQUENCEVERIFY OP_DROP <script>
+func SequenceLockScript(sequence int64, lockingScript []byte) ([]byte, error) {
+	return timeLockScript(OP_CHECKSEQUENCEVERIFY, sequence, lockingScript)
+}
+
+func timeLockScript(op byte, lock int64, lockingScript []byte) ([]byte, error) {
+	if lock < 0 || lock > 0xffffffff {
+		return nil, fmt.Errorf("%w: lock %d", ErrInvalidNumber, lock)
+	}
+
+	prefix, err := NewBuilder().AddNumber(lock).AddOp(op).AddOp(OP_DROP).Script()
+	if err != nil {
+		return nil, err
+	}
+
+	return append(prefix, lockingScript...), nil
+}
+
+// PayToPubKeyHashSigScript unlocks a P2PKH output: <sig> <pubkey>
+func PayToPubKeyHashSigScript(sig, pubKey []byte) ([]byte, error) {
+	return NewBuilder().AddData(sig).AddData(pubKey).Script()
+}
+
+// MultiSigSigScript unlocks a multisig output with signatures given in key
+// order. For P2SH outputs the redeem script is pushed last.
+func MultiSigSigScript(sigs [][]byte, redeemScript []byte) ([]byte, error) {
+	builder := NewBuilder()
+	for _, sig := range sigs {
+		builder.AddData(sig)
+	}
+	if redeemScript != nil {
+		builder.AddData(redeemScript)
+	}
+
+	return builder.Script()
+}
+
+// GetScriptClass returns the standard template a locking script follows
+func GetScriptClass(script []byte) ScriptClass {
+	instructions, err := parse(script)
+	if err != nil {
+		return NonStandardTy
+	}
+
+	switch {
+	case isPubKeyHash(instructions):
+		return PubKeyHashTy
+	case isScriptHash(instructions):
+		return ScriptHashTy
+	case isMultiSig(instructions):
+		return MultiSigTy
+	case isNullData(instructions):
+		return NullDataTy
+	}
+
+	return NonStandardTy
+}
+
+// IsPayToScriptHash reports whether a locking script is a P2SH script
+func IsPayToScriptHash(script []byte) bool {
+	return GetScriptClass(script) == ScriptHashTy
+}
+
+// IsUnspendable reports whether no signature script can ever spend the output
+func IsUnspendable(script []byte) bool {
+	return len(script) > MaxScriptSize || (len(script) > 0 && script[0] == OP_RETURN)
+}
+
+// ExtractPubKeyHash returns the public key hash of a P2PKH script, or nil
+func ExtractPubKeyHash(script []byte) []byte {
+	instructions, err := parse(script)
+	if err != nil || !isPubKeyHash(instructions) {
+		return nil
+	}
+
+	return instructions[2].data
+}
+
+// ExtractScriptHash returns the script hash of a P2SH script, or nil
+func ExtractScriptHash(script []byte) []byte {
+	instructions, err := parse(script)
+	if err != nil || !isScriptHash(instructions) {
+		return nil
+	}
+
+	return instructions[1].data
+}
+
+// ExtractTimeLock splits a script built by LockTimeScript or
+// SequenceLockScript into the timelock opcode, the lock and the wrapped
+// locking script. Scripts without a timelock are returned with opcode 0.
+func ExtractTimeLock(script []byte) (byte, int64, []byte) {
+	instructions, err := parse(script)
+	if err != nil || len(instructions) < 3 {
+		return 0, 0, script
+	}
+
+	op := instructions[1].op
+	if (op != OP_CHECKLOCKTIMEVERIFY && op != OP_CHECKSEQUENCEVERIFY) ||
+		instructions[2].op != OP_DROP || !instructions[0].isPush() {
+		return 0, 0, script
+	}
+
+	lock, err := decodeNumber(pushValue(instructions[0]), lockNumberLen)
+	if err != nil || lock < 0 {
+		return 0, 0, script
+	}
+
+	// Lock numbers are at most 5 bytes, so they are always direct pushes
+	prefixLen := 1 + len(instructions[0].data) + 2
+
+	return op, int64(lock), script[prefixLen:]
+}
+
+// ExtractMultiSig returns the required signature count and public keys of
+// a multisig script
+func ExtractMultiSig(script []byte) (int, [][]byte, error) {
+	instructions, err := parse(script)
+	if err != nil {
+		return 0, nil, err
+	}
+	if !isMultiSig(instructions) {
+		return 0, nil, fmt.Errorf("%w: not a multisig script", ErrInvalidMultiSig)
+	}
+
+	var pubKeys [][]byte
+	for _, in := range instructions[1 : len(instructions)-2] {
+		pubKeys = append(pubKeys, in.data)
+	}
+
+	return smallIntValue(instructions[0].op), pubKeys, nil
+}
+
+func isPubKeyHash(instructions []instruction) bool {
+	return len(instructions) == 5 &&
+		instructions[0].op == OP_DUP &&
+		instructions[1].op == OP_HASH160 &&
+		len(instructions[2].data) == 20 && instructions[2].op == 20 &&
+		instructions[3].op == OP_EQUALVERIFY &&
+		instructions[4].op == OP_CHECKSIG
+}
+
+func isScriptHash(instructions []instruction) bool {
+	return len(instructions) == 3 &&
+		instructions[0].op == OP_HASH160 &&
+		len(instructions[1].data) == 20 && instructions[1].op == 20 &&
+		instructions[2].op == OP_EQUAL
+}
+
+func isMultiSig(instructions []instruction) bool {
+	count := len(instructions)
+	if count < 4 || instructions[count-1].op != OP_CHECKMULTISIG {
+		return false
+	}
+
+	first, last := instructions[0].op, instructions[count-2].op
+	if !isSmallInt(first) || !isSmallInt(last) {
+		return false
+	}
+
+	m, n := smallIntValue(first), smallIntValue(last)
+	if m < 1 || m > n || n != count-3 {
+		return false
+	}
+
+	for _, in := range instructions[1 : count-2] {
+		if len(in.data) == 0 {
+			return false
+		}
+	}
+
+	return true
+}
+
+func isNullData(instructions []instruction) bool {
+	if len(instructions) == 1 {
+		return instructions[0].op == OP_RETURN
+	}
+
+	return len(instructions) == 2 &&
+		instructions[0].op == OP_RETURN &&
+		instructions[1].isPush() &&
+		len(instructions[1].data) <= MaxDataCarrierSize
+}
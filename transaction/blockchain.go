@@ -2,24 +2,48 @@ package transaction
 
 import (
 	"bytes"
-	"crypto/ecdsa"
+	"context"
+	"encoding/gob"
 	"encoding/hex"
 	"errors"
 	"fmt"
 	"log"
+	"math/big"
 	"os"
+	"sync"
+	"time"
+
+	"blockchain-app/chaincfg"
+	"blockchain-app/merkle"
+	"blockchain-app/pow"
+	"blockchain-app/script"
+	"blockchain-app/wallet"
 
 	"github.com/dgraph-io/badger/v3"
 )
 
-const dbFile = "./blockchain-tx.db"
+const dbFile = "./blockchain-tx_%s.db"
 const blocksBucket = "blocks"
 const genesisCoinbaseData = "The Times 03/Jan/2009 Chancellor on brink of second bailout for banks"
 
-// Blockchain implements interactions with a DB
+// blockEncodingVersion prefixes every serialized block so the format can
+// evolve. Version 3 stores Bits as a compact uint32 target instead of a
+// count of leading zero bits; older databases must be recreated.
+const blockEncodingVersion = byte(3)
+
+// miner solves proof of work for new blocks
+var miner pow.Miner = pow.DefaultMiner
+
+// Blockchain implements interactions with a DB. Changes to the chain, such
+// as adding blocks and rebuilding indexes, are serialized by mu, so a miner
+// and blocks arriving from peers can add blocks at the same time.
 type Blockchain struct {
-	tip []byte
-	db  *badger.DB
+	mu           sync.Mutex
+	stateMu      sync.RWMutex // guards tip and txIndex
+	tip          []byte
+	db           *badger.DB
+	reorgHandler ReorgHandler
+	txIndex      bool
 }
 
 // Block represents a block in the blockchain
@@ -27,8 +51,10 @@ type Block struct {
 	Timestamp     int64
 	Transactions  []*Transaction
 	PrevBlockHash []byte
+	MerkleRoot    []byte
 	Hash          []byte
 	Nonce         int
+	Bits          uint32
 	Height        int
 }
 
@@ -40,7 +66,7 @@ type BlockchainIterator struct {
 
 // CreateBlockchain creates a new blockchain DB
 func CreateBlockchain(address, nodeID string) *Blockchain {
-	dbFile := fmt.Sprintf(dbFile, nodeID)
+	dbFile := dbPath(nodeID)
 	if dbExists(dbFile) {
 		fmt.Println("Blockchain already exists.")
 		os.Exit(1)
@@ -48,7 +74,10 @@ func CreateBlockchain(address, nodeID string) *Blockchain {
 
 	var tip []byte
 
-	cbtx := NewCoinbaseTX(address, genesisCoinbaseData)
+	cbtx, err := NewCoinbaseTX(address, genesisCoinbaseData, 0, 0)
+	if err != nil {
+		log.Panic(err)
+	}
 	genesis := NewGenesisBlock(cbtx)
 
 	opts := badger.DefaultOptions(dbFile)
@@ -70,21 +99,32 @@ func CreateBlockchain(address, nodeID string) *Blockchain {
 		}
 		tip = genesis.Hash
 
-		return err
+		err = txn.Set(workKey(genesis.Hash), pow.CalcWork(genesis.Bits).Bytes())
+		if err != nil {
+			return err
+		}
+
+		return setBlockHeight(txn, genesis)
 	})
 
 	if err != nil {
 		log.Panic(err)
 	}
 
-	bc := Blockchain{tip, db}
+	bc := Blockchain{tip: tip, db: db}
+	UTXOSet{&bc}.Reindex()
+
+	err = bc.EnableTxIndex()
+	if err != nil {
+		log.Panic(err)
+	}
 
 	return &bc
 }
 
 // NewBlockchain creates a new Blockchain with genesis Block
 func NewBlockchain(nodeID string) *Blockchain {
-	dbFile := fmt.Sprintf(dbFile, nodeID)
+	dbFile := dbPath(nodeID)
 	if dbExists(dbFile) == false {
 		fmt.Println("No existing blockchain found. Create one first.")
 		os.Exit(1)
@@ -104,7 +144,7 @@ func NewBlockchain(nodeID string) *Blockchain {
 			return err
 		}
 		err = item.Value(func(val []byte) error {
-			tip = val
+			tip = append([]byte{}, val...)
 			return nil
 		})
 		return err
@@ -114,71 +154,150 @@ func NewBlockchain(nodeID string) *Blockchain {
 		log.Panic(err)
 	}
 
-	bc := Blockchain{tip, db}
+	bc := Blockchain{tip: tip, db: db}
+
+	err = bc.ensureHeightIndex()
+	if err != nil {
+		log.Panic(err)
+	}
+
+	err = bc.loadTxIndexSetting()
+	if err != nil {
+		log.Panic(err)
+	}
 
 	return &bc
 }
 
-// AddBlock saves the block into the blockchain
-func (bc *Blockchain) AddBlock(block *Block) {
-	err := bc.db.Update(func(txn *badger.Txn) error {
-		blockInDb, _ := txn.Get(block.Hash)
-		if blockInDb != nil {
-			return nil
-		}
+// AddBlock validates the block and saves it into the blockchain together
+// with its cumulative chain work. A block that extends the current tip
+// becomes the new tip; a block on another branch triggers a reorganization
+// once its branch carries more work than the best chain, otherwise it is
+// only stored.
+func (bc *Blockchain) AddBlock(block *Block) error {
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
 
-		blockData := block.Serialize()
-		err := txn.Set(block.Hash, blockData)
-		if err != nil {
-			return err
-		}
+	if bc.HasBlock(block.Hash) {
+		return nil
+	}
 
-		item, err := txn.Get([]byte("lh"))
-		if err != nil {
-			return err
-		}
-		var lastHash []byte
-		err = item.Value(func(val []byte) error {
-			lastHash = val
-			return nil
-		})
+	err := bc.ValidateBlock(block)
+	if err != nil {
+		return err
+	}
+
+	prevWork, err := bc.chainWork(block.PrevBlockHash)
+	if err != nil {
+		return err
+	}
+	work := new(big.Int).Add(prevWork, pow.CalcWork(block.Bits))
+
+	err = bc.db.Update(func(txn *badger.Txn) error {
+		err := txn.Set(block.Hash, block.Serialize())
 		if err != nil {
 			return err
 		}
 
-		item, err = txn.Get(lastHash)
-		if err != nil {
-			return err
+		return txn.Set(workKey(block.Hash), work.Bytes())
+	})
+
+	if err != nil {
+		return err
+	}
+
+	tip := bc.currentTip()
+	if bytes.Equal(block.PrevBlockHash, tip) {
+		return bc.connectBlock(block)
+	}
+
+	tipWork, err := bc.chainWork(tip)
+	if err != nil {
+		return err
+	}
+	if work.Cmp(tipWork) > 0 {
+		return bc.reorganize(block)
+	}
+
+	return nil
+}
+
+// MerkleProof proves that a transaction is included in a block. The merkle
+// root commits to TxHash, the hash of the whole transaction with its
+// unlocking scripts.
+type MerkleProof struct {
+	BlockHash  []byte
+	MerkleRoot []byte
+	TxID       []byte
+	TxHash     []byte
+	Proof      *merkle.Proof
+}
+
+// Verify checks the proof against the merkle root it carries
+func (p *MerkleProof) Verify() bool {
+	return merkle.Verify(p.MerkleRoot, p.TxHash, p.Proof)
+}
+
+// GetMerkleProof finds the block containing a transaction and returns the
+// proof of its inclusion in that block
+func (bc *Blockchain) GetMerkleProof(txID []byte) (*MerkleProof, error) {
+	if bc.TxIndexEnabled() {
+		block, position, err := bc.findIndexedBlock(txID)
+		if err == errTxNotIndexed {
+			return nil, errors.New("Transaction is not found")
 		}
-		var lastBlockData []byte
-		err = item.Value(func(val []byte) error {
-			lastBlockData = val
-			return nil
-		})
 		if err != nil {
-			return err
+			return nil, err
 		}
 
-		lastBlock := DeserializeBlock(lastBlockData)
+		return block.merkleProof(position)
+	}
 
-		if block.Height > lastBlock.Height {
-			err = txn.Set([]byte("lh"), block.Hash)
-			if err != nil {
-				return err
+	bci := bc.Iterator()
+
+	for {
+		block := bci.Next()
+
+		for i, tx := range block.Transactions {
+			if bytes.Equal(tx.ID, txID) {
+				return block.merkleProof(i)
 			}
-			bc.tip = block.Hash
 		}
 
-		return nil
+		if len(block.PrevBlockHash) == 0 {
+			break
+		}
+	}
+
+	return nil, errors.New("Transaction is not found")
+}
+
+// Close closes the blockchain database
+func (bc *Blockchain) Close() error {
+	return bc.db.Close()
+}
+
+// HasBlock reports whether a block with the given hash is stored
+func (bc *Blockchain) HasBlock(blockHash []byte) bool {
+	err := bc.db.View(func(txn *badger.Txn) error {
+		_, err := txn.Get(blockHash)
+		return err
 	})
 
-	if err != nil {
-		log.Panic(err)
-	}
+	return err == nil
 }
 
-// FindTransaction finds a transaction by its ID
+// FindTransaction finds a transaction by its ID, through the transaction
+// index when it is enabled and by scanning the chain otherwise
 func (bc *Blockchain) FindTransaction(ID []byte) (Transaction, error) {
+	if bc.TxIndexEnabled() {
+		tx, err := bc.findIndexedTransaction(ID)
+		if err == errTxNotIndexed {
+			return Transaction{}, errors.New("Transaction is not found")
+		}
+		return tx, err
+	}
+
 	bci := bc.Iterator()
 
 	for {
@@ -212,6 +331,10 @@ func (bc *Blockchain) FindUTXO() map[string]TXOutputs {
 
 		Outputs:
 			for outIdx, out := range tx.Vout {
+				if script.IsUnspendable(out.ScriptPubKey) {
+					continue
+				}
+
 				// Was the output spent?
 				if spentTXOs[txID] != nil {
 					for _, spentOutIdx := range spentTXOs[txID] {
@@ -222,7 +345,10 @@ func (bc *Blockchain) FindUTXO() map[string]TXOutputs {
 				}
 
 				outs := UTXO[txID]
-				outs.Outputs = append(outs.Outputs, out)
+				if outs.Outputs == nil {
+					outs = TXOutputs{make(map[int]TXOutput), tx.IsCoinbase(), block.Height}
+				}
+				outs.Outputs[outIdx] = out
 				UTXO[txID] = outs
 			}
 
@@ -242,9 +368,113 @@ func (bc *Blockchain) FindUTXO() map[string]TXOutputs {
 	return UTXO
 }
 
+// FindUsedPubKeyHashes returns the hex encoded key hashes paid by P2PKH
+// outputs anywhere in the chain, spent or not, so a restored wallet can tell
+// which of its addresses were ever used
+func (bc *Blockchain) FindUsedPubKeyHashes() map[string]bool {
+	used := make(map[string]bool)
+	bci := bc.Iterator()
+
+	for {
+		block := bci.Next()
+
+		for _, tx := range block.Transactions {
+			for _, out := range tx.Vout {
+				_, _, lockingScript := script.ExtractTimeLock(out.ScriptPubKey)
+				if pubKeyHash := script.ExtractPubKeyHash(lockingScript); pubKeyHash != nil {
+					used[hex.EncodeToString(pubKeyHash)] = true
+				}
+			}
+		}
+
+		if len(block.PrevBlockHash) == 0 {
+			break
+		}
+	}
+
+	return used
+}
+
+// WalletTransaction is a transaction touching the addresses of a wallet:
+// what its outputs paid to them and what its inputs spent of theirs
+type WalletTransaction struct {
+	TxID     []byte
+	Height   int
+	Coinbase bool
+	Received int
+	Sent     int
+}
+
+// FindWalletTransactions returns the transactions paying to or spending
+// from the hex encoded key hashes, newest first
+func (bc *Blockchain) FindWalletTransactions(pubKeyHashes map[string]bool) []WalletTransaction {
+	var history []WalletTransaction
+	var inputs [][]TXInput
+	owned := make(map[string]int)
+	bci := bc.Iterator()
+
+	for {
+		block := bci.Next()
+
+		for _, tx := range block.Transactions {
+			entry := WalletTransaction{TxID: tx.ID, Height: block.Height, Coinbase: tx.IsCoinbase()}
+
+			for outIdx, out := range tx.Vout {
+				_, _, lockingScript := script.ExtractTimeLock(out.ScriptPubKey)
+				pubKeyHash := script.ExtractPubKeyHash(lockingScript)
+				if pubKeyHash != nil && pubKeyHashes[hex.EncodeToString(pubKeyHash)] {
+					entry.Received += out.Value
+					owned[fmt.Sprintf("%x:%d", tx.ID, outIdx)] = out.Value
+				}
+			}
+
+			history = append(history, entry)
+			if entry.Coinbase {
+				inputs = append(inputs, nil)
+			} else {
+				inputs = append(inputs, tx.Vin)
+			}
+		}
+
+		if len(block.PrevBlockHash) == 0 {
+			break
+		}
+	}
+
+	// Spent outputs are older than their spenders, so they are all known
+	// once the walk reaches the genesis block
+	var found []WalletTransaction
+	for i, entry := range history {
+		for _, in := range inputs[i] {
+			entry.Sent += owned[fmt.Sprintf("%x:%d", in.Txid, in.Vout)]
+		}
+		if entry.Received != 0 || entry.Sent != 0 {
+			found = append(found, entry)
+		}
+	}
+
+	return found
+}
+
+// currentTip returns the hash of the best block
+func (bc *Blockchain) currentTip() []byte {
+	bc.stateMu.RLock()
+	defer bc.stateMu.RUnlock()
+
+	return bc.tip
+}
+
+// setTip makes blockHash the best block
+func (bc *Blockchain) setTip(blockHash []byte) {
+	bc.stateMu.Lock()
+	defer bc.stateMu.Unlock()
+
+	bc.tip = blockHash
+}
+
 // Iterator returns a BlockchainIterator
 func (bc *Blockchain) Iterator() *BlockchainIterator {
-	bci := &BlockchainIterator{bc.tip, bc.db}
+	bci := &BlockchainIterator{bc.currentTip(), bc.db}
 
 	return bci
 }
@@ -259,8 +489,8 @@ func (i *BlockchainIterator) Next() *Block {
 			return err
 		}
 		err = item.Value(func(val []byte) error {
-			block = DeserializeBlock(val)
-			return nil
+			block, err = DeserializeBlock(val)
+			return err
 		})
 		return err
 	})
@@ -285,7 +515,7 @@ func (bc *Blockchain) GetBestHeight() int {
 		}
 		var lastHash []byte
 		err = item.Value(func(val []byte) error {
-			lastHash = val
+			lastHash = append([]byte{}, val...)
 			return nil
 		})
 		if err != nil {
@@ -297,7 +527,11 @@ func (bc *Blockchain) GetBestHeight() int {
 			return err
 		}
 		err = item.Value(func(val []byte) error {
-			lastBlock = *DeserializeBlock(val)
+			block, err := DeserializeBlock(val)
+			if err != nil {
+				return err
+			}
+			lastBlock = *block
 			return nil
 		})
 		return err
@@ -321,7 +555,11 @@ func (bc *Blockchain) GetBlock(blockHash []byte) (Block, error) {
 		}
 
 		err = item.Value(func(val []byte) error {
-			block = *DeserializeBlock(val)
+			b, err := DeserializeBlock(val)
+			if err != nil {
+				return err
+			}
+			block = *b
 			return nil
 		})
 
@@ -335,34 +573,28 @@ func (bc *Blockchain) GetBlock(blockHash []byte) (Block, error) {
 	return block, nil
 }
 
-// GetBlockHashes returns a list of hashes of all the blocks in the chain
-func (bc *Blockchain) GetBlockHashes() [][]byte {
-	var blocks [][]byte
-	bci := bc.Iterator()
-
-	for {
-		block := bci.Next()
-
-		blocks = append(blocks, block.Hash)
-
-		if len(block.PrevBlockHash) == 0 {
-			break
-		}
+// MineBlock mines a new block with the provided transactions
+func (bc *Blockchain) MineBlock(transactions []*Transaction) *Block {
+	newBlock, err := bc.MineBlockContext(context.Background(), transactions)
+	if err != nil {
+		log.Panic(err)
 	}
 
-	return blocks
+	return newBlock
 }
 
-// MineBlock mines a new block with the provided transactions
-func (bc *Blockchain) MineBlock(transactions []*Transaction) *Block {
-	var lastHash []byte
-	var lastHeight int
+// MineBlockContext mines a new block, stopping early when ctx is cancelled
+func (bc *Blockchain) MineBlockContext(ctx context.Context, transactions []*Transaction) (*Block, error) {
+	var lastBlock *Block
 
+	// Transactions may spend outputs of earlier ones in the list
+	pending := make(map[string]*Transaction)
 	for _, tx := range transactions {
-		// TODO: ignore transaction if it's not valid
-		if bc.VerifyTransaction(tx) != true {
-			log.Panic("ERROR: Invalid transaction")
+		err := bc.verifyTransaction(tx, pending)
+		if err != nil {
+			return nil, err
 		}
+		pending[hex.EncodeToString(tx.ID)] = tx
 	}
 
 	err := bc.db.View(func(txn *badger.Txn) error {
@@ -370,8 +602,9 @@ func (bc *Blockchain) MineBlock(transactions []*Transaction) *Block {
 		if err != nil {
 			return err
 		}
+		var lastHash []byte
 		err = item.Value(func(val []byte) error {
-			lastHash = val
+			lastHash = append([]byte{}, val...)
 			return nil
 		})
 		if err != nil {
@@ -383,73 +616,115 @@ func (bc *Blockchain) MineBlock(transactions []*Transaction) *Block {
 			return err
 		}
 		err = item.Value(func(val []byte) error {
-			lastBlock := DeserializeBlock(val)
-			lastHeight = lastBlock.Height
-			return nil
+			lastBlock, err = DeserializeBlock(val)
+			return err
 		})
 		return err
 	})
 
 	if err != nil {
-		log.Panic(err)
+		return nil, err
 	}
 
-	newBlock := NewBlock(transactions, lastHash, lastHeight+1)
-
-	err = bc.db.Update(func(txn *badger.Txn) error {
-		err := txn.Set(newBlock.Hash, newBlock.Serialize())
+	// A coinbase built for another tip would only be refused after mining
+	if len(transactions) > 0 && transactions[0].IsCoinbase() {
+		err = checkCoinbaseHeight(transactions[0], lastBlock.Height+1)
 		if err != nil {
-			return err
+			return nil, err
 		}
+	}
 
-		err = txn.Set([]byte("lh"), newBlock.Hash)
-		if err != nil {
-			return err
-		}
+	bits, err := bc.CalcNextBits(lastBlock)
+	if err != nil {
+		return nil, err
+	}
 
-		bc.tip = newBlock.Hash
-		return nil
-	})
+	newBlock, err := NewBlockContext(ctx, transactions, lastBlock.Hash, lastBlock.Height+1, bits)
+	if err != nil {
+		return nil, err
+	}
 
+	err = bc.AddBlock(newBlock)
 	if err != nil {
-		log.Panic(err)
+		return nil, err
 	}
 
-	return newBlock
+	return newBlock, nil
 }
 
-// SignTransaction signs inputs of a Transaction
-func (bc *Blockchain) SignTransaction(tx *Transaction, privKey ecdsa.PrivateKey) {
+// findPrevTransactions collects the transactions referenced by the inputs of
+// tx, looking in pending first and then in the chain
+func (bc *Blockchain) findPrevTransactions(tx *Transaction, pending map[string]*Transaction) (map[string]Transaction, error) {
 	prevTXs := make(map[string]Transaction)
 
 	for _, vin := range tx.Vin {
+		txID := hex.EncodeToString(vin.Txid)
+		if prevTX, ok := pending[txID]; ok {
+			prevTXs[txID] = *prevTX
+			continue
+		}
+
 		prevTX, err := bc.FindTransaction(vin.Txid)
 		if err != nil {
-			log.Panic(err)
+			return nil, err
 		}
-		prevTXs[hex.EncodeToString(prevTX.ID)] = prevTX
+		prevTXs[txID] = prevTX
 	}
 
-	tx.Sign(privKey, prevTXs)
+	return prevTXs, nil
 }
 
-// VerifyTransaction verifies transaction input signatures
-func (bc *Blockchain) VerifyTransaction(tx *Transaction) bool {
+// SignTransaction signs inputs of a Transaction
+func (bc *Blockchain) SignTransaction(tx *Transaction, signer wallet.Signer) error {
+	prevTXs, err := bc.findPrevTransactions(tx, nil)
+	if err != nil {
+		return err
+	}
+
+	return tx.Sign(signer, prevTXs)
+}
+
+// VerifyTransaction verifies transaction input signatures. Inputs spending
+// outputs that can't be found, for example after a reorganization, are
+// reported as errors.
+func (bc *Blockchain) VerifyTransaction(tx *Transaction) error {
+	return bc.verifyTransaction(tx, nil)
+}
+
+// verifyTransaction verifies the signatures of tx, looking the transactions
+// it spends from up in pending first and then in the chain
+func (bc *Blockchain) verifyTransaction(tx *Transaction, pending map[string]*Transaction) error {
 	if tx.IsCoinbase() {
-		return true
+		return nil
 	}
 
-	prevTXs := make(map[string]Transaction)
+	prevTXs, err := bc.findPrevTransactions(tx, pending)
+	if err != nil {
+		return fmt.Errorf("%w: %x: %v", ErrDoubleSpend, tx.ID, err)
+	}
 
-	for _, vin := range tx.Vin {
-		prevTX, err := bc.FindTransaction(vin.Txid)
-		if err != nil {
-			log.Panic(err)
-		}
-		prevTXs[hex.EncodeToString(prevTX.ID)] = prevTX
+	err = tx.VerifyScripts(prevTXs)
+	if err != nil {
+		return fmt.Errorf("%w: %x: %v", ErrBadSignature, tx.ID, err)
+	}
+
+	return nil
+}
+
+// BlockchainExists reports whether the node has a blockchain database
+func BlockchainExists(nodeID string) bool {
+	return dbExists(dbPath(nodeID))
+}
+
+// dbPath returns the database of a node on the selected network. Main
+// network databases keep their original name; other networks add theirs so
+// their chains never mix.
+func dbPath(nodeID string) string {
+	if params.Name != chaincfg.MainNetParams.Name {
+		nodeID = params.Name + "_" + nodeID
 	}
 
-	return tx.Verify(prevTXs)
+	return fmt.Sprintf(dbFile, nodeID)
 }
 
 func dbExists(dbFile string) bool {
@@ -462,20 +737,145 @@ func dbExists(dbFile string) bool {
 
 // Serialize serializes the block
 func (b *Block) Serialize() []byte {
-	return []byte{} // Simplified for now
+	var result bytes.Buffer
+	result.WriteByte(blockEncodingVersion)
+
+	encoder := gob.NewEncoder(&result)
+	err := encoder.Encode(b)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	return result.Bytes()
 }
 
 // DeserializeBlock deserializes a block
-func DeserializeBlock(d []byte) *Block {
-	return &Block{} // Simplified for now
+func DeserializeBlock(d []byte) (*Block, error) {
+	if len(d) == 0 {
+		return nil, errors.New("block data is empty")
+	}
+	if d[0] != blockEncodingVersion {
+		return nil, fmt.Errorf("unsupported block encoding version %d", d[0])
+	}
+
+	var block Block
+
+	decoder := gob.NewDecoder(bytes.NewReader(d[1:]))
+	err := decoder.Decode(&block)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode block: %v", err)
+	}
+
+	return &block, nil
+}
+
+// SetMiner replaces the miner used by NewBlock
+func SetMiner(m pow.Miner) {
+	miner = m
+}
+
+// HashTransactions returns the merkle root of the block transactions. Its
+// leaves are full transaction hashes rather than IDs, which leave out the
+// unlocking scripts, so the block hash covers the signatures too.
+func (b *Block) HashTransactions() []byte {
+	root, err := merkle.Root(b.transactionHashes())
+	if err != nil {
+		return nil
+	}
+
+	return root
+}
+
+// transactionHashes returns the full hashes of the block transactions in
+// order
+func (b *Block) transactionHashes() [][]byte {
+	var hashes [][]byte
+
+	for _, tx := range b.Transactions {
+		hashes = append(hashes, tx.Hash())
+	}
+
+	return hashes
+}
+
+// merkleProof returns the proof of inclusion of the transaction at position
+func (b *Block) merkleProof(position int) (*MerkleProof, error) {
+	hashes := b.transactionHashes()
+	tree, err := merkle.NewTree(hashes)
+	if err != nil {
+		return nil, err
+	}
+	proof, err := tree.Proof(position)
+	if err != nil {
+		return nil, err
+	}
+
+	return &MerkleProof{b.Hash, b.MerkleRoot, b.Transactions[position].ID, hashes[position], proof}, nil
+}
+
+// Header returns the proof-of-work header of the block
+func (b *Block) Header() *pow.Header {
+	return &pow.Header{
+		PrevBlockHash: b.PrevBlockHash,
+		MerkleRoot:    b.MerkleRoot,
+		Timestamp:     b.Timestamp,
+		Bits:          b.Bits,
+		Nonce:         b.Nonce,
+		Height:        b.Height,
+	}
 }
 
-// NewBlock creates and returns Block
-func NewBlock(transactions []*Transaction, prevBlockHash []byte, height int) *Block {
-	return &Block{} // Simplified for now - would include PoW mining
+// ValidatePoW checks that the block hash matches its header and meets the target
+func (b *Block) ValidatePoW() bool {
+	header := b.Header()
+	if !bytes.Equal(header.Hash(), b.Hash) {
+		return false
+	}
+
+	return pow.Validate(header, b.Nonce)
+}
+
+// NewBlock creates, mines and returns Block
+func NewBlock(transactions []*Transaction, prevBlockHash []byte, height int, bits uint32) *Block {
+	block, err := NewBlockContext(context.Background(), transactions, prevBlockHash, height, bits)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	return block
+}
+
+// NewBlockContext creates and mines a Block, giving up when ctx is cancelled
+func NewBlockContext(ctx context.Context, transactions []*Transaction, prevBlockHash []byte, height int, bits uint32) (*Block, error) {
+	block := &Block{
+		Timestamp:     time.Now().Unix(),
+		Transactions:  transactions,
+		PrevBlockHash: prevBlockHash,
+		Hash:          []byte{},
+		Nonce:         0,
+		Bits:          bits,
+		Height:        height,
+	}
+	block.MerkleRoot = block.HashTransactions()
+
+	header := block.Header()
+	result, err := miner.Mine(ctx, header)
+	if err != nil {
+		return nil, err
+	}
+
+	block.Timestamp = header.Timestamp
+	block.Hash = result.Hash
+	block.Nonce = result.Nonce
+
+	if !block.ValidatePoW() {
+		return nil, errors.New("mined block failed proof-of-work validation")
+	}
+
+	return block, nil
 }
 
 // NewGenesisBlock creates and returns genesis Block
 func NewGenesisBlock(coinbase *Transaction) *Block {
-	return NewBlock([]*Transaction{coinbase}, []byte{}, 0)
+	return NewBlock([]*Transaction{coinbase}, []byte{}, 0, params.PowLimitBits)
 }
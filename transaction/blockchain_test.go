@@ -0,0 +1,84 @@
+package transaction
+
+import (
+	"bytes"
+	"os"
+	"testing"
+
+	"blockchain-app/wallet"
+)
+
+// newFundedBlockchain creates a blockchain in a temporary directory whose
+// genesis coinbase, paying w, can be spent in the next block
+func newFundedBlockchain(t *testing.T) (*Blockchain, *wallet.Wallet) {
+	t.Helper()
+
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.Chdir(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) })
+
+	w := wallet.NewWallet()
+	address := string(w.GetAddress())
+	bc := CreateBlockchain(address, "test")
+	t.Cleanup(func() { bc.Close() })
+
+	for height := 1; height <= params.CoinbaseMaturity; height++ {
+		bc.MineBlock([]*Transaction{newCoinbase(t, address, height)})
+	}
+
+	return bc, w
+}
+
+func TestGetMerkleProof(t *testing.T) {
+	bc, w := newFundedBlockchain(t)
+	address := string(w.GetAddress())
+	genesis, err := bc.GetBlockByHeight(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Spending the genesis coinbase puts a transaction after a coinbase
+	spend, err := NewUTXOTransaction(w, address, 10, 1, &UTXOSet{bc})
+	if err != nil {
+		t.Fatal(err)
+	}
+	block := bc.MineBlock([]*Transaction{newCoinbase(t, address, params.CoinbaseMaturity+1), spend})
+
+	txs := map[string][]byte{
+		"genesis coinbase": genesis.Transactions[0].ID,
+		"coinbase":         block.Transactions[0].ID,
+		"spend":            spend.ID,
+	}
+	blocks := map[string][]byte{"genesis coinbase": genesis.Hash, "coinbase": block.Hash, "spend": block.Hash}
+
+	check := func(t *testing.T) {
+		for name, txID := range txs {
+			proof, err := bc.GetMerkleProof(txID)
+			if err != nil {
+				t.Fatalf("%s: %v", name, err)
+			}
+			if !bytes.Equal(proof.BlockHash, blocks[name]) || !bytes.Equal(proof.TxID, txID) {
+				t.Errorf("%s: proof of %x in block %x", name, proof.TxID, proof.BlockHash)
+			}
+			if !proof.Verify() {
+				t.Errorf("%s: proof does not verify", name)
+			}
+		}
+
+		if _, err := bc.GetMerkleProof(bytes.Repeat([]byte{0xab}, 32)); err == nil {
+			t.Error("GetMerkleProof of an unknown transaction succeeded")
+		}
+	}
+
+	t.Run("indexed", check)
+	if err := bc.DisableTxIndex(); err != nil {
+		t.Fatal(err)
+	}
+	t.Run("scanning", check)
+}
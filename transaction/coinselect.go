@@ -0,0 +1,263 @@
+package transaction
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"sort"
+	"strings"
+)
+
+// Estimated serialized sizes used to price inputs and outputs before a
+// transaction is built. They are upper bounds for P2PKH inputs and outputs,
+// so a fee computed from them covers the signed transaction.
+const (
+	txOverheadSize = 310
+	inputSize      = 160
+	outputSize     = 40
+)
+
+// bnbMaxTries bounds the branch and bound search
+const bnbMaxTries = 100000
+
+// Coin selection errors
+var (
+	ErrInsufficientFunds   = errors.New("not enough funds")
+	ErrNoChangelessMatch   = errors.New("no combination of coins avoids change")
+	ErrUnknownCoinSelector = errors.New("unknown coin selection strategy")
+)
+
+// Coin is an unspent output a wallet can spend
+type Coin struct {
+	Txid   []byte
+	Vout   int
+	Output TXOutput
+}
+
+// effectiveValue is what the coin adds to a transaction once the fee of
+// the input spending it is paid
+func (c Coin) effectiveValue(feeRate int) int {
+	return c.Output.Value - EstimateFee(inputSize, feeRate)
+}
+
+// CoinSelector picks the coins funding a transaction
+type CoinSelector interface {
+	// Select returns coins whose effective value at feeRate, a fee per
+	// 1000 bytes, covers target plus the fee of the rest of the transaction
+	Select(coins []Coin, target, feeRate int) ([]Coin, error)
+}
+
+// DefaultCoinSelector avoids change when it can and spends the fewest
+// coins otherwise
+var DefaultCoinSelector CoinSelector = BranchAndBound{Fallback: LargestFirst{}}
+
+// coinSelectors lists the strategies selectable by name
+var coinSelectors = map[string]CoinSelector{
+	"largest":  LargestFirst{},
+	"smallest": SmallestFirst{},
+	"bnb":      DefaultCoinSelector,
+	"random":   RandomImprove{},
+}
+
+// CoinSelectorByName returns the strategy called largest, smallest, bnb or
+// random
+func CoinSelectorByName(name string) (CoinSelector, error) {
+	selector, ok := coinSelectors[strings.ToLower(name)]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownCoinSelector, name)
+	}
+
+	return selector, nil
+}
+
+// estimateTxSize returns the estimated size of a transaction
+func estimateTxSize(inputs, outputs int) int {
+	return txOverheadSize + inputs*inputSize + outputs*outputSize
+}
+
+// selectionTarget returns the effective value coins must add up to so they
+// pay target and the fee of a transaction with the given outputs
+func selectionTarget(target, feeRate, outputs int) int {
+	return target + EstimateFee(estimateTxSize(0, outputs), feeRate)
+}
+
+// isDust reports whether change is worth no more than the fee of spending it
+func isDust(change, feeRate int) bool {
+	return change <= EstimateFee(inputSize, feeRate)
+}
+
+// usableCoins returns the coins adding value at feeRate
+func usableCoins(coins []Coin, feeRate int) []Coin {
+	var usable []Coin
+	for _, coin := range coins {
+		if coin.effectiveValue(feeRate) > 0 {
+			usable = append(usable, coin)
+		}
+	}
+
+	return usable
+}
+
+// accumulate takes coins in order until they are worth need
+func accumulate(coins []Coin, need, feeRate int) ([]Coin, error) {
+	var selected []Coin
+	total := 0
+
+	for _, coin := range coins {
+		if total >= need {
+			break
+		}
+		selected = append(selected, coin)
+		total += coin.effectiveValue(feeRate)
+	}
+
+	if total < need {
+		return nil, fmt.Errorf("%w: have %d, need %d", ErrInsufficientFunds, total, need)
+	}
+
+	return selected, nil
+}
+
+// LargestFirst spends the largest coins first, using the fewest inputs
+type LargestFirst struct{}
+
+// Select implements CoinSelector
+func (LargestFirst) Select(coins []Coin, target, feeRate int) ([]Coin, error) {
+	pool := usableCoins(coins, feeRate)
+	sort.SliceStable(pool, func(i, j int) bool { return pool[i].Output.Value > pool[j].Output.Value })
+
+	return accumulate(pool, selectionTarget(target, feeRate, 2), feeRate)
+}
+
+// SmallestFirst spends the smallest coins first, consolidating dust at the
+// cost of larger transactions
+type SmallestFirst struct{}
+
+// Select implements CoinSelector
+func (SmallestFirst) Select(coins []Coin, target, feeRate int) ([]Coin, error) {
+	pool := usableCoins(coins, feeRate)
+	sort.SliceStable(pool, func(i, j int) bool { return pool[i].Output.Value < pool[j].Output.Value })
+
+	return accumulate(pool, selectionTarget(target, feeRate, 2), feeRate)
+}
+
+// BranchAndBound searches for coins paying target without change: their
+// excess must not exceed the cost of creating and later spending a change
+// output, and is left to the miner. When no such combination exists the
+// Fallback strategy is used, or ErrNoChangelessMatch returned without one.
+type BranchAndBound struct {
+	Fallback CoinSelector
+}
+
+// Select implements CoinSelector
+func (s BranchAndBound) Select(coins []Coin, target, feeRate int) ([]Coin, error) {
+	pool := usableCoins(coins, feeRate)
+	sort.SliceStable(pool, func(i, j int) bool { return pool[i].Output.Value > pool[j].Output.Value })
+
+	need := selectionTarget(target, feeRate, 1)
+	upper := need + EstimateFee(outputSize+inputSize, feeRate)
+
+	// remaining[i] is the value of pool[i:], to prune branches that can't
+	// reach need any more
+	remaining := make([]int, len(pool)+1)
+	for i := len(pool) - 1; i >= 0; i-- {
+		remaining[i] = remaining[i+1] + pool[i].effectiveValue(feeRate)
+	}
+
+	var selected, best []Coin
+	bestExcess := -1
+	tries := 0
+
+	// search includes or skips pool[i], largest coins first, and reports
+	// whether to stop
+	var search func(i, total int) bool
+	search = func(i, total int) bool {
+		tries++
+		if tries > bnbMaxTries {
+			return true
+		}
+		if total > upper {
+			return false
+		}
+		if total >= need {
+			if excess := total - need; bestExcess < 0 || excess < bestExcess {
+				best = append([]Coin(nil), selected...)
+				bestExcess = excess
+			}
+			return bestExcess == 0
+		}
+		if i == len(pool) || total+remaining[i] < need {
+			return false
+		}
+
+		selected = append(selected, pool[i])
+		if search(i+1, total+pool[i].effectiveValue(feeRate)) {
+			return true
+		}
+		selected = selected[:len(selected)-1]
+
+		return search(i+1, total)
+	}
+	search(0, 0)
+
+	if best != nil {
+		return best, nil
+	}
+	if s.Fallback != nil {
+		return s.Fallback.Select(coins, target, feeRate)
+	}
+	if remaining[0] < need {
+		return nil, fmt.Errorf("%w: have %d, need %d", ErrInsufficientFunds, remaining[0], need)
+	}
+
+	return nil, ErrNoChangelessMatch
+}
+
+// RandomImprove picks random coins until target is paid, then keeps adding
+// random coins while that brings the total closer to twice the target
+// without passing three times it. The change then tends to be about the
+// size of the payment, which keeps the wallet supplied with useful coins.
+type RandomImprove struct {
+	// Rand is the source of randomness, the global source when nil
+	Rand *rand.Rand
+}
+
+// Select implements CoinSelector
+func (s RandomImprove) Select(coins []Coin, target, feeRate int) ([]Coin, error) {
+	pool := usableCoins(coins, feeRate)
+	shuffle := rand.Shuffle
+	if s.Rand != nil {
+		shuffle = s.Rand.Shuffle
+	}
+	shuffle(len(pool), func(i, j int) { pool[i], pool[j] = pool[j], pool[i] })
+
+	need := selectionTarget(target, feeRate, 2)
+	selected, err := accumulate(pool, need, feeRate)
+	if err != nil {
+		return nil, err
+	}
+
+	total := 0
+	for _, coin := range selected {
+		total += coin.effectiveValue(feeRate)
+	}
+
+	ideal, limit := 2*need, 3*need
+	for _, coin := range pool[len(selected):] {
+		next := total + coin.effectiveValue(feeRate)
+		if next <= limit && abs(ideal-next) < abs(ideal-total) {
+			selected = append(selected, coin)
+			total = next
+		}
+	}
+
+	return selected, nil
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+
+	return n
+}
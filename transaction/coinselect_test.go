@@ -0,0 +1,182 @@
+package transaction
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"reflect"
+	"testing"
+
+	"blockchain-app/wallet"
+)
+
+// testFeeRate makes the fee of every byte 1, so an input costs inputSize
+const testFeeRate = 1000
+
+// testCoins returns coins of the given values paying to one key
+func testCoins(t *testing.T, values ...int) []Coin {
+	var coins []Coin
+	for i, value := range values {
+		coins = append(coins, Coin{Txid: []byte(fmt.Sprintf("coin %d", i)), Output: testOutput(t, value, "owner")})
+	}
+
+	return coins
+}
+
+// coinValues returns the values of coins in order
+func coinValues(coins []Coin) []int {
+	var values []int
+	for _, coin := range coins {
+		values = append(values, coin.Output.Value)
+	}
+
+	return values
+}
+
+// effectiveTotal returns the value coins add to a transaction at feeRate
+func effectiveTotal(coins []Coin, feeRate int) int {
+	total := 0
+	for _, coin := range coins {
+		total += coin.effectiveValue(feeRate)
+	}
+
+	return total
+}
+
+func TestCoinSelectors(t *testing.T) {
+	// A coin of 100 costs more to spend than it is worth
+	coins := testCoins(t, 5000, 100, 4000, 2000, 1500)
+
+	tests := []struct {
+		name     string
+		selector CoinSelector
+		target   int
+		want     []int
+	}{
+		{"largest first", LargestFirst{}, 2830, []int{5000}},
+		{"largest first needs two coins", LargestFirst{}, 7000, []int{5000, 4000}},
+		{"smallest first", SmallestFirst{}, 2790, []int{1500, 2000}},
+		{"smallest first skips uneconomic coins", SmallestFirst{}, 900, []int{1500}},
+		{"branch and bound avoids change", BranchAndBound{}, 2830, []int{2000, 1500}},
+		{"branch and bound exact single coin", BranchAndBound{}, 4490, []int{5000}},
+		{"branch and bound falls back", BranchAndBound{Fallback: LargestFirst{}}, 100, []int{5000}},
+		{"default selector avoids change", DefaultCoinSelector, 2830, []int{2000, 1500}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			selected, err := tt.selector.Select(coins, tt.target, testFeeRate)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := coinValues(selected); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("selected %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBranchAndBoundLeavesNoChange(t *testing.T) {
+	coins := testCoins(t, 5000, 4000, 2000, 1500)
+	target := 2830
+
+	selected, err := BranchAndBound{}.Select(coins, target, testFeeRate)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The excess over a one output transaction must not be worth a change
+	// output
+	need := selectionTarget(target, testFeeRate, 1)
+	excess := effectiveTotal(selected, testFeeRate) - need
+	if excess < 0 || excess > EstimateFee(outputSize+inputSize, testFeeRate) {
+		t.Errorf("selection %v leaves excess %d", coinValues(selected), excess)
+	}
+
+	// Without a changeless match and without a fallback it gives up
+	if _, err := (BranchAndBound{}).Select(coins, 100, testFeeRate); !errors.Is(err, ErrNoChangelessMatch) {
+		t.Errorf("Select without a match returned %v, want %v", err, ErrNoChangelessMatch)
+	}
+}
+
+func TestRandomImproveCoversTarget(t *testing.T) {
+	coins := testCoins(t, 500, 700, 1000, 1200, 1500, 2000, 2500, 3000, 4000, 5000)
+	target := 1500
+	need := selectionTarget(target, testFeeRate, 2)
+
+	for seed := int64(0); seed < 20; seed++ {
+		selector := RandomImprove{Rand: rand.New(rand.NewSource(seed))}
+		selected, err := selector.Select(coins, target, testFeeRate)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		total := effectiveTotal(selected, testFeeRate)
+		if total < need {
+			t.Errorf("seed %d: selection worth %d, need %d", seed, total, need)
+		}
+
+		seen := make(map[string]bool)
+		for _, coin := range selected {
+			if seen[string(coin.Txid)] {
+				t.Errorf("seed %d: coin %s selected twice", seed, coin.Txid)
+			}
+			seen[string(coin.Txid)] = true
+		}
+	}
+}
+
+func TestCoinSelectorsReportInsufficientFunds(t *testing.T) {
+	selectors := map[string]CoinSelector{
+		"largest":             LargestFirst{},
+		"smallest":            SmallestFirst{},
+		"bnb":                 BranchAndBound{},
+		"bnb with a fallback": DefaultCoinSelector,
+		"random":              RandomImprove{Rand: rand.New(rand.NewSource(1))},
+	}
+	pools := map[string][]Coin{
+		"no coins":         nil,
+		"too little":       testCoins(t, 1000, 2000),
+		"uneconomic coins": testCoins(t, 100, 150, 160),
+	}
+
+	for name, selector := range selectors {
+		for pool, coins := range pools {
+			t.Run(name+"/"+pool, func(t *testing.T) {
+				selected, err := selector.Select(coins, 5000, testFeeRate)
+				if !errors.Is(err, ErrInsufficientFunds) {
+					t.Errorf("Select returned %v, want %v", err, ErrInsufficientFunds)
+				}
+				if selected != nil {
+					t.Errorf("Select returned coins %v with the error", coinValues(selected))
+				}
+			})
+		}
+	}
+}
+
+func TestCoinSelectorByName(t *testing.T) {
+	for _, name := range []string{"largest", "smallest", "bnb", "random", "BnB"} {
+		if _, err := CoinSelectorByName(name); err != nil {
+			t.Errorf("CoinSelectorByName(%q): %v", name, err)
+		}
+	}
+
+	if _, err := CoinSelectorByName("oldest"); !errors.Is(err, ErrUnknownCoinSelector) {
+		t.Errorf("CoinSelectorByName of an unknown strategy returned %v, want %v", err, ErrUnknownCoinSelector)
+	}
+}
+
+func TestNewTransactionReportsInsufficientFunds(t *testing.T) {
+	bc, address := newMinedBlockchain(t)
+	utxoSet := &UTXOSet{bc}
+
+	for name, selector := range coinSelectors {
+		t.Run(name, func(t *testing.T) {
+			_, err := NewUTXOTransactionWithSelector(wallet.NewWallet(), address, 1, testFeeRate, selector, utxoSet)
+			if !errors.Is(err, ErrInsufficientFunds) {
+				t.Errorf("NewUTXOTransactionWithSelector returned %v, want %v", err, ErrInsufficientFunds)
+			}
+		})
+	}
+}
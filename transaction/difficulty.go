@@ -0,0 +1,75 @@
+package transaction
+
+import (
+	"fmt"
+
+	"blockchain-app/chaincfg"
+	"blockchain-app/pow"
+)
+
+// params are the consensus rules the blockchain follows
+var params = &chaincfg.MainNetParams
+
+// SetNetParams selects the network whose consensus rules blockchains follow.
+// Each network keeps its blockchains in databases of its own.
+func SetNetParams(netParams *chaincfg.Params) {
+	params = netParams
+}
+
+// NetParams returns the network whose consensus rules blockchains follow
+func NetParams() *chaincfg.Params {
+	return params
+}
+
+// CalcNextBits returns the compact target required for the block after prev.
+// Every RetargetInterval blocks the target is recomputed from the time the
+// previous interval took; in between it stays unchanged.
+func (bc *Blockchain) CalcNextBits(prev *Block) (uint32, error) {
+	if prev == nil {
+		return params.PowLimitBits, nil
+	}
+
+	interval := params.RetargetInterval
+	if (prev.Height+1)%interval != 0 {
+		return prev.Bits, nil
+	}
+
+	first := *prev
+	for i := 0; i < interval-1; i++ {
+		block, err := bc.GetBlock(first.PrevBlockHash)
+		if err != nil {
+			return 0, fmt.Errorf("failed to find retarget block: %v", err)
+		}
+		first = block
+	}
+
+	// The interval spans interval-1 gaps between its first and last block
+	actualTimespan := prev.Timestamp - first.Timestamp
+	targetTimespan := int64(interval-1) * params.TargetTimePerBlock
+
+	return pow.Retarget(prev.Bits, actualTimespan, targetTimespan, params.MaxRetargetFactor, params.PowLimitBits), nil
+}
+
+// checkDifficulty verifies that block carries the bits the retarget rule expects
+func (bc *Blockchain) checkDifficulty(block *Block) error {
+	var prev *Block
+
+	if len(block.PrevBlockHash) > 0 {
+		prevBlock, err := bc.GetBlock(block.PrevBlockHash)
+		if err != nil {
+			return err
+		}
+		prev = &prevBlock
+	}
+
+	expected, err := bc.CalcNextBits(prev)
+	if err != nil {
+		return err
+	}
+
+	if block.Bits != expected {
+		return fmt.Errorf("%w: got %08x, want %08x", ErrBadDifficulty, block.Bits, expected)
+	}
+
+	return nil
+}
@@ -0,0 +1,76 @@
+package transaction
+
+import (
+	"encoding/hex"
+	"fmt"
+)
+
+// CalculateFee returns the fee paid by a transaction: the value of the
+// outputs it spends minus the value of the outputs it creates. The spent
+// outputs are looked up in the UTXO set.
+func (bc *Blockchain) CalculateFee(tx *Transaction) (int, error) {
+	return bc.calculateFee(UTXOSet{bc}, nil, tx)
+}
+
+// TotalFees returns the fees paid by a list of transactions, as included in
+// a block. Transactions may spend outputs created earlier in the list.
+func (bc *Blockchain) TotalFees(txs []*Transaction) (int, error) {
+	utxoSet := UTXOSet{bc}
+	pending := make(map[string]*Transaction)
+	total := 0
+
+	for _, tx := range txs {
+		fee, err := bc.calculateFee(utxoSet, pending, tx)
+		if err != nil {
+			return 0, err
+		}
+
+		total += fee
+		if !MoneyRange(total) {
+			return 0, fmt.Errorf("%w: fees are worth more than %d", ErrBadTransaction, MaxMoney)
+		}
+		pending[hex.EncodeToString(tx.ID)] = tx
+	}
+
+	return total, nil
+}
+
+// calculateFee computes the fee of a transaction, looking spent outputs up
+// among pending transactions first and then in the UTXO set
+func (bc *Blockchain) calculateFee(utxoSet UTXOSet, pending map[string]*Transaction, tx *Transaction) (int, error) {
+	if tx.IsCoinbase() {
+		return 0, nil
+	}
+
+	inputValue := 0
+	for _, vin := range tx.Vin {
+		out, found, err := bc.findBlockOutput(utxoSet, pending, vin)
+		if err != nil {
+			return 0, err
+		}
+		if !found {
+			return 0, fmt.Errorf("%w: %x:%d", ErrDoubleSpend, vin.Txid, vin.Vout)
+		}
+		inputValue += out.Value
+		if !MoneyRange(out.Value) || !MoneyRange(inputValue) {
+			return 0, fmt.Errorf("%w: %x inputs are worth more than %d", ErrBadTransaction, tx.ID, MaxMoney)
+		}
+	}
+
+	outputValue, err := tx.OutputValue()
+	if err != nil {
+		return 0, err
+	}
+	fee := inputValue - outputValue
+	if fee < 0 {
+		return 0, fmt.Errorf("%w: %x spends more than its inputs", ErrBadTransaction, tx.ID)
+	}
+
+	return fee, nil
+}
+
+// EstimateFee returns the fee for a transaction of the given size at a rate
+// expressed per 1000 bytes, rounded up
+func EstimateFee(size, feeRate int) int {
+	return (size*feeRate + 999) / 1000
+}
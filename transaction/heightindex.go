@@ -0,0 +1,166 @@
+package transaction
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	"github.com/dgraph-io/badger/v3"
+)
+
+// heightIndexPrefix prefixes the height -> block hash entries of the best chain
+const heightIndexPrefix = "h:"
+
+// ErrHeightOutOfRange is returned for heights above the best chain tip
+var ErrHeightOutOfRange = errors.New("height is out of range")
+
+// ForwardIterator walks the best chain from genesis to the tip
+type ForwardIterator struct {
+	height int
+	bc     *Blockchain
+}
+
+// ForwardIterator returns an iterator starting at the genesis block
+func (bc *Blockchain) ForwardIterator() *ForwardIterator {
+	return &ForwardIterator{0, bc}
+}
+
+// Next returns the next block, or nil once the tip has been passed
+func (i *ForwardIterator) Next() *Block {
+	block, err := i.bc.GetBlockByHeight(i.height)
+	if err != nil {
+		return nil
+	}
+
+	i.height++
+
+	return &block
+}
+
+// GetBlockByHeight returns the block of the best chain at the given height
+func (bc *Blockchain) GetBlockByHeight(height int) (Block, error) {
+	hash, err := bc.blockHashAtHeight(height)
+	if err != nil {
+		return Block{}, err
+	}
+
+	return bc.GetBlock(hash)
+}
+
+// GetBlockHashes returns the hashes of the best chain blocks from height
+// from to height to inclusive, genesis first. to is capped at the tip.
+func (bc *Blockchain) GetBlockHashes(from, to int) ([][]byte, error) {
+	if from < 0 || from > to {
+		return nil, fmt.Errorf("invalid height range %d-%d", from, to)
+	}
+
+	var hashes [][]byte
+
+	err := bc.db.View(func(txn *badger.Txn) error {
+		for height := from; height <= to; height++ {
+			item, err := txn.Get(heightKey(height))
+			if err == badger.ErrKeyNotFound {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+
+			hash, err := item.ValueCopy(nil)
+			if err != nil {
+				return err
+			}
+			hashes = append(hashes, hash)
+		}
+
+		return nil
+	})
+
+	return hashes, err
+}
+
+// blockHashAtHeight reads the hash of the best chain block at a height
+func (bc *Blockchain) blockHashAtHeight(height int) ([]byte, error) {
+	var hash []byte
+
+	err := bc.db.View(func(txn *badger.Txn) error {
+		item, err := txn.Get(heightKey(height))
+		if err == badger.ErrKeyNotFound {
+			return fmt.Errorf("%w: %d", ErrHeightOutOfRange, height)
+		}
+		if err != nil {
+			return err
+		}
+
+		hash, err = item.ValueCopy(nil)
+		return err
+	})
+
+	return hash, err
+}
+
+// ensureHeightIndex rebuilds the height index when it doesn't match the
+// tip, which happens for databases created before the index existed
+func (bc *Blockchain) ensureHeightIndex() error {
+	tip, err := bc.GetBlock(bc.currentTip())
+	if err != nil {
+		return err
+	}
+
+	hash, err := bc.blockHashAtHeight(tip.Height)
+	if err == nil && bytes.Equal(hash, tip.Hash) {
+		return nil
+	}
+
+	return bc.ReindexHeights()
+}
+
+// ReindexHeights rebuilds the height index from the best chain
+func (bc *Blockchain) ReindexHeights() error {
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+
+	err := bc.db.DropPrefix([]byte(heightIndexPrefix))
+	if err != nil {
+		return err
+	}
+
+	bci := bc.Iterator()
+
+	for {
+		block := bci.Next()
+
+		err := bc.db.Update(func(txn *badger.Txn) error {
+			return setBlockHeight(txn, block)
+		})
+		if err != nil {
+			return err
+		}
+
+		if len(block.PrevBlockHash) == 0 {
+			break
+		}
+	}
+
+	return nil
+}
+
+// setBlockHeight records a block as the best chain block at its height
+func setBlockHeight(txn *badger.Txn, block *Block) error {
+	return txn.Set(heightKey(block.Height), block.Hash)
+}
+
+// unsetBlockHeight removes a disconnected block from the height index
+func unsetBlockHeight(txn *badger.Txn, block *Block) error {
+	return txn.Delete(heightKey(block.Height))
+}
+
+// heightKey returns the height index key of a height
+func heightKey(height int) []byte {
+	key := make([]byte, len(heightIndexPrefix)+8)
+	copy(key, heightIndexPrefix)
+	binary.BigEndian.PutUint64(key[len(heightIndexPrefix):], uint64(height))
+
+	return key
+}
@@ -0,0 +1,243 @@
+package transaction
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+
+	"blockchain-app/script"
+	"blockchain-app/wallet"
+)
+
+// Multisig errors
+var (
+	ErrNotMultiSig  = errors.New("input does not spend a multisig output")
+	ErrNotCoSigner  = errors.New("key is not part of the multisig")
+	ErrRedeemScript = errors.New("redeem script does not match the script hash")
+)
+
+// NewMultiSigTXOutput creates an output locked with a bare multisig script
+// requiring required signatures out of pubKeys
+func NewMultiSigTXOutput(value, required int, pubKeys [][]byte) (*TXOutput, error) {
+	lockingScript, err := script.MultiSigScript(required, pubKeys)
+	if err != nil {
+		return nil, err
+	}
+
+	return &TXOutput{value, lockingScript}, nil
+}
+
+// NewMultiSigTransaction creates an unsigned transaction spending the P2SH
+// outputs of a multisig address, paying a fee of feeRate per 1000 bytes from
+// coins picked by DefaultCoinSelector. Key holders then add their signatures
+// one after another with SignMultiSig.
+func NewMultiSigTransaction(ms *wallet.MultiSig, to string, amount, feeRate int, UTXOSet *UTXOSet) (*Transaction, error) {
+	if amount <= 0 || feeRate < 0 {
+		return nil, errors.New("invalid amount or fee rate")
+	}
+
+	coins, err := UTXOSet.FindSpendableScriptCoins(ms.ScriptHash())
+	if err != nil {
+		return nil, err
+	}
+
+	payment, err := NewTXOutput(amount, to)
+	if err != nil {
+		return nil, err
+	}
+
+	from := fmt.Sprintf("%s", ms.GetAddress())
+	tx, err := fundTransaction(coins, from, payment, 0, feeRate, 0, DefaultCoinSelector, UTXOSet)
+	if err != nil {
+		return nil, err
+	}
+
+	// Push the redeem script so later signers don't need to know it
+	for inID := range tx.Vin {
+		sigScript, err := script.MultiSigSigScript(nil, ms.RedeemScript)
+		if err != nil {
+			return nil, err
+		}
+		tx.Vin[inID].ScriptSig = sigScript
+	}
+
+	return tx, nil
+}
+
+// SignMultiSig adds the SIGHASH_ALL signature of signer to every input
+// spending a multisig output. Signatures already present are kept, so key
+// holders can sign in any order until enough of them did. redeemScript is
+// only needed for P2SH inputs that don't carry it in their unlocking script
+// yet.
+func (tx *Transaction) SignMultiSig(signer wallet.Signer, prevTXs map[string]Transaction, redeemScript []byte) error {
+	return tx.SignMultiSigWithHashType(signer, prevTXs, redeemScript, SigHashAll)
+}
+
+// SignMultiSigWithHashType is like SignMultiSig with a chosen hash type
+func (tx *Transaction) SignMultiSigWithHashType(signer wallet.Signer, prevTXs map[string]Transaction, redeemScript []byte, hashType SigHashType) error {
+	if tx.IsCoinbase() {
+		return nil
+	}
+
+	pubKey := signer.PublicKey()
+	cache := NewSigHashCache(tx)
+	signed := 0
+
+	for inID, vin := range tx.Vin {
+		prevOut, err := prevOutput(prevTXs, vin)
+		if err != nil {
+			return err
+		}
+
+		in, err := tx.parseMultiSigInput(cache, inID, prevOut, redeemScript)
+		if errors.Is(err, ErrNotMultiSig) {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("input %d: %w", inID, err)
+		}
+
+		keyIndex := -1
+		for i, key := range in.pubKeys {
+			if bytes.Equal(key, pubKey) {
+				keyIndex = i
+				break
+			}
+		}
+		if keyIndex == -1 {
+			return fmt.Errorf("input %d: %w", inID, ErrNotCoSigner)
+		}
+
+		in.sigs[keyIndex], err = cache.signInput(signer, inID, prevOut, hashType)
+		if err != nil {
+			return fmt.Errorf("input %d: %w", inID, err)
+		}
+
+		// CHECKMULTISIG wants exactly required signatures in key order
+		var sigs [][]byte
+		for i := range in.pubKeys {
+			if sig, ok := in.sigs[i]; ok && len(sigs) < in.required {
+				sigs = append(sigs, sig)
+			}
+		}
+
+		sigScript, err := script.MultiSigSigScript(sigs, in.redeemScript)
+		if err != nil {
+			return fmt.Errorf("input %d: %w", inID, err)
+		}
+		tx.Vin[inID].ScriptSig = sigScript
+		signed++
+	}
+
+	if signed == 0 {
+		return ErrNotMultiSig
+	}
+
+	return nil
+}
+
+// MultiSigStatus returns how many valid signatures input inID carries and
+// how many its multisig script requires
+func (tx *Transaction) MultiSigStatus(inID int, prevTXs map[string]Transaction) (int, int, error) {
+	if inID < 0 || inID >= len(tx.Vin) {
+		return 0, 0, fmt.Errorf("input %d does not exist", inID)
+	}
+
+	prevOut, err := prevOutput(prevTXs, tx.Vin[inID])
+	if err != nil {
+		return 0, 0, err
+	}
+
+	in, err := tx.parseMultiSigInput(NewSigHashCache(tx), inID, prevOut, nil)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	return len(in.sigs), in.required, nil
+}
+
+// multiSigInput is the state of a partially signed multisig input
+type multiSigInput struct {
+	required     int
+	pubKeys      [][]byte
+	redeemScript []byte
+	// sigs maps the index of a key in pubKeys to its valid signature
+	sigs map[int][]byte
+}
+
+// parseMultiSigInput reads the multisig script spent by input inID and the
+// valid signatures its unlocking script already holds
+func (tx *Transaction) parseMultiSigInput(cache *SigHashCache, inID int, prevOut TXOutput, redeemScript []byte) (*multiSigInput, error) {
+	pushed, err := script.PushedData(tx.Vin[inID].ScriptSig)
+	if err != nil {
+		return nil, err
+	}
+
+	prevScript := prevOut.ScriptPubKey
+	multiSigScript := prevScript
+	in := &multiSigInput{sigs: make(map[int][]byte)}
+
+	switch script.GetScriptClass(prevScript) {
+	case script.MultiSigTy:
+	case script.ScriptHashTy:
+		if redeemScript == nil && len(pushed) > 0 {
+			redeemScript = pushed[len(pushed)-1]
+		}
+		if len(pushed) > 0 && bytes.Equal(pushed[len(pushed)-1], redeemScript) {
+			pushed = pushed[:len(pushed)-1]
+		}
+		if !bytes.Equal(script.Hash160(redeemScript), script.ExtractScriptHash(prevScript)) {
+			return nil, ErrRedeemScript
+		}
+		if script.GetScriptClass(redeemScript) != script.MultiSigTy {
+			return nil, ErrNotMultiSig
+		}
+		multiSigScript = redeemScript
+		in.redeemScript = redeemScript
+	default:
+		return nil, ErrNotMultiSig
+	}
+
+	in.required, in.pubKeys, err = script.ExtractMultiSig(multiSigScript)
+	if err != nil {
+		return nil, err
+	}
+
+	checker := &txChecker{tx, inID, prevOut, cache}
+	for _, sig := range pushed {
+		for i, key := range in.pubKeys {
+			if _, ok := in.sigs[i]; ok {
+				continue
+			}
+			// Malformed signatures are dropped like ones that don't match
+			if valid, err := checker.CheckSig(sig, key); err == nil && valid {
+				in.sigs[i] = sig
+				break
+			}
+		}
+	}
+
+	return in, nil
+}
+
+// prevOutput returns the output spent by vin
+func prevOutput(prevTXs map[string]Transaction, vin TXInput) (TXOutput, error) {
+	prevTx, ok := prevTXs[hex.EncodeToString(vin.Txid)]
+	if !ok || prevTx.ID == nil || vin.Vout < 0 || vin.Vout >= len(prevTx.Vout) {
+		return TXOutput{}, fmt.Errorf("previous output %x:%d not found", vin.Txid, vin.Vout)
+	}
+
+	return prevTx.Vout[vin.Vout], nil
+}
+
+// SignMultiSigTransaction adds the signature of signer to the multisig
+// inputs of a Transaction
+func (bc *Blockchain) SignMultiSigTransaction(tx *Transaction, signer wallet.Signer, redeemScript []byte) error {
+	prevTXs, err := bc.findPrevTransactions(tx, nil)
+	if err != nil {
+		return err
+	}
+
+	return tx.SignMultiSig(signer, prevTXs, redeemScript)
+}
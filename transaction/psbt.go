@@ -0,0 +1,414 @@
+package transaction
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/gob"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+
+	"blockchain-app/script"
+	"blockchain-app/wallet"
+)
+
+// psbtMagic starts every serialized PSBT
+var psbtMagic = []byte("psbt")
+
+// psbtEncodingVersion follows the magic of every serialized PSBT
+const psbtEncodingVersion = byte(1)
+
+// Partially signed transaction errors
+var (
+	ErrBadPSBT        = errors.New("invalid partially signed transaction")
+	ErrPSBTMismatch   = errors.New("partially signed transactions spend different transactions")
+	ErrPSBTIncomplete = errors.New("input does not have enough signatures")
+	ErrNotSigner      = errors.New("key cannot sign any input")
+)
+
+// PSBT is a partially signed transaction. It carries the unsigned
+// transaction together with the outputs it spends, so it can be signed
+// away from the chain, for example on an air-gapped wallet, and collects
+// the signatures of several parties until it can be finalized:
+//
+//	create (online) -> sign (offline, every key holder) -> combine ->
+//	finalize -> extract and broadcast (online)
+type PSBT struct {
+	Tx     Transaction
+	Inputs []PSBTInput
+}
+
+// PSBTInput holds what signers of one input need besides the transaction
+type PSBTInput struct {
+	// PrevOut is the output spent by the input
+	PrevOut TXOutput
+
+	// RedeemScript is the script behind a P2SH PrevOut
+	RedeemScript []byte
+
+	// PartialSigs maps hex encoded public keys to their signature, hash
+	// type byte included
+	PartialSigs map[string][]byte
+
+	// FinalScriptSig is the unlocking script built by Finalize
+	FinalScriptSig []byte
+}
+
+// NewPSBT creates a PSBT for tx spending the outputs in prevTXs. Unlocking
+// scripts are dropped, except for redeem scripts pushed by
+// NewMultiSigTransaction which are kept for signers.
+func NewPSBT(tx *Transaction, prevTXs map[string]Transaction) (*PSBT, error) {
+	if tx.IsCoinbase() {
+		return nil, fmt.Errorf("%w: coinbase transactions are not signed", ErrBadPSBT)
+	}
+
+	p := &PSBT{Tx: *tx}
+	p.Tx.Vin = make([]TXInput, len(tx.Vin))
+	p.Tx.Vout = append([]TXOutput(nil), tx.Vout...)
+
+	for inID, vin := range tx.Vin {
+		prevOut, err := prevOutput(prevTXs, vin)
+		if err != nil {
+			return nil, err
+		}
+
+		in := PSBTInput{PrevOut: prevOut, PartialSigs: make(map[string][]byte)}
+		if script.IsPayToScriptHash(prevOut.ScriptPubKey) {
+			pushed, err := script.PushedData(vin.ScriptSig)
+			if err == nil && len(pushed) > 0 {
+				in.setRedeemScript(pushed[len(pushed)-1])
+			}
+		}
+
+		p.Tx.Vin[inID] = TXInput{vin.Txid, vin.Vout, nil, vin.Sequence}
+		p.Inputs = append(p.Inputs, in)
+	}
+	p.Tx.ID = p.Tx.Hash()
+
+	return p, nil
+}
+
+// NewPSBT creates a PSBT for tx, looking up the outputs it spends
+func (bc *Blockchain) NewPSBT(tx *Transaction) (*PSBT, error) {
+	prevTXs, err := bc.findPrevTransactions(tx, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewPSBT(tx, prevTXs)
+}
+
+// AddRedeemScript attaches redeemScript to every P2SH input paying its hash
+func (p *PSBT) AddRedeemScript(redeemScript []byte) {
+	for inID := range p.Inputs {
+		p.Inputs[inID].setRedeemScript(redeemScript)
+	}
+}
+
+// Sign adds the SIGHASH_ALL signature of signer to every input it can
+// sign and returns how many it signed
+func (p *PSBT) Sign(signer wallet.Signer) (int, error) {
+	return p.SignWithHashType(signer, SigHashAll)
+}
+
+// SignWithHashType is like Sign with a chosen hash type
+func (p *PSBT) SignWithHashType(signer wallet.Signer, hashType SigHashType) (int, error) {
+	pubKey := signer.PublicKey()
+	cache := NewSigHashCache(&p.Tx)
+	signed := 0
+
+	for inID := range p.Inputs {
+		in := &p.Inputs[inID]
+		if in.FinalScriptSig != nil || !in.canSign(pubKey) {
+			continue
+		}
+
+		sig, err := cache.signInput(signer, inID, in.PrevOut, hashType)
+		if err != nil {
+			return signed, fmt.Errorf("input %d: %w", inID, err)
+		}
+		in.PartialSigs[hex.EncodeToString(pubKey)] = sig
+		signed++
+	}
+
+	if signed == 0 {
+		return 0, ErrNotSigner
+	}
+
+	return signed, nil
+}
+
+// Combine merges the signatures and redeem scripts of other, a copy of the
+// same PSBT signed by someone else. Nothing is merged if any signature of
+// other doesn't verify.
+func (p *PSBT) Combine(other *PSBT) error {
+	if !bytes.Equal(p.Tx.Hash(), other.Tx.Hash()) || len(other.Inputs) != len(p.Inputs) {
+		return ErrPSBTMismatch
+	}
+
+	cache := NewSigHashCache(&p.Tx)
+	merged := make([]PSBTInput, len(p.Inputs))
+
+	for inID, in := range p.Inputs {
+		theirs := other.Inputs[inID]
+
+		if !bytes.Equal(in.PrevOut.ScriptPubKey, theirs.PrevOut.ScriptPubKey) || in.PrevOut.Value != theirs.PrevOut.Value {
+			return fmt.Errorf("%w: input %d spends a different output", ErrPSBTMismatch, inID)
+		}
+		if in.RedeemScript == nil && theirs.RedeemScript != nil {
+			in.setRedeemScript(theirs.RedeemScript)
+		}
+
+		sigs := make(map[string][]byte, len(in.PartialSigs))
+		for key, sig := range in.PartialSigs {
+			sigs[key] = sig
+		}
+
+		for key, sig := range theirs.PartialSigs {
+			if _, ok := sigs[key]; ok {
+				continue
+			}
+
+			pubKey, err := hex.DecodeString(key)
+			if err != nil {
+				return fmt.Errorf("%w: input %d: bad public key", ErrBadPSBT, inID)
+			}
+			checker := &txChecker{&p.Tx, inID, in.PrevOut, cache}
+			valid, err := checker.CheckSig(sig, pubKey)
+			if err != nil || !valid {
+				return fmt.Errorf("%w: input %d: invalid signature of %s", ErrBadPSBT, inID, key)
+			}
+			sigs[key] = sig
+		}
+
+		in.PartialSigs = sigs
+		merged[inID] = in
+	}
+
+	p.Inputs = merged
+
+	return nil
+}
+
+// Finalize builds the unlocking script of every input from its partial
+// signatures and checks it against the spent output. Nothing changes
+// unless every input can be finalized.
+func (p *PSBT) Finalize() error {
+	scripts := make([][]byte, len(p.Inputs))
+
+	for inID := range p.Inputs {
+		sigScript, err := p.Inputs[inID].finalScriptSig()
+		if err != nil {
+			return fmt.Errorf("input %d: %w", inID, err)
+		}
+		scripts[inID] = sigScript
+	}
+
+	tx := p.Tx
+	tx.Vin = append([]TXInput(nil), p.Tx.Vin...)
+	prevOuts := make([]TXOutput, len(p.Inputs))
+	for inID := range p.Inputs {
+		tx.Vin[inID].ScriptSig = scripts[inID]
+		prevOuts[inID] = p.Inputs[inID].PrevOut
+	}
+
+	err := tx.verifyScripts(prevOuts)
+	if err != nil {
+		return err
+	}
+
+	for inID := range p.Inputs {
+		p.Inputs[inID].FinalScriptSig = scripts[inID]
+	}
+
+	return nil
+}
+
+// IsComplete reports whether every input is finalized
+func (p *PSBT) IsComplete() bool {
+	for _, in := range p.Inputs {
+		if in.FinalScriptSig == nil {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Extract returns the signed transaction of a finalized PSBT
+func (p *PSBT) Extract() (*Transaction, error) {
+	if !p.IsComplete() {
+		return nil, fmt.Errorf("%w: not finalized", ErrPSBTIncomplete)
+	}
+
+	tx := p.Tx
+	tx.Vin = append([]TXInput(nil), p.Tx.Vin...)
+	for inID, in := range p.Inputs {
+		tx.Vin[inID].ScriptSig = in.FinalScriptSig
+	}
+
+	return &tx, nil
+}
+
+// Fee returns the value of the spent outputs minus the value of the outputs
+func (p *PSBT) Fee() (int, error) {
+	inputValue := 0
+	for _, in := range p.Inputs {
+		inputValue += in.PrevOut.Value
+		if !MoneyRange(in.PrevOut.Value) || !MoneyRange(inputValue) {
+			return 0, fmt.Errorf("%w: inputs are worth more than %d", ErrBadPSBT, MaxMoney)
+		}
+	}
+
+	outputValue, err := p.Tx.OutputValue()
+	if err != nil {
+		return 0, err
+	}
+
+	return inputValue - outputValue, nil
+}
+
+// Serialize serializes the PSBT
+func (p *PSBT) Serialize() []byte {
+	var buff bytes.Buffer
+	buff.Write(psbtMagic)
+	buff.WriteByte(psbtEncodingVersion)
+
+	enc := gob.NewEncoder(&buff)
+	err := enc.Encode(p)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	return buff.Bytes()
+}
+
+// DeserializePSBT deserializes a PSBT
+func DeserializePSBT(data []byte) (*PSBT, error) {
+	if !bytes.HasPrefix(data, psbtMagic) || len(data) <= len(psbtMagic) {
+		return nil, fmt.Errorf("%w: missing magic", ErrBadPSBT)
+	}
+	if version := data[len(psbtMagic)]; version != psbtEncodingVersion {
+		return nil, fmt.Errorf("%w: unsupported encoding version %d", ErrBadPSBT, version)
+	}
+
+	var p PSBT
+	dec := gob.NewDecoder(bytes.NewReader(data[len(psbtMagic)+1:]))
+	err := dec.Decode(&p)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrBadPSBT, err)
+	}
+
+	if len(p.Inputs) != len(p.Tx.Vin) {
+		return nil, fmt.Errorf("%w: %d inputs but %d input records", ErrBadPSBT, len(p.Tx.Vin), len(p.Inputs))
+	}
+	for inID := range p.Inputs {
+		if p.Inputs[inID].PartialSigs == nil {
+			p.Inputs[inID].PartialSigs = make(map[string][]byte)
+		}
+		p.Tx.Vin[inID].ScriptSig = nil
+	}
+	// The stored ID is not trusted, it is the hash of the unsigned transaction
+	p.Tx.ID = p.Tx.Hash()
+
+	return &p, nil
+}
+
+// Base64 returns the serialized PSBT encoded as base64, to be passed around
+// as text or in files
+func (p *PSBT) Base64() string {
+	return base64.StdEncoding.EncodeToString(p.Serialize())
+}
+
+// DecodePSBTBase64 decodes a PSBT from its base64 encoding
+func DecodePSBTBase64(encoded string) (*PSBT, error) {
+	data, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrBadPSBT, err)
+	}
+
+	return DeserializePSBT(data)
+}
+
+// setRedeemScript keeps redeemScript if the input pays to its hash
+func (in *PSBTInput) setRedeemScript(redeemScript []byte) {
+	scriptHash := script.ExtractScriptHash(in.PrevOut.ScriptPubKey)
+	if scriptHash != nil && bytes.Equal(script.Hash160(redeemScript), scriptHash) {
+		in.RedeemScript = redeemScript
+	}
+}
+
+// multiSig returns the multisig script spent by the input, directly or
+// through P2SH
+func (in *PSBTInput) multiSig() (int, [][]byte, error) {
+	multiSigScript := in.PrevOut.ScriptPubKey
+	if script.IsPayToScriptHash(multiSigScript) {
+		if in.RedeemScript == nil {
+			return 0, nil, ErrRedeemScript
+		}
+		multiSigScript = in.RedeemScript
+	}
+	if script.GetScriptClass(multiSigScript) != script.MultiSigTy {
+		return 0, nil, ErrNotMultiSig
+	}
+
+	return script.ExtractMultiSig(multiSigScript)
+}
+
+// canSign reports whether pubKey may sign the input
+func (in *PSBTInput) canSign(pubKey []byte) bool {
+	_, _, lockingScript := script.ExtractTimeLock(in.PrevOut.ScriptPubKey)
+	if pubKeyHash := script.ExtractPubKeyHash(lockingScript); pubKeyHash != nil {
+		return bytes.Equal(script.Hash160(pubKey), pubKeyHash)
+	}
+
+	_, pubKeys, err := in.multiSig()
+	if err != nil {
+		return false
+	}
+	for _, key := range pubKeys {
+		if bytes.Equal(key, pubKey) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// finalScriptSig builds the unlocking script of the input from its partial
+// signatures
+func (in *PSBTInput) finalScriptSig() ([]byte, error) {
+	if in.FinalScriptSig != nil {
+		return in.FinalScriptSig, nil
+	}
+
+	_, _, lockingScript := script.ExtractTimeLock(in.PrevOut.ScriptPubKey)
+	if pubKeyHash := script.ExtractPubKeyHash(lockingScript); pubKeyHash != nil {
+		for key, sig := range in.PartialSigs {
+			pubKey, err := hex.DecodeString(key)
+			if err == nil && bytes.Equal(script.Hash160(pubKey), pubKeyHash) {
+				return script.PayToPubKeyHashSigScript(sig, pubKey)
+			}
+		}
+		return nil, ErrPSBTIncomplete
+	}
+
+	required, pubKeys, err := in.multiSig()
+	if err != nil {
+		return nil, err
+	}
+
+	// CHECKMULTISIG wants exactly required signatures in key order
+	var sigs [][]byte
+	for _, key := range pubKeys {
+		if sig, ok := in.PartialSigs[hex.EncodeToString(key)]; ok && len(sigs) < required {
+			sigs = append(sigs, sig)
+		}
+	}
+	if len(sigs) < required {
+		return nil, fmt.Errorf("%w: %d of %d", ErrPSBTIncomplete, len(sigs), required)
+	}
+
+	return script.MultiSigSigScript(sigs, in.RedeemScript)
+}
@@ -0,0 +1,239 @@
+package transaction
+
+import (
+	"encoding/hex"
+	"errors"
+	"reflect"
+	"testing"
+
+	"blockchain-app/wallet"
+)
+
+// psbtFixture is a PSBT spending a P2PKH output of alice and a 2-of-3
+// multisig output of alice, bob and carol
+type psbtFixture struct {
+	psbt              *PSBT
+	prevTXs           map[string]Transaction
+	alice, bob, carol *wallet.Wallet
+	multiSig          *wallet.MultiSig
+}
+
+func newPSBTFixture(t *testing.T) *psbtFixture {
+	t.Helper()
+
+	f := &psbtFixture{alice: wallet.NewWallet(), bob: wallet.NewWallet(), carol: wallet.NewWallet()}
+
+	var err error
+	f.multiSig, err = wallet.NewMultiSig(2, [][]byte{f.alice.PublicKey, f.bob.PublicKey, f.carol.PublicKey})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	prev := testTransaction(
+		[]TXInput{{Vout: -1, ScriptSig: []byte("funding")}},
+		newOutput(t, 50, string(f.alice.GetAddress())),
+		newOutput(t, 70, string(f.multiSig.GetAddress())),
+	)
+	tx := testTransaction(
+		[]TXInput{{Txid: prev.ID, Vout: 0}, {Txid: prev.ID, Vout: 1}},
+		newOutput(t, 110, string(wallet.NewWallet().GetAddress())),
+	)
+	f.prevTXs = map[string]Transaction{hex.EncodeToString(prev.ID): *prev}
+
+	f.psbt, err = NewPSBT(tx, f.prevTXs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.psbt.AddRedeemScript(f.multiSig.RedeemScript)
+
+	return f
+}
+
+// copyPSBT returns a copy of p passed through its base64 encoding, as
+// handed to another signer
+func copyPSBT(t *testing.T, p *PSBT) *PSBT {
+	t.Helper()
+
+	decoded, err := DecodePSBTBase64(p.Base64())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return decoded
+}
+
+func TestPSBTSerializeRoundTrip(t *testing.T) {
+	f := newPSBTFixture(t)
+
+	if decoded := copyPSBT(t, f.psbt); !reflect.DeepEqual(decoded, f.psbt) {
+		t.Errorf("unsigned PSBT changed by the round trip:\n%+v\nwant\n%+v", decoded, f.psbt)
+	}
+
+	if _, err := f.psbt.Sign(f.alice.Signer()); err != nil {
+		t.Fatal(err)
+	}
+	decoded := copyPSBT(t, f.psbt)
+	if !reflect.DeepEqual(decoded, f.psbt) {
+		t.Errorf("signed PSBT changed by the round trip:\n%+v\nwant\n%+v", decoded, f.psbt)
+	}
+	if !reflect.DeepEqual(decoded.Inputs[1].RedeemScript, f.multiSig.RedeemScript) {
+		t.Error("redeem script lost by the round trip")
+	}
+}
+
+func TestPSBTSignCombineFinalizeExtract(t *testing.T) {
+	f := newPSBTFixture(t)
+
+	aliceCopy, bobCopy := copyPSBT(t, f.psbt), copyPSBT(t, f.psbt)
+
+	if signed, err := aliceCopy.Sign(f.alice.Signer()); err != nil || signed != 2 {
+		t.Fatalf("alice signed %d inputs, %v; want 2", signed, err)
+	}
+	if signed, err := bobCopy.Sign(f.bob.Signer()); err != nil || signed != 1 {
+		t.Fatalf("bob signed %d inputs, %v; want 1", signed, err)
+	}
+
+	// One signature doesn't satisfy the 2-of-3 input
+	if err := aliceCopy.Finalize(); !errors.Is(err, ErrPSBTIncomplete) {
+		t.Fatalf("Finalize with one multisig signature returned %v, want %v", err, ErrPSBTIncomplete)
+	}
+	if aliceCopy.Inputs[0].FinalScriptSig != nil {
+		t.Error("failed Finalize finalized an input")
+	}
+	if _, err := aliceCopy.Extract(); !errors.Is(err, ErrPSBTIncomplete) {
+		t.Fatalf("Extract before Finalize returned %v, want %v", err, ErrPSBTIncomplete)
+	}
+
+	if err := aliceCopy.Combine(bobCopy); err != nil {
+		t.Fatal(err)
+	}
+	if len(aliceCopy.Inputs[1].PartialSigs) != 2 {
+		t.Fatalf("combined multisig input has %d signatures, want 2", len(aliceCopy.Inputs[1].PartialSigs))
+	}
+	if err := aliceCopy.Finalize(); err != nil {
+		t.Fatal(err)
+	}
+	if !aliceCopy.IsComplete() {
+		t.Fatal("finalized PSBT is not complete")
+	}
+
+	tx, err := aliceCopy.Extract()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.VerifyScripts(f.prevTXs); err != nil {
+		t.Fatalf("extracted transaction does not verify: %v", err)
+	}
+	if fee, err := aliceCopy.Fee(); err != nil || fee != 10 {
+		t.Errorf("Fee = %d, %v; want 10", fee, err)
+	}
+
+	// Signatures of a third key are merged, but only two are used
+	carolCopy := copyPSBT(t, f.psbt)
+	if _, err := carolCopy.Sign(f.carol.Signer()); err != nil {
+		t.Fatal(err)
+	}
+	if err := carolCopy.Combine(aliceCopy); err != nil {
+		t.Fatal(err)
+	}
+	if err := carolCopy.Combine(bobCopy); err != nil {
+		t.Fatal(err)
+	}
+	if err := carolCopy.Finalize(); err != nil {
+		t.Fatal(err)
+	}
+	tx, err = carolCopy.Extract()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.VerifyScripts(f.prevTXs); err != nil {
+		t.Fatalf("transaction signed by three keys does not verify: %v", err)
+	}
+}
+
+func TestPSBTRejectsMismatches(t *testing.T) {
+	f := newPSBTFixture(t)
+	if _, err := f.psbt.Sign(f.alice.Signer()); err != nil {
+		t.Fatal(err)
+	}
+	before := copyPSBT(t, f.psbt)
+
+	t.Run("other transaction", func(t *testing.T) {
+		other := newPSBTFixture(t)
+		if err := f.psbt.Combine(other.psbt); !errors.Is(err, ErrPSBTMismatch) {
+			t.Errorf("Combine returned %v, want %v", err, ErrPSBTMismatch)
+		}
+	})
+
+	t.Run("different spent output", func(t *testing.T) {
+		other := copyPSBT(t, before)
+		other.Inputs[0].PrevOut.Value++
+		if err := f.psbt.Combine(other); !errors.Is(err, ErrPSBTMismatch) {
+			t.Errorf("Combine returned %v, want %v", err, ErrPSBTMismatch)
+		}
+	})
+
+	t.Run("forged signature", func(t *testing.T) {
+		other := copyPSBT(t, before)
+		other.Inputs[1].PartialSigs[hex.EncodeToString(f.bob.PublicKey)] = other.Inputs[1].PartialSigs[hex.EncodeToString(f.alice.PublicKey)]
+		if err := f.psbt.Combine(other); !errors.Is(err, ErrBadPSBT) {
+			t.Errorf("Combine returned %v, want %v", err, ErrBadPSBT)
+		}
+	})
+
+	t.Run("input records not matching the inputs", func(t *testing.T) {
+		other := copyPSBT(t, before)
+		other.Inputs = other.Inputs[:1]
+		if _, err := DeserializePSBT(other.Serialize()); !errors.Is(err, ErrBadPSBT) {
+			t.Errorf("DeserializePSBT returned %v, want %v", err, ErrBadPSBT)
+		}
+	})
+
+	if !reflect.DeepEqual(f.psbt, before) {
+		t.Error("rejected Combine changed the PSBT")
+	}
+}
+
+func TestDeserializePSBTRejectsBadEncodings(t *testing.T) {
+	f := newPSBTFixture(t)
+	data := f.psbt.Serialize()
+
+	wrongVersion := append([]byte(nil), data...)
+	wrongVersion[len(psbtMagic)]++
+
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"empty", nil},
+		{"magic only", psbtMagic},
+		{"wrong magic", append([]byte("pbst"), data[len(psbtMagic):]...)},
+		{"wrong version", wrongVersion},
+		{"truncated", data[:len(data)/2]},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := DeserializePSBT(tt.data); !errors.Is(err, ErrBadPSBT) {
+				t.Errorf("DeserializePSBT returned %v, want %v", err, ErrBadPSBT)
+			}
+		})
+	}
+
+	if _, err := DecodePSBTBase64("not base64!"); !errors.Is(err, ErrBadPSBT) {
+		t.Errorf("DecodePSBTBase64 returned %v, want %v", err, ErrBadPSBT)
+	}
+}
+
+func TestPSBTSignRequiresAKey(t *testing.T) {
+	f := newPSBTFixture(t)
+
+	if _, err := f.psbt.Sign(wallet.NewWallet().Signer()); !errors.Is(err, ErrNotSigner) {
+		t.Errorf("Sign with an unrelated key returned %v, want %v", err, ErrNotSigner)
+	}
+
+	coinbase := testTransaction([]TXInput{{Vout: -1, ScriptSig: []byte("coinbase")}}, newOutput(t, 50, string(f.alice.GetAddress())))
+	if _, err := NewPSBT(coinbase, nil); !errors.Is(err, ErrBadPSBT) {
+		t.Errorf("NewPSBT of a coinbase returned %v, want %v", err, ErrBadPSBT)
+	}
+}
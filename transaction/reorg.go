@@ -0,0 +1,377 @@
+package transaction
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+
+	"blockchain-app/pow"
+
+	"github.com/dgraph-io/badger/v3"
+)
+
+// workPrefix prefixes the cumulative chain work stored for every block
+const workPrefix = "work:"
+
+// invalidPrefix prefixes the marker of every block known to be invalid
+const invalidPrefix = "invalid:"
+
+// ReorgHandler receives the transactions of disconnected blocks that are not
+// part of the new best chain, so they can be put back into the mempool
+type ReorgHandler func(resurrected []*Transaction)
+
+// SetReorgHandler registers a function that is called after a reorganization
+func (bc *Blockchain) SetReorgHandler(handler ReorgHandler) {
+	bc.reorgHandler = handler
+}
+
+// GetChainWork returns the cumulative work of the best chain
+func (bc *Blockchain) GetChainWork() *big.Int {
+	work, err := bc.chainWork(bc.currentTip())
+	if err != nil {
+		log.Panic(err)
+	}
+
+	return work
+}
+
+// chainWork returns the cumulative work of the chain ending at blockHash.
+// Blocks stored without a work entry get one computed from their ancestors.
+func (bc *Blockchain) chainWork(blockHash []byte) (*big.Int, error) {
+	var missing []Block
+	work := big.NewInt(0)
+	hash := blockHash
+
+	for len(hash) > 0 {
+		stored, err := bc.storedWork(hash)
+		if err != nil {
+			return nil, err
+		}
+		if stored != nil {
+			work = stored
+			break
+		}
+
+		block, err := bc.GetBlock(hash)
+		if err != nil {
+			return nil, err
+		}
+		missing = append(missing, block)
+		hash = block.PrevBlockHash
+	}
+
+	for i := len(missing) - 1; i >= 0; i-- {
+		work = new(big.Int).Add(work, pow.CalcWork(missing[i].Bits))
+
+		err := bc.db.Update(func(txn *badger.Txn) error {
+			return txn.Set(workKey(missing[i].Hash), work.Bytes())
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return work, nil
+}
+
+// storedWork reads the cumulative work of a block, or nil when none is stored
+func (bc *Blockchain) storedWork(blockHash []byte) (*big.Int, error) {
+	var work *big.Int
+
+	err := bc.db.View(func(txn *badger.Txn) error {
+		item, err := txn.Get(workKey(blockHash))
+		if err == badger.ErrKeyNotFound {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		return item.Value(func(val []byte) error {
+			work = new(big.Int).SetBytes(val)
+			return nil
+		})
+	})
+
+	return work, err
+}
+
+// workKey returns the key of the cumulative work entry of a block
+func workKey(blockHash []byte) []byte {
+	return append([]byte(workPrefix), blockHash...)
+}
+
+// connectBlock applies a block on top of the current tip and makes it the
+// new tip. The chainstate, the indexes and the stored tip change in one
+// database transaction, the tip written last.
+func (bc *Blockchain) connectBlock(block *Block) error {
+	err := bc.db.Update(func(txn *badger.Txn) error {
+		err := UTXOSet{bc}.update(txn, block)
+		if err != nil {
+			return err
+		}
+
+		err = setBlockHeight(txn, block)
+		if err != nil {
+			return err
+		}
+
+		if bc.TxIndexEnabled() {
+			err = indexBlock(txn, block)
+			if err != nil {
+				return err
+			}
+		}
+
+		return txn.Set([]byte("lh"), block.Hash)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to connect block %x: %w", block.Hash, err)
+	}
+
+	bc.setTip(block.Hash)
+
+	return nil
+}
+
+// disconnectBlock reverts the tip block and makes its parent the new tip,
+// in one database transaction like connectBlock
+func (bc *Blockchain) disconnectBlock(block *Block) error {
+	if !bytes.Equal(block.Hash, bc.currentTip()) {
+		return fmt.Errorf("block %x is not the tip", block.Hash)
+	}
+
+	err := bc.db.Update(func(txn *badger.Txn) error {
+		err := UTXOSet{bc}.disconnect(txn, block)
+		if err != nil {
+			return err
+		}
+
+		err = unsetBlockHeight(txn, block)
+		if err != nil {
+			return err
+		}
+
+		if bc.TxIndexEnabled() {
+			err = unindexBlock(txn, block)
+			if err != nil {
+				return err
+			}
+		}
+
+		return txn.Set([]byte("lh"), block.PrevBlockHash)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to disconnect block %x: %w", block.Hash, err)
+	}
+
+	bc.setTip(block.PrevBlockHash)
+
+	return nil
+}
+
+// reorganize switches the best chain to the branch ending at newTip. Blocks
+// of the old branch are disconnected back to the fork point, then the new
+// branch is connected; if any of its blocks turns out to be invalid the old
+// branch is restored and that block and its descendants are marked invalid.
+// Only failures of the data the header commits to are marked: a block whose
+// scripts fail is deleted instead, so a valid copy can still be accepted.
+func (bc *Blockchain) reorganize(newTip *Block) error {
+	detach, attach, err := bc.findFork(newTip)
+	if err != nil {
+		return err
+	}
+
+	// A branch through a block already known to be invalid is never chosen
+	for i, block := range attach {
+		if bc.isInvalid(block.Hash) {
+			return bc.invalidateBranch(attach[i:], fmt.Errorf("%w: %x", ErrInvalidAncestor, block.Hash))
+		}
+	}
+
+	fmt.Printf("Reorganizing: disconnecting %d blocks, connecting %d blocks\n", len(detach), len(attach))
+
+	for _, block := range detach {
+		err := bc.disconnectBlock(block)
+		if err != nil {
+			return err
+		}
+	}
+
+	for i, block := range attach {
+		err := bc.checkTransactions(block)
+		if err != nil {
+			restoreErr := bc.restoreBranch(attach[:i], detach)
+			if restoreErr != nil {
+				return restoreErr
+			}
+			if errors.Is(err, ErrBadSignature) {
+				return bc.forgetBranch(attach[i:], err)
+			}
+			if errors.Is(err, ErrInvalidBlock) {
+				return bc.invalidateBranch(attach[i:], err)
+			}
+			return err
+		}
+
+		err = bc.connectBlock(block)
+		if err != nil {
+			restoreErr := bc.restoreBranch(attach[:i], detach)
+			if restoreErr != nil {
+				return restoreErr
+			}
+			return err
+		}
+	}
+
+	if bc.reorgHandler != nil {
+		bc.reorgHandler(resurrectedTransactions(detach, attach))
+	}
+
+	return nil
+}
+
+// restoreBranch undoes a failed reorganization by disconnecting the blocks
+// connected so far and reconnecting the old branch
+func (bc *Blockchain) restoreBranch(connected, detached []*Block) error {
+	for i := len(connected) - 1; i >= 0; i-- {
+		err := bc.disconnectBlock(connected[i])
+		if err != nil {
+			return fmt.Errorf("failed to restore the old branch: %w", err)
+		}
+	}
+
+	for i := len(detached) - 1; i >= 0; i-- {
+		err := bc.connectBlock(detached[i])
+		if err != nil {
+			return fmt.Errorf("failed to restore the old branch: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// invalidateBranch marks blocks, the first of which failed validation with
+// cause and the rest its descendants, as invalid and returns cause
+func (bc *Blockchain) invalidateBranch(blocks []*Block, cause error) error {
+	err := bc.db.Update(func(txn *badger.Txn) error {
+		for _, block := range blocks {
+			err := txn.Set(invalidKey(block.Hash), []byte{})
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Marked %d blocks from %x invalid: %v\n", len(blocks), blocks[0].Hash, cause)
+
+	return cause
+}
+
+// forgetBranch deletes blocks, the first of which failed validation with
+// cause and the rest its descendants, together with their chain work and
+// returns cause. They can be received and validated again later.
+func (bc *Blockchain) forgetBranch(blocks []*Block, cause error) error {
+	err := bc.db.Update(func(txn *badger.Txn) error {
+		for _, block := range blocks {
+			err := txn.Delete(block.Hash)
+			if err != nil {
+				return err
+			}
+
+			err = txn.Delete(workKey(block.Hash))
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Deleted %d blocks from %x: %v\n", len(blocks), blocks[0].Hash, cause)
+
+	return cause
+}
+
+// isInvalid reports whether a block was marked invalid
+func (bc *Blockchain) isInvalid(blockHash []byte) bool {
+	err := bc.db.View(func(txn *badger.Txn) error {
+		_, err := txn.Get(invalidKey(blockHash))
+		return err
+	})
+
+	return err == nil
+}
+
+// invalidKey returns the key of the invalid marker of a block
+func invalidKey(blockHash []byte) []byte {
+	return append([]byte(invalidPrefix), blockHash...)
+}
+
+// findFork returns the blocks to disconnect (tip first) and the blocks to
+// connect (fork point first) to move the best chain to newTip
+func (bc *Blockchain) findFork(newTip *Block) ([]*Block, []*Block, error) {
+	var detach, attach []*Block
+
+	tip, err := bc.GetBlock(bc.currentTip())
+	if err != nil {
+		return nil, nil, err
+	}
+	oldBlock := &tip
+	newBlock := newTip
+
+	for !bytes.Equal(oldBlock.Hash, newBlock.Hash) {
+		if oldBlock.Height >= newBlock.Height {
+			detach = append(detach, oldBlock)
+			parent, err := bc.GetBlock(oldBlock.PrevBlockHash)
+			if err != nil {
+				return nil, nil, err
+			}
+			oldBlock = &parent
+		} else {
+			attach = append(attach, newBlock)
+			parent, err := bc.GetBlock(newBlock.PrevBlockHash)
+			if err != nil {
+				return nil, nil, err
+			}
+			newBlock = &parent
+		}
+	}
+
+	for i, j := 0, len(attach)-1; i < j; i, j = i+1, j-1 {
+		attach[i], attach[j] = attach[j], attach[i]
+	}
+
+	return detach, attach, nil
+}
+
+// resurrectedTransactions returns the non-coinbase transactions of the
+// detached blocks that were not included again by the attached ones
+func resurrectedTransactions(detach, attach []*Block) []*Transaction {
+	included := make(map[string]bool)
+	for _, block := range attach {
+		for _, tx := range block.Transactions {
+			included[hex.EncodeToString(tx.ID)] = true
+		}
+	}
+
+	var resurrected []*Transaction
+	for i := len(detach) - 1; i >= 0; i-- {
+		for _, tx := range detach[i].Transactions {
+			if !tx.IsCoinbase() && !included[hex.EncodeToString(tx.ID)] {
+				resurrected = append(resurrected, tx)
+			}
+		}
+	}
+
+	return resurrected
+}
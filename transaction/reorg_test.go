@@ -0,0 +1,219 @@
+package transaction
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"sync"
+	"testing"
+
+	"blockchain-app/script"
+	"blockchain-app/wallet"
+)
+
+// newMinedBlockchain creates a blockchain with a mined genesis block in a
+// temporary directory
+func newMinedBlockchain(t *testing.T) (*Blockchain, string) {
+	t.Helper()
+
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.Chdir(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) })
+
+	address := string(wallet.EncodeAddress(wallet.PubKeyHashAddress, script.Hash160([]byte("miner"))))
+	bc := CreateBlockchain(address, "test")
+	t.Cleanup(func() { bc.Close() })
+
+	return bc, address
+}
+
+// mineOn mines a block holding a fresh coinbase and txs on top of prev
+func mineOn(t *testing.T, bc *Blockchain, prev *Block, address string, txs ...*Transaction) *Block {
+	t.Helper()
+
+	bits, err := bc.CalcNextBits(prev)
+	if err != nil {
+		t.Fatal(err)
+	}
+	coinbase := newCoinbase(t, address, prev.Height+1)
+
+	return NewBlock(append([]*Transaction{coinbase}, txs...), prev.Hash, prev.Height+1, bits)
+}
+
+// newCoinbase returns a coinbase of the block at height paying address
+func newCoinbase(t *testing.T, address string, height int) *Transaction {
+	t.Helper()
+
+	coinbase, err := NewCoinbaseTX(address, "", height, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return coinbase
+}
+
+// newOutput returns an output of value paying address
+func newOutput(t *testing.T, value int, address string) TXOutput {
+	t.Helper()
+
+	out, err := NewTXOutput(value, address)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return *out
+}
+
+func TestReorganizeMarksFailedBranchInvalid(t *testing.T) {
+	bc, address := newMinedBlockchain(t)
+
+	genesis, err := bc.GetBlock(bc.tip)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a1 := bc.MineBlock([]*Transaction{newCoinbase(t, address, 1)})
+
+	// b1 ties with a1 and is only stored; b2 spends an output that doesn't
+	// exist, which is only noticed while reorganizing onto it
+	b1 := mineOn(t, bc, &genesis, address)
+	if err := bc.AddBlock(b1); err != nil {
+		t.Fatal(err)
+	}
+
+	missing := Transaction{Vin: []TXInput{{Txid: bytes.Repeat([]byte{0xab}, 32), Vout: 0}}, Vout: []TXOutput{newOutput(t, 1, address)}}
+	missing.ID = missing.UnsignedHash()
+	b2 := mineOn(t, bc, b1, address, &missing)
+
+	err = bc.AddBlock(b2)
+	if !errors.Is(err, ErrDoubleSpend) {
+		t.Fatalf("AddBlock of b2 returned %v, want %v", err, ErrDoubleSpend)
+	}
+	if !bytes.Equal(bc.tip, a1.Hash) {
+		t.Fatalf("tip %x after failed reorganization, want a1 %x", bc.tip, a1.Hash)
+	}
+	if !bc.isInvalid(b2.Hash) || bc.isInvalid(b1.Hash) {
+		t.Fatalf("b1 invalid %v, b2 invalid %v; want only b2", bc.isInvalid(b1.Hash), bc.isInvalid(b2.Hash))
+	}
+
+	// Descendants of b2 are refused, however much work they add
+	b3 := mineOn(t, bc, b2, address)
+	if err := bc.AddBlock(b3); !errors.Is(err, ErrInvalidAncestor) {
+		t.Fatalf("AddBlock of b3 returned %v, want %v", err, ErrInvalidAncestor)
+	}
+	b4 := mineOn(t, bc, b3, address)
+	if err := bc.AddBlock(b4); !errors.Is(err, ErrInvalidAncestor) {
+		t.Fatalf("AddBlock of b4 returned %v, want %v", err, ErrInvalidAncestor)
+	}
+	if bc.HasBlock(b3.Hash) || bc.HasBlock(b4.Hash) {
+		t.Error("descendants of an invalid block were stored")
+	}
+
+	// The valid part of the branch can still win
+	c2 := mineOn(t, bc, b1, address)
+	if err := bc.AddBlock(c2); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(bc.tip, c2.Hash) {
+		t.Fatalf("tip %x, want c2 %x", bc.tip, c2.Hash)
+	}
+}
+
+func TestReorganizeDeletesBlockWithBadScripts(t *testing.T) {
+	bc, w := newFundedBlockchain(t)
+	address := string(w.GetAddress())
+
+	fork, err := bc.GetBlock(bc.tip)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a1 := bc.MineBlock([]*Transaction{newCoinbase(t, address, fork.Height+1)})
+	b1 := mineOn(t, bc, &fork, address)
+	if err := bc.AddBlock(b1); err != nil {
+		t.Fatal(err)
+	}
+
+	// b2 carries a spend whose signature was tampered with, which is only
+	// noticed while reorganizing onto it
+	spend, err := NewUTXOTransaction(w, address, 10, 1, &UTXOSet{bc})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tampered := *spend
+	tampered.Vin = append([]TXInput(nil), spend.Vin...)
+	tampered.Vin[0].ScriptSig = append([]byte(nil), spend.Vin[0].ScriptSig...)
+	tampered.Vin[0].ScriptSig[10] ^= 0xff
+	b2 := mineOn(t, bc, b1, address, &tampered)
+
+	if err := bc.AddBlock(b2); !errors.Is(err, ErrBadSignature) {
+		t.Fatalf("AddBlock of b2 returned %v, want %v", err, ErrBadSignature)
+	}
+	if !bytes.Equal(bc.tip, a1.Hash) {
+		t.Fatalf("tip %x after failed reorganization, want a1 %x", bc.tip, a1.Hash)
+	}
+	if bc.isInvalid(b2.Hash) || bc.HasBlock(b2.Hash) {
+		t.Fatalf("b2 invalid %v, stored %v; want it forgotten", bc.isInvalid(b2.Hash), bc.HasBlock(b2.Hash))
+	}
+
+	// b2 is validated again rather than refused as known
+	if err := bc.AddBlock(b2); !errors.Is(err, ErrBadSignature) {
+		t.Fatalf("AddBlock of b2 again returned %v, want %v", err, ErrBadSignature)
+	}
+
+	// The branch is still open to a block with valid scripts
+	c2 := mineOn(t, bc, b1, address, spend)
+	if err := bc.AddBlock(c2); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(bc.tip, c2.Hash) {
+		t.Fatalf("tip %x, want c2 %x", bc.tip, c2.Hash)
+	}
+}
+
+func TestConcurrentMiningKeepsChainstateConsistent(t *testing.T) {
+	bc, address := newMinedBlockchain(t)
+
+	// Miners race on the same tip, so some of their blocks end up as forks.
+	// A coinbase built before the tip moved is refused and built again.
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for mined := 0; mined < 3; {
+				coinbase, err := NewCoinbaseTX(address, "", bc.GetBestHeight()+1, 0)
+				if err == nil {
+					_, err = bc.MineBlockContext(context.Background(), []*Transaction{coinbase})
+				}
+				if errors.Is(err, ErrBadCoinbase) {
+					continue
+				}
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				mined++
+			}
+		}()
+	}
+	wg.Wait()
+
+	tip, err := bc.GetBlock(bc.currentTip())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tip.Height != bc.GetBestHeight() {
+		t.Fatalf("tip at height %d, best height %d", tip.Height, bc.GetBestHeight())
+	}
+
+	utxoSet := UTXOSet{bc}
+	if got, want := utxoSet.CountTransactions(), len(bc.FindUTXO()); got != want {
+		t.Errorf("UTXO set holds %d transactions, the chain %d", got, want)
+	}
+}
@@ -0,0 +1,241 @@
+package transaction
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// SigHashType selects which parts of a transaction a signature commits to.
+// It is appended to every signature so the verifier rebuilds the same hash.
+type SigHashType byte
+
+// Signature hash types
+const (
+	// SigHashAll signs every input and every output
+	SigHashAll SigHashType = 0x01
+
+	// SigHashNone signs every input but no output
+	SigHashNone SigHashType = 0x02
+
+	// SigHashSingle signs every input and the output at the index of the
+	// signed input
+	SigHashSingle SigHashType = 0x03
+
+	// SigHashAnyOneCanPay is combined with the types above to sign only the
+	// input being signed, so others can add inputs of their own
+	SigHashAnyOneCanPay SigHashType = 0x80
+
+	// sigHashMask extracts the base type without the AnyOneCanPay flag
+	sigHashMask = 0x1f
+)
+
+// Signature hash errors
+var (
+	ErrBadSigHashType  = errors.New("invalid signature hash type")
+	ErrSigHashNoOutput = errors.New("SIGHASH_SINGLE input has no matching output")
+)
+
+// IsValid reports whether the type is one of the defined combinations
+func (t SigHashType) IsValid() bool {
+	base := t &^ SigHashAnyOneCanPay
+	return base >= SigHashAll && base <= SigHashSingle
+}
+
+// String returns the name of the type, e.g. ALL|ANYONECANPAY
+func (t SigHashType) String() string {
+	var name string
+
+	switch t & sigHashMask {
+	case SigHashAll:
+		name = "ALL"
+	case SigHashNone:
+		name = "NONE"
+	case SigHashSingle:
+		name = "SINGLE"
+	default:
+		return fmt.Sprintf("UNKNOWN(%02x)", byte(t))
+	}
+
+	if t&SigHashAnyOneCanPay != 0 {
+		name += "|ANYONECANPAY"
+	}
+
+	return name
+}
+
+// SigHashCache holds the parts of the signature hash preimage shared by all
+// inputs of a transaction, so signing or verifying n inputs hashes the
+// inputs and outputs once instead of n times. It must not be reused after
+// the transaction changes.
+type SigHashCache struct {
+	tx           *Transaction
+	hashPrevouts []byte
+	hashSequence []byte
+	hashOutputs  []byte
+}
+
+// NewSigHashCache creates an empty cache for tx. Midstates are computed the
+// first time they are needed.
+func NewSigHashCache(tx *Transaction) *SigHashCache {
+	return &SigHashCache{tx: tx}
+}
+
+// SignatureHash returns the hash an input signature commits to. prevOut is
+// the output spent by input inID, its value and script are signed as well.
+func (tx *Transaction) SignatureHash(inID int, prevOut TXOutput, hashType SigHashType) ([]byte, error) {
+	return NewSigHashCache(tx).SignatureHash(inID, prevOut, hashType)
+}
+
+// SignatureHash returns the double SHA-256 of the preimage built by
+// SigHashPreimage
+func (c *SigHashCache) SignatureHash(inID int, prevOut TXOutput, hashType SigHashType) ([]byte, error) {
+	preimage, err := c.SigHashPreimage(inID, prevOut, hashType)
+	if err != nil {
+		return nil, err
+	}
+
+	return doubleSHA256(preimage), nil
+}
+
+// SigHashPreimage serializes the data signed for input inID. Integers are
+// little-endian and byte strings are prefixed with their uint32 length:
+//
+//	hashPrevouts  32 bytes, zero with ANYONECANPAY
+//	hashSequence  32 bytes, zero with ANYONECANPAY, SINGLE or NONE
+//	outpoint      txid, vout uint32
+//	scriptCode    locking script of the spent output
+//	value         int64 value of the spent output
+//	sequence      uint32
+//	hashOutputs   32 bytes, only output inID with SINGLE, zero with NONE
+//	lockTime      int64
+//	hashType      uint32
+func (c *SigHashCache) SigHashPreimage(inID int, prevOut TXOutput, hashType SigHashType) ([]byte, error) {
+	tx := c.tx
+
+	if inID < 0 || inID >= len(tx.Vin) {
+		return nil, fmt.Errorf("input %d does not exist", inID)
+	}
+	if !hashType.IsValid() {
+		return nil, fmt.Errorf("%w: %02x", ErrBadSigHashType, byte(hashType))
+	}
+
+	base := hashType & sigHashMask
+	anyOneCanPay := hashType&SigHashAnyOneCanPay != 0
+
+	if base == SigHashSingle && inID >= len(tx.Vout) {
+		return nil, fmt.Errorf("%w: input %d", ErrSigHashNoOutput, inID)
+	}
+
+	zeroHash := make([]byte, sha256.Size)
+	var buf bytes.Buffer
+
+	if anyOneCanPay {
+		buf.Write(zeroHash)
+	} else {
+		buf.Write(c.prevoutsHash())
+	}
+
+	if anyOneCanPay || base != SigHashAll {
+		buf.Write(zeroHash)
+	} else {
+		buf.Write(c.sequenceHash())
+	}
+
+	vin := tx.Vin[inID]
+	writeOutpoint(&buf, vin)
+	writeVarBytes(&buf, prevOut.ScriptPubKey)
+	writeUint64(&buf, uint64(prevOut.Value))
+	writeUint32(&buf, vin.Sequence)
+
+	switch base {
+	case SigHashAll:
+		buf.Write(c.outputsHash())
+	case SigHashSingle:
+		var out bytes.Buffer
+		writeOutput(&out, tx.Vout[inID])
+		buf.Write(doubleSHA256(out.Bytes()))
+	default:
+		buf.Write(zeroHash)
+	}
+
+	writeUint64(&buf, uint64(tx.LockTime))
+	writeUint32(&buf, uint32(hashType))
+
+	return buf.Bytes(), nil
+}
+
+// prevoutsHash returns the midstate committing to every outpoint spent
+func (c *SigHashCache) prevoutsHash() []byte {
+	if c.hashPrevouts == nil {
+		var buf bytes.Buffer
+		for _, vin := range c.tx.Vin {
+			writeOutpoint(&buf, vin)
+		}
+		c.hashPrevouts = doubleSHA256(buf.Bytes())
+	}
+
+	return c.hashPrevouts
+}
+
+// sequenceHash returns the midstate committing to every input sequence
+func (c *SigHashCache) sequenceHash() []byte {
+	if c.hashSequence == nil {
+		var buf bytes.Buffer
+		for _, vin := range c.tx.Vin {
+			writeUint32(&buf, vin.Sequence)
+		}
+		c.hashSequence = doubleSHA256(buf.Bytes())
+	}
+
+	return c.hashSequence
+}
+
+// outputsHash returns the midstate committing to every output
+func (c *SigHashCache) outputsHash() []byte {
+	if c.hashOutputs == nil {
+		var buf bytes.Buffer
+		for _, out := range c.tx.Vout {
+			writeOutput(&buf, out)
+		}
+		c.hashOutputs = doubleSHA256(buf.Bytes())
+	}
+
+	return c.hashOutputs
+}
+
+func writeOutpoint(buf *bytes.Buffer, vin TXInput) {
+	writeVarBytes(buf, vin.Txid)
+	writeUint32(buf, uint32(vin.Vout))
+}
+
+func writeOutput(buf *bytes.Buffer, out TXOutput) {
+	writeUint64(buf, uint64(out.Value))
+	writeVarBytes(buf, out.ScriptPubKey)
+}
+
+func writeVarBytes(buf *bytes.Buffer, data []byte) {
+	writeUint32(buf, uint32(len(data)))
+	buf.Write(data)
+}
+
+func writeUint32(buf *bytes.Buffer, n uint32) {
+	var b [4]byte
+	binary.LittleEndian.PutUint32(b[:], n)
+	buf.Write(b[:])
+}
+
+func writeUint64(buf *bytes.Buffer, n uint64) {
+	var b [8]byte
+	binary.LittleEndian.PutUint64(b[:], n)
+	buf.Write(b[:])
+}
+
+func doubleSHA256(data []byte) []byte {
+	first := sha256.Sum256(data)
+	second := sha256.Sum256(first[:])
+
+	return second[:]
+}
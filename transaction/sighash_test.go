@@ -0,0 +1,160 @@
+package transaction
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"os"
+	"testing"
+)
+
+// sigHashVectors mirrors testdata/sighash_vectors.json: one transaction and
+// the preimage and hash of every input signed with every hash type
+type sigHashVectors struct {
+	Inputs []struct {
+		Txid     string `json:"txid"`
+		Vout     int    `json:"vout"`
+		Sequence uint32 `json:"sequence"`
+	} `json:"inputs"`
+	Outputs  []vectorOutput `json:"outputs"`
+	LockTime int64          `json:"lockTime"`
+	Vectors  []struct {
+		Input    int          `json:"input"`
+		PrevOut  vectorOutput `json:"prevOut"`
+		HashType SigHashType  `json:"hashType"`
+		Name     string       `json:"name"`
+		Preimage string       `json:"preimage"`
+		SigHash  string       `json:"sigHash"`
+	} `json:"vectors"`
+}
+
+// vectorOutput is an output of the vectors with its script in hex
+type vectorOutput struct {
+	Value  int    `json:"value"`
+	Script string `json:"script"`
+}
+
+func (o vectorOutput) output(t *testing.T) TXOutput {
+	t.Helper()
+
+	return TXOutput{o.Value, mustDecodeHex(t, o.Script)}
+}
+
+func mustDecodeHex(t *testing.T, s string) []byte {
+	t.Helper()
+
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return b
+}
+
+// loadSigHashVectors reads the vectors and builds their transaction
+func loadSigHashVectors(t *testing.T) (*sigHashVectors, *Transaction) {
+	t.Helper()
+
+	data, err := os.ReadFile("testdata/sighash_vectors.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var vectors sigHashVectors
+	err = json.Unmarshal(data, &vectors)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tx := &Transaction{LockTime: vectors.LockTime}
+	for _, in := range vectors.Inputs {
+		tx.Vin = append(tx.Vin, TXInput{Txid: mustDecodeHex(t, in.Txid), Vout: in.Vout, Sequence: in.Sequence})
+	}
+	for _, out := range vectors.Outputs {
+		tx.Vout = append(tx.Vout, out.output(t))
+	}
+
+	return &vectors, tx
+}
+
+func TestSigHashVectors(t *testing.T) {
+	vectors, tx := loadSigHashVectors(t)
+	if len(vectors.Vectors) != 16 {
+		t.Fatalf("found %d vectors, want 16", len(vectors.Vectors))
+	}
+
+	// One cache serves every vector, as when all inputs of a transaction
+	// are signed in turn
+	cache := NewSigHashCache(tx)
+	seen := make(map[SigHashType]bool)
+
+	for _, v := range vectors.Vectors {
+		prevOut := v.PrevOut.output(t)
+		seen[v.HashType] = true
+
+		if v.HashType.String() != v.Name {
+			t.Errorf("input %d: hash type %02x is named %s, want %s", v.Input, byte(v.HashType), v.HashType, v.Name)
+		}
+
+		preimage, err := cache.SigHashPreimage(v.Input, prevOut, v.HashType)
+		if err != nil {
+			t.Errorf("input %d %s: %v", v.Input, v.Name, err)
+			continue
+		}
+		if got := hex.EncodeToString(preimage); got != v.Preimage {
+			t.Errorf("input %d %s: preimage\n got %s\nwant %s", v.Input, v.Name, got, v.Preimage)
+		}
+
+		cached, err := cache.SignatureHash(v.Input, prevOut, v.HashType)
+		if err != nil {
+			t.Fatal(err)
+		}
+		uncached, err := tx.SignatureHash(v.Input, prevOut, v.HashType)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := hex.EncodeToString(cached); got != v.SigHash {
+			t.Errorf("input %d %s: cached sighash %s, want %s", v.Input, v.Name, got, v.SigHash)
+		}
+		if !bytes.Equal(cached, uncached) {
+			t.Errorf("input %d %s: cached sighash %x differs from uncached %x", v.Input, v.Name, cached, uncached)
+		}
+	}
+
+	for _, base := range []SigHashType{SigHashAll, SigHashNone, SigHashSingle} {
+		if !seen[base] || !seen[base|SigHashAnyOneCanPay] {
+			t.Errorf("vectors don't cover %s with and without ANYONECANPAY", base)
+		}
+	}
+}
+
+func TestSigHashSingleWithoutOutput(t *testing.T) {
+	vectors, tx := loadSigHashVectors(t)
+	prevOut := vectors.Vectors[0].PrevOut.output(t)
+	input := len(tx.Vout)
+
+	for _, hashType := range []SigHashType{SigHashSingle, SigHashSingle | SigHashAnyOneCanPay} {
+		_, err := tx.SignatureHash(input, prevOut, hashType)
+		if !errors.Is(err, ErrSigHashNoOutput) {
+			t.Errorf("%s for input %d returned %v, want %v", hashType, input, err, ErrSigHashNoOutput)
+		}
+
+		// The script checker reports it instead of a mismatched signature
+		checker := &txChecker{tx, input, prevOut, NewSigHashCache(tx)}
+		valid, err := checker.CheckSig([]byte{0x30, byte(hashType)}, prevOut.ScriptPubKey)
+		if valid || !errors.Is(err, ErrSigHashNoOutput) {
+			t.Errorf("CheckSig with %s for input %d = %v, %v; want %v", hashType, input, valid, err, ErrSigHashNoOutput)
+		}
+	}
+}
+
+func TestSigHashRejectsBadType(t *testing.T) {
+	_, tx := loadSigHashVectors(t)
+
+	for _, hashType := range []SigHashType{0x00, 0x04, 0x41, 0x84} {
+		_, err := tx.SignatureHash(0, TXOutput{}, hashType)
+		if !errors.Is(err, ErrBadSigHashType) {
+			t.Errorf("hash type %02x returned %v, want %v", byte(hashType), err, ErrBadSigHashType)
+		}
+	}
+}
@@ -0,0 +1,324 @@
+package transaction
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sort"
+
+	"blockchain-app/script"
+)
+
+// LockTimeThreshold separates lock times: below it they are block heights,
+// from it on unix timestamps
+const LockTimeThreshold = 500000000
+
+// Input sequence flags for relative timelocks
+const (
+	// SequenceFinal disables both the relative lock of the input and, when
+	// every input is final, the lock time of the transaction
+	SequenceFinal = uint32(0xffffffff)
+
+	// SequenceLockTimeDisabled turns off the relative lock of the input
+	SequenceLockTimeDisabled = uint32(1 << 31)
+
+	// SequenceLockTimeIsSeconds makes the relative lock count time instead of blocks
+	SequenceLockTimeIsSeconds = uint32(1 << 22)
+
+	// SequenceLockTimeMask extracts the lock value from a sequence
+	SequenceLockTimeMask = uint32(0x0000ffff)
+
+	// SequenceLockTimeGranularity is the shift turning a time lock value
+	// into seconds, time locks count units of 512 seconds
+	SequenceLockTimeGranularity = 9
+)
+
+// medianTimeBlocks is the number of blocks whose timestamps make up the
+// median time past
+const medianTimeBlocks = 11
+
+// Timelock errors
+var (
+	ErrNonFinal       = errors.New("transaction lock time has not passed")
+	ErrSequenceLocked = errors.New("input relative lock has not passed")
+)
+
+// RelativeHeightLock returns the input sequence requiring the spent output
+// to be blocks deep
+func RelativeHeightLock(blocks int) uint32 {
+	return uint32(blocks) & SequenceLockTimeMask
+}
+
+// RelativeTimeLock returns the input sequence requiring seconds, rounded up
+// to units of 512, to pass since the spent output was mined
+func RelativeTimeLock(seconds int64) uint32 {
+	units := (seconds + 1<<SequenceLockTimeGranularity - 1) >> SequenceLockTimeGranularity
+	return SequenceLockTimeIsSeconds | uint32(units)&SequenceLockTimeMask
+}
+
+// NewTimeLockedTXOutput creates an output for the address that can't be
+// spent before lockTime, a block height or a unix time
+func NewTimeLockedTXOutput(value int, address string, lockTime int64) (*TXOutput, error) {
+	txo, err := NewTXOutput(value, address)
+	if err != nil {
+		return nil, err
+	}
+
+	lockingScript, err := script.LockTimeScript(lockTime, txo.ScriptPubKey)
+	if err != nil {
+		return nil, err
+	}
+	txo.ScriptPubKey = lockingScript
+
+	return txo, nil
+}
+
+// NewSequenceLockedTXOutput creates an output for the address that can only
+// be spent once the relative lock in sequence has passed since it was mined
+func NewSequenceLockedTXOutput(value int, address string, sequence uint32) (*TXOutput, error) {
+	txo, err := NewTXOutput(value, address)
+	if err != nil {
+		return nil, err
+	}
+
+	lockingScript, err := script.SequenceLockScript(int64(sequence), txo.ScriptPubKey)
+	if err != nil {
+		return nil, err
+	}
+	txo.ScriptPubKey = lockingScript
+
+	return txo, nil
+}
+
+// IsFinal reports whether the transaction may be included in a block at
+// height whose parent has the given median time past
+func (tx *Transaction) IsFinal(height int, medianTime int64) bool {
+	if tx.LockTime == 0 {
+		return true
+	}
+
+	limit := int64(height)
+	if tx.LockTime >= LockTimeThreshold {
+		limit = medianTime
+	}
+	if tx.LockTime < limit {
+		return true
+	}
+
+	// Final inputs opt out of the lock time
+	for _, vin := range tx.Vin {
+		if vin.Sequence != SequenceFinal {
+			return false
+		}
+	}
+
+	return true
+}
+
+// CheckLockTime implements OP_CHECKLOCKTIMEVERIFY: the transaction lock time
+// must be of the same kind as lockTime and at least as late, and the input
+// must not opt out of it
+func (c *txChecker) CheckLockTime(lockTime int64) bool {
+	txLockTime := c.tx.LockTime
+
+	if (txLockTime < LockTimeThreshold) != (lockTime < LockTimeThreshold) {
+		return false
+	}
+	if lockTime > txLockTime {
+		return false
+	}
+
+	return c.tx.Vin[c.inID].Sequence != SequenceFinal
+}
+
+// CheckSequence implements OP_CHECKSEQUENCEVERIFY: the input relative lock
+// must be of the same kind as sequence and at least as long
+func (c *txChecker) CheckSequence(sequence int64) bool {
+	txSequence := c.tx.Vin[c.inID].Sequence
+	if txSequence&SequenceLockTimeDisabled != 0 {
+		return false
+	}
+
+	required := uint32(sequence)
+	if required&SequenceLockTimeIsSeconds != txSequence&SequenceLockTimeIsSeconds {
+		return false
+	}
+
+	return required&SequenceLockTimeMask <= txSequence&SequenceLockTimeMask
+}
+
+// CalcPastMedianTime returns the median timestamp of the block and up to
+// ten of its ancestors. Lock times are compared against it rather than
+// against a single timestamp, which the miner chooses freely.
+func (bc *Blockchain) CalcPastMedianTime(block *Block) (int64, error) {
+	timestamps := []int64{block.Timestamp}
+
+	current := *block
+	for len(timestamps) < medianTimeBlocks && len(current.PrevBlockHash) > 0 {
+		prev, err := bc.GetBlock(current.PrevBlockHash)
+		if err != nil {
+			return 0, err
+		}
+
+		timestamps = append(timestamps, prev.Timestamp)
+		current = prev
+	}
+
+	sort.Slice(timestamps, func(i, j int) bool { return timestamps[i] < timestamps[j] })
+
+	return timestamps[len(timestamps)/2], nil
+}
+
+// tipMedianTime returns the median time past of the current tip, which the
+// next block is checked against
+func (bc *Blockchain) tipMedianTime() (int64, error) {
+	tip, err := bc.GetBlock(bc.currentTip())
+	if err != nil {
+		return 0, err
+	}
+
+	return bc.CalcPastMedianTime(&tip)
+}
+
+// CheckTimeLocks reports an error when a transaction could not be included
+// in the next block because of its lock time or the relative locks of its
+// inputs
+func (bc *Blockchain) CheckTimeLocks(tx *Transaction) error {
+	medianTime, err := bc.tipMedianTime()
+	if err != nil {
+		return err
+	}
+
+	return bc.checkTimeLocks(UTXOSet{bc}, nil, tx, bc.GetBestHeight()+1, medianTime)
+}
+
+// checkTimeLocks checks that tx may be included in a block at height whose
+// parent has medianTime. Outputs created by pending transactions count as
+// mined at height.
+func (bc *Blockchain) checkTimeLocks(utxoSet UTXOSet, pending map[string]*Transaction, tx *Transaction, height int, medianTime int64) error {
+	if !tx.IsFinal(height, medianTime) {
+		return fmt.Errorf("%w: %x locked until %d", ErrNonFinal, tx.ID, tx.LockTime)
+	}
+	if tx.IsCoinbase() {
+		return nil
+	}
+
+	for _, vin := range tx.Vin {
+		if vin.Sequence&SequenceLockTimeDisabled != 0 {
+			continue
+		}
+
+		outputHeight := height
+		if _, ok := pending[hex.EncodeToString(vin.Txid)]; !ok {
+			outs, found, err := utxoSet.FindOutputs(vin.Txid)
+			if err != nil {
+				return err
+			}
+			if found {
+				outputHeight = outs.Height
+			}
+		}
+
+		passed, err := bc.sequenceLockPassed(vin.Sequence, outputHeight, height, medianTime)
+		if err != nil {
+			return err
+		}
+		if !passed {
+			return fmt.Errorf("%w: %x:%d", ErrSequenceLocked, vin.Txid, vin.Vout)
+		}
+	}
+
+	return nil
+}
+
+// sequenceLockPassed reports whether the relative lock in sequence on an
+// output mined at outputHeight has passed for a block at height whose parent
+// has medianTime. Time locks start at the median time past of the block
+// before the output.
+func (bc *Blockchain) sequenceLockPassed(sequence uint32, outputHeight, height int, medianTime int64) (bool, error) {
+	value := sequence & SequenceLockTimeMask
+
+	if sequence&SequenceLockTimeIsSeconds == 0 {
+		return height >= outputHeight+int(value), nil
+	}
+
+	if outputHeight >= height {
+		return value == 0, nil
+	}
+
+	startHeight := outputHeight - 1
+	if startHeight < 0 {
+		startHeight = 0
+	}
+	start, err := bc.GetBlockByHeight(startHeight)
+	if err != nil {
+		return false, err
+	}
+	startTime, err := bc.CalcPastMedianTime(&start)
+	if err != nil {
+		return false, err
+	}
+
+	return medianTime >= startTime+int64(value)<<SequenceLockTimeGranularity, nil
+}
+
+// timeLockPassed reports whether a timelocked output mined at outputHeight
+// can be spent in a block at height whose parent has medianTime. Outputs
+// without a timelock always can.
+func (bc *Blockchain) timeLockPassed(out TXOutput, outputHeight, height int, medianTime int64) bool {
+	op, lock, _ := script.ExtractTimeLock(out.ScriptPubKey)
+
+	switch op {
+	case script.OP_CHECKLOCKTIMEVERIFY:
+		spendable := &Transaction{LockTime: lock, Vin: []TXInput{{Sequence: SequenceFinal - 1}}}
+		return spendable.IsFinal(height, medianTime)
+	case script.OP_CHECKSEQUENCEVERIFY:
+		if uint32(lock)&SequenceLockTimeDisabled != 0 {
+			return true
+		}
+		passed, err := bc.sequenceLockPassed(uint32(lock), outputHeight, height, medianTime)
+		return err == nil && passed
+	}
+
+	return true
+}
+
+// unlockInputs sets the lock time and input sequences a transaction needs
+// to spend timelocked outputs, and makes inputs non-final when the
+// transaction has a lock time of its own so that it is enforced
+func (u UTXOSet) unlockInputs(tx *Transaction) error {
+	for inID, vin := range tx.Vin {
+		out, found, err := u.FindOutput(vin.Txid, vin.Vout)
+		if err != nil {
+			return err
+		}
+		if !found {
+			return fmt.Errorf("output %x:%d is not in the UTXO set", vin.Txid, vin.Vout)
+		}
+
+		op, lock, _ := script.ExtractTimeLock(out.ScriptPubKey)
+		switch op {
+		case script.OP_CHECKLOCKTIMEVERIFY:
+			if tx.LockTime != 0 && (tx.LockTime < LockTimeThreshold) != (lock < LockTimeThreshold) {
+				return fmt.Errorf("%x:%d mixes height and time lock times", vin.Txid, vin.Vout)
+			}
+			if lock > tx.LockTime {
+				tx.LockTime = lock
+			}
+		case script.OP_CHECKSEQUENCEVERIFY:
+			tx.Vin[inID].Sequence = uint32(lock)
+		}
+	}
+
+	if tx.LockTime == 0 {
+		return nil
+	}
+
+	for inID := range tx.Vin {
+		if tx.Vin[inID].Sequence == SequenceFinal {
+			tx.Vin[inID].Sequence = SequenceFinal - 1
+		}
+	}
+
+	return nil
+}
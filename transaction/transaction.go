@@ -2,41 +2,51 @@ package transaction
 
 import (
 	"bytes"
-	"crypto/ecdsa"
-	"crypto/elliptic"
 	"crypto/rand"
 	"crypto/sha256"
 	"encoding/gob"
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"log"
-	"math/big"
 	"strings"
 
+	"blockchain-app/script"
 	"blockchain-app/wallet"
 )
 
-const subsidy = 10
+// MaxMoney is more than the subsidy schedule of any network can mint. Amounts
+// and sums of amounts above it are invalid, which keeps them from overflowing.
+const MaxMoney = 21000000
 
-// Transaction represents a blockchain transaction
+// MoneyRange reports whether value is a valid amount
+func MoneyRange(value int) bool {
+	return value >= 0 && value <= MaxMoney
+}
+
+// Transaction represents a blockchain transaction. LockTime is the height,
+// or the unix time from LockTimeThreshold on, before which it can't be mined.
 type Transaction struct {
-	ID   []byte
-	Vin  []TXInput
-	Vout []TXOutput
+	ID       []byte
+	Vin      []TXInput
+	Vout     []TXOutput
+	LockTime int64
 }
 
-// TXInput represents a transaction input
+// TXInput represents a transaction input. ScriptSig holds the unlocking
+// script, or arbitrary data for a coinbase. Sequence carries the relative
+// timelock of the input, SequenceFinal disables it.
 type TXInput struct {
 	Txid      []byte
 	Vout      int
-	Signature []byte
-	PubKey    []byte
+	ScriptSig []byte
+	Sequence  uint32
 }
 
-// TXOutput represents a transaction output
+// TXOutput represents a transaction output locked by ScriptPubKey
 type TXOutput struct {
-	Value      int
-	PubKeyHash []byte
+	Value        int
+	ScriptPubKey []byte
 }
 
 // IsCoinbase checks whether the transaction is coinbase
@@ -57,6 +67,40 @@ func (tx Transaction) Serialize() []byte {
 	return encoded.Bytes()
 }
 
+// DeserializeTransaction decodes a transaction and checks that its ID
+// matches its contents
+func DeserializeTransaction(data []byte) (*Transaction, error) {
+	var tx Transaction
+
+	dec := gob.NewDecoder(bytes.NewReader(data))
+	err := dec.Decode(&tx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode transaction: %v", err)
+	}
+	if !bytes.Equal(tx.ID, tx.UnsignedHash()) {
+		return nil, fmt.Errorf("transaction ID %x does not match its contents", tx.ID)
+	}
+
+	return &tx, nil
+}
+
+// OutputValue returns the total value of the transaction outputs. Negative
+// outputs and totals above MaxMoney are refused.
+func (tx Transaction) OutputValue() (int, error) {
+	total := 0
+	for i, out := range tx.Vout {
+		if !MoneyRange(out.Value) {
+			return 0, fmt.Errorf("%w: %x output %d is worth %d", ErrBadTransaction, tx.ID, i, out.Value)
+		}
+		total += out.Value
+		if !MoneyRange(total) {
+			return 0, fmt.Errorf("%w: %x outputs are worth more than %d", ErrBadTransaction, tx.ID, MaxMoney)
+		}
+	}
+
+	return total, nil
+}
+
 // Hash returns the hash of the Transaction
 func (tx *Transaction) Hash() []byte {
 	var hash [32]byte
@@ -69,36 +113,81 @@ func (tx *Transaction) Hash() []byte {
 	return hash[:]
 }
 
-// Sign signs each input of a Transaction
-func (tx *Transaction) Sign(privKey ecdsa.PrivateKey, prevTXs map[string]Transaction) {
+// UnsignedHash returns the hash the transaction ID is computed from. The ID
+// is assigned before the inputs are signed, so unlocking scripts are not
+// covered. Coinbase data is kept, it is what makes coinbase IDs unique.
+func (tx *Transaction) UnsignedHash() []byte {
+	if tx.IsCoinbase() {
+		return tx.Hash()
+	}
+
+	txCopy := *tx
+	txCopy.Vin = make([]TXInput, len(tx.Vin))
+
+	for i, vin := range tx.Vin {
+		txCopy.Vin[i] = TXInput{vin.Txid, vin.Vout, nil, vin.Sequence}
+	}
+
+	return txCopy.Hash()
+}
+
+// Sign signs each input of a Transaction spending a P2PKH output with
+// SIGHASH_ALL
+func (tx *Transaction) Sign(signer wallet.Signer, prevTXs map[string]Transaction) error {
+	return tx.SignWithHashType(signer, prevTXs, SigHashAll)
+}
+
+// SignWithHashType signs each input of a Transaction spending a P2PKH output,
+// committing to the parts of the transaction selected by hashType
+func (tx *Transaction) SignWithHashType(signer wallet.Signer, prevTXs map[string]Transaction, hashType SigHashType) error {
 	if tx.IsCoinbase() {
-		return
+		return nil
 	}
 
 	for _, vin := range tx.Vin {
-		if prevTXs[hex.EncodeToString(vin.Txid)].ID == nil {
-			log.Panic("ERROR: Previous transaction is not correct")
+		prevTx, ok := prevTXs[hex.EncodeToString(vin.Txid)]
+		if !ok || prevTx.ID == nil || vin.Vout < 0 || vin.Vout >= len(prevTx.Vout) {
+			return fmt.Errorf("previous output %x:%d is unknown", vin.Txid, vin.Vout)
 		}
 	}
 
-	txCopy := tx.TrimmedCopy()
+	pubKey := signer.PublicKey()
+	cache := NewSigHashCache(tx)
 
-	for inID, vin := range txCopy.Vin {
+	for inID, vin := range tx.Vin {
 		prevTx := prevTXs[hex.EncodeToString(vin.Txid)]
-		txCopy.Vin[inID].Signature = nil
-		txCopy.Vin[inID].PubKey = prevTx.Vout[vin.Vout].PubKeyHash
+		prevOut := prevTx.Vout[vin.Vout]
 
-		dataToSign := fmt.Sprintf("%x\n", txCopy)
+		signature, err := cache.signInput(signer, inID, prevOut, hashType)
+		if err != nil {
+			return err
+		}
 
-		r, s, err := ecdsa.Sign(rand.Reader, &privKey, []byte(dataToSign))
+		sigScript, err := script.PayToPubKeyHashSigScript(signature, pubKey)
 		if err != nil {
-			log.Panic(err)
+			return err
 		}
-		signature := append(r.Bytes(), s.Bytes()...)
+		tx.Vin[inID].ScriptSig = sigScript
+	}
+
+	return nil
+}
 
-		tx.Vin[inID].Signature = signature
-		txCopy.Vin[inID].PubKey = nil
+// signInput returns the signature of input inID followed by the hash type
+// byte. Unlocking scripts are not part of the signature hash, so the
+// cache stays valid while inputs are signed one after another.
+func (c *SigHashCache) signInput(signer wallet.Signer, inID int, prevOut TXOutput, hashType SigHashType) ([]byte, error) {
+	hash, err := c.SignatureHash(inID, prevOut, hashType)
+	if err != nil {
+		return nil, err
+	}
+
+	signature, err := signer.Sign(hash)
+	if err != nil {
+		return nil, err
 	}
+
+	return append(signature, byte(hashType)), nil
 }
 
 // String returns a human-readable representation of a transaction
@@ -111,167 +200,337 @@ func (tx Transaction) String() string {
 		lines = append(lines, fmt.Sprintf("     Input %d:", i))
 		lines = append(lines, fmt.Sprintf("       TXID:      %x", input.Txid))
 		lines = append(lines, fmt.Sprintf("       Out:       %d", input.Vout))
-		lines = append(lines, fmt.Sprintf("       Signature: %x", input.Signature))
-		lines = append(lines, fmt.Sprintf("       PubKey:    %x", input.PubKey))
+		lines = append(lines, fmt.Sprintf("       ScriptSig: %s", script.Disassemble(input.ScriptSig)))
+		lines = append(lines, fmt.Sprintf("       Sequence:  %08x", input.Sequence))
 	}
 
 	for i, output := range tx.Vout {
 		lines = append(lines, fmt.Sprintf("     Output %d:", i))
 		lines = append(lines, fmt.Sprintf("       Value:  %d", output.Value))
-		lines = append(lines, fmt.Sprintf("       Script: %x", output.PubKeyHash))
+		lines = append(lines, fmt.Sprintf("       Script: %s", script.Disassemble(output.ScriptPubKey)))
+	}
+
+	if tx.LockTime != 0 {
+		lines = append(lines, fmt.Sprintf("     LockTime: %d", tx.LockTime))
 	}
 
 	return strings.Join(lines, "\n")
 }
 
-// TrimmedCopy creates a trimmed copy of Transaction to be used in signing
-func (tx *Transaction) TrimmedCopy() Transaction {
-	var inputs []TXInput
-	var outputs []TXOutput
+// Verify runs the unlocking script of every input against the locking
+// script of the output it spends
+func (tx *Transaction) Verify(prevTXs map[string]Transaction) bool {
+	return tx.VerifyScripts(prevTXs) == nil
+}
 
-	for _, vin := range tx.Vin {
-		inputs = append(inputs, TXInput{vin.Txid, vin.Vout, nil, nil})
+// VerifyScripts is like Verify but reports why an input failed
+func (tx *Transaction) VerifyScripts(prevTXs map[string]Transaction) error {
+	if tx.IsCoinbase() {
+		return nil
 	}
 
-	for _, vout := range tx.Vout {
-		outputs = append(outputs, TXOutput{vout.Value, vout.PubKeyHash})
+	prevOuts := make([]TXOutput, len(tx.Vin))
+	for inID, vin := range tx.Vin {
+		prevOut, err := prevOutput(prevTXs, vin)
+		if err != nil {
+			return fmt.Errorf("input %d: %w", inID, err)
+		}
+		prevOuts[inID] = prevOut
 	}
 
-	txCopy := Transaction{tx.ID, inputs, outputs}
+	return tx.verifyScripts(prevOuts)
+}
 
-	return txCopy
+// verifyScripts runs the unlocking script of every input against the
+// locking script of prevOuts[inID]
+func (tx *Transaction) verifyScripts(prevOuts []TXOutput) error {
+	cache := NewSigHashCache(tx)
+
+	for inID, vin := range tx.Vin {
+		checker := &txChecker{tx, inID, prevOuts[inID], cache}
+
+		err := script.Verify(vin.ScriptSig, prevOuts[inID].ScriptPubKey, checker)
+		if err != nil {
+			return fmt.Errorf("input %d: %w", inID, err)
+		}
+	}
+
+	return nil
 }
 
-// Verify verifies signatures of Transaction inputs
-func (tx *Transaction) Verify(prevTXs map[string]Transaction) bool {
-	if tx.IsCoinbase() {
-		return true
+// txChecker verifies signatures over the signature hash of one input
+// and the timelocks its scripts require
+type txChecker struct {
+	tx      *Transaction
+	inID    int
+	prevOut TXOutput
+	cache   *SigHashCache
+}
+
+// CheckSig verifies a signature followed by its hash type byte against a
+// public key. Badly encoded or unhashable signatures are reported as errors
+func (c *txChecker) CheckSig(sig, pubKey []byte) (bool, error) {
+	if len(sig) < 2 {
+		return false, fmt.Errorf("%w: length %d", wallet.ErrNonCanonicalSignature, len(sig))
 	}
 
-	for _, vin := range tx.Vin {
-		if prevTXs[hex.EncodeToString(vin.Txid)].ID == nil {
-			log.Panic("ERROR: Previous transaction is not correct")
-		}
+	hashType := SigHashType(sig[len(sig)-1])
+	if !hashType.IsValid() {
+		return false, fmt.Errorf("%w: %02x", ErrBadSigHashType, byte(hashType))
 	}
 
-	txCopy := tx.TrimmedCopy()
-	curve := elliptic.P256()
+	hash, err := c.cache.SignatureHash(c.inID, c.prevOut, hashType)
+	if err != nil {
+		return false, err
+	}
 
-	for inID, vin := range tx.Vin {
-		prevTx := prevTXs[hex.EncodeToString(vin.Txid)]
-		txCopy.Vin[inID].Signature = nil
-		txCopy.Vin[inID].PubKey = prevTx.Vout[vin.Vout].PubKeyHash
-
-		r := big.Int{}
-		s := big.Int{}
-		sigLen := len(vin.Signature)
-		r.SetBytes(vin.Signature[:(sigLen / 2)])
-		s.SetBytes(vin.Signature[(sigLen / 2):])
-
-		x := big.Int{}
-		y := big.Int{}
-		keyLen := len(vin.PubKey)
-		x.SetBytes(vin.PubKey[:(keyLen / 2)])
-		y.SetBytes(vin.PubKey[(keyLen / 2):])
-
-		dataToVerify := fmt.Sprintf("%x\n", txCopy)
-
-		rawPubKey := ecdsa.PublicKey{Curve: curve, X: &x, Y: &y}
-		if ecdsa.Verify(&rawPubKey, []byte(dataToVerify), &r, &s) == false {
-			return false
-		}
-		txCopy.Vin[inID].PubKey = nil
+	return wallet.Verify(pubKey, hash, sig[:len(sig)-1])
+}
+
+// CalcBlockSubsidy returns the subsidy of the block at the given height,
+// halved every SubsidyHalvingInterval blocks
+func CalcBlockSubsidy(height int) int {
+	halvings := height / params.SubsidyHalvingInterval
+	if halvings >= 63 {
+		return 0
 	}
 
-	return true
+	return params.InitialSubsidy >> uint(halvings)
 }
 
-// NewCoinbaseTX creates a new coinbase transaction
-func NewCoinbaseTX(to, data string) *Transaction {
+// NewCoinbaseTX creates a new coinbase transaction for the block at the
+// given height, paying its subsidy plus the fees of the other transactions.
+// Its script commits to the height, so coinbases of different blocks never
+// share an ID.
+func NewCoinbaseTX(to, data string, height, fees int) (*Transaction, error) {
 	if data == "" {
 		randData := make([]byte, 20)
 		_, err := rand.Read(randData)
 		if err != nil {
-			log.Panic(err)
+			return nil, err
 		}
 
 		data = fmt.Sprintf("%x", randData)
 	}
 
-	txin := TXInput{[]byte{}, -1, nil, []byte(data)}
-	txout := NewTXOutput(subsidy, to)
-	tx := Transaction{nil, []TXInput{txin}, []TXOutput{*txout}}
+	scriptSig, err := script.NewBuilder().AddNumber(int64(height)).AddData([]byte(data)).Script()
+	if err != nil {
+		return nil, err
+	}
+
+	txin := TXInput{[]byte{}, -1, scriptSig, SequenceFinal}
+	txout, err := NewTXOutput(CalcBlockSubsidy(height)+fees, to)
+	if err != nil {
+		return nil, err
+	}
+	tx := Transaction{nil, []TXInput{txin}, []TXOutput{*txout}, 0}
 	tx.ID = tx.Hash()
 
-	return &tx
+	return &tx, nil
 }
 
-// NewUTXOTransaction creates a new transaction
-func NewUTXOTransaction(wallet *wallet.Wallet, to string, amount int, UTXOSet *UTXOSet) *Transaction {
-	var inputs []TXInput
-	var outputs []TXOutput
+// NewUTXOTransaction creates a new transaction paying a fixed fee. The fee
+// is whatever the inputs are worth beyond the outputs, so it is simply left
+// out of the change.
+func NewUTXOTransaction(wallet *wallet.Wallet, to string, amount, fee int, UTXOSet *UTXOSet) (*Transaction, error) {
+	return NewUTXOTransactionWithLockTime(wallet, to, amount, fee, 0, UTXOSet)
+}
+
+// NewUTXOTransactionWithLockTime creates a new transaction that can't be
+// mined before lockTime, a block height or a unix time from
+// LockTimeThreshold on. Zero creates a transaction valid right away.
+func NewUTXOTransactionWithLockTime(wallet *wallet.Wallet, to string, amount, fee int, lockTime int64, UTXOSet *UTXOSet) (*Transaction, error) {
+	payment, err := NewTXOutput(amount, to)
+	if err != nil {
+		return nil, err
+	}
+
+	return newUTXOTransaction(wallet, payment, fee, 0, lockTime, DefaultCoinSelector, UTXOSet)
+}
+
+// NewVestingTransaction creates a new transaction paying amount to an output
+// that the recipient can't spend before unlockAt, a block height or a unix
+// time from LockTimeThreshold on
+func NewVestingTransaction(wallet *wallet.Wallet, to string, amount, fee int, unlockAt int64, UTXOSet *UTXOSet) (*Transaction, error) {
+	payment, err := NewTimeLockedTXOutput(amount, to, unlockAt)
+	if err != nil {
+		return nil, err
+	}
+
+	return newUTXOTransaction(wallet, payment, fee, 0, 0, DefaultCoinSelector, UTXOSet)
+}
+
+// NewUTXOTransactionWithFeeRate creates a new transaction whose fee is
+// feeRate per 1000 bytes of the signed transaction
+func NewUTXOTransactionWithFeeRate(wallet *wallet.Wallet, to string, amount, feeRate int, UTXOSet *UTXOSet) (*Transaction, error) {
+	return NewUTXOTransactionWithSelector(wallet, to, amount, feeRate, DefaultCoinSelector, UTXOSet)
+}
+
+// NewUTXOTransactionWithSelector is like NewUTXOTransactionWithFeeRate with
+// the inputs chosen by selector
+func NewUTXOTransactionWithSelector(wallet *wallet.Wallet, to string, amount, feeRate int, selector CoinSelector, UTXOSet *UTXOSet) (*Transaction, error) {
+	payment, err := NewTXOutput(amount, to)
+	if err != nil {
+		return nil, err
+	}
+
+	return newUTXOTransaction(wallet, payment, 0, feeRate, 0, selector, UTXOSet)
+}
+
+// newUTXOTransaction creates a signed transaction from the wallet funding
+// payment, a fixed fee and feeRate per 1000 bytes. Watch-only and locked
+// wallets can't sign and are refused.
+func newUTXOTransaction(wallet *wallet.Wallet, payment *TXOutput, fee, feeRate int, lockTime int64, selector CoinSelector, UTXOSet *UTXOSet) (*Transaction, error) {
+	if err := wallet.CanSign(); err != nil {
+		return nil, err
+	}
+	from := fmt.Sprintf("%s", wallet.GetAddress())
+
+	tx, err := newUnsignedTransaction(from, payment, fee, feeRate, lockTime, selector, UTXOSet)
+	if err != nil {
+		return nil, err
+	}
+	err = UTXOSet.Blockchain.SignTransaction(tx, wallet.Signer())
+	if err != nil {
+		return nil, err
+	}
+
+	return tx, nil
+}
+
+// NewUnsignedTransaction creates a transaction from the P2PKH address from
+// without signing it, so only the address and no private key is needed.
+// The fee is feeRate per 1000 bytes and inputs are chosen by selector.
+func NewUnsignedTransaction(from, to string, amount, feeRate int, selector CoinSelector, UTXOSet *UTXOSet) (*Transaction, error) {
+	payment, err := NewTXOutput(amount, to)
+	if err != nil {
+		return nil, err
+	}
+
+	return newUnsignedTransaction(from, payment, 0, feeRate, 0, selector, UTXOSet)
+}
+
+// newUnsignedTransaction creates a transaction spending outputs of the
+// address from to fund payment, a fixed fee and feeRate per 1000 bytes,
+// sending the change back to from. Change worth less than the fee of
+// spending it is left to the miner instead.
+func newUnsignedTransaction(from string, payment *TXOutput, fee, feeRate int, lockTime int64, selector CoinSelector, UTXOSet *UTXOSet) (*Transaction, error) {
+	amount := payment.Value
+	if amount <= 0 || fee < 0 || feeRate < 0 || lockTime < 0 {
+		return nil, errors.New("invalid amount, fee, fee rate or lock time")
+	}
 
-	pubKeyHash := wallet.HashPubKey(wallet.PublicKey)
+	addrType, pubKeyHash, err := wallet.DecodeAddress(from)
+	if err != nil {
+		return nil, err
+	}
+	if addrType != wallet.PubKeyHashAddress {
+		return nil, fmt.Errorf("%w: %s is not a key address", wallet.ErrInvalidAddress, from)
+	}
+
+	coins, err := UTXOSet.FindSpendableCoins(pubKeyHash)
+	if err != nil {
+		return nil, err
+	}
 
-	acc, validOutputs := UTXOSet.FindSpendableOutputs(pubKeyHash, amount)
+	return fundTransaction(coins, from, payment, fee, feeRate, lockTime, selector, UTXOSet)
+}
 
-	if acc < amount {
-		log.Panic("ERROR: Not enough funds")
+// fundTransaction creates a transaction paying payment, a fixed fee and
+// feeRate per 1000 bytes from coins chosen by selector, sending the change
+// to the address changeTo
+func fundTransaction(coins []Coin, changeTo string, payment *TXOutput, fee, feeRate int, lockTime int64, selector CoinSelector, UTXOSet *UTXOSet) (*Transaction, error) {
+	amount := payment.Value
+	selected, err := selector.Select(coins, amount+fee, feeRate)
+	if err != nil {
+		return nil, err
 	}
 
 	// Build a list of inputs
-	for txid, outs := range validOutputs {
-		txID, err := hex.DecodeString(txid)
-		if err != nil {
-			log.Panic(err)
-		}
+	var inputs []TXInput
+	acc := 0
+	for _, coin := range selected {
+		inputs = append(inputs, TXInput{coin.Txid, coin.Vout, nil, SequenceFinal})
+		acc += coin.Output.Value
+	}
 
-		for _, out := range outs {
-			input := TXInput{txID, out, nil, wallet.PublicKey}
-			inputs = append(inputs, input)
-		}
+	if acc < amount+fee+EstimateFee(estimateTxSize(len(inputs), 1), feeRate) {
+		return nil, fmt.Errorf("%w: selected %d for %d", ErrInsufficientFunds, acc, amount+fee)
 	}
 
 	// Build a list of outputs
-	from := fmt.Sprintf("%s", wallet.GetAddress())
-	outputs = append(outputs, *NewTXOutput(amount, to))
-	if acc > amount {
-		outputs = append(outputs, *NewTXOutput(acc-amount, from)) // a change
+	outputs := []TXOutput{*payment}
+	change := acc - amount - fee - EstimateFee(estimateTxSize(len(inputs), 2), feeRate)
+	if !isDust(change, feeRate) {
+		changeOutput, err := NewTXOutput(change, changeTo)
+		if err != nil {
+			return nil, err
+		}
+		outputs = append(outputs, *changeOutput) // a change
 	}
 
-	tx := Transaction{nil, inputs, outputs}
+	tx := Transaction{nil, inputs, outputs, lockTime}
+	err = UTXOSet.unlockInputs(&tx)
+	if err != nil {
+		return nil, err
+	}
 	tx.ID = tx.Hash()
-	UTXOSet.Blockchain.SignTransaction(&tx, wallet.PrivateKey)
 
-	return &tx
+	return &tx, nil
 }
 
 // NewTXOutput create a new TXOutput
-func NewTXOutput(value int, address string) *TXOutput {
+func NewTXOutput(value int, address string) (*TXOutput, error) {
 	txo := &TXOutput{value, nil}
-	txo.Lock([]byte(address))
+	err := txo.Lock([]byte(address))
+	if err != nil {
+		return nil, err
+	}
 
-	return txo
+	return txo, nil
 }
 
-// CanUnlockOutputWith checks whether the address initiated the transaction
-func (in *TXInput) CanUnlockOutputWith(unlockingData []byte) bool {
-	return bytes.Compare(in.PubKey, unlockingData) == 0
-}
+// NewNullDataOutput creates an unspendable output carrying data
+func NewNullDataOutput(data []byte) (*TXOutput, error) {
+	nullData, err := script.NullDataScript(data)
+	if err != nil {
+		return nil, err
+	}
 
-// CanBeUnlockedWith checks if the output can be unlocked with the provided data
-func (out *TXOutput) CanBeUnlockedWith(unlockingData []byte) bool {
-	return bytes.Compare(out.PubKeyHash, unlockingData) == 0
+	return &TXOutput{0, nullData}, nil
 }
 
-// Lock signs the output
-func (out *TXOutput) Lock(address []byte) {
-	pubKeyHash := wallet.Base58Decode(address)
-	pubKeyHash = pubKeyHash[1 : len(pubKeyHash)-4]
-	out.PubKeyHash = pubKeyHash
+// Lock locks the output to the address: a P2SH script for script hash
+// addresses and a P2PKH script otherwise
+func (out *TXOutput) Lock(address []byte) error {
+	addrType, hash, err := wallet.DecodeAddress(string(address))
+	if err != nil {
+		return err
+	}
+
+	var lockingScript []byte
+	if addrType == wallet.ScriptHashAddress {
+		lockingScript, err = script.PayToScriptHashScript(hash)
+	} else {
+		lockingScript, err = script.PayToPubKeyHashScript(hash)
+	}
+	if err != nil {
+		return err
+	}
+	out.ScriptPubKey = lockingScript
+
+	return nil
 }
 
-// IsLockedWithKey checks if the output is locked with the key hash
+// IsLockedWithKey checks if the output is a P2PKH output paying the key
+// hash, possibly behind a timelock
 func (out *TXOutput) IsLockedWithKey(pubKeyHash []byte) bool {
-	return bytes.Compare(out.PubKeyHash, pubKeyHash) == 0
+	_, _, lockingScript := script.ExtractTimeLock(out.ScriptPubKey)
+	return bytes.Equal(script.ExtractPubKeyHash(lockingScript), pubKeyHash)
+}
+
+// IsLockedWithScriptHash checks if the output is a P2SH output paying the
+// redeem script hash
+func (out *TXOutput) IsLockedWithScriptHash(scriptHash []byte) bool {
+	return bytes.Equal(script.ExtractScriptHash(out.ScriptPubKey), scriptHash)
 }
@@ -0,0 +1,53 @@
+package transaction
+
+import (
+	"errors"
+	"testing"
+
+	"blockchain-app/wallet"
+)
+
+func TestConstructorsRejectBadAddresses(t *testing.T) {
+	bc, address := newMinedBlockchain(t)
+	utxoSet := &UTXOSet{bc}
+
+	w := wallet.NewWallet()
+
+	tests := []struct {
+		name string
+		call func() error
+	}{
+		{"NewTXOutput", func() error {
+			_, err := NewTXOutput(1, "not an address")
+			return err
+		}},
+		{"NewTimeLockedTXOutput", func() error {
+			_, err := NewTimeLockedTXOutput(1, "not an address", 10)
+			return err
+		}},
+		{"NewCoinbaseTX", func() error {
+			_, err := NewCoinbaseTX("not an address", "", 1, 0)
+			return err
+		}},
+		{"NewUTXOTransaction", func() error {
+			_, err := NewUTXOTransaction(w, "not an address", 1, 0, utxoSet)
+			return err
+		}},
+		{"NewUTXOTransactionWithSelector", func() error {
+			_, err := NewUTXOTransactionWithSelector(w, "not an address", 1, 1000, DefaultCoinSelector, utxoSet)
+			return err
+		}},
+		{"NewUnsignedTransaction", func() error {
+			_, err := NewUnsignedTransaction(address, "not an address", 1, 1000, DefaultCoinSelector, utxoSet)
+			return err
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.call(); !errors.Is(err, wallet.ErrInvalidAddress) {
+				t.Errorf("returned %v, want %v", err, wallet.ErrInvalidAddress)
+			}
+		})
+	}
+}
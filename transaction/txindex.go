@@ -0,0 +1,245 @@
+package transaction
+
+import (
+	"bytes"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"log"
+
+	"github.com/dgraph-io/badger/v3"
+)
+
+// txIndexPrefix prefixes the location entry of every indexed transaction
+const txIndexPrefix = "tx:"
+
+// txIndexKey marks a database whose transaction index is enabled and complete
+const txIndexKey = "txindex"
+
+// txLocationEncodingVersion prefixes every serialized TxLocation
+const txLocationEncodingVersion = byte(1)
+
+// errTxNotIndexed is returned when the index has no entry for a transaction
+var errTxNotIndexed = errors.New("transaction is not indexed")
+
+// TxLocation tells in which block and at which position a transaction is stored
+type TxLocation struct {
+	BlockHash []byte
+	Position  int
+}
+
+// Serialize serializes the TxLocation
+func (loc TxLocation) Serialize() []byte {
+	var buff bytes.Buffer
+	buff.WriteByte(txLocationEncodingVersion)
+
+	enc := gob.NewEncoder(&buff)
+	err := enc.Encode(loc)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	return buff.Bytes()
+}
+
+// DeserializeTxLocation deserializes a TxLocation
+func DeserializeTxLocation(data []byte) (TxLocation, error) {
+	var loc TxLocation
+
+	if len(data) == 0 {
+		return loc, errors.New("tx location data is empty")
+	}
+	if data[0] != txLocationEncodingVersion {
+		return loc, fmt.Errorf("unsupported tx location encoding version %d", data[0])
+	}
+
+	dec := gob.NewDecoder(bytes.NewReader(data[1:]))
+	err := dec.Decode(&loc)
+	if err != nil {
+		return loc, fmt.Errorf("failed to decode tx location: %v", err)
+	}
+
+	return loc, nil
+}
+
+// TxIndexEnabled reports whether transactions are looked up through the index
+func (bc *Blockchain) TxIndexEnabled() bool {
+	bc.stateMu.RLock()
+	defer bc.stateMu.RUnlock()
+
+	return bc.txIndex
+}
+
+// setTxIndex records whether the transaction index is kept up to date
+func (bc *Blockchain) setTxIndex(enabled bool) {
+	bc.stateMu.Lock()
+	defer bc.stateMu.Unlock()
+
+	bc.txIndex = enabled
+}
+
+// EnableTxIndex builds the transaction index and keeps it up to date from
+// now on. The setting is stored in the database and survives restarts.
+func (bc *Blockchain) EnableTxIndex() error {
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+
+	err := bc.reindexTransactions()
+	if err != nil {
+		return err
+	}
+
+	err = bc.db.Update(func(txn *badger.Txn) error {
+		return txn.Set([]byte(txIndexKey), []byte{1})
+	})
+	if err != nil {
+		return err
+	}
+
+	bc.setTxIndex(true)
+
+	return nil
+}
+
+// DisableTxIndex drops the transaction index and goes back to scanning the chain
+func (bc *Blockchain) DisableTxIndex() error {
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+
+	bc.setTxIndex(false)
+
+	err := bc.db.Update(func(txn *badger.Txn) error {
+		return txn.Delete([]byte(txIndexKey))
+	})
+	if err != nil {
+		return err
+	}
+
+	return bc.db.DropPrefix([]byte(txIndexPrefix))
+}
+
+// ReindexTransactions rebuilds the transaction index from the best chain
+func (bc *Blockchain) ReindexTransactions() error {
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+
+	return bc.reindexTransactions()
+}
+
+// reindexTransactions rebuilds the transaction index while mu is held
+func (bc *Blockchain) reindexTransactions() error {
+	err := bc.db.DropPrefix([]byte(txIndexPrefix))
+	if err != nil {
+		return err
+	}
+
+	bci := bc.Iterator()
+
+	for {
+		block := bci.Next()
+
+		err := bc.db.Update(func(txn *badger.Txn) error {
+			return indexBlock(txn, block)
+		})
+		if err != nil {
+			return err
+		}
+
+		if len(block.PrevBlockHash) == 0 {
+			break
+		}
+	}
+
+	return nil
+}
+
+// loadTxIndexSetting reads whether the transaction index is enabled
+func (bc *Blockchain) loadTxIndexSetting() error {
+	return bc.db.View(func(txn *badger.Txn) error {
+		_, err := txn.Get([]byte(txIndexKey))
+		if err == badger.ErrKeyNotFound {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		bc.txIndex = true
+		return nil
+	})
+}
+
+// indexBlock adds the transactions of a block to the index
+func indexBlock(txn *badger.Txn, block *Block) error {
+	for i, tx := range block.Transactions {
+		loc := TxLocation{block.Hash, i}
+
+		err := txn.Set(txLocationKey(tx.ID), loc.Serialize())
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// unindexBlock removes the transactions of a disconnected block from the index
+func unindexBlock(txn *badger.Txn, block *Block) error {
+	for _, tx := range block.Transactions {
+		err := txn.Delete(txLocationKey(tx.ID))
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// findIndexedTransaction looks a transaction up through the index
+func (bc *Blockchain) findIndexedTransaction(ID []byte) (Transaction, error) {
+	block, position, err := bc.findIndexedBlock(ID)
+	if err != nil {
+		return Transaction{}, err
+	}
+
+	return *block.Transactions[position], nil
+}
+
+// findIndexedBlock looks up through the index the block containing a
+// transaction and the position of the transaction in it
+func (bc *Blockchain) findIndexedBlock(ID []byte) (Block, int, error) {
+	var loc TxLocation
+
+	err := bc.db.View(func(txn *badger.Txn) error {
+		item, err := txn.Get(txLocationKey(ID))
+		if err == badger.ErrKeyNotFound {
+			return errTxNotIndexed
+		}
+		if err != nil {
+			return err
+		}
+
+		return item.Value(func(val []byte) error {
+			loc, err = DeserializeTxLocation(val)
+			return err
+		})
+	})
+	if err != nil {
+		return Block{}, 0, err
+	}
+
+	block, err := bc.GetBlock(loc.BlockHash)
+	if err != nil {
+		return Block{}, 0, err
+	}
+	if loc.Position < 0 || loc.Position >= len(block.Transactions) {
+		return Block{}, 0, fmt.Errorf("tx location %d out of range in block %x", loc.Position, loc.BlockHash)
+	}
+
+	return block, loc.Position, nil
+}
+
+// txLocationKey returns the index key of a transaction
+func txLocationKey(txID []byte) []byte {
+	return append([]byte(txIndexPrefix), txID...)
+}
@@ -1,41 +1,183 @@
 package transaction
 
 import (
+	"bytes"
+	"encoding/gob"
 	"encoding/hex"
+	"errors"
+	"fmt"
 	"log"
+	"sort"
+
+	"blockchain-app/script"
 
 	"github.com/dgraph-io/badger/v3"
 )
 
 const utxoBucket = "chainstate"
 
+// outputsEncodingVersion prefixes every serialized TXOutputs record
+const outputsEncodingVersion = byte(5)
+
+// undoPrefix prefixes the undo record stored for every connected block
+const undoPrefix = "undo:"
+
+// undoEncodingVersion prefixes every serialized undo record
+const undoEncodingVersion = byte(3)
+
+// Chainstate update errors
+var (
+	ErrMissingOutput    = errors.New("output is not in the UTXO set")
+	ErrDuplicateOutputs = errors.New("transaction already has unspent outputs")
+)
+
 // UTXOSet represents UTXO set
 type UTXOSet struct {
 	Blockchain *Blockchain
 }
 
-// TXOutputs collects TXOutput
+// TXOutputs collects the unspent outputs of a transaction keyed by their
+// index, along with the height of the block that created them
 type TXOutputs struct {
-	Outputs []TXOutput
+	Outputs  map[int]TXOutput
+	Coinbase bool
+	Height   int
+}
+
+// IsMature reports whether the outputs can be spent in a block at spendHeight.
+// Coinbase outputs must wait CoinbaseMaturity blocks.
+func (outs TXOutputs) IsMature(spendHeight int) bool {
+	return !outs.Coinbase || spendHeight-outs.Height >= params.CoinbaseMaturity
+}
+
+// outputsRecord is the stored form of TXOutputs. Gob encodes maps in
+// random order, so the outputs are kept sorted by index to give a record
+// the same bytes every time it is written.
+type outputsRecord struct {
+	Indexes  []int
+	Outputs  []TXOutput
+	Coinbase bool
+	Height   int
 }
 
 // Serialize serializes TXOutputs
 func (outs TXOutputs) Serialize() []byte {
-	return []byte{} // Simplified for now
+	var buff bytes.Buffer
+	buff.WriteByte(outputsEncodingVersion)
+
+	record := outputsRecord{Coinbase: outs.Coinbase, Height: outs.Height}
+	for index := range outs.Outputs {
+		record.Indexes = append(record.Indexes, index)
+	}
+	sort.Ints(record.Indexes)
+	for _, index := range record.Indexes {
+		record.Outputs = append(record.Outputs, outs.Outputs[index])
+	}
+
+	enc := gob.NewEncoder(&buff)
+	err := enc.Encode(record)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	return buff.Bytes()
 }
 
 // DeserializeOutputs deserializes TXOutputs
-func DeserializeOutputs(data []byte) TXOutputs {
-	return TXOutputs{} // Simplified for now
+func DeserializeOutputs(data []byte) (TXOutputs, error) {
+	var outputs TXOutputs
+
+	if len(data) == 0 {
+		return outputs, errors.New("outputs data is empty")
+	}
+	if data[0] != outputsEncodingVersion {
+		return outputs, fmt.Errorf("unsupported outputs encoding version %d", data[0])
+	}
+
+	var record outputsRecord
+	dec := gob.NewDecoder(bytes.NewReader(data[1:]))
+	err := dec.Decode(&record)
+	if err != nil {
+		return outputs, fmt.Errorf("failed to decode outputs: %v", err)
+	}
+	if len(record.Indexes) != len(record.Outputs) {
+		return outputs, fmt.Errorf("outputs record has %d indexes for %d outputs", len(record.Indexes), len(record.Outputs))
+	}
+
+	outputs = TXOutputs{make(map[int]TXOutput, len(record.Outputs)), record.Coinbase, record.Height}
+	for i, index := range record.Indexes {
+		outputs.Outputs[index] = record.Outputs[i]
+	}
+
+	return outputs, nil
 }
 
 // FindSpendableOutputs finds and returns unspent outputs to reference in inputs
 func (u UTXOSet) FindSpendableOutputs(pubkeyHash []byte, amount int) (int, map[string][]int) {
+	return u.findSpendableOutputs(func(out TXOutput) bool {
+		return out.IsLockedWithKey(pubkeyHash)
+	}, amount)
+}
+
+// FindSpendableScriptOutputs finds unspent P2SH outputs locked to scriptHash
+// worth at least amount
+func (u UTXOSet) FindSpendableScriptOutputs(scriptHash []byte, amount int) (int, map[string][]int) {
+	return u.findSpendableOutputs(func(out TXOutput) bool {
+		return out.IsLockedWithScriptHash(scriptHash)
+	}, amount)
+}
+
+// FindSpendableCoins returns every output locked to pubKeyHash that could
+// be spent in the next block
+func (u UTXOSet) FindSpendableCoins(pubKeyHash []byte) ([]Coin, error) {
+	return u.spendableCoins(func(out TXOutput) bool {
+		return out.IsLockedWithKey(pubKeyHash)
+	})
+}
+
+// FindSpendableScriptCoins returns every P2SH output locked to scriptHash
+// that could be spent in the next block
+func (u UTXOSet) FindSpendableScriptCoins(scriptHash []byte) ([]Coin, error) {
+	return u.spendableCoins(func(out TXOutput) bool {
+		return out.IsLockedWithScriptHash(scriptHash)
+	})
+}
+
+// findSpendableOutputs collects mature outputs accepted by match whose
+// timelocks have passed until they are worth amount
+func (u UTXOSet) findSpendableOutputs(match func(TXOutput) bool, amount int) (int, map[string][]int) {
 	unspentOutputs := make(map[string][]int)
 	accumulated := 0
+
+	coins, err := u.spendableCoins(match)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	for _, coin := range coins {
+		if accumulated >= amount {
+			break
+		}
+		txID := hex.EncodeToString(coin.Txid)
+		accumulated += coin.Output.Value
+		unspentOutputs[txID] = append(unspentOutputs[txID], coin.Vout)
+	}
+
+	return accumulated, unspentOutputs
+}
+
+// spendableCoins returns the mature outputs accepted by match whose
+// timelocks have passed, in key order and by output index
+func (u UTXOSet) spendableCoins(match func(TXOutput) bool) ([]Coin, error) {
+	var coins []Coin
 	db := u.Blockchain.db
+	spendHeight := u.Blockchain.GetBestHeight() + 1
+	medianTime, err := u.Blockchain.tipMedianTime()
+	if err != nil {
+		return nil, err
+	}
 
-	err := db.View(func(txn *badger.Txn) error {
+	err = db.View(func(txn *badger.Txn) error {
 		opts := badger.DefaultIteratorOptions
 		opts.Prefix = []byte(utxoBucket)
 		it := txn.NewIterator(opts)
@@ -43,17 +185,29 @@ func (u UTXOSet) FindSpendableOutputs(pubkeyHash []byte, amount int) (int, map[s
 
 		for it.Rewind(); it.Valid(); it.Next() {
 			item := it.Item()
-			key := item.Key()
+			txID := item.KeyCopy(nil)[len(utxoBucket):]
 			err := item.Value(func(v []byte) error {
-				outs := DeserializeOutputs(v)
+				outs, err := DeserializeOutputs(v)
+				if err != nil {
+					return err
+				}
 
-				txID := hex.EncodeToString(key[len(utxoBucket):])
+				if !outs.IsMature(spendHeight) {
+					return nil
+				}
 
-				for outIdx, out := range outs.Outputs {
-					if out.IsLockedWithKey(pubkeyHash) && accumulated < amount {
-						accumulated += out.Value
-						unspentOutputs[txID] = append(unspentOutputs[txID], outIdx)
+				indexes := make([]int, 0, len(outs.Outputs))
+				for outIdx := range outs.Outputs {
+					indexes = append(indexes, outIdx)
+				}
+				sort.Ints(indexes)
+
+				for _, outIdx := range indexes {
+					out := outs.Outputs[outIdx]
+					if !match(out) || !u.Blockchain.timeLockPassed(out, outs.Height, spendHeight, medianTime) {
+						continue
 					}
+					coins = append(coins, Coin{txID, outIdx, out})
 				}
 				return nil
 			})
@@ -64,15 +218,25 @@ func (u UTXOSet) FindSpendableOutputs(pubkeyHash []byte, amount int) (int, map[s
 		return nil
 	})
 
-	if err != nil {
-		log.Panic(err)
-	}
-
-	return accumulated, unspentOutputs
+	return coins, err
 }
 
 // FindUTXO finds UTXO for a public key hash
 func (u UTXOSet) FindUTXO(pubKeyHash []byte) []TXOutput {
+	return u.findUTXO(func(out TXOutput) bool {
+		return out.IsLockedWithKey(pubKeyHash)
+	})
+}
+
+// FindScriptUTXO finds UTXO locked to a redeem script hash
+func (u UTXOSet) FindScriptUTXO(scriptHash []byte) []TXOutput {
+	return u.findUTXO(func(out TXOutput) bool {
+		return out.IsLockedWithScriptHash(scriptHash)
+	})
+}
+
+// findUTXO returns the unspent outputs accepted by match
+func (u UTXOSet) findUTXO(match func(TXOutput) bool) []TXOutput {
 	var UTXOs []TXOutput
 	db := u.Blockchain.db
 
@@ -85,10 +249,13 @@ func (u UTXOSet) FindUTXO(pubKeyHash []byte) []TXOutput {
 		for it.Rewind(); it.Valid(); it.Next() {
 			item := it.Item()
 			err := item.Value(func(v []byte) error {
-				outs := DeserializeOutputs(v)
+				outs, err := DeserializeOutputs(v)
+				if err != nil {
+					return err
+				}
 
 				for _, out := range outs.Outputs {
-					if out.IsLockedWithKey(pubKeyHash) {
+					if match(out) {
 						UTXOs = append(UTXOs, out)
 					}
 				}
@@ -108,6 +275,41 @@ func (u UTXOSet) FindUTXO(pubKeyHash []byte) []TXOutput {
 	return UTXOs
 }
 
+// FindOutput returns the unspent output vout of the transaction txID, if any
+func (u UTXOSet) FindOutput(txID []byte, vout int) (TXOutput, bool, error) {
+	outs, found, err := u.FindOutputs(txID)
+	if err != nil || !found {
+		return TXOutput{}, false, err
+	}
+
+	out, found := outs.Outputs[vout]
+	return out, found, nil
+}
+
+// FindOutputs returns the unspent outputs of the transaction txID, if any
+func (u UTXOSet) FindOutputs(txID []byte) (TXOutputs, bool, error) {
+	var outs TXOutputs
+	var found bool
+
+	err := u.Blockchain.db.View(func(txn *badger.Txn) error {
+		item, err := txn.Get(append([]byte(utxoBucket), txID...))
+		if err == badger.ErrKeyNotFound {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		return item.Value(func(v []byte) error {
+			outs, err = DeserializeOutputs(v)
+			found = err == nil
+			return err
+		})
+	})
+
+	return outs, found, err
+}
+
 // CountTransactions returns the number of transactions in the UTXO set
 func (u UTXOSet) CountTransactions() int {
 	db := u.Blockchain.db
@@ -134,6 +336,9 @@ func (u UTXOSet) CountTransactions() int {
 
 // Reindex rebuilds the UTXO set
 func (u UTXOSet) Reindex() {
+	u.Blockchain.mu.Lock()
+	defer u.Blockchain.mu.Unlock()
+
 	db := u.Blockchain.db
 	bucketName := []byte(utxoBucket)
 
@@ -179,61 +384,240 @@ func (u UTXOSet) Reindex() {
 	}
 }
 
-// Update updates the UTXO set with transactions from the Block
-func (u UTXOSet) Update(block *Block) {
-	db := u.Blockchain.db
+// Update updates the UTXO set with transactions from the Block and stores
+// the outputs it spends as the block's undo record
+func (u UTXOSet) Update(block *Block) error {
+	return u.Blockchain.db.Update(func(txn *badger.Txn) error {
+		return u.update(txn, block)
+	})
+}
 
-	err := db.Update(func(txn *badger.Txn) error {
-		for _, tx := range block.Transactions {
-			if tx.IsCoinbase() == false {
-				for _, vin := range tx.Vin {
-					updatedOuts := TXOutputs{}
-					outsBytes, err := txn.Get(append([]byte(utxoBucket), vin.Txid...))
-					if err != nil {
-						continue
-					}
-					err = outsBytes.Value(func(v []byte) error {
-						outs := DeserializeOutputs(v)
-
-						for outIdx, out := range outs.Outputs {
-							if outIdx != vin.Vout {
-								updatedOuts.Outputs = append(updatedOuts.Outputs, out)
-							}
-						}
-						return nil
-					})
-					if err != nil {
-						return err
-					}
+// update applies Update within txn. It fails when an input spends an
+// output missing from the set or a transaction ID already has unspent
+// outputs, since the undo record could not restore the chainstate then.
+func (u UTXOSet) update(txn *badger.Txn, block *Block) error {
+	var undo []SpentOutput
+
+	for _, tx := range block.Transactions {
+		if tx.IsCoinbase() == false {
+			for _, vin := range tx.Vin {
+				key := append([]byte(utxoBucket), vin.Txid...)
+				updatedOuts, err := getOutputs(txn, key)
+				if err == badger.ErrKeyNotFound {
+					return fmt.Errorf("%w: %x:%d", ErrMissingOutput, vin.Txid, vin.Vout)
+				}
+				if err != nil {
+					return err
+				}
 
-					if len(updatedOuts.Outputs) == 0 {
-						err := txn.Delete(append([]byte(utxoBucket), vin.Txid...))
-						if err != nil {
-							return err
-						}
-					} else {
-						err := txn.Set(append([]byte(utxoBucket), vin.Txid...), updatedOuts.Serialize())
-						if err != nil {
-							return err
-						}
-					}
+				out, ok := updatedOuts.Outputs[vin.Vout]
+				if !ok {
+					return fmt.Errorf("%w: %x:%d", ErrMissingOutput, vin.Txid, vin.Vout)
+				}
+				undo = append(undo, SpentOutput{vin.Txid, vin.Vout, out, updatedOuts.Coinbase, updatedOuts.Height})
+				delete(updatedOuts.Outputs, vin.Vout)
+
+				if len(updatedOuts.Outputs) == 0 {
+					err = txn.Delete(key)
+				} else {
+					err = txn.Set(key, updatedOuts.Serialize())
 				}
+				if err != nil {
+					return err
+				}
+			}
+		}
+
+		newOutputs := TXOutputs{make(map[int]TXOutput), tx.IsCoinbase(), block.Height}
+		for outIdx, out := range tx.Vout {
+			if !script.IsUnspendable(out.ScriptPubKey) {
+				newOutputs.Outputs[outIdx] = out
+			}
+		}
+		if len(newOutputs.Outputs) == 0 {
+			continue
+		}
+
+		key := append([]byte(utxoBucket), tx.ID...)
+		_, err := txn.Get(key)
+		if err == nil {
+			return fmt.Errorf("%w: %x", ErrDuplicateOutputs, tx.ID)
+		}
+		if err != badger.ErrKeyNotFound {
+			return err
+		}
+
+		err = txn.Set(key, newOutputs.Serialize())
+		if err != nil {
+			return err
+		}
+	}
+
+	return txn.Set(undoKey(block.Hash), serializeUndo(undo))
+}
+
+// getOutputs reads the outputs stored under key within txn
+func getOutputs(txn *badger.Txn, key []byte) (TXOutputs, error) {
+	var outs TXOutputs
+
+	item, err := txn.Get(key)
+	if err != nil {
+		return outs, err
+	}
+
+	err = item.Value(func(v []byte) error {
+		outs, err = DeserializeOutputs(v)
+		return err
+	})
+
+	return outs, err
+}
+
+// SpentOutput records an output removed from the UTXO set by a block
+// together with the origin of the transaction that created it
+type SpentOutput struct {
+	Txid     []byte
+	Index    int
+	Output   TXOutput
+	Coinbase bool
+	Height   int
+}
+
+// Disconnect reverts Update for a block using its undo record, restoring
+// the chainstate to what it was before the block was connected
+func (u UTXOSet) Disconnect(block *Block) error {
+	return u.Blockchain.db.Update(func(txn *badger.Txn) error {
+		return u.disconnect(txn, block)
+	})
+}
+
+// disconnect applies Disconnect within txn
+func (u UTXOSet) disconnect(txn *badger.Txn, block *Block) error {
+	var undo []SpentOutput
+
+	item, err := txn.Get(undoKey(block.Hash))
+	if err == nil {
+		err = item.Value(func(val []byte) error {
+			undo, err = deserializeUndo(val)
+			return err
+		})
+	}
+	if err != nil {
+		return fmt.Errorf("failed to load undo record of block %x: %v", block.Hash, err)
+	}
+
+	return u.revert(txn, block, undo)
+}
+
+// revert removes the outputs created by a block and puts back the outputs
+// it spent, then drops the block's undo record. Transactions are undone
+// last to first, so the outputs of each one are exactly those it created
+// when they are removed; anything else means the chainstate is not the one
+// the block was connected to and revert fails.
+func (u UTXOSet) revert(txn *badger.Txn, block *Block, undo []SpentOutput) error {
+	next := len(undo)
+
+	for i := len(block.Transactions) - 1; i >= 0; i-- {
+		tx := block.Transactions[i]
+
+		created := 0
+		for _, out := range tx.Vout {
+			if !script.IsUnspendable(out.ScriptPubKey) {
+				created++
+			}
+		}
+		if created > 0 {
+			key := append([]byte(utxoBucket), tx.ID...)
+			outs, err := getOutputs(txn, key)
+			if err == badger.ErrKeyNotFound || err == nil && len(outs.Outputs) != created {
+				return fmt.Errorf("%w: outputs of %x were spent outside block %x", ErrMissingOutput, tx.ID, block.Hash)
+			}
+			if err != nil {
+				return err
 			}
 
-			newOutputs := TXOutputs{}
-			for _, out := range tx.Vout {
-				newOutputs.Outputs = append(newOutputs.Outputs, out)
+			err = txn.Delete(key)
+			if err != nil {
+				return err
+			}
+		}
+
+		if tx.IsCoinbase() {
+			continue
+		}
+		if next < len(tx.Vin) {
+			return fmt.Errorf("undo record of block %x is missing spent outputs", block.Hash)
+		}
+		next -= len(tx.Vin)
+
+		for j := len(tx.Vin) - 1; j >= 0; j-- {
+			spent := undo[next+j]
+			vin := tx.Vin[j]
+			if !bytes.Equal(spent.Txid, vin.Txid) || spent.Index != vin.Vout {
+				return fmt.Errorf("undo record of block %x does not match input %x:%d", block.Hash, vin.Txid, vin.Vout)
+			}
+
+			key := append([]byte(utxoBucket), spent.Txid...)
+			outs, err := getOutputs(txn, key)
+			if err == badger.ErrKeyNotFound {
+				outs, err = TXOutputs{make(map[int]TXOutput), spent.Coinbase, spent.Height}, nil
+			}
+			if err != nil {
+				return err
+			}
+			if _, ok := outs.Outputs[spent.Index]; ok {
+				return fmt.Errorf("%w: %x:%d", ErrDuplicateOutputs, spent.Txid, spent.Index)
 			}
 
-			err := txn.Set(append([]byte(utxoBucket), tx.ID...), newOutputs.Serialize())
+			outs.Outputs[spent.Index] = spent.Output
+			err = txn.Set(key, outs.Serialize())
 			if err != nil {
 				return err
 			}
 		}
-		return nil
-	})
+	}
+	if next != 0 {
+		return fmt.Errorf("undo record of block %x has %d outputs no input spends", block.Hash, next)
+	}
+
+	return txn.Delete(undoKey(block.Hash))
+}
+
+// undoKey returns the key of the undo record of a block
+func undoKey(blockHash []byte) []byte {
+	return append([]byte(undoPrefix), blockHash...)
+}
+
+// serializeUndo serializes the outputs spent by a block
+func serializeUndo(undo []SpentOutput) []byte {
+	var buff bytes.Buffer
+	buff.WriteByte(undoEncodingVersion)
 
+	enc := gob.NewEncoder(&buff)
+	err := enc.Encode(undo)
 	if err != nil {
 		log.Panic(err)
 	}
+
+	return buff.Bytes()
+}
+
+// deserializeUndo deserializes the outputs spent by a block
+func deserializeUndo(data []byte) ([]SpentOutput, error) {
+	var undo []SpentOutput
+
+	if len(data) == 0 {
+		return undo, errors.New("undo data is empty")
+	}
+	if data[0] != undoEncodingVersion {
+		return undo, fmt.Errorf("unsupported undo encoding version %d", data[0])
+	}
+
+	dec := gob.NewDecoder(bytes.NewReader(data[1:]))
+	err := dec.Decode(&undo)
+	if err != nil {
+		return undo, fmt.Errorf("failed to decode undo data: %v", err)
+	}
+
+	return undo, nil
 }
@@ -0,0 +1,273 @@
+package transaction
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+
+	"blockchain-app/script"
+
+	"github.com/dgraph-io/badger/v3"
+)
+
+// newTestBlockchain returns a blockchain over an empty database in a
+// temporary directory
+func newTestBlockchain(t *testing.T) *Blockchain {
+	t.Helper()
+
+	opts := badger.DefaultOptions(t.TempDir())
+	opts.Logger = nil
+	db, err := badger.Open(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	return &Blockchain{db: db}
+}
+
+// testOutput returns an output of value paying to a key hash derived from tag
+func testOutput(t *testing.T, value int, tag string) TXOutput {
+	t.Helper()
+
+	lock, err := script.PayToPubKeyHashScript(script.Hash160([]byte(tag)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return TXOutput{value, lock}
+}
+
+// testTransaction returns a transaction spending ins and creating outs
+func testTransaction(ins []TXInput, outs ...TXOutput) *Transaction {
+	tx := &Transaction{Vin: ins, Vout: outs}
+	tx.ID = tx.Hash()
+
+	return tx
+}
+
+// testCoinbase returns a coinbase paying value at height
+func testCoinbase(t *testing.T, height, value int) *Transaction {
+	return testTransaction(
+		[]TXInput{{Vout: -1, ScriptSig: []byte(fmt.Sprintf("height %d", height))}},
+		testOutput(t, value, fmt.Sprintf("miner %d", height)),
+	)
+}
+
+// testBlock returns a block at height holding txs; UTXOSet.Update and
+// Disconnect look only at its hash, height and transactions
+func testBlock(height int, txs ...*Transaction) *Block {
+	return &Block{Transactions: txs, Hash: []byte(fmt.Sprintf("block %d", height)), Height: height}
+}
+
+// snapshot copies every key and value of the database under prefix
+func snapshot(t *testing.T, db *badger.DB, prefix string) map[string][]byte {
+	t.Helper()
+
+	state := make(map[string][]byte)
+	err := db.View(func(txn *badger.Txn) error {
+		opts := badger.DefaultIteratorOptions
+		opts.Prefix = []byte(prefix)
+		it := txn.NewIterator(opts)
+		defer it.Close()
+
+		for it.Rewind(); it.Valid(); it.Next() {
+			value, err := it.Item().ValueCopy(nil)
+			if err != nil {
+				return err
+			}
+			state[string(it.Item().KeyCopy(nil))] = value
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return state
+}
+
+func TestUTXOSetDisconnectRestoresChainstate(t *testing.T) {
+	bc := newTestBlockchain(t)
+	u := UTXOSet{bc}
+
+	origin := testTransaction(
+		[]TXInput{{Vout: -1, ScriptSig: []byte("origin")}},
+		testOutput(t, 50, "origin"), testOutput(t, 50, "origin"),
+	)
+	if err := u.Update(testBlock(0, origin)); err != nil {
+		t.Fatal(err)
+	}
+
+	// The first block leaves records with several outputs behind, so the
+	// second one both removes whole records and rewrites partial ones
+	funding := testTransaction(
+		[]TXInput{{Txid: origin.ID, Vout: 0}},
+		testOutput(t, 10, "alice"), testOutput(t, 20, "bob"), testOutput(t, 30, "carol"),
+	)
+	single := testTransaction(
+		[]TXInput{{Txid: origin.ID, Vout: 1}},
+		testOutput(t, 40, "dave"),
+	)
+	if err := u.Update(testBlock(1, testCoinbase(t, 1, 50), funding, single)); err != nil {
+		t.Fatal(err)
+	}
+
+	before := snapshot(t, bc.db, utxoBucket)
+	if len(before) != 3 {
+		t.Fatalf("chainstate has %d records before block 2, want 3", len(before))
+	}
+
+	// parent creates outputs that child spends later in the same block,
+	// one of them left unspent
+	parent := testTransaction(
+		[]TXInput{{Txid: funding.ID, Vout: 1}, {Txid: single.ID, Vout: 0}},
+		testOutput(t, 25, "erin"), testOutput(t, 25, "frank"), testOutput(t, 10, "grace"),
+	)
+	child := testTransaction(
+		[]TXInput{{Txid: parent.ID, Vout: 0}, {Txid: parent.ID, Vout: 2}},
+		testOutput(t, 35, "heidi"),
+	)
+	grandchild := testTransaction(
+		[]TXInput{{Txid: child.ID, Vout: 0}, {Txid: funding.ID, Vout: 2}},
+		testOutput(t, 65, "ivan"),
+	)
+	block := testBlock(2, testCoinbase(t, 2, 50), parent, child, grandchild)
+	if err := u.Update(block); err != nil {
+		t.Fatal(err)
+	}
+
+	if reflect.DeepEqual(snapshot(t, bc.db, utxoBucket), before) {
+		t.Fatal("Update of block 2 left the chainstate unchanged")
+	}
+	if _, ok, err := u.FindOutputs(child.ID); err != nil || ok {
+		t.Fatalf("output spent in its own block still unspent: ok=%v err=%v", ok, err)
+	}
+
+	if err := u.Disconnect(block); err != nil {
+		t.Fatal(err)
+	}
+
+	after := snapshot(t, bc.db, utxoBucket)
+	for key, value := range before {
+		if !bytes.Equal(after[key], value) {
+			t.Errorf("record %x is %x after Disconnect, want %x", key, after[key], value)
+		}
+	}
+	for key := range after {
+		if _, ok := before[key]; !ok {
+			t.Errorf("record %x left behind by Disconnect", key)
+		}
+	}
+
+	if undo := snapshot(t, bc.db, string(undoKey(block.Hash))); len(undo) != 0 {
+		t.Error("undo record of block 2 not removed")
+	}
+	if err := u.Disconnect(block); err == nil {
+		t.Error("second Disconnect of block 2 succeeded without an undo record")
+	}
+}
+
+func TestUTXOSetUpdateRejectsInconsistentBlocks(t *testing.T) {
+	bc := newTestBlockchain(t)
+	u := UTXOSet{bc}
+
+	coinbase := testCoinbase(t, 1, 50)
+	if err := u.Update(testBlock(1, coinbase)); err != nil {
+		t.Fatal(err)
+	}
+	before := snapshot(t, bc.db, "")
+
+	tests := []struct {
+		name  string
+		block *Block
+		want  error
+	}{
+		{
+			"unknown transaction",
+			testBlock(2, testCoinbase(t, 2, 50), testTransaction([]TXInput{{Txid: []byte("unknown"), Vout: 0}}, testOutput(t, 1, "a"))),
+			ErrMissingOutput,
+		},
+		{
+			"unknown output index",
+			testBlock(2, testCoinbase(t, 2, 50), testTransaction([]TXInput{{Txid: coinbase.ID, Vout: 1}}, testOutput(t, 1, "a"))),
+			ErrMissingOutput,
+		},
+		{
+			"output spent twice",
+			testBlock(2, testCoinbase(t, 2, 50),
+				testTransaction([]TXInput{{Txid: coinbase.ID, Vout: 0}}, testOutput(t, 1, "a")),
+				testTransaction([]TXInput{{Txid: coinbase.ID, Vout: 0}}, testOutput(t, 1, "b"))),
+			ErrMissingOutput,
+		},
+		{
+			"duplicate coinbase",
+			testBlock(2, coinbase),
+			ErrDuplicateOutputs,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := u.Update(tt.block)
+			if !errors.Is(err, tt.want) {
+				t.Fatalf("Update returned %v, want %v", err, tt.want)
+			}
+			if !reflect.DeepEqual(snapshot(t, bc.db, ""), before) {
+				t.Error("failed Update changed the database")
+			}
+		})
+	}
+}
+
+func TestUTXOSetDisconnectRejectsChangedChainstate(t *testing.T) {
+	bc := newTestBlockchain(t)
+	u := UTXOSet{bc}
+
+	block := testBlock(1, testCoinbase(t, 1, 50))
+	if err := u.Update(block); err != nil {
+		t.Fatal(err)
+	}
+
+	// Spending the coinbase in a later block that is never disconnected
+	// leaves nothing for Disconnect to remove
+	spend := testTransaction([]TXInput{{Txid: block.Transactions[0].ID, Vout: 0}}, testOutput(t, 50, "a"))
+	if err := u.Update(testBlock(2, testCoinbase(t, 2, 50), spend)); err != nil {
+		t.Fatal(err)
+	}
+	before := snapshot(t, bc.db, "")
+
+	if err := u.Disconnect(block); !errors.Is(err, ErrMissingOutput) {
+		t.Fatalf("Disconnect returned %v, want %v", err, ErrMissingOutput)
+	}
+	if !reflect.DeepEqual(snapshot(t, bc.db, ""), before) {
+		t.Error("failed Disconnect changed the database")
+	}
+}
+
+func TestTXOutputsSerializeIsDeterministic(t *testing.T) {
+	outs := TXOutputs{make(map[int]TXOutput), true, 7}
+	for i := 0; i < 16; i++ {
+		outs.Outputs[i*3] = testOutput(t, i+1, fmt.Sprintf("key %d", i))
+	}
+
+	want := outs.Serialize()
+	for i := 0; i < 20; i++ {
+		if got := outs.Serialize(); !bytes.Equal(got, want) {
+			t.Fatalf("serialization %d differs from the first", i)
+		}
+	}
+
+	decoded, err := DeserializeOutputs(want)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(decoded.Serialize(), want) {
+		t.Error("round trip changed the record")
+	}
+	if decoded.Coinbase != outs.Coinbase || decoded.Height != outs.Height || len(decoded.Outputs) != len(outs.Outputs) {
+		t.Errorf("decoded %+v, want %+v", decoded, outs)
+	}
+}
@@ -0,0 +1,347 @@
+package transaction
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"runtime"
+	"sync"
+	"time"
+
+	"blockchain-app/script"
+
+	"github.com/dgraph-io/badger/v3"
+)
+
+// maxFutureBlockTime is how far ahead of the local clock a block timestamp may be
+const maxFutureBlockTime = 2 * time.Hour
+
+// Block validation errors
+var (
+	ErrBadPoW          = errors.New("block hash does not satisfy proof of work")
+	ErrOrphan          = errors.New("previous block is unknown")
+	ErrBadMerkleRoot   = errors.New("merkle root does not match the block transactions")
+	ErrDoubleSpend     = errors.New("input spends an unavailable output")
+	ErrBadCoinbase     = errors.New("invalid coinbase transaction")
+	ErrBadDifficulty   = errors.New("block difficulty does not match the expected retarget")
+	ErrBadHeight       = errors.New("block height does not follow its parent")
+	ErrBadTimestamp    = errors.New("invalid block timestamp")
+	ErrBadTransaction  = errors.New("invalid transaction")
+	ErrBadSignature    = errors.New("invalid transaction signature")
+	ErrImmatureSpend   = errors.New("input spends an immature coinbase output")
+	ErrInvalidAncestor = errors.New("block descends from an invalid block")
+)
+
+// ErrInvalidBlock wraps the errors of ValidateBlock and checkTransactions
+// that come from the block breaking the consensus rules. Errors reading the
+// chain are returned unwrapped, the block may well be valid.
+var ErrInvalidBlock = errors.New("block breaks the consensus rules")
+
+// consensusErrors are the errors of blocks that break the consensus rules,
+// as opposed to errors reading the chain
+var consensusErrors = []error{
+	ErrBadPoW, ErrBadMerkleRoot, ErrDoubleSpend, ErrBadCoinbase, ErrBadDifficulty,
+	ErrBadHeight, ErrBadTimestamp, ErrBadTransaction, ErrBadSignature,
+	ErrImmatureSpend, ErrInvalidAncestor, ErrNonFinal, ErrSequenceLocked,
+}
+
+// invalidBlock wraps err in ErrInvalidBlock when it comes from a block
+// breaking the consensus rules
+func invalidBlock(err error) error {
+	if errors.Is(err, ErrInvalidBlock) {
+		return err
+	}
+	for _, target := range consensusErrors {
+		if errors.Is(err, target) {
+			return fmt.Errorf("%w: %w", ErrInvalidBlock, err)
+		}
+	}
+
+	return err
+}
+
+// ValidateBlock runs every consensus check on a block before it is stored.
+// Transactions are only checked against the UTXO set when the block extends
+// the current tip, because the set describes the state at that tip.
+func (bc *Blockchain) ValidateBlock(block *Block) (err error) {
+	defer func() { err = invalidBlock(err) }()
+
+	err = checkBlockSanity(block)
+	if err != nil {
+		return err
+	}
+
+	// Children of invalid blocks are marked too, so whole branches stay out
+	if bc.isInvalid(block.PrevBlockHash) {
+		return bc.invalidateBranch([]*Block{block}, fmt.Errorf("%w: %x", ErrInvalidAncestor, block.PrevBlockHash))
+	}
+
+	prev, err := bc.GetBlock(block.PrevBlockHash)
+	if errors.Is(err, badger.ErrKeyNotFound) || len(block.PrevBlockHash) == 0 {
+		return fmt.Errorf("%w: %x", ErrOrphan, block.PrevBlockHash)
+	}
+	if err != nil {
+		return err
+	}
+
+	if block.Height != prev.Height+1 {
+		return fmt.Errorf("%w: got %d, want %d", ErrBadHeight, block.Height, prev.Height+1)
+	}
+
+	if block.Timestamp < prev.Timestamp {
+		return fmt.Errorf("%w: block is older than its parent", ErrBadTimestamp)
+	}
+
+	err = bc.checkDifficulty(block)
+	if err != nil {
+		return err
+	}
+
+	if bytes.Equal(block.PrevBlockHash, bc.currentTip()) {
+		return bc.checkTransactions(block)
+	}
+
+	return nil
+}
+
+// checkBlockSanity runs the checks that don't depend on the rest of the chain
+func checkBlockSanity(block *Block) error {
+	if !block.ValidatePoW() {
+		return ErrBadPoW
+	}
+
+	if time.Unix(block.Timestamp, 0).After(time.Now().Add(maxFutureBlockTime)) {
+		return fmt.Errorf("%w: block is too far in the future", ErrBadTimestamp)
+	}
+
+	if len(block.Transactions) == 0 || !block.Transactions[0].IsCoinbase() {
+		return fmt.Errorf("%w: first transaction must be a coinbase", ErrBadCoinbase)
+	}
+	err := checkCoinbaseHeight(block.Transactions[0], block.Height)
+	if err != nil {
+		return err
+	}
+
+	for i, tx := range block.Transactions {
+		if i > 0 && tx.IsCoinbase() {
+			return fmt.Errorf("%w: more than one coinbase", ErrBadCoinbase)
+		}
+		if !bytes.Equal(tx.ID, tx.UnsignedHash()) {
+			return fmt.Errorf("%w: %x has a wrong ID", ErrBadTransaction, tx.ID)
+		}
+	}
+
+	if !bytes.Equal(block.MerkleRoot, block.HashTransactions()) {
+		return ErrBadMerkleRoot
+	}
+
+	return nil
+}
+
+// checkCoinbaseHeight checks that the script of a coinbase starts with the
+// height of its block
+func checkCoinbaseHeight(coinbase *Transaction, height int) error {
+	prefix, err := script.NewBuilder().AddNumber(int64(height)).Script()
+	if err != nil {
+		return err
+	}
+	if !bytes.HasPrefix(coinbase.Vin[0].ScriptSig, prefix) {
+		return fmt.Errorf("%w: coinbase doesn't start with the block height %d", ErrBadCoinbase, height)
+	}
+
+	return nil
+}
+
+// checkTransactions validates the inputs, amounts, timelocks and signatures
+// of every transaction in a block that extends the current tip
+func (bc *Blockchain) checkTransactions(block *Block) (err error) {
+	defer func() { err = invalidBlock(err) }()
+
+	utxoSet := UTXOSet{bc}
+	pending := make(map[string]*Transaction)
+	spent := make(map[string]bool)
+	var jobs []scriptJob
+	fees := 0
+
+	prev, err := bc.GetBlock(block.PrevBlockHash)
+	if err != nil {
+		return err
+	}
+	medianTime, err := bc.CalcPastMedianTime(&prev)
+	if err != nil {
+		return err
+	}
+
+	for _, tx := range block.Transactions {
+		err := bc.checkTimeLocks(utxoSet, pending, tx, block.Height, medianTime)
+		if err != nil {
+			return err
+		}
+
+		// Outputs of the coinbase are checked too, a negative one would
+		// let the others mint more than allowed
+		outputValue, err := tx.OutputValue()
+		if err != nil {
+			return err
+		}
+
+		if tx.IsCoinbase() {
+			pending[hex.EncodeToString(tx.ID)] = tx
+			continue
+		}
+
+		if len(tx.Vin) == 0 || len(tx.Vout) == 0 {
+			return fmt.Errorf("%w: %x has no inputs or outputs", ErrBadTransaction, tx.ID)
+		}
+
+		inputValue := 0
+		for _, vin := range tx.Vin {
+			outpoint := fmt.Sprintf("%x:%d", vin.Txid, vin.Vout)
+			if spent[outpoint] {
+				return fmt.Errorf("%w: %s is spent twice in the block", ErrDoubleSpend, outpoint)
+			}
+			spent[outpoint] = true
+
+			out, found, err := bc.findBlockOutput(utxoSet, pending, vin)
+			if err != nil {
+				return err
+			}
+			if !found {
+				return fmt.Errorf("%w: %s", ErrDoubleSpend, outpoint)
+			}
+			inputValue += out.Value
+			if !MoneyRange(out.Value) || !MoneyRange(inputValue) {
+				return fmt.Errorf("%w: %x inputs are worth more than %d", ErrBadTransaction, tx.ID, MaxMoney)
+			}
+
+			err = checkInputMaturity(utxoSet, pending, vin, block.Height)
+			if err != nil {
+				return err
+			}
+		}
+
+		if outputValue > inputValue {
+			return fmt.Errorf("%w: %x spends more than its inputs", ErrBadTransaction, tx.ID)
+		}
+		fees += inputValue - outputValue
+		if !MoneyRange(fees) {
+			return fmt.Errorf("%w: fees are worth more than %d", ErrBadTransaction, MaxMoney)
+		}
+
+		prevTXs, err := bc.findPrevTransactions(tx, pending)
+		if err != nil {
+			return err
+		}
+		jobs = append(jobs, scriptJob{tx, prevTXs})
+
+		pending[hex.EncodeToString(tx.ID)] = tx
+	}
+
+	allowed := CalcBlockSubsidy(block.Height) + fees
+	coinbaseValue, err := block.Transactions[0].OutputValue()
+	if err != nil {
+		return err
+	}
+	if coinbaseValue > allowed {
+		return fmt.Errorf("%w: claims %d, allowed %d", ErrBadCoinbase, coinbaseValue, allowed)
+	}
+
+	// Scripts are the most expensive check, so they run last
+	return verifyScriptsBatch(jobs)
+}
+
+// scriptJob is a transaction whose scripts are verified against the
+// transactions it spends from
+type scriptJob struct {
+	tx      *Transaction
+	prevTXs map[string]Transaction
+}
+
+// verifyScriptsBatch verifies the scripts of every job on all CPUs. Once the
+// spent outputs are known the transactions don't depend on each other, so a
+// block verifies in about the time of its largest share. The error of the
+// first failing transaction in block order is returned.
+func verifyScriptsBatch(jobs []scriptJob) error {
+	errs := make([]error, len(jobs))
+	next := make(chan int)
+
+	var wg sync.WaitGroup
+	for w := 0; w < runtime.NumCPU(); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range next {
+				errs[i] = jobs[i].tx.VerifyScripts(jobs[i].prevTXs)
+			}
+		}()
+	}
+
+	for i := range jobs {
+		next <- i
+	}
+	close(next)
+	wg.Wait()
+
+	for i, err := range errs {
+		if err != nil {
+			return fmt.Errorf("%w: %x: %v", ErrBadSignature, jobs[i].tx.ID, err)
+		}
+	}
+
+	return nil
+}
+
+// CheckCoinbaseMaturity reports an error when a transaction spends a
+// coinbase output that would still be immature in the next block
+func (bc *Blockchain) CheckCoinbaseMaturity(tx *Transaction) error {
+	if tx.IsCoinbase() {
+		return nil
+	}
+
+	utxoSet := UTXOSet{bc}
+	spendHeight := bc.GetBestHeight() + 1
+
+	for _, vin := range tx.Vin {
+		err := checkInputMaturity(utxoSet, nil, vin, spendHeight)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// checkInputMaturity checks that the output spent by vin may be spent in a
+// block at spendHeight. A coinbase of the same block is never mature.
+func checkInputMaturity(utxoSet UTXOSet, pending map[string]*Transaction, vin TXInput, spendHeight int) error {
+	if tx, ok := pending[hex.EncodeToString(vin.Txid)]; ok {
+		if tx.IsCoinbase() && params.CoinbaseMaturity > 0 {
+			return fmt.Errorf("%w: %x:%d", ErrImmatureSpend, vin.Txid, vin.Vout)
+		}
+		return nil
+	}
+
+	outs, found, err := utxoSet.FindOutputs(vin.Txid)
+	if err != nil || !found {
+		return err
+	}
+	if !outs.IsMature(spendHeight) {
+		return fmt.Errorf("%w: %x:%d created at height %d", ErrImmatureSpend, vin.Txid, vin.Vout, outs.Height)
+	}
+
+	return nil
+}
+
+// findBlockOutput looks up the output spent by vin among the earlier
+// transactions of the block and then in the UTXO set
+func (bc *Blockchain) findBlockOutput(utxoSet UTXOSet, pending map[string]*Transaction, vin TXInput) (TXOutput, bool, error) {
+	if tx, ok := pending[hex.EncodeToString(vin.Txid)]; ok {
+		if vin.Vout < 0 || vin.Vout >= len(tx.Vout) {
+			return TXOutput{}, false, nil
+		}
+		return tx.Vout[vin.Vout], true, nil
+	}
+
+	return utxoSet.FindOutput(vin.Txid, vin.Vout)
+}
@@ -0,0 +1,179 @@
+package transaction
+
+import (
+	"bytes"
+	"errors"
+	"math"
+	"testing"
+)
+
+func TestCheckTransactionsRejectsBadValues(t *testing.T) {
+	bc, address := newMinedBlockchain(t)
+	tip, err := bc.GetBlock(bc.tip)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A negative coinbase output would let the others mint whatever it
+	// takes away, an overflowing one would wrap the sum below the subsidy
+	tests := []struct {
+		name   string
+		values []int
+	}{
+		{"negative coinbase output", []int{1000, -(1000 - CalcBlockSubsidy(1))}},
+		{"coinbase output above MaxMoney", []int{MaxMoney + 1}},
+		{"overflowing coinbase outputs", []int{math.MaxInt, math.MaxInt, 2}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			coinbase := newCoinbase(t, address, 1)
+			coinbase.Vout = nil
+			for _, value := range tt.values {
+				coinbase.Vout = append(coinbase.Vout, newOutput(t, value, address))
+			}
+			coinbase.ID = coinbase.UnsignedHash()
+
+			bits, err := bc.CalcNextBits(&tip)
+			if err != nil {
+				t.Fatal(err)
+			}
+			block := NewBlock([]*Transaction{coinbase}, tip.Hash, 1, bits)
+
+			err = bc.AddBlock(block)
+			if !errors.Is(err, ErrBadTransaction) {
+				t.Errorf("AddBlock returned %v, want %v", err, ErrBadTransaction)
+			}
+		})
+	}
+}
+
+func TestCoinbaseCommitsToHeight(t *testing.T) {
+	bc, address := newMinedBlockchain(t)
+	tip, err := bc.GetBlock(bc.tip)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The same payment in two blocks still gets two IDs
+	first, err := NewCoinbaseTX(address, "data", 1, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := NewCoinbaseTX(address, "data", 2, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Equal(first.ID, second.ID) {
+		t.Error("coinbases of different heights share an ID")
+	}
+
+	noHeight := testTransaction([]TXInput{{Vout: -1, ScriptSig: []byte("data")}}, newOutput(t, CalcBlockSubsidy(1), address))
+
+	tests := []struct {
+		name     string
+		coinbase *Transaction
+	}{
+		{"other height", second},
+		{"no height", noHeight},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bits, err := bc.CalcNextBits(&tip)
+			if err != nil {
+				t.Fatal(err)
+			}
+			block := NewBlock([]*Transaction{tt.coinbase}, tip.Hash, 1, bits)
+
+			err = bc.AddBlock(block)
+			if !errors.Is(err, ErrBadCoinbase) {
+				t.Errorf("AddBlock returned %v, want %v", err, ErrBadCoinbase)
+			}
+		})
+	}
+
+	bits, err := bc.CalcNextBits(&tip)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := bc.AddBlock(NewBlock([]*Transaction{first}, tip.Hash, 1, bits)); err != nil {
+		t.Errorf("AddBlock of a coinbase committing to its height: %v", err)
+	}
+}
+
+func TestBlockHashCoversSignatures(t *testing.T) {
+	bc, w := newFundedBlockchain(t)
+	address := string(w.GetAddress())
+	tip, err := bc.GetBlock(bc.tip)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	spend, err := NewUTXOTransaction(w, address, 10, 1, &UTXOSet{bc})
+	if err != nil {
+		t.Fatal(err)
+	}
+	genuine := mineOn(t, bc, &tip, address, spend)
+
+	// Stripping the signature keeps the transaction ID, but not the merkle
+	// root the block hash commits to
+	stripped := *spend
+	stripped.Vin = append([]TXInput(nil), spend.Vin...)
+	stripped.Vin[0].ScriptSig = nil
+	forged := *genuine
+	forged.Transactions = []*Transaction{genuine.Transactions[0], &stripped}
+
+	if !bytes.Equal(stripped.UnsignedHash(), spend.ID) {
+		t.Fatal("stripping the signature changed the transaction ID")
+	}
+	if err := bc.AddBlock(&forged); !errors.Is(err, ErrBadMerkleRoot) {
+		t.Fatalf("AddBlock of the forged block returned %v, want %v", err, ErrBadMerkleRoot)
+	}
+	if bc.HasBlock(genuine.Hash) {
+		t.Fatal("forged block stored under the genuine hash")
+	}
+
+	if err := bc.AddBlock(genuine); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(bc.tip, genuine.Hash) {
+		t.Errorf("tip %x, want the genuine block %x", bc.tip, genuine.Hash)
+	}
+}
+
+func TestValidateBlockWrapsConsensusErrors(t *testing.T) {
+	bc, address := newMinedBlockchain(t)
+	tip, err := bc.GetBlock(bc.tip)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bits, err := bc.CalcNextBits(&tip)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// An unknown parent is no fault of the block, a coinbase of the wrong
+	// height is
+	tests := []struct {
+		name    string
+		block   *Block
+		want    error
+		invalid bool
+	}{
+		{"orphan", NewBlock([]*Transaction{newCoinbase(t, address, 1)}, bytes.Repeat([]byte{0xab}, 32), 1, bits), ErrOrphan, false},
+		{"bad coinbase", NewBlock([]*Transaction{newCoinbase(t, address, 2)}, tip.Hash, 1, bits), ErrBadCoinbase, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := bc.ValidateBlock(tt.block)
+			if !errors.Is(err, tt.want) {
+				t.Fatalf("ValidateBlock returned %v, want %v", err, tt.want)
+			}
+			if errors.Is(err, ErrInvalidBlock) != tt.invalid {
+				t.Errorf("ValidateBlock returned %v, wrapping %v is %v", err, ErrInvalidBlock, !tt.invalid)
+			}
+		})
+	}
+}
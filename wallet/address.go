@@ -0,0 +1,145 @@
+package wallet
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strings"
+
+	"blockchain-app/chaincfg"
+
+	"golang.org/x/crypto/ripemd160"
+)
+
+// AddressType tells which kind of locking script an address pays to
+type AddressType int
+
+const (
+	// PubKeyHashAddress pays to the hash of a public key (P2PKH)
+	PubKeyHashAddress AddressType = iota
+
+	// ScriptHashAddress pays to the hash of a redeem script (P2SH)
+	ScriptHashAddress
+)
+
+// Address errors. Every error decoding an address wraps ErrInvalidAddress,
+// and those with a known cause also one of the others.
+var (
+	ErrInvalidAddress   = errors.New("invalid address")
+	ErrWrongNetwork     = errors.New("wrong network")
+	ErrBadChecksum      = errors.New("bad checksum")
+	ErrInvalidCharacter = errors.New("invalid character")
+)
+
+// netParams is the network whose version bytes addresses and keys use
+var netParams = &chaincfg.MainNetParams
+
+// SetNetParams selects the network addresses and keys are encoded for and
+// accepted from. Wallet files don't depend on it, their addresses follow.
+func SetNetParams(params *chaincfg.Params) {
+	netParams = params
+}
+
+// NetParams returns the network addresses and keys are encoded for
+func NetParams() *chaincfg.Params {
+	return netParams
+}
+
+// encodeAddress returns the Base58 address of a hash with a version byte
+func encodeAddress(version byte, hash []byte) []byte {
+	versionedPayload := append([]byte{version}, hash...)
+	checksum := checksum(versionedPayload)
+
+	fullPayload := append(versionedPayload, checksum...)
+
+	return Base58Encode(fullPayload)
+}
+
+// EncodeAddress returns the Base58 address of the selected network paying
+// to a hash
+func EncodeAddress(addrType AddressType, hash []byte) []byte {
+	if addrType == ScriptHashAddress {
+		return encodeAddress(netParams.ScriptHashAddrID, hash)
+	}
+
+	return encodeAddress(netParams.PubKeyHashAddrID, hash)
+}
+
+// EncodeBech32Address returns the Bech32 address of the selected network
+// paying to a hash
+func EncodeBech32Address(addrType AddressType, hash []byte) []byte {
+	if addrType == ScriptHashAddress {
+		return encodeBech32Address(netParams.Bech32HRP, bech32ScriptHashType, hash)
+	}
+
+	return encodeBech32Address(netParams.Bech32HRP, bech32PubKeyHashType, hash)
+}
+
+// DecodeAddress returns what a Base58 or Bech32 address of the selected
+// network pays to and the hash it carries
+func DecodeAddress(address string) (AddressType, []byte, error) {
+	if len(address) == 0 {
+		return 0, nil, fmt.Errorf("%w: empty address", ErrInvalidAddress)
+	}
+
+	lower := strings.ToLower(address)
+	for _, params := range chaincfg.Networks {
+		if strings.HasPrefix(lower, params.Bech32HRP+"1") {
+			return decodeBech32Address(address)
+		}
+	}
+
+	return decodeBase58Address(address)
+}
+
+// decodeBase58Address decodes a versioned and checksummed Base58 address
+func decodeBase58Address(address string) (AddressType, []byte, error) {
+	payload, err := Base58Decode([]byte(address))
+	if err != nil {
+		return 0, nil, fmt.Errorf("%w: %w: %s", ErrInvalidAddress, err, address)
+	}
+	if len(payload) != 1+ripemd160.Size+addressChecksumLen {
+		return 0, nil, fmt.Errorf("%w: %s has length %d", ErrInvalidAddress, address, len(payload))
+	}
+
+	versionedPayload := payload[:len(payload)-addressChecksumLen]
+	if !bytes.Equal(checksum(versionedPayload), payload[len(payload)-addressChecksumLen:]) {
+		return 0, nil, fmt.Errorf("%w: %w: %s", ErrInvalidAddress, ErrBadChecksum, address)
+	}
+
+	version, hash := versionedPayload[0], versionedPayload[1:]
+	switch version {
+	case netParams.PubKeyHashAddrID:
+		return PubKeyHashAddress, hash, nil
+	case netParams.ScriptHashAddrID:
+		return ScriptHashAddress, hash, nil
+	}
+
+	return 0, nil, networkError(ErrInvalidAddress, address, func(params *chaincfg.Params) bool {
+		return params.PubKeyHashAddrID == version || params.ScriptHashAddrID == version
+	})
+}
+
+// ValidateAddress checks an address of the selected network. Errors wrap
+// ErrInvalidAddress and, when the cause is known, ErrWrongNetwork,
+// ErrBadChecksum or ErrInvalidCharacter.
+func ValidateAddress(address string) error {
+	_, _, err := DecodeAddress(address)
+	return err
+}
+
+// networkError returns the error for an address or key of another network,
+// naming the networks belongs accepts
+func networkError(base error, subject string, belongs func(*chaincfg.Params) bool) error {
+	var names []string
+	for _, params := range chaincfg.Networks {
+		if params.Name != netParams.Name && belongs(params) {
+			names = append(names, params.Name)
+		}
+	}
+	if len(names) == 0 {
+		return fmt.Errorf("%w: %s has an unknown version", base, subject)
+	}
+
+	return fmt.Errorf("%w: %w: %s is for %s, not %s", base, ErrWrongNetwork, subject, strings.Join(names, " or "), netParams.Name)
+}
@@ -0,0 +1,202 @@
+package wallet
+
+import (
+	"fmt"
+	"strings"
+
+	"blockchain-app/chaincfg"
+
+	"golang.org/x/crypto/ripemd160"
+)
+
+// Bech32 parameters of BIP173, with the Bech32m checksum constant of BIP350
+const (
+	bech32Charset     = "qpzry9x8gf2tvdw0s3jn54khce6mua7l"
+	bech32MaxLen      = 90
+	bech32ChecksumLen = 6
+
+	bech32Const  = uint32(1)
+	bech32mConst = uint32(0x2bc830a3)
+)
+
+// Bech32 addresses start with their address type where SegWit addresses
+// have a witness version: 0 for public key hashes, checksummed with Bech32,
+// and 1 for script hashes, checksummed with Bech32m as BIP350 requires from
+// version 1 on. They lock outputs to the same scripts as Base58 addresses.
+const (
+	bech32PubKeyHashType = byte(0)
+	bech32ScriptHashType = byte(1)
+)
+
+var bech32Generator = [5]uint32{0x3b6a57b2, 0x26508e6d, 0x1ea119fa, 0x3d4233dd, 0x2a1462b3}
+
+// bech32Polymod computes the BCH checksum of 5 bit values
+func bech32Polymod(values []byte) uint32 {
+	chk := uint32(1)
+	for _, v := range values {
+		top := chk >> 25
+		chk = (chk&0x1ffffff)<<5 ^ uint32(v)
+		for i, g := range bech32Generator {
+			if (top>>i)&1 == 1 {
+				chk ^= g
+			}
+		}
+	}
+
+	return chk
+}
+
+// bech32HRPExpand spreads the human readable part over 5 bit values so the
+// checksum covers it
+func bech32HRPExpand(hrp string) []byte {
+	values := make([]byte, 0, 2*len(hrp)+1)
+	for i := 0; i < len(hrp); i++ {
+		values = append(values, hrp[i]>>5)
+	}
+	values = append(values, 0)
+	for i := 0; i < len(hrp); i++ {
+		values = append(values, hrp[i]&31)
+	}
+
+	return values
+}
+
+// bech32Encode returns hrp, the separator 1 and data followed by a checksum
+// made with constant, in the Bech32 alphabet
+func bech32Encode(hrp string, data []byte, constant uint32) string {
+	values := append(bech32HRPExpand(hrp), data...)
+	values = append(values, make([]byte, bech32ChecksumLen)...)
+	mod := bech32Polymod(values) ^ constant
+
+	var b strings.Builder
+	b.WriteString(hrp)
+	b.WriteByte('1')
+	for _, v := range data {
+		b.WriteByte(bech32Charset[v])
+	}
+	for i := 0; i < bech32ChecksumLen; i++ {
+		b.WriteByte(bech32Charset[(mod>>(5*(5-i)))&31])
+	}
+
+	return b.String()
+}
+
+// bech32Decode splits a Bech32 or Bech32m string into its human readable
+// part and data, returning the checksum constant it matched
+func bech32Decode(s string) (string, []byte, uint32, error) {
+	if len(s) > bech32MaxLen {
+		return "", nil, 0, fmt.Errorf("length %d over %d", len(s), bech32MaxLen)
+	}
+
+	lower := strings.ToLower(s)
+	if lower != s && strings.ToUpper(s) != s {
+		return "", nil, 0, fmt.Errorf("%w: mixed case", ErrInvalidCharacter)
+	}
+
+	sep := strings.LastIndexByte(lower, '1')
+	if sep < 1 || sep+1+bech32ChecksumLen > len(lower) {
+		return "", nil, 0, fmt.Errorf("missing separator or checksum")
+	}
+
+	hrp := lower[:sep]
+	for i := 0; i < len(hrp); i++ {
+		if hrp[i] < 33 || hrp[i] > 126 {
+			return "", nil, 0, fmt.Errorf("%w %q", ErrInvalidCharacter, hrp[i])
+		}
+	}
+
+	data := make([]byte, 0, len(lower)-sep-1)
+	for i := sep + 1; i < len(lower); i++ {
+		v := strings.IndexByte(bech32Charset, lower[i])
+		if v == -1 {
+			return "", nil, 0, fmt.Errorf("%w %q", ErrInvalidCharacter, s[i])
+		}
+		data = append(data, byte(v))
+	}
+
+	constant := bech32Polymod(append(bech32HRPExpand(hrp), data...))
+	if constant != bech32Const && constant != bech32mConst {
+		return "", nil, 0, ErrBadChecksum
+	}
+
+	return hrp, data[:len(data)-bech32ChecksumLen], constant, nil
+}
+
+// convertBits regroups data from fromBits to toBits bit values. Encoding
+// pads the last group with zeros; decoding rejects padding that isn't.
+func convertBits(data []byte, fromBits, toBits uint, pad bool) ([]byte, error) {
+	var acc uint32
+	var bits uint
+	maxValue := uint32(1)<<toBits - 1
+
+	var out []byte
+	for _, v := range data {
+		if uint32(v)>>fromBits != 0 {
+			return nil, fmt.Errorf("value %d over %d bits", v, fromBits)
+		}
+		acc = acc<<fromBits | uint32(v)
+		bits += fromBits
+		for bits >= toBits {
+			bits -= toBits
+			out = append(out, byte((acc>>bits)&maxValue))
+		}
+	}
+
+	if pad {
+		if bits > 0 {
+			out = append(out, byte((acc<<(toBits-bits))&maxValue))
+		}
+	} else if bits >= fromBits || (acc<<(toBits-bits))&maxValue != 0 {
+		return nil, fmt.Errorf("invalid padding")
+	}
+
+	return out, nil
+}
+
+// encodeBech32Address returns the Bech32 address of a hash
+func encodeBech32Address(hrp string, addrType byte, hash []byte) []byte {
+	program, _ := convertBits(hash, 8, 5, true)
+	constant := bech32Const
+	if addrType != bech32PubKeyHashType {
+		constant = bech32mConst
+	}
+
+	return []byte(bech32Encode(hrp, append([]byte{addrType}, program...), constant))
+}
+
+// decodeBech32Address returns what a Bech32 address of the selected network
+// pays to and its hash
+func decodeBech32Address(address string) (AddressType, []byte, error) {
+	hrp, data, constant, err := bech32Decode(address)
+	if err != nil {
+		return 0, nil, fmt.Errorf("%w: %w: %s", ErrInvalidAddress, err, address)
+	}
+	if hrp != netParams.Bech32HRP {
+		return 0, nil, networkError(ErrInvalidAddress, address, func(params *chaincfg.Params) bool {
+			return params.Bech32HRP == hrp
+		})
+	}
+	if len(data) == 0 {
+		return 0, nil, fmt.Errorf("%w: %s has no address type", ErrInvalidAddress, address)
+	}
+
+	var addrType AddressType
+	switch {
+	case data[0] == bech32PubKeyHashType && constant == bech32Const:
+		addrType = PubKeyHashAddress
+	case data[0] == bech32ScriptHashType && constant == bech32mConst:
+		addrType = ScriptHashAddress
+	case data[0] > bech32ScriptHashType:
+		return 0, nil, fmt.Errorf("%w: %s has unknown address type %d", ErrInvalidAddress, address, data[0])
+	default:
+		// The checksum is valid, but with the constant of the other type
+		return 0, nil, fmt.Errorf("%w: %w: %s", ErrInvalidAddress, ErrBadChecksum, address)
+	}
+
+	hash, err := convertBits(data[1:], 5, 8, false)
+	if err != nil || len(hash) != ripemd160.Size {
+		return 0, nil, fmt.Errorf("%w: %s has a bad hash", ErrInvalidAddress, address)
+	}
+
+	return addrType, hash, nil
+}
@@ -0,0 +1,193 @@
+package wallet
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"testing"
+
+	"blockchain-app/chaincfg"
+)
+
+func TestBech32ValidChecksums(t *testing.T) {
+	tests := []struct {
+		s        string
+		constant uint32
+	}{
+		// BIP173
+		{"A12UEL5L", bech32Const},
+		{"a12uel5l", bech32Const},
+		{"an83characterlonghumanreadablepartthatcontainsthenumber1andtheexcludedcharactersbio1tt5tgs", bech32Const},
+		{"abcdef1qpzry9x8gf2tvdw0s3jn54khce6mua7lmqqqxw", bech32Const},
+		{"11qqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqc8247j", bech32Const},
+		{"split1checkupstagehandshakeupstreamerranterredcaperred2y9e3w", bech32Const},
+		{"?1ezyfcl", bech32Const},
+
+		// BIP350
+		{"A1LQFN3A", bech32mConst},
+		{"a1lqfn3a", bech32mConst},
+		{"an83characterlonghumanreadablepartthatcontainsthetheexcludedcharactersbioandnumber11sg7hg6", bech32mConst},
+		{"abcdef1l7aum6echk45nj3s0wdvt2fg8x9yrzpqzd3ryx", bech32mConst},
+		{"11llllllllllllllllllllllllllllllllllllllllllllllllllllllllllllllllllllllllllllllllllludsr8", bech32mConst},
+		{"split1checkupstagehandshakeupstreamerranterredcaperredlc445v", bech32mConst},
+		{"?1v759aa", bech32mConst},
+	}
+
+	for _, tt := range tests {
+		hrp, data, constant, err := bech32Decode(tt.s)
+		if err != nil {
+			t.Errorf("bech32Decode(%q): %v", tt.s, err)
+			continue
+		}
+		if constant != tt.constant {
+			t.Errorf("bech32Decode(%q) matched constant %x, want %x", tt.s, constant, tt.constant)
+		}
+
+		// Encoding gives the lowercase form back
+		if encoded := bech32Encode(hrp, data, constant); encoded != strings.ToLower(tt.s) {
+			t.Errorf("bech32Encode of %q = %q", tt.s, encoded)
+		}
+	}
+}
+
+func TestBech32InvalidStrings(t *testing.T) {
+	tests := []struct {
+		s      string
+		reason string
+	}{
+		// BIP173
+		{"\x201nwldj5", "hrp character out of range"},
+		{"\x7f1axkwrx", "hrp character out of range"},
+		{"\x801eym55h", "hrp character out of range"},
+		{"an84characterslonghumanreadablepartthatcontainsthenumber1andtheexcludedcharactersbio1569pvx", "overall max length exceeded"},
+		{"pzry9x0s0muk", "no separator character"},
+		{"1pzry9x0s0muk", "empty hrp"},
+		{"x1b4n0q5v", "invalid data character"},
+		{"li1dgmt3", "too short checksum"},
+		{"de1lg7wt\xff", "invalid character in checksum"},
+		{"A1G7SGD8", "checksum calculated with uppercase form of hrp"},
+		{"10a06t8", "empty hrp"},
+		{"1qzzfhee", "empty hrp"},
+
+		// BIP350
+		{"\x201xj0phk", "hrp character out of range"},
+		{"\x7f1g6xzxy", "hrp character out of range"},
+		{"\x801vctc34", "hrp character out of range"},
+		{"an84characterslonghumanreadablepartthatcontainsthetheexcludedcharactersbioandnumber11d6pts4", "overall max length exceeded"},
+		{"qyrz8wqd2c9m", "no separator character"},
+		{"1qyrz8wqd2c9m", "empty hrp"},
+		{"y1b0jsk6g", "invalid data character"},
+		{"lt1igcx5c0", "invalid data character"},
+		{"in1muywd", "too short checksum"},
+		{"mm1crxm3i", "invalid character in checksum"},
+		{"au1s5cgom", "invalid character in checksum"},
+		{"M1VUXWEZ", "checksum calculated with uppercase form of hrp"},
+		{"16plkw9", "empty hrp"},
+		{"1p2gdwpf", "empty hrp"},
+
+		{"A12uEL5L", "mixed case"},
+	}
+
+	for _, tt := range tests {
+		if _, _, _, err := bech32Decode(tt.s); err == nil {
+			t.Errorf("bech32Decode(%q) succeeded, want error for %s", tt.s, tt.reason)
+		}
+	}
+}
+
+func TestDecodeBech32Address(t *testing.T) {
+	defer SetNetParams(NetParams())
+	SetNetParams(&chaincfg.MainNetParams)
+
+	// The BIP173 P2WPKH address is a version 0 address of a 20 byte hash
+	hash, err := hex.DecodeString("751e76e8199196d454941c45d1b3a323f1433bd6")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, address := range []string{"BC1QW508D6QEJXTDG4Y5R3ZARVARY0C5XW7KV8F3T4", "bc1qw508d6qejxtdg4y5r3zarvary0c5xw7kv8f3t4"} {
+		addrType, decoded, err := DecodeAddress(address)
+		if err != nil {
+			t.Fatalf("DecodeAddress(%q): %v", address, err)
+		}
+		if addrType != PubKeyHashAddress || !bytes.Equal(decoded, hash) {
+			t.Errorf("DecodeAddress(%q) = %v %x, want %v %x", address, addrType, decoded, PubKeyHashAddress, hash)
+		}
+	}
+	if encoded := string(EncodeBech32Address(PubKeyHashAddress, hash)); encoded != "bc1qw508d6qejxtdg4y5r3zarvary0c5xw7kv8f3t4" {
+		t.Errorf("EncodeBech32Address = %s", encoded)
+	}
+
+	program, err := convertBits(hash, 8, 5, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		address string
+		want    error
+	}{
+		{"script hash", bech32Encode("bc", append([]byte{bech32ScriptHashType}, program...), bech32mConst), nil},
+		{"public key hash with bech32m", bech32Encode("bc", append([]byte{bech32PubKeyHashType}, program...), bech32mConst), ErrBadChecksum},
+		{"script hash with bech32", bech32Encode("bc", append([]byte{bech32ScriptHashType}, program...), bech32Const), ErrBadChecksum},
+		{"unknown address type", bech32Encode("bc", append([]byte{2}, program...), bech32mConst), ErrInvalidAddress},
+		{"no address type", bech32Encode("bc", nil, bech32Const), ErrInvalidAddress},
+		{"short hash", bech32Encode("bc", append([]byte{bech32PubKeyHashType}, program[:len(program)-2]...), bech32Const), ErrInvalidAddress},
+		{"bad checksum", "bc1qw508d6qejxtdg4y5r3zarvary0c5xw7kv8f3t5", ErrBadChecksum},
+		{"invalid character", "bc1qw508d6qejxtdg4y5r3zarvary0c5xw7kv8f3tb", ErrInvalidCharacter},
+		{"mixed case", "bc1qw508d6qejxtdg4y5r3zarvary0c5xw7kv8f3T4", ErrInvalidCharacter},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, _, err := DecodeAddress(tt.address)
+			if tt.want == nil {
+				if err != nil {
+					t.Errorf("DecodeAddress(%q): %v", tt.address, err)
+				}
+				return
+			}
+			if !errors.Is(err, ErrInvalidAddress) || !errors.Is(err, tt.want) {
+				t.Errorf("DecodeAddress(%q) returned %v, want %v", tt.address, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestAddressesRejectedOnOtherNetworks(t *testing.T) {
+	defer SetNetParams(NetParams())
+
+	hash := HashPubKey([]byte("public key"))
+
+	for _, from := range chaincfg.Networks {
+		SetNetParams(from)
+		addresses := map[string]bool{
+			string(EncodeBech32Address(PubKeyHashAddress, hash)): true,
+			string(EncodeBech32Address(ScriptHashAddress, hash)): true,
+			string(EncodeAddress(PubKeyHashAddress, hash)):       false,
+			string(EncodeAddress(ScriptHashAddress, hash)):       false,
+		}
+
+		for _, to := range chaincfg.Networks {
+			SetNetParams(to)
+
+			for address, bech32 := range addresses {
+				err := ValidateAddress(address)
+
+				// Base58 versions are shared by the test networks, only the
+				// Bech32 prefix tells their addresses apart
+				sameVersions := from.PubKeyHashAddrID == to.PubKeyHashAddrID && from.ScriptHashAddrID == to.ScriptHashAddrID
+				if from == to || (!bech32 && sameVersions) {
+					if err != nil {
+						t.Errorf("%s address %s rejected on %s: %v", from.Name, address, to.Name, err)
+					}
+					continue
+				}
+				if !errors.Is(err, ErrInvalidAddress) || !errors.Is(err, ErrWrongNetwork) {
+					t.Errorf("%s address %s on %s returned %v, want %v", from.Name, address, to.Name, err, ErrWrongNetwork)
+				}
+			}
+		}
+	}
+}
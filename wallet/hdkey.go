@@ -0,0 +1,331 @@
+package wallet
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/sha512"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+// HardenedKeyStart is the first hardened child index. Hardened children are
+// derived from the parent private key, so leaking one child key and the
+// parent chain code doesn't expose the siblings.
+const HardenedKeyStart = uint32(0x80000000)
+
+// Master key HMAC keys of SLIP-10, which extends BIP32 to P-256 and Ed25519
+var masterKeySalts = map[KeyType][]byte{
+	KeyTypeECDSA:   []byte("Nist256p1 seed"),
+	KeyTypeEd25519: []byte("ed25519 seed"),
+}
+
+// Key derivation errors
+var (
+	ErrInvalidSeed     = errors.New("seed must be 16 to 64 bytes")
+	ErrInvalidPath     = errors.New("invalid derivation path")
+	ErrDeriveHardened  = errors.New("cannot derive a hardened child from a public key")
+	ErrNonHardenedKey  = errors.New("Ed25519 keys only have hardened children")
+	ErrPublicOnlyKey   = errors.New("extended key has no private key")
+	errInvalidChildKey = errors.New("derived key is invalid")
+)
+
+// ExtendedKey is a private or public key with the chain code needed to
+// derive its children
+type ExtendedKey struct {
+	keyType   KeyType
+	key       []byte // 32 byte private key, or serialized public key
+	chainCode []byte
+	depth     uint8
+	parentFP  []byte
+	childNum  uint32
+	private   bool
+}
+
+// NewMasterKey derives the root key of the given scheme from a seed
+func NewMasterKey(seed []byte, kt KeyType) (*ExtendedKey, error) {
+	if len(seed) < 16 || len(seed) > 64 {
+		return nil, ErrInvalidSeed
+	}
+	salt, ok := masterKeySalts[kt]
+	if !ok {
+		return nil, fmt.Errorf("%w: %d", ErrUnknownKeyType, byte(kt))
+	}
+
+	data := seed
+	for {
+		il, ir := hmacSHA512(salt, data)
+		if kt == KeyTypeEd25519 || validScalar(il) {
+			return &ExtendedKey{
+				keyType:   kt,
+				key:       il,
+				chainCode: ir,
+				parentFP:  make([]byte, 4),
+				private:   true,
+			}, nil
+		}
+		// SLIP-10 retries with the output as input on an invalid key
+		data = append(append([]byte(nil), il...), ir...)
+	}
+}
+
+// KeyType returns the signature scheme of the key
+func (k *ExtendedKey) KeyType() KeyType {
+	return k.keyType
+}
+
+// IsPrivate reports whether the key can sign and derive hardened children
+func (k *ExtendedKey) IsPrivate() bool {
+	return k.private
+}
+
+// Depth returns the number of derivation steps from the master key
+func (k *ExtendedKey) Depth() uint8 {
+	return k.depth
+}
+
+// ChildNumber returns the index the key was derived at
+func (k *ExtendedKey) ChildNumber() uint32 {
+	return k.childNum
+}
+
+// PublicKey returns the serialized public key, prefixed by its type
+func (k *ExtendedKey) PublicKey() []byte {
+	if !k.private {
+		return k.key
+	}
+	if k.keyType == KeyTypeEd25519 {
+		return Ed25519Signer{ed25519.NewKeyFromSeed(k.key)}.PublicKey()
+	}
+
+	x, y := elliptic.P256().ScalarBaseMult(k.key)
+	return elliptic.MarshalCompressed(elliptic.P256(), x, y)
+}
+
+// Fingerprint identifies the key by the first bytes of its public key hash
+func (k *ExtendedKey) Fingerprint() []byte {
+	return HashPubKey(k.PublicKey())[:4]
+}
+
+// Neuter returns the public version of the key. It derives the same
+// non-hardened public children, so it can hand out addresses it can't spend.
+func (k *ExtendedKey) Neuter() *ExtendedKey {
+	if !k.private {
+		return k
+	}
+
+	return &ExtendedKey{
+		keyType:   k.keyType,
+		key:       k.PublicKey(),
+		chainCode: k.chainCode,
+		depth:     k.depth,
+		parentFP:  k.parentFP,
+		childNum:  k.childNum,
+	}
+}
+
+// Child derives the child key at index i, hardened from HardenedKeyStart on
+func (k *ExtendedKey) Child(i uint32) (*ExtendedKey, error) {
+	hardened := i >= HardenedKeyStart
+	if hardened && !k.private {
+		return nil, ErrDeriveHardened
+	}
+	if !hardened && k.keyType == KeyTypeEd25519 {
+		return nil, ErrNonHardenedKey
+	}
+
+	var index [4]byte
+	binary.BigEndian.PutUint32(index[:], i)
+
+	var data []byte
+	if hardened {
+		data = append([]byte{0x00}, k.key...)
+	} else {
+		data = append([]byte(nil), k.PublicKey()...)
+	}
+	data = append(data, index[:]...)
+
+	for {
+		il, ir := hmacSHA512(k.chainCode, data)
+
+		childKey, err := k.childKey(il)
+		if err == nil {
+			return &ExtendedKey{
+				keyType:   k.keyType,
+				key:       childKey,
+				chainCode: ir,
+				depth:     k.depth + 1,
+				parentFP:  k.Fingerprint(),
+				childNum:  i,
+				private:   k.private,
+			}, nil
+		}
+		if err != errInvalidChildKey {
+			return nil, err
+		}
+
+		// SLIP-10 retries with 0x01 || IR || index on an invalid key
+		data = append(append([]byte{0x01}, ir...), index[:]...)
+	}
+}
+
+// childKey tweaks the key with il: Ed25519 children are il itself, P-256
+// children add il to the private key or il*G to the public key
+func (k *ExtendedKey) childKey(il []byte) ([]byte, error) {
+	if k.keyType == KeyTypeEd25519 {
+		return il, nil
+	}
+	if !validScalar(il) {
+		return nil, errInvalidChildKey
+	}
+
+	curve := elliptic.P256()
+	if k.private {
+		n := curve.Params().N
+		child := new(big.Int).SetBytes(il)
+		child.Add(child, new(big.Int).SetBytes(k.key))
+		child.Mod(child, n)
+		if child.Sign() == 0 {
+			return nil, errInvalidChildKey
+		}
+
+		return child.FillBytes(make([]byte, 32)), nil
+	}
+
+	parent, err := ParsePubKey(k.key)
+	if err != nil {
+		return nil, err
+	}
+	x, y := curve.ScalarBaseMult(il)
+	x, y = curve.Add(x, y, parent.X, parent.Y)
+	if x.Sign() == 0 && y.Sign() == 0 {
+		return nil, errInvalidChildKey
+	}
+
+	return elliptic.MarshalCompressed(curve, x, y), nil
+}
+
+// Derive follows a path of child indexes from the key
+func (k *ExtendedKey) Derive(path []uint32) (*ExtendedKey, error) {
+	key := k
+	for _, i := range path {
+		child, err := key.Child(i)
+		if err != nil {
+			return nil, err
+		}
+		key = child
+	}
+
+	return key, nil
+}
+
+// Signer returns a signer for the private key
+func (k *ExtendedKey) Signer() (Signer, error) {
+	if !k.private {
+		return nil, ErrPublicOnlyKey
+	}
+	if k.keyType == KeyTypeEd25519 {
+		return Ed25519Signer{ed25519.NewKeyFromSeed(k.key)}, nil
+	}
+
+	return ECDSASigner{ecdsaKeyFromScalar(k.key)}, nil
+}
+
+// Wallet returns a wallet holding the private key
+func (k *ExtendedKey) Wallet() (*Wallet, error) {
+	if !k.private {
+		return nil, ErrPublicOnlyKey
+	}
+
+	wallet := &Wallet{PublicKey: k.PublicKey()}
+	if k.keyType == KeyTypeEd25519 {
+		wallet.Ed25519Key = ed25519.NewKeyFromSeed(k.key)
+	} else {
+		wallet.PrivateKey = *ecdsaKeyFromScalar(k.key)
+	}
+
+	return wallet, nil
+}
+
+// wipe overwrites the key and chain code
+func (k *ExtendedKey) wipe() {
+	wipe(k.key)
+	wipe(k.chainCode)
+	k.key = nil
+	k.chainCode = nil
+}
+
+// ParsePath parses a path such as m/44'/1'/0'/0/5, where ', h or H marks
+// a hardened index
+func ParsePath(path string) ([]uint32, error) {
+	parts := strings.Split(path, "/")
+	if parts[0] != "m" {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidPath, path)
+	}
+
+	indexes := make([]uint32, 0, len(parts)-1)
+	for _, part := range parts[1:] {
+		hardened := strings.HasSuffix(part, "'") || strings.HasSuffix(part, "h") || strings.HasSuffix(part, "H")
+		if hardened {
+			part = part[:len(part)-1]
+		}
+
+		n, err := strconv.ParseUint(part, 10, 32)
+		if err != nil || uint32(n) >= HardenedKeyStart {
+			return nil, fmt.Errorf("%w: %s", ErrInvalidPath, path)
+		}
+		if hardened {
+			n += uint64(HardenedKeyStart)
+		}
+		indexes = append(indexes, uint32(n))
+	}
+
+	return indexes, nil
+}
+
+// FormatPath returns the textual form of a path, marking hardened indexes
+// with '
+func FormatPath(path []uint32) string {
+	var b strings.Builder
+	b.WriteString("m")
+	for _, i := range path {
+		if i >= HardenedKeyStart {
+			fmt.Fprintf(&b, "/%d'", i-HardenedKeyStart)
+		} else {
+			fmt.Fprintf(&b, "/%d", i)
+		}
+	}
+
+	return b.String()
+}
+
+// hmacSHA512 returns both halves of HMAC-SHA512(key, data)
+func hmacSHA512(key, data []byte) ([]byte, []byte) {
+	mac := hmac.New(sha512.New, key)
+	mac.Write(data)
+	sum := mac.Sum(nil)
+
+	return sum[:32], sum[32:]
+}
+
+// validScalar reports whether b is a valid P-256 private key, in [1, n)
+func validScalar(b []byte) bool {
+	n := new(big.Int).SetBytes(b)
+	return n.Sign() > 0 && n.Cmp(elliptic.P256().Params().N) < 0
+}
+
+// ecdsaKeyFromScalar returns the P-256 private key with the scalar d
+func ecdsaKeyFromScalar(d []byte) *ecdsa.PrivateKey {
+	curve := elliptic.P256()
+	x, y := curve.ScalarBaseMult(d)
+
+	return &ecdsa.PrivateKey{
+		PublicKey: ecdsa.PublicKey{Curve: curve, X: x, Y: y},
+		D:         new(big.Int).SetBytes(d),
+	}
+}
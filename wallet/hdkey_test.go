@@ -0,0 +1,232 @@
+package wallet
+
+import (
+	"encoding/hex"
+	"errors"
+	"testing"
+)
+
+// slip10Vector is one key of a SLIP-10 test vector chain. Public keys are in
+// SLIP-10 form, which prefixes Ed25519 keys with 0x00.
+type slip10Vector struct {
+	path      string
+	chainCode string
+	private   string
+	public    string
+}
+
+// slip10Seed is the seed of the first SLIP-10 test vector
+const slip10Seed = "000102030405060708090a0b0c0d0e0f"
+
+var slip10Vectors = map[KeyType][]slip10Vector{
+	KeyTypeECDSA: {
+		{
+			"m",
+			"beeb672fe4621673f722f38529c07392fecaa61015c80c34f29ce8b41b3cb6ea",
+			"612091aaa12e22dd2abef664f8a01a82cae99ad7441b7ef8110424915c268bc2",
+			"0266874dc6ade47b3ecd096745ca09bcd29638dd52c2c12117b11ed3e458cfa9e8",
+		},
+		{
+			"m/0'",
+			"3460cea53e6a6bb5fb391eeef3237ffd8724bf0a40e94943c98b83825342ee11",
+			"6939694369114c67917a182c59ddb8cafc3004e63ca5d3b84403ba8613debc0c",
+			"0384610f5ecffe8fda089363a41f56a5c7ffc1d81b59a612d0d649b2d22355590c",
+		},
+		{
+			"m/0'/1",
+			"4187afff1aafa8445010097fb99d23aee9f599450c7bd140b6826ac22ba21d0c",
+			"284e9d38d07d21e4e281b645089a94f4cf5a5a81369acf151a1c3a57f18b2129",
+			"03526c63f8d0b4bbbf9c80df553fe66742df4676b241dabefdef67733e070f6844",
+		},
+		{
+			"m/0'/1/2'",
+			"98c7514f562e64e74170cc3cf304ee1ce54d6b6da4f880f313e8204c2a185318",
+			"694596e8a54f252c960eb771a3c41e7e32496d03b954aeb90f61635b8e092aa7",
+			"0359cf160040778a4b14c5f4d7b76e327ccc8c4a6086dd9451b7482b5a4972dda0",
+		},
+		{
+			"m/0'/1/2'/2",
+			"ba96f776a5c3907d7fd48bde5620ee374d4acfd540378476019eab70790c63a0",
+			"5996c37fd3dd2679039b23ed6f70b506c6b56b3cb5e424681fb0fa64caf82aaa",
+			"029f871f4cb9e1c97f9f4de9ccd0d4a2f2a171110c61178f84430062230833ff20",
+		},
+		{
+			"m/0'/1/2'/2/1000000000",
+			"b9b7b82d326bb9cb5b5b121066feea4eb93d5241103c9e7a18aad40f1dde8059",
+			"21c4f269ef0a5fd1badf47eeacebeeaa3de22eb8e5b0adcd0f27dd99d34d0119",
+			"02216cd26d31147f72427a453c443ed2cde8a1e53c9cc44e5ddf739725413fe3f4",
+		},
+	},
+	KeyTypeEd25519: {
+		{
+			"m",
+			"90046a93de5380a72b5e45010748567d5ea02bbf6522f979e05c0d8d8ca9fffb",
+			"2b4be7f19ee27bbf30c667b642d5f4aa69fd169872f8fc3059c08ebae2eb19e7",
+			"00a4b2856bfec510abab89753fac1ac0e1112364e7d250545963f135f2a33188ed",
+		},
+		{
+			"m/0'",
+			"8b59aa11380b624e81507a27fedda59fea6d0b779a778918a2fd3590e16e9c69",
+			"68e0fe46dfb67e368c75379acec591dad19df3cde26e63b93a8e704f1dade7a3",
+			"008c8a13df77a28f3445213a0f432fde644acaa215fc72dcdf300d5efaa85d350c",
+		},
+		{
+			"m/0'/1'",
+			"a320425f77d1b5c2505a6b1b27382b37368ee640e3557c315416801243552f14",
+			"b1d0bad404bf35da785a64ca1ac54b2617211d2777696fbffaf208f746ae84f2",
+			"001932a5270f335bed617d5b935c80aedb1a35bd9fc1e31acafd5372c30f5c1187",
+		},
+		{
+			"m/0'/1'/2'",
+			"2e69929e00b5ab250f49c3fb1c12f252de4fed2c1db88387094a0f8c4c9ccd6c",
+			"92a5b23c0b8a99e37d07df3fb9966917f5d06e02ddbd909c7e184371463e9fc9",
+			"00ae98736566d30ed0e9d2f4486a64bc95740d89c7db33f52121f8ea8f76ff0fc1",
+		},
+		{
+			"m/0'/1'/2'/2'",
+			"8f6d87f93d750e0efccda017d662a1b31a266e4a6f5993b15f5c1f07f74dd5cc",
+			"30d1dc7e5fc04c31219ab25a27ae00b50f6fd66622f6e9c913253d6511d1e662",
+			"008abae2d66361c879b900d204ad2cc4984fa2aa344dd7ddc46007329ac76c429c",
+		},
+		{
+			"m/0'/1'/2'/2'/1000000000'",
+			"68789923a0cac2cd5a29172a475fe9e0fb14cd6adb5ad98a3fa70333e7afa230",
+			"8f94d394a8e8fd6b1bc2f3f49f5c47e385281d5c17e65324b0f62483e37e8793",
+			"003c24da049451555d51a7014a37337aa4e12d41e485abccfa46b47dfb2af54b7a",
+		},
+	},
+}
+
+func TestSLIP10Vectors(t *testing.T) {
+	seed, err := hex.DecodeString(slip10Seed)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for kt, vectors := range slip10Vectors {
+		master, err := NewMasterKey(seed, kt)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for _, v := range vectors {
+			path, err := ParsePath(v.path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			key, err := master.Derive(path)
+			if err != nil {
+				t.Fatalf("%v %s: %v", kt, v.path, err)
+			}
+
+			if got := hex.EncodeToString(key.chainCode); got != v.chainCode {
+				t.Errorf("%v %s: chain code %s, want %s", kt, v.path, got, v.chainCode)
+			}
+			if got := hex.EncodeToString(key.key); got != v.private {
+				t.Errorf("%v %s: private key %s, want %s", kt, v.path, got, v.private)
+			}
+
+			// Ed25519 keys are serialized with their own prefix byte here
+			public := key.PublicKey()
+			if kt == KeyTypeEd25519 {
+				public = append([]byte{0x00}, public[1:]...)
+			}
+			if got := hex.EncodeToString(public); got != v.public {
+				t.Errorf("%v %s: public key %s, want %s", kt, v.path, got, v.public)
+			}
+			if key.Depth() != uint8(len(path)) {
+				t.Errorf("%v %s: depth %d, want %d", kt, v.path, key.Depth(), len(path))
+			}
+		}
+	}
+}
+
+func TestNeuteredKeyDerivesPublicChildren(t *testing.T) {
+	seed, err := hex.DecodeString(slip10Seed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	master, err := NewMasterKey(seed, KeyTypeECDSA)
+	if err != nil {
+		t.Fatal(err)
+	}
+	parent, err := master.Child(HardenedKeyStart)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	private, err := parent.Child(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	public, err := parent.Neuter().Child(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if public.IsPrivate() || hex.EncodeToString(public.PublicKey()) != hex.EncodeToString(private.PublicKey()) {
+		t.Errorf("public child %x, want %x", public.PublicKey(), private.PublicKey())
+	}
+
+	if _, err := parent.Neuter().Child(HardenedKeyStart); !errors.Is(err, ErrDeriveHardened) {
+		t.Errorf("hardened child of a public key returned %v, want %v", err, ErrDeriveHardened)
+	}
+	if _, err := public.Signer(); !errors.Is(err, ErrPublicOnlyKey) {
+		t.Errorf("Signer of a public key returned %v, want %v", err, ErrPublicOnlyKey)
+	}
+}
+
+func TestEd25519KeysOnlyHaveHardenedChildren(t *testing.T) {
+	seed, err := hex.DecodeString(slip10Seed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	master, err := NewMasterKey(seed, KeyTypeEd25519)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := master.Child(0); !errors.Is(err, ErrNonHardenedKey) {
+		t.Errorf("non-hardened Ed25519 child returned %v, want %v", err, ErrNonHardenedKey)
+	}
+}
+
+func TestNewMasterKeyRejectsBadSeeds(t *testing.T) {
+	for _, size := range []int{0, 15, 65} {
+		if _, err := NewMasterKey(make([]byte, size), KeyTypeECDSA); !errors.Is(err, ErrInvalidSeed) {
+			t.Errorf("seed of %d bytes returned %v, want %v", size, err, ErrInvalidSeed)
+		}
+	}
+}
+
+func TestParsePath(t *testing.T) {
+	tests := []struct {
+		path string
+		want []uint32
+		err  bool
+	}{
+		{"m", []uint32{}, false},
+		{"m/44'/1h/0H/0/5", []uint32{HardenedKeyStart + 44, HardenedKeyStart + 1, HardenedKeyStart, 0, 5}, false},
+		{"m/2147483647'", []uint32{HardenedKeyStart + 2147483647}, false},
+		{"m/2147483648", nil, true},
+		{"44'/0", nil, true},
+		{"m/x", nil, true},
+		{"m//1", nil, true},
+	}
+
+	for _, tt := range tests {
+		path, err := ParsePath(tt.path)
+		if tt.err {
+			if !errors.Is(err, ErrInvalidPath) {
+				t.Errorf("ParsePath(%q) returned %v, want %v", tt.path, err, ErrInvalidPath)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("ParsePath(%q): %v", tt.path, err)
+			continue
+		}
+		if FormatPath(path) != FormatPath(tt.want) || len(path) != len(tt.want) {
+			t.Errorf("ParsePath(%q) = %v, want %v", tt.path, path, tt.want)
+		}
+	}
+}
@@ -0,0 +1,301 @@
+package wallet
+
+import (
+	"errors"
+	"fmt"
+)
+
+// BIP44 path levels: m/44'/coin'/account'/change/index
+const (
+	hdPurpose = 44
+
+	// HDCoinType is the SLIP-44 coin type of the chain, the one shared by
+	// all test networks
+	HDCoinType = 1
+
+	// DefaultGapLimit is how many unused addresses in a row end discovery
+	DefaultGapLimit = 20
+)
+
+// Chains of an account: receiving addresses are handed out to payers, change
+// addresses receive what's left of the wallet's own transactions
+const (
+	ExternalChain uint32 = 0
+	ChangeChain   uint32 = 1
+)
+
+// HD wallet errors
+var (
+	ErrHDWalletExists = errors.New("wallet already has a seed")
+	ErrNoHDWallet     = errors.New("wallet has no seed")
+)
+
+// HDWallet derives the keys of Wallets from a mnemonic seed phrase, so the
+// words alone restore every address handed out
+type HDWallet struct {
+	Mnemonic string
+	Seed     []byte
+	Account  uint32
+
+	// NextIndex is the next unused index of each chain, keyed by chainKey
+	NextIndex map[string]uint32
+
+	masters map[KeyType]*ExtendedKey
+}
+
+// HDWalletData is used for JSON serialization
+type HDWalletData struct {
+	Mnemonic  string            `json:"mnemonic"`
+	Seed      []byte            `json:"seed"`
+	Account   uint32            `json:"account"`
+	NextIndex map[string]uint32 `json:"next_index"`
+}
+
+// NewHDWallet returns an HD wallet seeded by a mnemonic and an optional
+// passphrase
+func NewHDWallet(mnemonic, passphrase string) (*HDWallet, error) {
+	if err := ValidateMnemonic(mnemonic); err != nil {
+		return nil, err
+	}
+
+	return &HDWallet{
+		Mnemonic:  mnemonic,
+		Seed:      MnemonicToSeed(mnemonic, passphrase),
+		NextIndex: make(map[string]uint32),
+	}, nil
+}
+
+// BIP44Path returns the path of a key. P-256 keys follow BIP44; Ed25519
+// only has hardened children, so its change and index levels are hardened.
+func BIP44Path(kt KeyType, account, change, index uint32) []uint32 {
+	path := []uint32{
+		hdPurpose + HardenedKeyStart,
+		HDCoinType + HardenedKeyStart,
+		account + HardenedKeyStart,
+		change,
+		index,
+	}
+	if kt == KeyTypeEd25519 {
+		path[3] += HardenedKeyStart
+		path[4] += HardenedKeyStart
+	}
+
+	return path
+}
+
+// chainKey names a chain in NextIndex
+func chainKey(kt KeyType, change uint32) string {
+	return fmt.Sprintf("%s/%d", kt, change)
+}
+
+// master returns the root key of a scheme
+func (hd *HDWallet) master(kt KeyType) (*ExtendedKey, error) {
+	if key, ok := hd.masters[kt]; ok {
+		return key, nil
+	}
+
+	key, err := NewMasterKey(hd.Seed, kt)
+	if err != nil {
+		return nil, err
+	}
+	if hd.masters == nil {
+		hd.masters = make(map[KeyType]*ExtendedKey)
+	}
+	hd.masters[kt] = key
+
+	return key, nil
+}
+
+// DeriveWallet returns the wallet at an index of a chain of the account
+func (hd *HDWallet) DeriveWallet(kt KeyType, change, index uint32) (*Wallet, error) {
+	master, err := hd.master(kt)
+	if err != nil {
+		return nil, err
+	}
+
+	path := BIP44Path(kt, hd.Account, change, index)
+	key, err := master.Derive(path)
+	if err != nil {
+		return nil, err
+	}
+
+	wallet, err := key.Wallet()
+	if err != nil {
+		return nil, err
+	}
+	wallet.Path = FormatPath(path)
+
+	return wallet, nil
+}
+
+// InitHD seeds the wallets from a mnemonic. Keys created before keep
+// working but can only be restored from the wallet file.
+func (ws *Wallets) InitHD(mnemonic, passphrase string) error {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+
+	return ws.initHD(mnemonic, passphrase)
+}
+
+// initHD is InitHD for callers holding mu
+func (ws *Wallets) initHD(mnemonic, passphrase string) error {
+	if ws.isLocked() {
+		return ErrWalletLocked
+	}
+	if ws.HD != nil {
+		return ErrHDWalletExists
+	}
+
+	hd, err := NewHDWallet(mnemonic, passphrase)
+	if err != nil {
+		return err
+	}
+	ws.HD = hd
+
+	return nil
+}
+
+// NextAddress derives the next address of a chain and adds its wallet. A
+// fresh 12 word seed is generated for wallets without one; it is available
+// from Mnemonic and must be written down.
+func (ws *Wallets) NextAddress(kt KeyType, change uint32) (string, error) {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+
+	if ws.isLocked() {
+		return "", ErrWalletLocked
+	}
+	if ws.HD == nil {
+		mnemonic, err := NewMnemonic(DefaultEntropyBits)
+		if err != nil {
+			return "", err
+		}
+		if err := ws.initHD(mnemonic, ""); err != nil {
+			return "", err
+		}
+	}
+
+	key := chainKey(kt, change)
+	index := ws.HD.NextIndex[key]
+
+	wallet, err := ws.HD.DeriveWallet(kt, change, index)
+	if err != nil {
+		return "", err
+	}
+	address := string(wallet.GetAddress())
+
+	ws.Wallets[address] = wallet
+	ws.HD.NextIndex[key] = index + 1
+
+	return address, nil
+}
+
+// Discover scans both chains of a scheme for addresses used reports as seen
+// on chain, stopping after gapLimit unused addresses in a row. Every address
+// up to the last used one is added and the next indexes move past it. It
+// returns the number of used addresses found.
+func (ws *Wallets) Discover(kt KeyType, gapLimit int, used func(pubKeyHash []byte) bool) (int, error) {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+
+	if ws.isLocked() {
+		return 0, ErrWalletLocked
+	}
+	if ws.HD == nil {
+		return 0, ErrNoHDWallet
+	}
+
+	found := 0
+	for _, change := range []uint32{ExternalChain, ChangeChain} {
+		var derived []*Wallet
+		next := uint32(0)
+
+		for gap := 0; gap < gapLimit; gap++ {
+			index := uint32(len(derived))
+			wallet, err := ws.HD.DeriveWallet(kt, change, index)
+			if err != nil {
+				return found, err
+			}
+			derived = append(derived, wallet)
+
+			if used(HashPubKey(wallet.PublicKey)) {
+				found++
+				next = index + 1
+				gap = -1
+			}
+		}
+
+		for _, wallet := range derived[:next] {
+			ws.Wallets[string(wallet.GetAddress())] = wallet
+		}
+
+		key := chainKey(kt, change)
+		if next > ws.HD.NextIndex[key] {
+			ws.HD.NextIndex[key] = next
+		}
+	}
+
+	return found, nil
+}
+
+// HasSeed reports whether the wallets are derived from a seed, false while
+// an encrypted seed is locked
+func (ws *Wallets) HasSeed() bool {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+
+	return ws.HD != nil
+}
+
+// Mnemonic returns the seed phrase of the wallets
+func (ws *Wallets) Mnemonic() (string, error) {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+
+	if ws.isLocked() {
+		return "", ErrWalletLocked
+	}
+	if ws.HD == nil {
+		return "", ErrNoHDWallet
+	}
+
+	return ws.HD.Mnemonic, nil
+}
+
+// wipe overwrites the seed and the master keys derived from it
+func (hd *HDWallet) wipe() {
+	wipe(hd.Seed)
+	hd.Seed = nil
+	hd.Mnemonic = ""
+
+	for kt, key := range hd.masters {
+		key.wipe()
+		delete(hd.masters, kt)
+	}
+	hd.masters = nil
+}
+
+// data returns the JSON form of the HD wallet
+func (hd *HDWallet) data() *HDWalletData {
+	return &HDWalletData{
+		Mnemonic:  hd.Mnemonic,
+		Seed:      hd.Seed,
+		Account:   hd.Account,
+		NextIndex: hd.NextIndex,
+	}
+}
+
+// hdWalletFromData rebuilds an HD wallet read from JSON
+func hdWalletFromData(data *HDWalletData) *HDWallet {
+	hd := &HDWallet{
+		Mnemonic:  data.Mnemonic,
+		Seed:      data.Seed,
+		Account:   data.Account,
+		NextIndex: data.NextIndex,
+	}
+	if hd.NextIndex == nil {
+		hd.NextIndex = make(map[string]uint32)
+	}
+
+	return hd
+}
@@ -0,0 +1,398 @@
+package wallet
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"golang.org/x/crypto/scrypt"
+)
+
+// Keystore parameters. scrypt with N = 2^15 and r = 8 takes about 32 MiB and
+// a tenth of a second per guess, AES-256-GCM authenticates the ciphertext so
+// a wrong passphrase is detected rather than yielding garbage keys.
+const (
+	keystoreVersion = 1
+	keystoreKDF     = "scrypt"
+	keystoreCipher  = "aes-256-gcm"
+	scryptN         = 1 << 15
+	scryptR         = 8
+	scryptP         = 1
+	keystoreKeyLen  = 32
+	keystoreSaltLen = 32
+)
+
+// Limits on the scrypt parameters read from a wallet file, so a tampered
+// file can't make unlocking take more than 1 GiB or run for hours
+const (
+	maxScryptN = 1 << 20
+	maxScryptR = 8
+	maxScryptP = 16
+)
+
+// Keystore errors
+var (
+	ErrWalletLocked       = errors.New("wallet is locked")
+	ErrWalletNotEncrypted = errors.New("wallet is not encrypted")
+	ErrWalletEncrypted    = errors.New("wallet is already encrypted")
+	ErrWrongPassphrase    = errors.New("wrong passphrase")
+	ErrEmptyPassphrase    = errors.New("passphrase is empty")
+	ErrBadKeystore        = errors.New("malformed keystore")
+)
+
+// keystoreData is the encrypted part of a wallet file with the parameters
+// needed to decrypt it
+type keystoreData struct {
+	Version    int    `json:"version"`
+	KDF        string `json:"kdf"`
+	N          int    `json:"n"`
+	R          int    `json:"r"`
+	P          int    `json:"p"`
+	Salt       []byte `json:"salt"`
+	Cipher     string `json:"cipher"`
+	Nonce      []byte `json:"nonce"`
+	Ciphertext []byte `json:"ciphertext"`
+}
+
+// keystore is the encryption state of Wallets, guarded by their mutex. The
+// key derived from the passphrase is kept only while the wallets are
+// unlocked.
+type keystore struct {
+	data  keystoreData
+	key   []byte
+	timer *time.Timer
+}
+
+// newKeystoreData returns fresh parameters with a random salt
+func newKeystoreData() (keystoreData, error) {
+	salt := make([]byte, keystoreSaltLen)
+	if _, err := rand.Read(salt); err != nil {
+		return keystoreData{}, err
+	}
+
+	return keystoreData{
+		Version: keystoreVersion,
+		KDF:     keystoreKDF,
+		N:       scryptN,
+		R:       scryptR,
+		P:       scryptP,
+		Salt:    salt,
+		Cipher:  keystoreCipher,
+	}, nil
+}
+
+// deriveKey stretches a passphrase into the encryption key
+func (d *keystoreData) deriveKey(passphrase string) ([]byte, error) {
+	if d.Version != keystoreVersion || d.KDF != keystoreKDF || d.Cipher != keystoreCipher {
+		return nil, fmt.Errorf("%w: version %d, %s, %s", ErrBadKeystore, d.Version, d.KDF, d.Cipher)
+	}
+	if d.N <= 1 || d.N > maxScryptN || d.N&(d.N-1) != 0 || d.R <= 0 || d.R > maxScryptR || d.P <= 0 || d.P > maxScryptP {
+		return nil, fmt.Errorf("%w: scrypt N %d, r %d, p %d", ErrBadKeystore, d.N, d.R, d.P)
+	}
+
+	return scrypt.Key([]byte(passphrase), d.Salt, d.N, d.R, d.P, keystoreKeyLen)
+}
+
+// seal encrypts plaintext under key with a fresh nonce
+func (d *keystoreData) seal(key, plaintext []byte) error {
+	aead, err := newAEAD(key)
+	if err != nil {
+		return err
+	}
+
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return err
+	}
+
+	d.Nonce = nonce
+	d.Ciphertext = aead.Seal(nil, nonce, plaintext, nil)
+
+	return nil
+}
+
+// open decrypts the ciphertext, failing with ErrWrongPassphrase when key
+// doesn't authenticate it
+func (d *keystoreData) open(key []byte) ([]byte, error) {
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(d.Nonce) != aead.NonceSize() {
+		return nil, fmt.Errorf("%w: nonce length %d", ErrBadKeystore, len(d.Nonce))
+	}
+
+	plaintext, err := aead.Open(nil, d.Nonce, d.Ciphertext, nil)
+	if err != nil {
+		return nil, ErrWrongPassphrase
+	}
+
+	return plaintext, nil
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
+
+// IsEncrypted reports whether the wallets are saved in a keystore
+func (ws *Wallets) IsEncrypted() bool {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+
+	return ws.keystore != nil
+}
+
+// IsLocked reports whether the wallets are encrypted and their private
+// keys unavailable
+func (ws *Wallets) IsLocked() bool {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+
+	return ws.isLocked()
+}
+
+// isLocked is IsLocked for callers holding mu
+func (ws *Wallets) isLocked() bool {
+	return ws.keystore != nil && ws.keystore.key == nil
+}
+
+// Encrypt protects the wallets with a passphrase from the next SaveToFile
+// on. It converts a plaintext wallet file and leaves the wallets unlocked.
+func (ws *Wallets) Encrypt(passphrase string) error {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+
+	if ws.keystore != nil {
+		return ErrWalletEncrypted
+	}
+	if passphrase == "" {
+		return ErrEmptyPassphrase
+	}
+
+	data, err := newKeystoreData()
+	if err != nil {
+		return err
+	}
+	key, err := data.deriveKey(passphrase)
+	if err != nil {
+		return err
+	}
+
+	ws.keystore = &keystore{data: data, key: key}
+
+	return nil
+}
+
+// Unlock decrypts the private keys and seed. With a positive timeout the
+// wallets lock again once it has passed; unlocking again restarts it. The
+// timeout locks from another goroutine, so until then keys should be used
+// through Signer rather than read from the Wallets fields.
+func (ws *Wallets) Unlock(passphrase string, timeout time.Duration) error {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+
+	return ws.unlock(passphrase, timeout)
+}
+
+// unlock is Unlock for callers holding mu
+func (ws *Wallets) unlock(passphrase string, timeout time.Duration) error {
+	if ws.keystore == nil {
+		return ErrWalletNotEncrypted
+	}
+
+	ks := ws.keystore
+	key, err := ks.data.deriveKey(passphrase)
+	if err != nil {
+		return err
+	}
+	plaintext, err := ks.data.open(key)
+	if err != nil {
+		return err
+	}
+
+	var fileData walletFileData
+	if err := json.Unmarshal(plaintext, &fileData); err != nil {
+		return fmt.Errorf("%w: %v", ErrBadKeystore, err)
+	}
+	if err := ws.loadFileData(fileData); err != nil {
+		return err
+	}
+	ks.key = key
+
+	if ks.timer != nil {
+		ks.timer.Stop()
+		ks.timer = nil
+	}
+	if timeout > 0 {
+		var timer *time.Timer
+		timer = time.AfterFunc(timeout, func() {
+			ws.mu.Lock()
+			defer ws.mu.Unlock()
+
+			// A timer stopped too late by Unlock or Lock leaves them be
+			if ks.timer == timer {
+				ws.lock()
+			}
+		})
+		ks.timer = timer
+	}
+
+	return nil
+}
+
+// Lock wipes the private keys, the seed and the keys derived from it from
+// memory, leaving the addresses and public keys
+func (ws *Wallets) Lock() error {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+
+	if ws.keystore == nil {
+		return ErrWalletNotEncrypted
+	}
+	ws.lock()
+
+	return nil
+}
+
+// lock is Lock for callers holding mu
+func (ws *Wallets) lock() {
+	ks := ws.keystore
+	if ks.timer != nil {
+		ks.timer.Stop()
+		ks.timer = nil
+	}
+	wipe(ks.key)
+	ks.key = nil
+
+	for _, wallet := range ws.Wallets {
+		wallet.wipe()
+	}
+	if ws.HD != nil {
+		ws.HD.wipe()
+		ws.HD = nil
+	}
+}
+
+// ChangePassphrase re-encrypts the wallets under a new passphrase with a
+// new salt. The change is written by the next SaveToFile, and the wallets
+// are left unlocked.
+func (ws *Wallets) ChangePassphrase(oldPassphrase, newPassphrase string) error {
+	if newPassphrase == "" {
+		return ErrEmptyPassphrase
+	}
+
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+
+	if err := ws.unlock(oldPassphrase, 0); err != nil {
+		return err
+	}
+
+	data, err := newKeystoreData()
+	if err != nil {
+		return err
+	}
+	key, err := data.deriveKey(newPassphrase)
+	if err != nil {
+		return err
+	}
+
+	ks := ws.keystore
+	wipe(ks.key)
+	ks.data = data
+	ks.key = key
+
+	return nil
+}
+
+// Signer returns the signer of an address, ErrWatchOnly for addresses
+// without a private key, or ErrWalletLocked while its key is encrypted.
+// Signers of encrypted wallets stop signing once the wallets lock.
+func (ws *Wallets) Signer(address string) (Signer, error) {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+
+	wallet, ok := ws.Wallets[address]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s is not in the wallet", ErrInvalidAddress, address)
+	}
+	if err := wallet.CanSign(); err != nil {
+		return nil, err
+	}
+	if ws.keystore == nil {
+		return wallet.Signer(), nil
+	}
+
+	return keystoreSigner{ws: ws, wallet: wallet, pubKey: wallet.PublicKey}, nil
+}
+
+// keystoreSigner signs with a wallet of an encrypted keystore, which the
+// unlock timeout may wipe at any time
+type keystoreSigner struct {
+	ws     *Wallets
+	wallet *Wallet
+	pubKey []byte
+}
+
+// PublicKey returns the serialized public key of the wallet
+func (s keystoreSigner) PublicKey() []byte {
+	return s.pubKey
+}
+
+// Sign signs hash unless the keystore was locked since
+func (s keystoreSigner) Sign(hash []byte) ([]byte, error) {
+	s.ws.mu.Lock()
+	defer s.ws.mu.Unlock()
+
+	if !s.wallet.HasPrivateKey() {
+		return nil, ErrWalletLocked
+	}
+
+	return s.wallet.Signer().Sign(hash)
+}
+
+// sealWallets encrypts the wallets and seed into the keystore and returns
+// what is written to disk: the keystore and the public keys. While locked
+// the existing ciphertext is kept. The caller holds the mutex of ws.
+func (ks *keystore) sealWallets(ws *Wallets) (walletFileData, error) {
+	plain := ws.fileData()
+	if ks.key != nil {
+		plaintext, err := json.Marshal(plain)
+		if err != nil {
+			return walletFileData{}, err
+		}
+		if err := ks.data.seal(ks.key, plaintext); err != nil {
+			return walletFileData{}, err
+		}
+	}
+
+	public := make(map[string]WalletData, len(plain.Wallets))
+	for address, data := range plain.Wallets {
+		public[address] = WalletData{
+			PublicKey:  data.PublicKey,
+			KeyType:    data.KeyType,
+			Path:       data.Path,
+			PubKeyHash: data.PubKeyHash,
+			WatchOnly:  data.WatchOnly,
+		}
+	}
+	keystoreCopy := ks.data
+
+	return walletFileData{Wallets: public, Keystore: &keystoreCopy}, nil
+}
+
+// wipe overwrites secret bytes
+func wipe(b []byte) {
+	for i := range b {
+		b[i] = 0
+	}
+}
@@ -0,0 +1,373 @@
+package wallet
+
+import (
+	"crypto/sha256"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+const testPassphrase = "correct horse battery staple"
+
+// newTestWallets returns empty wallets kept in a temporary directory
+func newTestWallets(t *testing.T) *Wallets {
+	t.Helper()
+
+	ws, err := NewWalletsFromFile(filepath.Join(t.TempDir(), DefaultWalletFile))
+	if err != nil && !os.IsNotExist(err) {
+		t.Fatal(err)
+	}
+
+	return ws
+}
+
+// reload reads the wallets back from their file
+func reload(t *testing.T, ws *Wallets) *Wallets {
+	t.Helper()
+
+	loaded, err := NewWalletsFromFile(ws.Path())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return loaded
+}
+
+// checkSigns fails unless the wallets sign for address with its key
+func checkSigns(t *testing.T, ws *Wallets, address string) {
+	t.Helper()
+
+	signer, err := ws.Signer(address)
+	if err != nil {
+		t.Fatalf("Signer: %v", err)
+	}
+	hash := sha256.Sum256([]byte("message"))
+	sig, err := signer.Sign(hash[:])
+	if err != nil {
+		t.Fatalf("Sign: %v", err)
+	}
+	if valid, err := Verify(signer.PublicKey(), hash[:], sig); err != nil || !valid {
+		t.Fatalf("signature does not verify: valid=%v err=%v", valid, err)
+	}
+}
+
+// checkLocked fails unless the private keys and seed of the wallets are
+// unavailable
+func checkLocked(t *testing.T, ws *Wallets, address string) {
+	t.Helper()
+
+	if !ws.IsLocked() {
+		t.Fatal("wallets are not locked")
+	}
+	if _, err := ws.Signer(address); !errors.Is(err, ErrWalletLocked) {
+		t.Errorf("Signer returned %v, want %v", err, ErrWalletLocked)
+	}
+	if _, err := ws.Mnemonic(); !errors.Is(err, ErrWalletLocked) {
+		t.Errorf("Mnemonic returned %v, want %v", err, ErrWalletLocked)
+	}
+	if _, err := ws.NextAddress(KeyTypeECDSA, ExternalChain); !errors.Is(err, ErrWalletLocked) {
+		t.Errorf("NextAddress returned %v, want %v", err, ErrWalletLocked)
+	}
+}
+
+func TestKeystoreEncryptLockUnlock(t *testing.T) {
+	ws := newTestWallets(t)
+	address, err := ws.NextAddress(KeyTypeECDSA, ExternalChain)
+	if err != nil {
+		t.Fatal(err)
+	}
+	edAddress, err := ws.NextAddress(KeyTypeEd25519, ExternalChain)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mnemonic, err := ws.Mnemonic()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := ws.Encrypt(testPassphrase); err != nil {
+		t.Fatal(err)
+	}
+	if !ws.IsEncrypted() || ws.IsLocked() {
+		t.Fatal("Encrypt should leave the wallets encrypted and unlocked")
+	}
+	checkSigns(t, ws, address)
+	ws.SaveToFile()
+
+	file, err := os.ReadFile(ws.Path())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, secret := range []string{strings.Fields(mnemonic)[0], "private_key_d", "ed25519_seed", "\"seed\""} {
+		if strings.Contains(string(file), secret) {
+			t.Errorf("encrypted wallet file contains %q", secret)
+		}
+	}
+
+	loaded := reload(t, ws)
+	if !loaded.IsEncrypted() || !loaded.HasAddress(address) || !loaded.HasAddress(edAddress) {
+		t.Fatal("encrypted file should load its addresses")
+	}
+	checkLocked(t, loaded, address)
+
+	if err := loaded.Unlock(testPassphrase, 0); err != nil {
+		t.Fatal(err)
+	}
+	checkSigns(t, loaded, address)
+	checkSigns(t, loaded, edAddress)
+	if got, err := loaded.Mnemonic(); err != nil || got != mnemonic {
+		t.Errorf("Mnemonic after Unlock = %q, %v", got, err)
+	}
+
+	signer, err := loaded.Signer(address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := loaded.Lock(); err != nil {
+		t.Fatal(err)
+	}
+	checkLocked(t, loaded, address)
+	if loaded.HasSeed() {
+		t.Error("seed kept after Lock")
+	}
+	if _, err := signer.Sign(make([]byte, 32)); !errors.Is(err, ErrWalletLocked) {
+		t.Errorf("signer of a locked wallet returned %v, want %v", err, ErrWalletLocked)
+	}
+
+	// Saving while locked keeps the ciphertext
+	loaded.SaveToFile()
+	again := reload(t, loaded)
+	if err := again.Unlock(testPassphrase, 0); err != nil {
+		t.Fatal(err)
+	}
+	checkSigns(t, again, address)
+}
+
+func TestKeystoreErrors(t *testing.T) {
+	ws := newTestWallets(t)
+	address, err := ws.NextAddress(KeyTypeECDSA, ExternalChain)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := ws.Lock(); !errors.Is(err, ErrWalletNotEncrypted) {
+		t.Errorf("Lock of a plaintext wallet returned %v, want %v", err, ErrWalletNotEncrypted)
+	}
+	if err := ws.Unlock(testPassphrase, 0); !errors.Is(err, ErrWalletNotEncrypted) {
+		t.Errorf("Unlock of a plaintext wallet returned %v, want %v", err, ErrWalletNotEncrypted)
+	}
+	if err := ws.Encrypt(""); !errors.Is(err, ErrEmptyPassphrase) {
+		t.Errorf("Encrypt with an empty passphrase returned %v, want %v", err, ErrEmptyPassphrase)
+	}
+
+	if err := ws.Encrypt(testPassphrase); err != nil {
+		t.Fatal(err)
+	}
+	if err := ws.Encrypt(testPassphrase); !errors.Is(err, ErrWalletEncrypted) {
+		t.Errorf("second Encrypt returned %v, want %v", err, ErrWalletEncrypted)
+	}
+	ws.SaveToFile()
+
+	loaded := reload(t, ws)
+	if err := loaded.Unlock("wrong passphrase", 0); !errors.Is(err, ErrWrongPassphrase) {
+		t.Errorf("Unlock with a wrong passphrase returned %v, want %v", err, ErrWrongPassphrase)
+	}
+	checkLocked(t, loaded, address)
+}
+
+func TestKeystoreRejectsBadScryptParameters(t *testing.T) {
+	// Each would fail or make deriving the key run away before the
+	// passphrase is even checked
+	tests := []struct {
+		name    string
+		n, r, p int
+	}{
+		{"N of 1", 1, scryptR, scryptP},
+		{"N not a power of two", 3 << 10, scryptR, scryptP},
+		{"huge N", 1 << 30, scryptR, scryptP},
+		{"zero r", scryptN, 0, scryptP},
+		{"huge r", scryptN, 1 << 20, scryptP},
+		{"negative p", scryptN, scryptR, -1},
+		{"huge p", scryptN, scryptR, 1 << 20},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d, err := newKeystoreData()
+			if err != nil {
+				t.Fatal(err)
+			}
+			d.N, d.R, d.P = tt.n, tt.r, tt.p
+
+			if _, err := d.deriveKey(testPassphrase); !errors.Is(err, ErrBadKeystore) {
+				t.Errorf("deriveKey returned %v, want %v", err, ErrBadKeystore)
+			}
+		})
+	}
+}
+
+func TestKeystoreUnlockTimeout(t *testing.T) {
+	ws := newTestWallets(t)
+	address, err := ws.NextAddress(KeyTypeECDSA, ExternalChain)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ws.Encrypt(testPassphrase); err != nil {
+		t.Fatal(err)
+	}
+	ws.SaveToFile()
+	loaded := reload(t, ws)
+
+	if err := loaded.Unlock(testPassphrase, 50*time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	checkSigns(t, loaded, address)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for !loaded.IsLocked() {
+		if time.Now().After(deadline) {
+			t.Fatal("wallets still unlocked after the timeout")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	checkLocked(t, loaded, address)
+
+	// Unlocking again without a timeout cancels the earlier one
+	if err := loaded.Unlock(testPassphrase, 50*time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	if err := loaded.Unlock(testPassphrase, 0); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(200 * time.Millisecond)
+	if loaded.IsLocked() {
+		t.Fatal("cancelled timeout locked the wallets")
+	}
+	checkSigns(t, loaded, address)
+}
+
+func TestKeystoreTimeoutRacesWalletUse(t *testing.T) {
+	ws := newTestWallets(t)
+	address, err := ws.NextAddress(KeyTypeECDSA, ExternalChain)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ws.Encrypt(testPassphrase); err != nil {
+		t.Fatal(err)
+	}
+	ws.SaveToFile()
+
+	if err := ws.Unlock(testPassphrase, 20*time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	signer, err := ws.Signer(address)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Run with -race: the timeout wipes keys while they are in use
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for !ws.IsLocked() {
+				_, err := signer.Sign(make([]byte, 32))
+				if err != nil && !errors.Is(err, ErrWalletLocked) {
+					t.Error(err)
+					return
+				}
+				_, err = ws.NextAddress(KeyTypeECDSA, ChangeChain)
+				if err != nil && !errors.Is(err, ErrWalletLocked) {
+					t.Error(err)
+					return
+				}
+				ws.GetAddresses()
+			}
+		}()
+	}
+	wg.Wait()
+
+	checkLocked(t, ws, address)
+}
+
+func TestKeystoreChangePassphrase(t *testing.T) {
+	ws := newTestWallets(t)
+	address, err := ws.NextAddress(KeyTypeECDSA, ExternalChain)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ws.Encrypt(testPassphrase); err != nil {
+		t.Fatal(err)
+	}
+	ws.SaveToFile()
+
+	loaded := reload(t, ws)
+	if err := loaded.ChangePassphrase("wrong passphrase", "new passphrase"); !errors.Is(err, ErrWrongPassphrase) {
+		t.Errorf("ChangePassphrase with a wrong passphrase returned %v, want %v", err, ErrWrongPassphrase)
+	}
+	if err := loaded.ChangePassphrase(testPassphrase, ""); !errors.Is(err, ErrEmptyPassphrase) {
+		t.Errorf("ChangePassphrase to an empty passphrase returned %v, want %v", err, ErrEmptyPassphrase)
+	}
+	if err := loaded.ChangePassphrase(testPassphrase, "new passphrase"); err != nil {
+		t.Fatal(err)
+	}
+	if loaded.IsLocked() {
+		t.Error("ChangePassphrase left the wallets locked")
+	}
+	loaded.SaveToFile()
+
+	changed := reload(t, loaded)
+	if err := changed.Unlock(testPassphrase, 0); !errors.Is(err, ErrWrongPassphrase) {
+		t.Errorf("Unlock with the old passphrase returned %v, want %v", err, ErrWrongPassphrase)
+	}
+	if err := changed.Unlock("new passphrase", 0); err != nil {
+		t.Fatal(err)
+	}
+	checkSigns(t, changed, address)
+}
+
+func TestKeystoreMigratesPlaintextFile(t *testing.T) {
+	ws := newTestWallets(t)
+	address, err := ws.NextAddress(KeyTypeECDSA, ExternalChain)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mnemonic, err := ws.Mnemonic()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ws.SaveToFile()
+
+	plaintext := reload(t, ws)
+	if plaintext.IsEncrypted() || plaintext.IsLocked() {
+		t.Fatal("plaintext file loaded as encrypted")
+	}
+	if err := plaintext.Encrypt(testPassphrase); err != nil {
+		t.Fatal(err)
+	}
+	plaintext.SaveToFile()
+
+	file, err := os.ReadFile(ws.Path())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(file), mnemonic) || strings.Contains(string(file), "private_key_d") {
+		t.Error("migrated wallet file still holds plaintext secrets")
+	}
+
+	encrypted := reload(t, plaintext)
+	checkLocked(t, encrypted, address)
+	if err := encrypted.Unlock(testPassphrase, 0); err != nil {
+		t.Fatal(err)
+	}
+	checkSigns(t, encrypted, address)
+	if got, err := encrypted.Mnemonic(); err != nil || got != mnemonic {
+		t.Errorf("Mnemonic after migration = %q, %v", got, err)
+	}
+}
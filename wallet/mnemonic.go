@@ -0,0 +1,138 @@
+package wallet
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/sha512"
+	_ "embed"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/pbkdf2"
+)
+
+// bip39English is the BIP39 English word list, one word per line
+//
+//go:embed bip39_english.txt
+var bip39English string
+
+// Mnemonic word list, and each word's index in it
+var (
+	wordList  = strings.Fields(bip39English)
+	wordIndex = indexWords(wordList)
+)
+
+// Mnemonic sizes, in bits of entropy. 128 bits give 12 words, 256 bits 24.
+const (
+	MinEntropyBits     = 128
+	MaxEntropyBits     = 256
+	DefaultEntropyBits = 128
+)
+
+// seedIterations is the PBKDF2 round count turning a mnemonic into a seed
+const seedIterations = 2048
+
+// ErrInvalidMnemonic is returned for phrases with unknown words, a wrong
+// length or a bad checksum
+var ErrInvalidMnemonic = errors.New("invalid mnemonic")
+
+func indexWords(words []string) map[string]int {
+	index := make(map[string]int, len(words))
+	for i, word := range words {
+		index[word] = i
+	}
+
+	return index
+}
+
+// NewMnemonic returns a phrase encoding bits of fresh entropy
+func NewMnemonic(bits int) (string, error) {
+	if bits < MinEntropyBits || bits > MaxEntropyBits || bits%32 != 0 {
+		return "", fmt.Errorf("%w: entropy must be 128 to 256 bits in steps of 32, got %d", ErrInvalidMnemonic, bits)
+	}
+
+	entropy := make([]byte, bits/8)
+	if _, err := rand.Read(entropy); err != nil {
+		return "", err
+	}
+
+	return EntropyToMnemonic(entropy)
+}
+
+// EntropyToMnemonic encodes entropy followed by the first bits of its
+// SHA-256 hash, one bit per 32 bits of entropy, as words of 11 bits each
+func EntropyToMnemonic(entropy []byte) (string, error) {
+	bits := len(entropy) * 8
+	if bits < MinEntropyBits || bits > MaxEntropyBits || bits%32 != 0 {
+		return "", fmt.Errorf("%w: entropy must be 16 to 32 bytes in steps of 4, got %d", ErrInvalidMnemonic, len(entropy))
+	}
+
+	hash := sha256.Sum256(entropy)
+	data := append(append([]byte(nil), entropy...), hash[0])
+	total := bits + bits/32
+
+	words := make([]string, total/11)
+	for i := range words {
+		index := 0
+		for j := 0; j < 11; j++ {
+			bit := i*11 + j
+			index = index<<1 | int(data[bit/8]>>(7-bit%8)&1)
+		}
+		words[i] = wordList[index]
+	}
+
+	return strings.Join(words, " "), nil
+}
+
+// MnemonicToEntropy decodes a phrase and checks its checksum
+func MnemonicToEntropy(mnemonic string) ([]byte, error) {
+	words := strings.Fields(mnemonic)
+	if len(words) < 12 || len(words) > 24 || len(words)%3 != 0 {
+		return nil, fmt.Errorf("%w: %d words", ErrInvalidMnemonic, len(words))
+	}
+
+	total := len(words) * 11
+	bits := total * 32 / 33
+	data := make([]byte, (total+7)/8)
+
+	for i, word := range words {
+		index, ok := wordIndex[word]
+		if !ok {
+			return nil, fmt.Errorf("%w: unknown word %q", ErrInvalidMnemonic, word)
+		}
+		for j := 0; j < 11; j++ {
+			if index>>(10-j)&1 == 1 {
+				bit := i*11 + j
+				data[bit/8] |= 1 << (7 - bit%8)
+			}
+		}
+	}
+
+	entropy := data[:bits/8]
+	hash := sha256.Sum256(entropy)
+	checksumBits := total - bits
+	mask := byte(0xff << (8 - checksumBits))
+	if data[bits/8]&mask != hash[0]&mask {
+		return nil, fmt.Errorf("%w: bad checksum", ErrInvalidMnemonic)
+	}
+
+	return entropy, nil
+}
+
+// ValidateMnemonic reports whether a phrase is well formed
+func ValidateMnemonic(mnemonic string) error {
+	_, err := MnemonicToEntropy(mnemonic)
+	return err
+}
+
+// MnemonicToSeed stretches a phrase and an optional passphrase into the 64
+// byte seed master keys are derived from. Any passphrase gives a valid but
+// different wallet, so it has to be remembered along with the words. The
+// passphrase is used as given, without Unicode normalization.
+func MnemonicToSeed(mnemonic, passphrase string) []byte {
+	password := strings.Join(strings.Fields(mnemonic), " ")
+	salt := "mnemonic" + passphrase
+
+	return pbkdf2.Key([]byte(password), []byte(salt), seedIterations, 64, sha512.New)
+}
@@ -0,0 +1,111 @@
+package wallet
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"testing"
+)
+
+// bip39Vectors are the reference vectors of the BIP39 English word list,
+// with seeds derived under the passphrase "TREZOR"
+var bip39Vectors = []struct {
+	entropy  string
+	mnemonic string
+	seed     string
+}{
+	{
+		"00000000000000000000000000000000",
+		"abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon about",
+		"c55257c360c07c72029aebc1b53c05ed0362ada38ead3e3e9efa3708e53495531f09a6987599d18264c1e1c92f2cf141630c7a3c4ab7c81b2f001698e7463b04",
+	},
+	{
+		"7f7f7f7f7f7f7f7f7f7f7f7f7f7f7f7f",
+		"legal winner thank year wave sausage worth useful legal winner thank yellow",
+		"2e8905819b8723fe2c1d161860e5ee1830318dbf49a83bd451cfb8440c28bd6fa457fe1296106559a3c80937a1c1069be3a3a5bd381ee6260e8d9739fce1f607",
+	},
+	{
+		"80808080808080808080808080808080",
+		"letter advice cage absurd amount doctor acoustic avoid letter advice cage above",
+		"d71de856f81a8acc65e6fc851a38d4d7ec216fd0796d0a6827a3ad6ed5511a30fa280f12eb2e47ed2ac03b5c462a0358d18d69fe4f985ec81778c1b370b652a8",
+	},
+	{
+		"ffffffffffffffffffffffffffffffff",
+		"zoo zoo zoo zoo zoo zoo zoo zoo zoo zoo zoo wrong",
+		"ac27495480225222079d7be181583751e86f571027b0497b5b5d11218e0a8a13332572917f0f8e5a589620c6f15b11c61dee327651a14c34e18231052e48c069",
+	},
+	{
+		"0000000000000000000000000000000000000000000000000000000000000000",
+		"abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon art",
+		"bda85446c68413707090a52022edd26a1c9462295029f2e60cd7c4f2bbd3097170af7a4d73245cafa9c3cca8d561a7c3de6f5d4a10be8ed2a5e608d68f92fcc8",
+	},
+}
+
+func TestMnemonicVectors(t *testing.T) {
+	for _, v := range bip39Vectors {
+		entropy, err := hex.DecodeString(v.entropy)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		mnemonic, err := EntropyToMnemonic(entropy)
+		if err != nil {
+			t.Fatalf("EntropyToMnemonic(%s): %v", v.entropy, err)
+		}
+		if mnemonic != v.mnemonic {
+			t.Errorf("EntropyToMnemonic(%s) = %q, want %q", v.entropy, mnemonic, v.mnemonic)
+		}
+
+		decoded, err := MnemonicToEntropy(v.mnemonic)
+		if err != nil {
+			t.Fatalf("MnemonicToEntropy(%q): %v", v.mnemonic, err)
+		}
+		if !bytes.Equal(decoded, entropy) {
+			t.Errorf("MnemonicToEntropy(%q) = %x, want %s", v.mnemonic, decoded, v.entropy)
+		}
+
+		if seed := hex.EncodeToString(MnemonicToSeed(v.mnemonic, "TREZOR")); seed != v.seed {
+			t.Errorf("MnemonicToSeed(%q) = %s, want %s", v.mnemonic, seed, v.seed)
+		}
+	}
+}
+
+func TestMnemonicToSeedNormalizesSpaces(t *testing.T) {
+	v := bip39Vectors[0]
+
+	spaced := "  " + strings.ReplaceAll(v.mnemonic, " ", " \t ") + "\n"
+	if seed := hex.EncodeToString(MnemonicToSeed(spaced, "TREZOR")); seed != v.seed {
+		t.Errorf("seed of the respaced phrase is %s, want %s", seed, v.seed)
+	}
+}
+
+func TestValidateMnemonicRejectsInvalidPhrases(t *testing.T) {
+	tests := []struct {
+		name     string
+		mnemonic string
+	}{
+		{"bad checksum", "abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon"},
+		{"unknown word", "abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abou"},
+		{"too few words", "abandon abandon abandon abandon abandon abandon abandon abandon about"},
+		{"not a multiple of three words", "abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon about"},
+		{"too many words", strings.Repeat("abandon ", 26) + "about"},
+		{"empty", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := ValidateMnemonic(tt.mnemonic); !errors.Is(err, ErrInvalidMnemonic) {
+				t.Errorf("ValidateMnemonic returned %v, want %v", err, ErrInvalidMnemonic)
+			}
+		})
+	}
+}
+
+func TestEntropyToMnemonicRejectsBadSizes(t *testing.T) {
+	for _, size := range []int{0, 12, 17, 36} {
+		if _, err := EntropyToMnemonic(make([]byte, size)); !errors.Is(err, ErrInvalidMnemonic) {
+			t.Errorf("EntropyToMnemonic of %d bytes returned %v, want %v", size, err, ErrInvalidMnemonic)
+		}
+	}
+}
@@ -0,0 +1,37 @@
+package wallet
+
+import "blockchain-app/script"
+
+// MultiSig describes an M-of-N shared address. It holds no private keys,
+// every key holder signs with their own Wallet.
+type MultiSig struct {
+	Required     int
+	PubKeys      [][]byte
+	RedeemScript []byte
+}
+
+// NewMultiSig builds the redeem script requiring required signatures out of
+// pubKeys. Signatures must later be given in the order of pubKeys.
+func NewMultiSig(required int, pubKeys [][]byte) (*MultiSig, error) {
+	redeemScript, err := script.MultiSigScript(required, pubKeys)
+	if err != nil {
+		return nil, err
+	}
+
+	return &MultiSig{required, pubKeys, redeemScript}, nil
+}
+
+// ScriptHash returns the hash of the redeem script locked into P2SH outputs
+func (ms *MultiSig) ScriptHash() []byte {
+	return script.Hash160(ms.RedeemScript)
+}
+
+// GetAddress returns the P2SH address of the multisig redeem script
+func (ms *MultiSig) GetAddress() []byte {
+	return EncodeAddress(ScriptHashAddress, ms.ScriptHash())
+}
+
+// GetBech32Address returns the P2SH address in Bech32m
+func (ms *MultiSig) GetBech32Address() []byte {
+	return EncodeBech32Address(ScriptHashAddress, ms.ScriptHash())
+}
@@ -0,0 +1,160 @@
+package wallet
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"math/big"
+)
+
+// Signature and public key encoding errors
+var (
+	ErrNonCanonicalSignature = errors.New("signature is not canonical DER")
+	ErrHighS                 = errors.New("signature S value is not low")
+	ErrInvalidPubKey         = errors.New("public key is not a compressed SEC1 point")
+)
+
+// compressedPubKeyLen is the length of a SEC1 compressed P-256 point
+const compressedPubKeyLen = 33
+
+// maxDERSignatureLen is the longest DER signature of two P-256 scalars
+const maxDERSignatureLen = 72
+
+// SerializePubKey returns the SEC1 compressed encoding of a public key
+func SerializePubKey(pubKey *ecdsa.PublicKey) []byte {
+	return elliptic.MarshalCompressed(pubKey.Curve, pubKey.X, pubKey.Y)
+}
+
+// ParsePubKey decodes a SEC1 compressed public key and checks that it lies
+// on the curve
+func ParsePubKey(data []byte) (*ecdsa.PublicKey, error) {
+	if len(data) != compressedPubKeyLen || (data[0] != 0x02 && data[0] != 0x03) {
+		return nil, ErrInvalidPubKey
+	}
+
+	curve := elliptic.P256()
+	x, y := elliptic.UnmarshalCompressed(curve, data)
+	if x == nil {
+		return nil, ErrInvalidPubKey
+	}
+
+	return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+}
+
+// Sign signs hash and returns the DER signature with a low S value. Both
+// (r, s) and (r, n-s) verify, so only the lower one is accepted to keep
+// signatures, and the transaction IDs covering them, non-malleable.
+func Sign(privKey *ecdsa.PrivateKey, hash []byte) ([]byte, error) {
+	r, s, err := ecdsa.Sign(rand.Reader, privKey, hash)
+	if err != nil {
+		return nil, err
+	}
+
+	halfOrder := new(big.Int).Rsh(privKey.Curve.Params().N, 1)
+	if s.Cmp(halfOrder) > 0 {
+		s.Sub(privKey.Curve.Params().N, s)
+	}
+
+	return encodeDER(r, s), nil
+}
+
+// verifyECDSA checks a DER signature of hash against a compressed public
+// key. Encodings that are not canonical or carry a high S value return an
+// error rather than false, they are never valid however the key matches.
+func verifyECDSA(pubKey, hash, sig []byte) (bool, error) {
+	r, s, err := ParseSignature(sig)
+	if err != nil {
+		return false, err
+	}
+
+	key, err := ParsePubKey(pubKey)
+	if err != nil {
+		return false, err
+	}
+
+	return ecdsa.Verify(key, hash, r, s), nil
+}
+
+// ParseSignature strictly decodes a DER signature: minimal lengths and
+// integers, no trailing data, and a low S value
+func ParseSignature(sig []byte) (*big.Int, *big.Int, error) {
+	// 0x30 <len> 0x02 <lenR> <R> 0x02 <lenS> <S>
+	if len(sig) < 8 || len(sig) > maxDERSignatureLen {
+		return nil, nil, fmt.Errorf("%w: length %d", ErrNonCanonicalSignature, len(sig))
+	}
+	if sig[0] != 0x30 || int(sig[1]) != len(sig)-2 {
+		return nil, nil, fmt.Errorf("%w: bad sequence header", ErrNonCanonicalSignature)
+	}
+
+	r, rest, err := parseDERInteger(sig[2:])
+	if err != nil {
+		return nil, nil, err
+	}
+	s, rest, err := parseDERInteger(rest)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(rest) != 0 {
+		return nil, nil, fmt.Errorf("%w: trailing data", ErrNonCanonicalSignature)
+	}
+
+	params := elliptic.P256().Params()
+	if r.Cmp(params.N) >= 0 || s.Cmp(params.N) >= 0 {
+		return nil, nil, fmt.Errorf("%w: scalar out of range", ErrNonCanonicalSignature)
+	}
+	if s.Cmp(new(big.Int).Rsh(params.N, 1)) > 0 {
+		return nil, nil, ErrHighS
+	}
+
+	return r, s, nil
+}
+
+// parseDERInteger reads a positive, minimally encoded DER integer
+func parseDERInteger(data []byte) (*big.Int, []byte, error) {
+	if len(data) < 3 || data[0] != 0x02 {
+		return nil, nil, fmt.Errorf("%w: missing integer", ErrNonCanonicalSignature)
+	}
+
+	size := int(data[1])
+	if size == 0 || len(data) < 2+size {
+		return nil, nil, fmt.Errorf("%w: bad integer length", ErrNonCanonicalSignature)
+	}
+
+	value := data[2 : 2+size]
+	if value[0]&0x80 != 0 {
+		return nil, nil, fmt.Errorf("%w: negative integer", ErrNonCanonicalSignature)
+	}
+	if size > 1 && value[0] == 0x00 && value[1]&0x80 == 0 {
+		return nil, nil, fmt.Errorf("%w: integer is not minimal", ErrNonCanonicalSignature)
+	}
+
+	n := new(big.Int).SetBytes(value)
+	if n.Sign() == 0 {
+		return nil, nil, fmt.Errorf("%w: zero integer", ErrNonCanonicalSignature)
+	}
+
+	return n, data[2+size:], nil
+}
+
+// encodeDER encodes r and s as a DER sequence of two integers
+func encodeDER(r, s *big.Int) []byte {
+	rb := derInteger(r)
+	sb := derInteger(s)
+
+	sig := []byte{0x30, byte(len(rb) + len(sb))}
+	sig = append(sig, rb...)
+
+	return append(sig, sb...)
+}
+
+// derInteger encodes a positive integer, padding it so the sign bit is clear
+func derInteger(n *big.Int) []byte {
+	value := n.Bytes()
+	if len(value) == 0 || value[0]&0x80 != 0 {
+		value = append([]byte{0x00}, value...)
+	}
+
+	return append([]byte{0x02, byte(len(value))}, value...)
+}
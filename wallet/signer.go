@@ -0,0 +1,205 @@
+package wallet
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"fmt"
+)
+
+// KeyType identifies a signature scheme. Serialized public keys start with
+// a byte telling their type apart, and since addresses and scripts commit to
+// the whole key, the verifier always knows which algorithm to run.
+type KeyType byte
+
+// Supported key types
+const (
+	// KeyTypeECDSA is ECDSA over P-256 with SEC1 compressed keys, whose
+	// first byte is 0x02 or 0x03
+	KeyTypeECDSA KeyType = iota
+
+	// KeyTypeEd25519 is Ed25519 with keys serialized as 0xed followed by
+	// the 32 byte public key
+	KeyTypeEd25519
+)
+
+// ed25519PubKeyPrefix is the first byte of a serialized Ed25519 public key
+const ed25519PubKeyPrefix = 0xed
+
+// ErrUnknownKeyType is returned for public keys of no supported scheme
+var ErrUnknownKeyType = errors.New("unknown key type")
+
+// String returns the name of the key type as accepted by ParseKeyType
+func (kt KeyType) String() string {
+	switch kt {
+	case KeyTypeECDSA:
+		return "ecdsa"
+	case KeyTypeEd25519:
+		return "ed25519"
+	}
+
+	return fmt.Sprintf("unknown(%d)", byte(kt))
+}
+
+// ParseKeyType returns the key type with the given name
+func ParseKeyType(name string) (KeyType, error) {
+	switch name {
+	case "ecdsa":
+		return KeyTypeECDSA, nil
+	case "ed25519":
+		return KeyTypeEd25519, nil
+	}
+
+	return 0, fmt.Errorf("%w: %s", ErrUnknownKeyType, name)
+}
+
+// KeyTypeOf returns the scheme of a serialized public key from its first byte
+func KeyTypeOf(pubKey []byte) (KeyType, error) {
+	if len(pubKey) == 0 {
+		return 0, ErrUnknownKeyType
+	}
+
+	switch pubKey[0] {
+	case 0x02, 0x03:
+		return KeyTypeECDSA, nil
+	case ed25519PubKeyPrefix:
+		return KeyTypeEd25519, nil
+	}
+
+	return 0, fmt.Errorf("%w: prefix %02x", ErrUnknownKeyType, pubKey[0])
+}
+
+// Signer signs hashes with the private key of one scheme
+type Signer interface {
+	// PublicKey returns the serialized public key, prefixed by its type
+	PublicKey() []byte
+	Sign(hash []byte) ([]byte, error)
+}
+
+// Verifier checks signatures of one scheme. Malformed keys or signatures
+// return an error, a well formed signature that doesn't match returns false.
+type Verifier interface {
+	// CheckSignatureEncoding reports an error unless sig is well formed
+	CheckSignatureEncoding(sig []byte) error
+	Verify(pubKey, hash, sig []byte) (bool, error)
+}
+
+// verifiers lists every supported scheme by key type
+var verifiers = map[KeyType]Verifier{
+	KeyTypeECDSA:   ECDSAVerifier{},
+	KeyTypeEd25519: Ed25519Verifier{},
+}
+
+// ECDSASigner signs with a P-256 key
+type ECDSASigner struct {
+	Key *ecdsa.PrivateKey
+}
+
+// PublicKey returns the compressed public key
+func (s ECDSASigner) PublicKey() []byte {
+	return SerializePubKey(&s.Key.PublicKey)
+}
+
+// Sign returns a low-S DER signature of hash
+func (s ECDSASigner) Sign(hash []byte) ([]byte, error) {
+	return Sign(s.Key, hash)
+}
+
+// ECDSAVerifier verifies low-S DER signatures against compressed P-256 keys
+type ECDSAVerifier struct{}
+
+// CheckSignatureEncoding checks that sig is strict low-S DER
+func (ECDSAVerifier) CheckSignatureEncoding(sig []byte) error {
+	_, _, err := ParseSignature(sig)
+	return err
+}
+
+// Verify checks a DER signature of hash
+func (ECDSAVerifier) Verify(pubKey, hash, sig []byte) (bool, error) {
+	return verifyECDSA(pubKey, hash, sig)
+}
+
+// Ed25519Signer signs with an Ed25519 key
+type Ed25519Signer struct {
+	Key ed25519.PrivateKey
+}
+
+// NewEd25519Key generates a new Ed25519 private key
+func NewEd25519Key() (ed25519.PrivateKey, error) {
+	_, private, err := ed25519.GenerateKey(rand.Reader)
+	return private, err
+}
+
+// PublicKey returns the prefixed Ed25519 public key
+func (s Ed25519Signer) PublicKey() []byte {
+	pubKey := s.Key.Public().(ed25519.PublicKey)
+	return append([]byte{ed25519PubKeyPrefix}, pubKey...)
+}
+
+// Sign returns the 64 byte Ed25519 signature of hash
+func (s Ed25519Signer) Sign(hash []byte) ([]byte, error) {
+	return ed25519.Sign(s.Key, hash), nil
+}
+
+// Ed25519Verifier verifies Ed25519 signatures. The standard library rejects
+// non-canonical S values, so signatures are not malleable.
+type Ed25519Verifier struct{}
+
+// CheckSignatureEncoding checks the length of sig
+func (Ed25519Verifier) CheckSignatureEncoding(sig []byte) error {
+	if len(sig) != ed25519.SignatureSize {
+		return fmt.Errorf("%w: Ed25519 signature length %d", ErrNonCanonicalSignature, len(sig))
+	}
+
+	return nil
+}
+
+// Verify checks an Ed25519 signature of hash
+func (v Ed25519Verifier) Verify(pubKey, hash, sig []byte) (bool, error) {
+	if len(pubKey) != 1+ed25519.PublicKeySize || pubKey[0] != ed25519PubKeyPrefix {
+		return false, fmt.Errorf("%w: bad Ed25519 key", ErrInvalidPubKey)
+	}
+	if err := v.CheckSignatureEncoding(sig); err != nil {
+		return false, err
+	}
+
+	return ed25519.Verify(ed25519.PublicKey(pubKey[1:]), hash, sig), nil
+}
+
+// VerifierFor returns the verifier of a key type
+func VerifierFor(kt KeyType) (Verifier, error) {
+	verifier, ok := verifiers[kt]
+	if !ok {
+		return nil, fmt.Errorf("%w: %d", ErrUnknownKeyType, byte(kt))
+	}
+
+	return verifier, nil
+}
+
+// Verify checks sig of hash with the scheme named by the first byte of
+// pubKey. A signature well formed for another scheme doesn't match rather
+// than being malformed, as happens when multisig tries it against each key.
+func Verify(pubKey, hash, sig []byte) (bool, error) {
+	kt, err := KeyTypeOf(pubKey)
+	if err != nil {
+		return false, err
+	}
+
+	verifier, err := VerifierFor(kt)
+	if err != nil {
+		return false, err
+	}
+
+	err = verifier.CheckSignatureEncoding(sig)
+	if err != nil {
+		for other, v := range verifiers {
+			if other != kt && v.CheckSignatureEncoding(sig) == nil {
+				return false, nil
+			}
+		}
+		return false, err
+	}
+
+	return verifier.Verify(pubKey, hash, sig)
+}
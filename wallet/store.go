@@ -0,0 +1,186 @@
+package wallet
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// walletFileExt is the extension of named wallet files
+const walletFileExt = ".json"
+
+// Named wallet errors
+var (
+	ErrInvalidWalletName = errors.New("invalid wallet name")
+	ErrWalletExists      = errors.New("wallet already exists")
+	ErrWalletNotFound    = errors.New("wallet not found")
+	ErrWalletNotLoaded   = errors.New("wallet is not loaded")
+)
+
+// walletNamePattern restricts names to what is safe as a file name
+var walletNamePattern = regexp.MustCompile(`^[A-Za-z0-9_-]{1,64}$`)
+
+// Store keeps named wallets as files in the wallets directory of a data
+// directory and tracks which of them are loaded. Its methods are safe for
+// concurrent use.
+type Store struct {
+	dir string
+
+	mu     sync.Mutex
+	loaded map[string]*Wallets
+}
+
+// NewStore returns the store of the wallets in dataDir
+func NewStore(dataDir string) *Store {
+	return &Store{
+		dir:    filepath.Join(dataDir, "wallets"),
+		loaded: make(map[string]*Wallets),
+	}
+}
+
+// Dir returns the directory holding the wallet files
+func (s *Store) Dir() string {
+	return s.dir
+}
+
+// path returns the file of a named wallet
+func (s *Store) path(name string) (string, error) {
+	if !walletNamePattern.MatchString(name) {
+		return "", fmt.Errorf("%w: %q", ErrInvalidWalletName, name)
+	}
+
+	return filepath.Join(s.dir, name+walletFileExt), nil
+}
+
+// Create makes an empty wallet file under a new name and loads it
+func (s *Store) Create(name string) (*Wallets, error) {
+	path, err := s.path(name)
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	err = os.MkdirAll(s.dir, 0700)
+	if err != nil {
+		return nil, err
+	}
+
+	// Claim the name so concurrent creates can't both succeed
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if os.IsExist(err) {
+		return nil, fmt.Errorf("%w: %s", ErrWalletExists, name)
+	}
+	if err != nil {
+		return nil, err
+	}
+	file.Close()
+
+	wallets := &Wallets{Wallets: make(map[string]*Wallet), path: path}
+	err = wallets.save()
+	if err != nil {
+		os.Remove(path)
+		return nil, err
+	}
+	s.loaded[name] = wallets
+
+	return wallets, nil
+}
+
+// Load reads a wallet file, or returns the wallet if it is already loaded
+func (s *Store) Load(name string) (*Wallets, error) {
+	path, err := s.path(name)
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if wallets, ok := s.loaded[name]; ok {
+		return wallets, nil
+	}
+
+	wallets, err := NewWalletsFromFile(path)
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("%w: %s", ErrWalletNotFound, name)
+	}
+	if err != nil {
+		return nil, err
+	}
+	s.loaded[name] = wallets
+
+	return wallets, nil
+}
+
+// Get returns a loaded wallet
+func (s *Store) Get(name string) (*Wallets, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	wallets, ok := s.loaded[name]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrWalletNotLoaded, name)
+	}
+
+	return wallets, nil
+}
+
+// Unload forgets a loaded wallet, locking it first if it is encrypted
+func (s *Store) Unload(name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	wallets, ok := s.loaded[name]
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrWalletNotLoaded, name)
+	}
+	if wallets.IsEncrypted() {
+		wallets.Lock()
+	}
+	delete(s.loaded, name)
+
+	return nil
+}
+
+// List returns the names of all wallet files, sorted
+func (s *Store) List() ([]string, error) {
+	entries, err := os.ReadDir(s.dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var names []string
+	for _, entry := range entries {
+		name := strings.TrimSuffix(entry.Name(), walletFileExt)
+		if entry.Type().IsRegular() && name != entry.Name() && walletNamePattern.MatchString(name) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	return names, nil
+}
+
+// Loaded returns the names of the loaded wallets, sorted
+func (s *Store) Loaded() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	names := make([]string, 0, len(s.loaded))
+	for name := range s.loaded {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
@@ -1,41 +1,78 @@
 package wallet
 
 import (
+	"bytes"
 	"crypto/ecdsa"
+	"crypto/ed25519"
 	"crypto/elliptic"
 	"crypto/rand"
 	"crypto/sha256"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"log"
 	"math/big"
 	"os"
+	"path/filepath"
+	"sync"
 
 	"golang.org/x/crypto/ripemd160"
 )
 
-const version = byte(0x00)
 const addressChecksumLen = 4
-const walletFile = "wallet.json"
 
-// Wallet stores private and public keys
+// DefaultWalletFile is the wallet file used when no path is given
+const DefaultWalletFile = "wallet.json"
+
+// Wallet stores private and public keys. ECDSA wallets keep their key in
+// PrivateKey, Ed25519 wallets in Ed25519Key; wallets of a locked keystore
+// have only their public key. Keys derived from the seed of Wallets record
+// their derivation path. Watch-only wallets track an address without its
+// private key, from the public key or only its hash in PubKeyHash.
 type Wallet struct {
 	PrivateKey ecdsa.PrivateKey
 	PublicKey  []byte
+	Ed25519Key ed25519.PrivateKey
+	Path       string
+	PubKeyHash []byte
+	WatchOnly  bool
 }
 
 // WalletData is used for JSON serialization
 type WalletData struct {
-	PrivateKeyD []byte `json:"private_key_d"`
-	PrivateKeyX []byte `json:"private_key_x"`
-	PrivateKeyY []byte `json:"private_key_y"`
+	PrivateKeyD []byte `json:"private_key_d,omitempty"`
+	PrivateKeyX []byte `json:"private_key_x,omitempty"`
+	PrivateKeyY []byte `json:"private_key_y,omitempty"`
 	PublicKey   []byte `json:"public_key"`
+	KeyType     string `json:"key_type,omitempty"`
+	Ed25519Seed []byte `json:"ed25519_seed,omitempty"`
+	Path        string `json:"path,omitempty"`
+	PubKeyHash  []byte `json:"pub_key_hash,omitempty"`
+	WatchOnly   bool   `json:"watch_only,omitempty"`
+}
+
+// walletFileData is the layout of the wallet file. Files written before
+// seeds existed hold only the map of wallets. Encrypted files hold the
+// public keys in Wallets and everything else in Keystore.
+type walletFileData struct {
+	HD       *HDWalletData         `json:"hd,omitempty"`
+	Wallets  map[string]WalletData `json:"wallets"`
+	Keystore *keystoreData         `json:"keystore,omitempty"`
 }
 
-// Wallets stores a collection of wallets
+// Wallets stores a collection of wallets, derived from the seed in HD,
+// optionally encrypted with a passphrase, in the file at path
 type Wallets struct {
 	Wallets map[string]*Wallet
+	HD      *HDWallet
+
+	path     string
+	keystore *keystore
+
+	// mu guards Wallets, HD and keystore, which the unlock timeout wipes
+	// from its own goroutine
+	mu sync.Mutex
 }
 
 // Base58 alphabet
@@ -56,8 +93,8 @@ func Base58Encode(input []byte) []byte {
 		result = append(result, b58Alphabet[mod.Int64()])
 	}
 
-	// Add leading zeros
-	if input[0] == 0x00 {
+	// Add a 1 for each leading zero byte
+	for i := 0; i < len(input) && input[i] == 0x00; i++ {
 		result = append(result, b58Alphabet[0])
 	}
 
@@ -69,36 +106,35 @@ func Base58Encode(input []byte) []byte {
 	return result
 }
 
-// Base58Decode decodes a Base58 string to a byte array
-func Base58Decode(input []byte) []byte {
+// Base58Decode decodes a Base58 string to a byte array. Characters outside
+// the alphabet give an error wrapping ErrInvalidCharacter.
+func Base58Decode(input []byte) ([]byte, error) {
+	if len(input) == 0 {
+		return nil, errors.New("empty Base58 string")
+	}
+
 	result := big.NewInt(0)
 
 	for _, b := range input {
-		charIndex := -1
-		for i, c := range b58Alphabet {
-			if c == b {
-				charIndex = i
-				break
-			}
-		}
+		charIndex := bytes.IndexByte(b58Alphabet, b)
 		if charIndex == -1 {
-			log.Panic("Invalid character in Base58 string")
+			return nil, fmt.Errorf("%w %q in Base58 string", ErrInvalidCharacter, b)
 		}
 		result.Mul(result, big.NewInt(58))
 		result.Add(result, big.NewInt(int64(charIndex)))
 	}
 
-	decoded := result.Bytes()
-
-	// Add leading zeros
-	if input[0] == b58Alphabet[0] {
-		decoded = append([]byte{0x00}, decoded...)
+	// Each leading 1 stands for a leading zero byte
+	zeros := 0
+	for zeros < len(input) && input[zeros] == b58Alphabet[0] {
+		zeros++
 	}
+	decoded := append(make([]byte, zeros), result.Bytes()...)
 
-	return decoded
+	return decoded, nil
 }
 
-// NewKeyPair generates a new private/public key pair
+// NewKeyPair generates a new private key and its compressed public key
 func NewKeyPair() (ecdsa.PrivateKey, []byte) {
 	curve := elliptic.P256()
 	private, err := ecdsa.GenerateKey(curve, rand.Reader)
@@ -106,29 +142,106 @@ func NewKeyPair() (ecdsa.PrivateKey, []byte) {
 		log.Panic(err)
 	}
 
-	pubKey := append(private.PublicKey.X.Bytes(), private.PublicKey.Y.Bytes()...)
+	pubKey := SerializePubKey(&private.PublicKey)
 	return *private, pubKey
 }
 
-// NewWallet creates and returns a Wallet
+// NewWallet creates and returns an ECDSA Wallet
 func NewWallet() *Wallet {
 	private, public := NewKeyPair()
-	wallet := Wallet{private, public}
+	wallet := Wallet{PrivateKey: private, PublicKey: public}
 
 	return &wallet
 }
 
-// GetAddress returns wallet address
-func (w Wallet) GetAddress() []byte {
-	pubKeyHash := HashPubKey(w.PublicKey)
+// NewWalletWithKeyType creates a Wallet signing with the given scheme
+func NewWalletWithKeyType(kt KeyType) (*Wallet, error) {
+	switch kt {
+	case KeyTypeECDSA:
+		return NewWallet(), nil
+	case KeyTypeEd25519:
+		private, err := NewEd25519Key()
+		if err != nil {
+			return nil, err
+		}
+		wallet := &Wallet{Ed25519Key: private}
+		wallet.PublicKey = wallet.Signer().PublicKey()
 
-	versionedPayload := append([]byte{version}, pubKeyHash...)
-	checksum := checksum(versionedPayload)
+		return wallet, nil
+	}
 
-	fullPayload := append(versionedPayload, checksum...)
-	address := Base58Encode(fullPayload)
+	return nil, fmt.Errorf("%w: %d", ErrUnknownKeyType, byte(kt))
+}
 
-	return address
+// KeyType returns the signature scheme of the wallet
+func (w Wallet) KeyType() KeyType {
+	kt, err := KeyTypeOf(w.PublicKey)
+	if err != nil {
+		return KeyTypeECDSA
+	}
+
+	return kt
+}
+
+// KeyHash returns the public key hash the address of the wallet pays to
+func (w Wallet) KeyHash() []byte {
+	if w.PublicKey == nil {
+		return w.PubKeyHash
+	}
+
+	return HashPubKey(w.PublicKey)
+}
+
+// CanSign returns ErrWatchOnly for watch-only wallets and ErrWalletLocked
+// for wallets whose key is encrypted
+func (w Wallet) CanSign() error {
+	if w.WatchOnly {
+		return ErrWatchOnly
+	}
+	if !w.HasPrivateKey() {
+		return ErrWalletLocked
+	}
+
+	return nil
+}
+
+// HasPrivateKey reports whether the wallet can sign
+func (w Wallet) HasPrivateKey() bool {
+	if w.KeyType() == KeyTypeEd25519 {
+		return w.Ed25519Key != nil
+	}
+
+	return w.PrivateKey.D != nil && w.PrivateKey.D.Sign() != 0
+}
+
+// wipe overwrites the private key and drops it
+func (w *Wallet) wipe() {
+	wipe(w.Ed25519Key)
+	w.Ed25519Key = nil
+	if w.PrivateKey.D != nil {
+		w.PrivateKey.D.SetInt64(0)
+	}
+	w.PrivateKey = ecdsa.PrivateKey{}
+}
+
+// Signer returns the signer for the private key of the wallet
+func (w *Wallet) Signer() Signer {
+	if w.Ed25519Key != nil {
+		return Ed25519Signer{w.Ed25519Key}
+	}
+
+	return ECDSASigner{&w.PrivateKey}
+}
+
+// GetAddress returns wallet address
+func (w Wallet) GetAddress() []byte {
+	return EncodeAddress(PubKeyHashAddress, w.KeyHash())
+}
+
+// GetBech32Address returns the wallet address in Bech32, paying to the same
+// key as GetAddress
+func (w Wallet) GetBech32Address() []byte {
+	return EncodeBech32Address(PubKeyHashAddress, w.KeyHash())
 }
 
 // HashPubKey hashes public key
@@ -145,23 +258,6 @@ func HashPubKey(pubKey []byte) []byte {
 	return publicRIPEMD160
 }
 
-// ValidateAddress validates wallet address
-func ValidateAddress(address string) bool {
-	pubKeyHash := Base58Decode([]byte(address))
-	actualChecksum := pubKeyHash[len(pubKeyHash)-addressChecksumLen:]
-	version := pubKeyHash[0]
-	pubKeyHash = pubKeyHash[1 : len(pubKeyHash)-addressChecksumLen]
-	targetChecksum := checksum(append([]byte{version}, pubKeyHash...))
-
-	for i, b := range actualChecksum {
-		if b != targetChecksum[i] {
-			return false
-		}
-	}
-
-	return true
-}
-
 // checksum generates a checksum for a public key
 func checksum(payload []byte) []byte {
 	firstSHA := sha256.Sum256(payload)
@@ -170,9 +266,16 @@ func checksum(payload []byte) []byte {
 	return secondSHA[:addressChecksumLen]
 }
 
-// NewWallets creates Wallets and fills it from a file if it exists
+// NewWallets creates Wallets and fills it from DefaultWalletFile if it
+// exists
 func NewWallets() (*Wallets, error) {
-	wallets := Wallets{}
+	return NewWalletsFromFile(DefaultWalletFile)
+}
+
+// NewWalletsFromFile creates Wallets kept in the file at path and fills it
+// from the file if it exists
+func NewWalletsFromFile(path string) (*Wallets, error) {
+	wallets := Wallets{path: path}
 	wallets.Wallets = make(map[string]*Wallet)
 
 	err := wallets.LoadFromFile()
@@ -180,18 +283,42 @@ func NewWallets() (*Wallets, error) {
 	return &wallets, err
 }
 
-// CreateWallet adds a Wallet to Wallets
-func (ws *Wallets) CreateWallet() string {
-	wallet := NewWallet()
-	address := fmt.Sprintf("%s", wallet.GetAddress())
+// NewWalletsInDir creates Wallets kept in DefaultWalletFile in dataDir, so
+// nodes with their own data directories don't share a wallet
+func NewWalletsInDir(dataDir string) (*Wallets, error) {
+	return NewWalletsFromFile(filepath.Join(dataDir, DefaultWalletFile))
+}
+
+// Path returns the file the wallets are loaded from and saved to
+func (ws *Wallets) Path() string {
+	if ws.path == "" {
+		return DefaultWalletFile
+	}
+
+	return ws.path
+}
 
-	ws.Wallets[address] = wallet
+// CreateWallet adds the next derived ECDSA receiving address to Wallets
+func (ws *Wallets) CreateWallet() string {
+	address, err := ws.CreateWalletWithKeyType(KeyTypeECDSA)
+	if err != nil {
+		log.Panic(err)
+	}
 
 	return address
 }
 
+// CreateWalletWithKeyType adds the next derived receiving address of the
+// given scheme
+func (ws *Wallets) CreateWalletWithKeyType(kt KeyType) (string, error) {
+	return ws.NextAddress(kt, ExternalChain)
+}
+
 // GetAddresses returns an array of addresses stored in the wallet file
 func (ws *Wallets) GetAddresses() []string {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+
 	var addresses []string
 
 	for address := range ws.Wallets {
@@ -203,30 +330,101 @@ func (ws *Wallets) GetAddresses() []string {
 
 // GetWallet returns a Wallet by its address
 func (ws *Wallets) GetWallet(address string) Wallet {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+
 	return *ws.Wallets[address]
 }
 
-// LoadFromFile loads wallets from the file
+// HasAddress reports whether an address is in the wallets
+func (ws *Wallets) HasAddress(address string) bool {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+
+	_, ok := ws.Wallets[address]
+	return ok
+}
+
+// LoadFromFile loads wallets from the file. Encrypted files load locked,
+// with addresses and public keys only.
 func (ws *Wallets) LoadFromFile() error {
-	if _, err := os.Stat(walletFile); os.IsNotExist(err) {
+	if _, err := os.Stat(ws.Path()); os.IsNotExist(err) {
 		return err
 	}
 
-	fileContent, err := ioutil.ReadFile(walletFile)
+	fileContent, err := ioutil.ReadFile(ws.Path())
 	if err != nil {
-		log.Panic(err)
+		return fmt.Errorf("failed to read wallet file %s: %w", ws.Path(), err)
 	}
 
-	var walletsData map[string]WalletData
-	err = json.Unmarshal(fileContent, &walletsData)
+	var fileData walletFileData
+	err = json.Unmarshal(fileContent, &fileData)
 	if err != nil {
-		log.Panic(err)
+		return fmt.Errorf("failed to decode wallet file %s: %w", ws.Path(), err)
+	}
+
+	if fileData.Wallets == nil {
+		err = json.Unmarshal(fileContent, &fileData.Wallets)
+		if err != nil {
+			return fmt.Errorf("failed to decode wallet file %s: %w", ws.Path(), err)
+		}
+	}
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+
+	if fileData.Keystore != nil {
+		ws.keystore = &keystore{data: *fileData.Keystore}
+	}
+
+	err = ws.loadFileData(fileData)
+	if err != nil {
+		return fmt.Errorf("failed to load wallet file %s: %w", ws.Path(), err)
+	}
+
+	return nil
+}
+
+// loadFileData adds the wallets and seed of decoded file data, replacing
+// wallets with the same address. The caller holds mu.
+func (ws *Wallets) loadFileData(fileData walletFileData) error {
+	if fileData.HD != nil {
+		ws.HD = hdWalletFromData(fileData.HD)
 	}
 
-	for address, walletData := range walletsData {
+	for address, walletData := range fileData.Wallets {
+		address, wallet, err := walletFromData(address, walletData)
+		if err != nil {
+			return err
+		}
+		ws.Wallets[address] = wallet
+	}
+
+	return nil
+}
+
+// walletFromData rebuilds a wallet read from JSON, returning its address
+func walletFromData(address string, walletData WalletData) (string, *Wallet, error) {
+	wallet := &Wallet{
+		PublicKey:  walletData.PublicKey,
+		Path:       walletData.Path,
+		PubKeyHash: walletData.PubKeyHash,
+		WatchOnly:  walletData.WatchOnly,
+	}
+
+	switch {
+	case walletData.KeyType == KeyTypeEd25519.String():
+		if walletData.Ed25519Seed == nil {
+			break
+		}
+		if len(walletData.Ed25519Seed) != ed25519.SeedSize {
+			return "", nil, fmt.Errorf("wallet %s has a bad Ed25519 seed", address)
+		}
+		wallet.Ed25519Key = ed25519.NewKeyFromSeed(walletData.Ed25519Seed)
+
+	case walletData.PrivateKeyD != nil:
 		// Reconstruct the private key
 		curve := elliptic.P256()
-		privateKey := &ecdsa.PrivateKey{
+		wallet.PrivateKey = ecdsa.PrivateKey{
 			PublicKey: ecdsa.PublicKey{
 				Curve: curve,
 				X:     new(big.Int).SetBytes(walletData.PrivateKeyX),
@@ -235,40 +433,122 @@ func (ws *Wallets) LoadFromFile() error {
 			D: new(big.Int).SetBytes(walletData.PrivateKeyD),
 		}
 
-		wallet := &Wallet{
-			PrivateKey: *privateKey,
-			PublicKey:  walletData.PublicKey,
+		// Files written before public keys were compressed store X||Y and
+		// an address derived from it
+		if len(walletData.PublicKey) != compressedPubKeyLen {
+			wallet.PublicKey = SerializePubKey(&wallet.PrivateKey.PublicKey)
 		}
+	}
 
-		ws.Wallets[address] = wallet
+	// Addresses are recomputed for the selected network, so the same file
+	// serves every network
+	if wallet.KeyHash() != nil {
+		address = string(wallet.GetAddress())
 	}
 
-	return nil
+	return address, wallet, nil
 }
 
-// SaveToFile saves wallets to a file
-func (ws Wallets) SaveToFile() {
+// fileData returns the wallets and seed in their JSON form. The caller
+// holds mu.
+func (ws *Wallets) fileData() walletFileData {
 	walletsData := make(map[string]WalletData)
 
 	for address, wallet := range ws.Wallets {
 		walletData := WalletData{
-			PrivateKeyD: wallet.PrivateKey.D.Bytes(),
-			PrivateKeyX: wallet.PrivateKey.PublicKey.X.Bytes(),
-			PrivateKeyY: wallet.PrivateKey.PublicKey.Y.Bytes(),
-			PublicKey:   wallet.PublicKey,
+			PublicKey:  wallet.PublicKey,
+			Path:       wallet.Path,
+			PubKeyHash: wallet.PubKeyHash,
+			WatchOnly:  wallet.WatchOnly,
+		}
+
+		if wallet.KeyType() == KeyTypeEd25519 {
+			walletData.KeyType = KeyTypeEd25519.String()
+			if wallet.Ed25519Key != nil {
+				walletData.Ed25519Seed = wallet.Ed25519Key.Seed()
+			}
+		} else if wallet.HasPrivateKey() {
+			walletData.PrivateKeyD = wallet.PrivateKey.D.Bytes()
+			walletData.PrivateKeyX = wallet.PrivateKey.PublicKey.X.Bytes()
+			walletData.PrivateKeyY = wallet.PrivateKey.PublicKey.Y.Bytes()
 		}
 		walletsData[address] = walletData
 	}
 
-	jsonData, err := json.MarshalIndent(walletsData, "", "  ")
+	fileData := walletFileData{Wallets: walletsData}
+	if ws.HD != nil {
+		fileData.HD = ws.HD.data()
+	}
+
+	return fileData
+}
+
+// SaveToFile saves wallets to their file, readable only by its owner.
+// Encrypted wallets are sealed in the keystore; while locked the existing
+// ciphertext is kept.
+func (ws *Wallets) SaveToFile() {
+	err := ws.save()
 	if err != nil {
 		log.Panic(err)
 	}
+}
+
+// save writes the wallet file, replacing it atomically so a crash leaves
+// either the old or the new file
+func (ws *Wallets) save() error {
+	var fileData walletFileData
 
-	err = ioutil.WriteFile(walletFile, jsonData, 0644)
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+
+	if ws.keystore != nil {
+		sealed, err := ws.keystore.sealWallets(ws)
+		if err != nil {
+			return err
+		}
+		fileData = sealed
+	} else {
+		fileData = ws.fileData()
+	}
+
+	jsonData, err := json.MarshalIndent(fileData, "", "  ")
 	if err != nil {
-		log.Panic(err)
+		return err
 	}
+
+	return WriteFileAtomic(ws.Path(), jsonData, 0600)
+}
+
+// WriteFileAtomic writes data to a temporary file next to path, flushes it
+// to disk and renames it over path, so readers never see a partial file
+func WriteFileAtomic(path string, data []byte, perm os.FileMode) error {
+	dir := filepath.Dir(path)
+	err := os.MkdirAll(dir, 0700)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(dir, filepath.Base(path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	_, err = tmp.Write(data)
+	if err == nil {
+		err = tmp.Chmod(perm)
+	}
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), path)
 }
 
 // HashPubKey from Wallet struct method - moved here for package access
@@ -0,0 +1,40 @@
+package wallet
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLoadCorruptWalletFile(t *testing.T) {
+	// "AQID" is a three byte Ed25519 seed
+	tests := []struct {
+		name    string
+		content string
+	}{
+		{"truncated", `{"wallets": {`},
+		{"not an object", `[1, 2, 3]`},
+		{"bad seed", `{"wallets": {"addr": {"public_key": "", "key_type": "ed25519", "ed25519_seed": "AQID"}}}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			path := filepath.Join(dir, "wallets", "alice"+walletFileExt)
+			if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.WriteFile(path, []byte(tt.content), 0600); err != nil {
+				t.Fatal(err)
+			}
+
+			if _, err := NewWalletsFromFile(path); err == nil || os.IsNotExist(err) {
+				t.Errorf("NewWalletsFromFile returned %v, want a load error", err)
+			}
+			if _, err := NewStore(dir).Load("alice"); err == nil || errors.Is(err, ErrWalletNotFound) {
+				t.Errorf("Load returned %v, want a load error", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,195 @@
+package wallet
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"errors"
+	"fmt"
+
+	"blockchain-app/chaincfg"
+)
+
+// Key suffixes of wallet import format. As in Bitcoin 0x01 marks a key whose
+// public key is compressed, which all ECDSA keys here are; Ed25519 seeds are
+// marked like their public keys.
+const (
+	wifCompressed = byte(0x01)
+	wifEd25519    = byte(0xed)
+	wifKeyLen     = 32
+)
+
+// Import and export errors
+var (
+	ErrInvalidWIF    = errors.New("invalid WIF private key")
+	ErrWatchOnly     = errors.New("address is watch-only")
+	ErrAddressExists = errors.New("address is already in the wallet")
+)
+
+// ExportWIF returns the private key of the wallet in wallet import format:
+// the Base58 encoding of the version of the selected network, the 32 byte
+// key, the key suffix and a checksum
+func (w Wallet) ExportWIF() (string, error) {
+	if err := w.CanSign(); err != nil {
+		return "", err
+	}
+
+	payload := make([]byte, 0, 1+wifKeyLen+1+addressChecksumLen)
+	payload = append(payload, netParams.PrivateKeyID)
+	if w.KeyType() == KeyTypeEd25519 {
+		payload = append(payload, w.Ed25519Key.Seed()...)
+		payload = append(payload, wifEd25519)
+	} else {
+		payload = append(payload, w.PrivateKey.D.FillBytes(make([]byte, wifKeyLen))...)
+		payload = append(payload, wifCompressed)
+	}
+	payload = append(payload, checksum(payload)...)
+
+	return string(Base58Encode(payload)), nil
+}
+
+// ParseWIF returns the wallet of a private key in wallet import format. Keys
+// of other networks are refused with an error wrapping ErrWrongNetwork.
+func ParseWIF(wif string) (*Wallet, error) {
+	payload, err := Base58Decode([]byte(wif))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidWIF, err)
+	}
+	if len(payload) != 1+wifKeyLen+1+addressChecksumLen {
+		return nil, fmt.Errorf("%w: length %d", ErrInvalidWIF, len(payload))
+	}
+
+	versionedPayload := payload[:len(payload)-addressChecksumLen]
+	if !bytes.Equal(checksum(versionedPayload), payload[len(payload)-addressChecksumLen:]) {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidWIF, ErrBadChecksum)
+	}
+	if version := versionedPayload[0]; version != netParams.PrivateKeyID {
+		return nil, networkError(ErrInvalidWIF, "key", func(params *chaincfg.Params) bool {
+			return params.PrivateKeyID == version
+		})
+	}
+
+	key := versionedPayload[1 : 1+wifKeyLen]
+	switch versionedPayload[1+wifKeyLen] {
+	case wifCompressed:
+		if !validScalar(key) {
+			return nil, fmt.Errorf("%w: key out of range", ErrInvalidWIF)
+		}
+		private := ecdsaKeyFromScalar(key)
+
+		return &Wallet{PrivateKey: *private, PublicKey: SerializePubKey(&private.PublicKey)}, nil
+
+	case wifEd25519:
+		wallet := &Wallet{Ed25519Key: ed25519.NewKeyFromSeed(key)}
+		wallet.PublicKey = wallet.Signer().PublicKey()
+
+		return wallet, nil
+	}
+
+	return nil, fmt.Errorf("%w: key suffix %02x", ErrInvalidWIF, versionedPayload[1+wifKeyLen])
+}
+
+// ImportWIF adds the wallet of a private key in wallet import format and
+// returns its address. A watch-only entry of the same address becomes
+// spendable.
+func (ws *Wallets) ImportWIF(wif string) (string, error) {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+
+	if ws.isLocked() {
+		return "", ErrWalletLocked
+	}
+
+	wallet, err := ParseWIF(wif)
+	if err != nil {
+		return "", err
+	}
+	address := string(wallet.GetAddress())
+
+	if existing, ok := ws.Wallets[address]; ok && !existing.WatchOnly {
+		return "", fmt.Errorf("%w: %s", ErrAddressExists, address)
+	}
+	ws.Wallets[address] = wallet
+
+	return address, nil
+}
+
+// ExportWIF returns the private key of an address in wallet import format,
+// ErrWatchOnly for addresses without one, or ErrWalletLocked while it is
+// encrypted
+func (ws *Wallets) ExportWIF(address string) (string, error) {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+
+	wallet, ok := ws.Wallets[address]
+	if !ok {
+		return "", fmt.Errorf("%w: %s is not in the wallet", ErrInvalidAddress, address)
+	}
+
+	return wallet.ExportWIF()
+}
+
+// ImportPubKey adds a watch-only entry for a serialized public key and
+// returns its address. It replaces a watch-only entry holding only the hash.
+// Watch-only entries need no passphrase, so they may be added while locked.
+func (ws *Wallets) ImportPubKey(pubKey []byte) (string, error) {
+	if err := checkPubKey(pubKey); err != nil {
+		return "", err
+	}
+
+	wallet := &Wallet{PublicKey: pubKey, WatchOnly: true}
+	address := string(wallet.GetAddress())
+
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+
+	if existing, ok := ws.Wallets[address]; ok && (!existing.WatchOnly || existing.PublicKey != nil) {
+		return "", fmt.Errorf("%w: %s", ErrAddressExists, address)
+	}
+	ws.Wallets[address] = wallet
+
+	return address, nil
+}
+
+// ImportAddress adds a watch-only entry for a P2PKH address, keeping only
+// the public key hash it pays to, and returns its Base58 address. Bech32
+// addresses are accepted too.
+func (ws *Wallets) ImportAddress(address string) (string, error) {
+	addrType, pubKeyHash, err := DecodeAddress(address)
+	if err != nil {
+		return "", err
+	}
+	if addrType != PubKeyHashAddress {
+		return "", fmt.Errorf("%w: %s is not a key address", ErrInvalidAddress, address)
+	}
+
+	wallet := &Wallet{PubKeyHash: pubKeyHash, WatchOnly: true}
+	address = string(wallet.GetAddress())
+
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+
+	if _, ok := ws.Wallets[address]; ok {
+		return "", fmt.Errorf("%w: %s", ErrAddressExists, address)
+	}
+	ws.Wallets[address] = wallet
+
+	return address, nil
+}
+
+// checkPubKey reports whether pubKey is a serialized public key of a
+// supported scheme
+func checkPubKey(pubKey []byte) error {
+	kt, err := KeyTypeOf(pubKey)
+	if err != nil {
+		return err
+	}
+	if kt == KeyTypeEd25519 {
+		if len(pubKey) != 1+ed25519.PublicKeySize {
+			return fmt.Errorf("%w: bad Ed25519 key", ErrInvalidPubKey)
+		}
+		return nil
+	}
+
+	_, err = ParsePubKey(pubKey)
+	return err
+}